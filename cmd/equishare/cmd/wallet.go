@@ -173,16 +173,21 @@ func runTransactions(cmd *cobra.Command, args []string) error {
 
 	rows := make([][]string, len(resp.Transactions))
 	for i, tx := range resp.Transactions {
+		fee := "-"
+		if tx.Fee != 0 {
+			fee = fmt.Sprintf("%.2f %s", tx.Fee, tx.Currency)
+		}
 		rows[i] = []string{
 			tx.ID[:8],
 			tx.Type,
 			fmt.Sprintf("%.2f %s", tx.Amount, tx.Currency),
+			fee,
 			output.FormatStatus(tx.Status),
 			tx.CreatedAt[:10],
 		}
 	}
 
-	output.Table([]string{"ID", "Type", "Amount", "Status", "Date"}, rows)
+	output.Table([]string{"ID", "Type", "Amount", "Fee", "Status", "Date"}, rows)
 	fmt.Println()
 	output.Info(fmt.Sprintf("Page %d of %d", resp.Page, (resp.Total+resp.PerPage-1)/resp.PerPage))
 
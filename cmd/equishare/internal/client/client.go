@@ -219,6 +219,7 @@ type Transaction struct {
 	ID          string  `json:"id"`
 	Type        string  `json:"type"`
 	Amount      float64 `json:"amount"`
+	Fee         float64 `json:"fee"`
 	Currency    string  `json:"currency"`
 	Status      string  `json:"status"`
 	Description string  `json:"description"`
@@ -0,0 +1,15 @@
+-- Track the USD reserved against an order so cancels and expiries release
+-- exactly what was locked (limit and stop orders are priced locally, not by
+-- the notional amount).
+ALTER TABLE orders ADD COLUMN locked_amount DECIMAL(20, 4) DEFAULT 0 CHECK (locked_amount >= 0);
+
+-- Open buys placed before this column existed locked their notional amount,
+-- or their qty at its price. status is still the order_status enum here, so
+-- it is compared as text.
+UPDATE orders
+SET locked_amount = CASE
+        WHEN amount > 0 THEN amount
+        ELSE COALESCE(qty * COALESCE(limit_price, stop_price), 0)
+    END
+WHERE side = 'buy'
+  AND status::text IN ('pending', 'submitted', 'partial', 'new', 'partial_fill');
@@ -0,0 +1,3 @@
+-- Amending an order replaces it at Alpaca with a new broker order ID.
+-- alpaca_order_id always holds the live order; this keeps the one it replaced.
+ALTER TABLE orders ADD COLUMN replaces_alpaca_order_id VARCHAR(100);
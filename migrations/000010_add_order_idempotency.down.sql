@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_orders_user_idempotency_key;
+DROP INDEX IF EXISTS idx_orders_client_order_id;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS idempotency_key,
+    DROP COLUMN IF EXISTS client_order_id;
@@ -0,0 +1,8 @@
+-- Client order IDs sent to Alpaca, and the optional Idempotency-Key header
+-- they were derived from, so retried placements return the original order.
+ALTER TABLE orders
+    ADD COLUMN client_order_id VARCHAR(100),
+    ADD COLUMN idempotency_key VARCHAR(255);
+
+CREATE UNIQUE INDEX idx_orders_client_order_id ON orders(client_order_id) WHERE client_order_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_orders_user_idempotency_key ON orders(user_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
@@ -0,0 +1,13 @@
+-- Transactional outbox: events are written in the same transaction as the
+-- state change they describe and relayed to Kafka after commit.
+CREATE TABLE outbox_events (
+    id UUID PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    event JSONB NOT NULL,
+    attempts INT DEFAULT 0,
+    last_error TEXT,
+    published_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_outbox_events_pending ON outbox_events(created_at) WHERE published_at IS NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_orders_open_reconcile;
+ALTER TABLE orders DROP COLUMN IF EXISTS reconciled_at;
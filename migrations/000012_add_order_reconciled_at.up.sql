@@ -0,0 +1,8 @@
+-- Last time the reconciler confirmed an open order against Alpaca, so
+-- long-lived GTC orders rotate through the batch instead of starving it.
+ALTER TABLE orders ADD COLUMN reconciled_at TIMESTAMPTZ;
+
+-- status is still the order_status enum here; 000017 recreates this index
+-- with the renamed statuses
+CREATE INDEX idx_orders_open_reconcile ON orders(COALESCE(reconciled_at, updated_at))
+    WHERE status IN ('pending', 'submitted', 'partial');
@@ -0,0 +1,37 @@
+-- Recurring investment plans: a fixed KES or USD amount invested in a symbol
+-- on a weekly or monthly schedule.
+CREATE TABLE investment_plans (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    amount DECIMAL(20, 4) NOT NULL CHECK (amount > 0),
+    currency currency NOT NULL,
+    frequency VARCHAR(20) NOT NULL CHECK (frequency IN ('weekly', 'monthly')),
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'paused', 'canceled')),
+    -- The scheduled time of the next run, and when to retry it after a
+    -- run that could not be funded
+    next_run_at TIMESTAMPTZ NOT NULL,
+    retry_at TIMESTAMPTZ,
+    attempts INT NOT NULL DEFAULT 0,
+    last_run_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_investment_plans_user_id ON investment_plans(user_id);
+CREATE INDEX idx_investment_plans_due ON investment_plans(COALESCE(retry_at, next_run_at))
+    WHERE status = 'active';
+
+-- One row per attempt to run a plan
+CREATE TABLE investment_plan_runs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    plan_id UUID NOT NULL REFERENCES investment_plans(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMPTZ NOT NULL,
+    attempt INT NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('placed', 'retrying', 'skipped', 'failed')),
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    reason TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_investment_plan_runs_plan_id ON investment_plan_runs(plan_id, created_at DESC);
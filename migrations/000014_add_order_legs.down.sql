@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_orders_parent_order_id;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS leg,
+    DROP COLUMN IF EXISTS parent_order_id,
+    DROP COLUMN IF EXISTS order_class;
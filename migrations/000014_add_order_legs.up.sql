@@ -0,0 +1,9 @@
+-- Bracket, OCO and OTO orders are saved as the order placed plus one row per
+-- exit leg. Legs point at the order they belong to and share its class.
+ALTER TABLE orders
+    ADD COLUMN order_class VARCHAR(20) NOT NULL DEFAULT 'simple'
+        CHECK (order_class IN ('simple', 'bracket', 'oco', 'oto')),
+    ADD COLUMN parent_order_id UUID REFERENCES orders(id) ON DELETE CASCADE,
+    ADD COLUMN leg VARCHAR(20) CHECK (leg IN ('take_profit', 'stop_loss'));
+
+CREATE INDEX idx_orders_parent_order_id ON orders(parent_order_id) WHERE parent_order_id IS NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_orders_basket_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS basket_id;
+
+DROP TABLE IF EXISTS baskets;
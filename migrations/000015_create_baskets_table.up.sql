@@ -0,0 +1,22 @@
+-- A basket invests one USD amount across several symbols by weight. Each
+-- symbol is placed as its own notional order pointing back at the basket.
+CREATE TABLE baskets (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100),
+    amount DECIMAL(20, 4) NOT NULL CHECK (amount > 0),
+    -- Outcome of submitting the legs: pending while they are being placed
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'placed', 'partially_placed', 'failed')),
+    idempotency_key VARCHAR(255),
+    source VARCHAR(20),
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_baskets_user_id ON baskets(user_id);
+CREATE UNIQUE INDEX idx_baskets_user_idempotency_key ON baskets(user_id, idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+ALTER TABLE orders ADD COLUMN basket_id UUID REFERENCES baskets(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_orders_basket_id ON orders(basket_id) WHERE basket_id IS NOT NULL;
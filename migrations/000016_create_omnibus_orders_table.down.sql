@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS omnibus_allocations;
+
+DROP INDEX IF EXISTS idx_orders_queued;
+DROP INDEX IF EXISTS idx_orders_omnibus_order_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS omnibus_order_id;
+
+DROP TABLE IF EXISTS omnibus_orders;
@@ -0,0 +1,49 @@
+-- Small notional buys in the same symbol are queued and submitted to Alpaca
+-- together as one omnibus order. The fill is allocated back to each order
+-- pro-rata by amount.
+CREATE TABLE omnibus_orders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    symbol VARCHAR(20) NOT NULL,
+    notional DECIMAL(20, 4) NOT NULL CHECK (notional > 0),
+    order_count INT NOT NULL,
+    alpaca_order_id VARCHAR(100),
+    client_order_id VARCHAR(100) NOT NULL UNIQUE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'submitted', 'filled', 'canceled', 'failed')),
+    filled_qty DECIMAL(20, 9) NOT NULL DEFAULT 0,
+    filled_avg_price DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    settled_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_omnibus_orders_alpaca_order_id ON omnibus_orders(alpaca_order_id) WHERE alpaca_order_id IS NOT NULL;
+CREATE INDEX idx_omnibus_orders_open ON omnibus_orders(updated_at) WHERE status IN ('pending', 'submitted');
+
+ALTER TABLE orders ADD COLUMN omnibus_order_id UUID REFERENCES omnibus_orders(id);
+
+CREATE INDEX idx_orders_omnibus_order_id ON orders(omnibus_order_id) WHERE omnibus_order_id IS NOT NULL;
+-- The queued-orders index needs the 'queued' status, which the order_status
+-- enum lacks; 000017 creates it once statuses are text
+
+-- Audit of how each omnibus fill was split: the exact pro-rata share, the
+-- shares allocated after rounding and the unspent amount refunded
+CREATE TABLE omnibus_allocations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    omnibus_order_id UUID NOT NULL REFERENCES omnibus_orders(id) ON DELETE CASCADE,
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount DECIMAL(20, 4) NOT NULL,
+    weight DECIMAL(12, 10) NOT NULL,
+    exact_qty DECIMAL(24, 12) NOT NULL,
+    allocated_qty DECIMAL(20, 9) NOT NULL,
+    rounding_qty DECIMAL(20, 9) NOT NULL,
+    price DECIMAL(20, 4) NOT NULL,
+    value DECIMAL(20, 4) NOT NULL,
+    refunded DECIMAL(20, 4) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (omnibus_order_id, order_id)
+);
+
+CREATE INDEX idx_omnibus_allocations_order_id ON omnibus_allocations(order_id);
+CREATE INDEX idx_omnibus_allocations_user_id ON omnibus_allocations(user_id);
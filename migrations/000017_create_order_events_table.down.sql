@@ -0,0 +1,27 @@
+DROP TABLE IF EXISTS order_events;
+
+DROP INDEX IF EXISTS idx_orders_status;
+DROP INDEX IF EXISTS idx_orders_open_reconcile;
+DROP INDEX IF EXISTS idx_orders_queued;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_status_check;
+ALTER TABLE orders ALTER COLUMN status DROP NOT NULL;
+ALTER TABLE orders ALTER COLUMN status DROP DEFAULT;
+
+CREATE TYPE order_status AS ENUM ('pending', 'submitted', 'partial', 'filled', 'cancelled', 'rejected', 'expired');
+
+-- Statuses the enum cannot hold map to their nearest legacy value
+ALTER TABLE orders ALTER COLUMN status TYPE order_status USING (
+    CASE status
+        WHEN 'new' THEN 'submitted'
+        WHEN 'held' THEN 'submitted'
+        WHEN 'queued' THEN 'pending'
+        WHEN 'partial_fill' THEN 'partial'
+        WHEN 'canceled' THEN 'cancelled'
+        WHEN 'failed' THEN 'rejected'
+        ELSE status
+    END
+)::order_status;
+ALTER TABLE orders ALTER COLUMN status SET DEFAULT 'pending';
+
+CREATE INDEX idx_orders_status ON orders(status);
@@ -0,0 +1,45 @@
+-- The order_status enum never matched the statuses trading-service writes
+-- (new, partial_fill, canceled, failed, held, queued). Statuses become text
+-- checked against the order state machine, with legacy values renamed.
+DROP INDEX IF EXISTS idx_orders_status;
+DROP INDEX IF EXISTS idx_orders_open_reconcile;
+DROP INDEX IF EXISTS idx_orders_queued;
+
+ALTER TABLE orders ALTER COLUMN status DROP DEFAULT;
+ALTER TABLE orders ALTER COLUMN status TYPE VARCHAR(20) USING (
+    CASE status::text
+        WHEN 'submitted' THEN 'new'
+        WHEN 'partial' THEN 'partial_fill'
+        WHEN 'cancelled' THEN 'canceled'
+        WHEN 'rejected' THEN 'failed'
+        ELSE COALESCE(status::text, 'pending')
+    END
+);
+ALTER TABLE orders ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE orders ALTER COLUMN status SET NOT NULL;
+ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN (
+    'queued', 'pending', 'held', 'new', 'partial_fill', 'filled', 'canceled', 'expired', 'failed'
+));
+
+DROP TYPE IF EXISTS order_status;
+
+CREATE INDEX idx_orders_status ON orders(status);
+CREATE INDEX idx_orders_open_reconcile ON orders(COALESCE(reconciled_at, updated_at))
+    WHERE status IN ('pending', 'new', 'partial_fill');
+CREATE INDEX idx_orders_queued ON orders(symbol, created_at) WHERE status = 'queued';
+
+-- Every status transition of an order, and what caused it
+CREATE TABLE order_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20) NOT NULL,
+    source VARCHAR(20) NOT NULL
+        CHECK (source IN ('api', 'webhook', 'stream', 'reconciler', 'expiry', 'aggregator')),
+    reason TEXT,
+    filled_qty DECIMAL(20, 9) NOT NULL DEFAULT 0,
+    -- Wall-clock time, so transitions made in one transaction stay ordered
+    created_at TIMESTAMPTZ DEFAULT clock_timestamp()
+);
+
+CREATE INDEX idx_order_events_order_id ON order_events(order_id, created_at);
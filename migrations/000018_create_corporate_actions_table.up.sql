@@ -0,0 +1,45 @@
+-- Corporate actions ingested from a provider. Splits and symbol changes are
+-- applied to holdings on the ex date. Dividends are entitled to the holders
+-- on the ex date and paid to them on the payable date.
+CREATE TABLE corporate_actions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    provider_ref VARCHAR(255) NOT NULL UNIQUE,
+    type VARCHAR(20) NOT NULL CHECK (type IN ('cash_dividend', 'stock_split', 'symbol_change')),
+    symbol VARCHAR(20) NOT NULL,
+    new_symbol VARCHAR(20),
+    rate DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    split_from DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    split_to DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    ex_date DATE NOT NULL,
+    payable_date DATE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'entitled', 'applied')),
+    holders INT NOT NULL DEFAULT 0,
+    processed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_corporate_actions_open ON corporate_actions(ex_date) WHERE status <> 'applied';
+CREATE INDEX idx_corporate_actions_symbol ON corporate_actions(symbol);
+
+-- What each holder received from an action: the holding before and after,
+-- and for dividends the gross amount, the tax withheld and the net credited
+CREATE TABLE corporate_action_entries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    action_id UUID NOT NULL REFERENCES corporate_actions(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    qty_before DECIMAL(20, 8) NOT NULL,
+    qty_after DECIMAL(20, 8) NOT NULL,
+    avg_cost_before DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    avg_cost_after DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    gross_amount DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    withholding_tax DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    net_amount DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    paid_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    UNIQUE (action_id, user_id)
+);
+
+CREATE INDEX idx_corporate_action_entries_user_id ON corporate_action_entries(user_id, created_at DESC);
@@ -0,0 +1,5 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS lot_ids;
+ALTER TABLE orders DROP COLUMN IF EXISTS lot_method;
+
+DROP TABLE IF EXISTS realized_gains;
+DROP TABLE IF EXISTS tax_lots;
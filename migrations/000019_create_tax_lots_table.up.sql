@@ -0,0 +1,54 @@
+-- Shares bought in each fill, consumed by sells in FIFO, LIFO or chosen
+-- order. remaining_qty is what is still held; a lot closes when it is sold
+-- out.
+CREATE TABLE tax_lots (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    order_id UUID REFERENCES orders(id),
+    qty DECIMAL(20, 8) NOT NULL CHECK (qty > 0),
+    remaining_qty DECIMAL(20, 8) NOT NULL CHECK (remaining_qty >= 0),
+    cost_per_share DECIMAL(20, 4) NOT NULL,
+    acquired_at TIMESTAMPTZ NOT NULL,
+    closed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_tax_lots_open ON tax_lots(user_id, symbol, acquired_at) WHERE remaining_qty > 0;
+CREATE INDEX idx_tax_lots_symbol ON tax_lots(symbol) WHERE remaining_qty > 0;
+
+-- Holdings bought before lots were tracked become one lot each, at their
+-- average cost
+INSERT INTO tax_lots (user_id, symbol, qty, remaining_qty, cost_per_share, acquired_at)
+SELECT user_id, symbol, quantity, quantity, avg_cost_basis, created_at
+FROM holdings
+WHERE quantity > 0;
+
+-- The gain or loss realized on each lot a sell fill consumed. lot_id is
+-- unset for shares no lot covered, which are costed at the holding's
+-- average.
+CREATE TABLE realized_gains (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    order_id UUID NOT NULL REFERENCES orders(id),
+    lot_id UUID REFERENCES tax_lots(id),
+    qty DECIMAL(20, 8) NOT NULL,
+    proceeds DECIMAL(20, 4) NOT NULL,
+    cost_basis DECIMAL(20, 4) NOT NULL,
+    fee DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    realized_pl DECIMAL(20, 4) NOT NULL,
+    term VARCHAR(10) NOT NULL CHECK (term IN ('short', 'long')),
+    acquired_at TIMESTAMPTZ NOT NULL,
+    sold_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_realized_gains_user_sold_at ON realized_gains(user_id, sold_at DESC);
+CREATE INDEX idx_realized_gains_order_id ON realized_gains(order_id);
+
+-- How a sell order picks the lots it consumes
+ALTER TABLE orders ADD COLUMN lot_method VARCHAR(10) NOT NULL DEFAULT 'fifo'
+    CHECK (lot_method IN ('fifo', 'lifo', 'specific'));
+ALTER TABLE orders ADD COLUMN lot_ids UUID[];
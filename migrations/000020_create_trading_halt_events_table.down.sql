@@ -0,0 +1 @@
+DROP TABLE IF EXISTS trading_halt_events;
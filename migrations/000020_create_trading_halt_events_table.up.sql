@@ -0,0 +1,13 @@
+-- Audit trail of trading halts set and cleared by operations. The active
+-- halts themselves live in Redis, where every order placement checks them.
+CREATE TABLE trading_halt_events (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    action VARCHAR(10) NOT NULL CHECK (action IN ('set', 'cleared')),
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('global', 'symbol', 'sell_only', 'user')),
+    target VARCHAR(100) NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    actor VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_trading_halt_events_created_at ON trading_halt_events(created_at DESC);
@@ -0,0 +1,27 @@
+-- Conditional orders: an order held back until a price condition on its
+-- symbol is met, then placed like any other order. The order itself is kept
+-- as the request it will be placed with.
+CREATE TABLE conditional_orders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    condition VARCHAR(30) NOT NULL
+        CHECK (condition IN ('price_above', 'price_below', 'change_from_open', 'position_gain')),
+    -- A price for price_above and price_below, a signed percentage otherwise
+    threshold DECIMAL(20, 4) NOT NULL,
+    order_request JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'triggered', 'placed', 'failed', 'canceled', 'expired')),
+    expires_at TIMESTAMPTZ,
+    -- Set once, when a replica claims the condition; the order is placed
+    -- only after the claim commits
+    triggered_at TIMESTAMPTZ,
+    trigger_price DECIMAL(20, 4),
+    order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+    reason TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_conditional_orders_user_id ON conditional_orders(user_id, created_at DESC);
+CREATE INDEX idx_conditional_orders_active ON conditional_orders(symbol) WHERE status = 'active';
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS position_drifts;
+DROP TABLE IF EXISTS position_reconciliations;
@@ -0,0 +1,40 @@
+-- Position reconciliation runs: user holdings summed per symbol and compared
+-- with the positions held at the broker. Scheduled runs happen once a day;
+-- operators can start more.
+CREATE TABLE position_reconciliations (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    trigger VARCHAR(20) NOT NULL CHECK (trigger IN ('scheduled', 'manual')),
+    run_date DATE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'running' CHECK (status IN ('running', 'completed', 'failed')),
+    symbols_checked INT NOT NULL DEFAULT 0,
+    drift_count INT NOT NULL DEFAULT 0,
+    error TEXT,
+    started_by VARCHAR(255) NOT NULL,
+    started_at TIMESTAMPTZ DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+-- One scheduled run a day, however many replicas try to start it
+CREATE UNIQUE INDEX idx_position_reconciliations_scheduled ON position_reconciliations(run_date)
+    WHERE trigger = 'scheduled';
+CREATE INDEX idx_position_reconciliations_started_at ON position_reconciliations(started_at DESC);
+
+-- The drift report: one row per symbol whose books disagree with the broker
+CREATE TABLE position_drifts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    reconciliation_id UUID NOT NULL REFERENCES position_reconciliations(id) ON DELETE CASCADE,
+    symbol VARCHAR(20) NOT NULL,
+    local_qty DECIMAL(20, 8) NOT NULL,
+    broker_qty DECIMAL(20, 8) NOT NULL,
+    -- broker_qty - local_qty
+    drift DECIMAL(20, 8) NOT NULL,
+    holders INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'corrected')),
+    corrected_by VARCHAR(255),
+    corrected_at TIMESTAMPTZ,
+    correction_note TEXT,
+    created_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+CREATE INDEX idx_position_drifts_reconciliation_id ON position_drifts(reconciliation_id, symbol);
+CREATE INDEX idx_position_drifts_open ON position_drifts(symbol) WHERE status = 'open';
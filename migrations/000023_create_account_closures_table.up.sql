@@ -0,0 +1,43 @@
+-- Account closures: a user's request to close their account, worked through
+-- in steps. Open orders are canceled, holdings sold, the USD balance
+-- converted to KES and the KES balance paid out to the user's M-Pesa number
+-- before the account is closed.
+CREATE TABLE account_closures (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- The M-Pesa number payouts are sent to, as it was when closure started
+    phone VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'canceling_orders'
+        CHECK (status IN ('canceling_orders', 'liquidating', 'awaiting_fills', 'converting',
+                          'paying_out', 'awaiting_payout', 'closed', 'failed')),
+    -- Each round of sell orders is placed under its own idempotency keys
+    sell_round INT NOT NULL DEFAULT 1,
+    converted_usd DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    converted_kes DECIMAL(20, 4) NOT NULL DEFAULT 0,
+    paid_out_kes DECIMAL(20, 2) NOT NULL DEFAULT 0,
+    -- The payout in flight: debited from the KES wallet when requested and
+    -- credited back only if M-Pesa reports it failed
+    payout_amount DECIMAL(20, 2),
+    payout_attempts INT NOT NULL DEFAULT 0,
+    payout_conversation_id VARCHAR(100),
+    payout_requested_at TIMESTAMPTZ,
+    payout_result_code INT,
+    payout_result_desc TEXT,
+    payout_receipt VARCHAR(50),
+    -- KES left in the wallet below the smallest payout M-Pesa makes
+    residual_kes DECIMAL(20, 4),
+    reason TEXT,
+    next_check_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    closed_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- A user has at most one closure in progress
+CREATE UNIQUE INDEX idx_account_closures_user_open ON account_closures(user_id)
+    WHERE status NOT IN ('closed', 'failed');
+CREATE INDEX idx_account_closures_user_id ON account_closures(user_id, created_at DESC);
+CREATE INDEX idx_account_closures_due ON account_closures(next_check_at)
+    WHERE status NOT IN ('closed', 'failed');
+CREATE UNIQUE INDEX idx_account_closures_conversation ON account_closures(payout_conversation_id)
+    WHERE payout_conversation_id IS NOT NULL;
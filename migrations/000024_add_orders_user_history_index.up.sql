@@ -0,0 +1,2 @@
+-- Order history is paged newest first by (created_at, id) within a user
+CREATE INDEX idx_orders_user_history ON orders(user_id, created_at DESC, id DESC);
@@ -0,0 +1 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS max_slippage_bps;
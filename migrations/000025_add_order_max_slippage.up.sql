@@ -0,0 +1,3 @@
+-- Market orders by qty are sent to the broker as limit orders a bounded
+-- distance through the quote; this is the band used, in basis points
+ALTER TABLE orders ADD COLUMN max_slippage_bps INT;
@@ -0,0 +1,6 @@
+-- Closure transitions were made on the user's behalf, so they fall back to api
+UPDATE order_events SET source = 'api' WHERE source = 'closure';
+
+ALTER TABLE order_events DROP CONSTRAINT IF EXISTS order_events_source_check;
+ALTER TABLE order_events ADD CONSTRAINT order_events_source_check
+    CHECK (source IN ('api', 'webhook', 'stream', 'reconciler', 'expiry', 'aggregator'));
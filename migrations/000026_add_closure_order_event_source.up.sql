@@ -0,0 +1,5 @@
+-- Account closures cancel and liquidate orders, recording their transitions
+-- with their own source
+ALTER TABLE order_events DROP CONSTRAINT IF EXISTS order_events_source_check;
+ALTER TABLE order_events ADD CONSTRAINT order_events_source_check
+    CHECK (source IN ('api', 'webhook', 'stream', 'reconciler', 'expiry', 'aggregator', 'closure'));
@@ -0,0 +1,3 @@
+-- The new terms of an amendment are saved before the order is replaced at
+-- Alpaca, so if saving the replacement fails the reconciler can finish it
+ALTER TABLE orders ADD COLUMN pending_replace JSONB;
@@ -0,0 +1,3 @@
+-- When the user asked to cancel an order. The order stays open, pending
+-- cancel, until Alpaca confirms the cancel or reports a fill.
+ALTER TABLE orders ADD COLUMN cancel_requested_at TIMESTAMPTZ;
@@ -2,6 +2,11 @@ package alpaca
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -38,6 +43,41 @@ func TestNewClient(t *testing.T) {
 	}
 }
 
+func TestDecodeResponse_APIError(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantNotFound bool
+		wantRejected bool
+	}{
+		{"not found", http.StatusNotFound, true, true},
+		{"unprocessable", http.StatusUnprocessableEntity, false, true},
+		{"server error", http.StatusInternalServerError, false, false},
+		{"unauthorized", http.StatusUnauthorized, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Body:       io.NopCloser(strings.NewReader(`{"message":"failed"}`)),
+			}
+
+			err := decodeResponse(resp, nil)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("decodeResponse() error = %v, want APIError with status %d", err, tt.status)
+			}
+			if got := IsNotFound(fmt.Errorf("get asset: %w", err)); got != tt.wantNotFound {
+				t.Errorf("IsNotFound() = %v, want %v", got, tt.wantNotFound)
+			}
+			if got := IsRejected(err); got != tt.wantRejected {
+				t.Errorf("IsRejected() = %v, want %v", got, tt.wantRejected)
+			}
+		})
+	}
+}
+
 func TestMockClient_CreateOrder(t *testing.T) {
 	ctx := context.Background()
 	client := NewMockClient()
@@ -151,6 +191,140 @@ func TestMockClient_CancelOrder(t *testing.T) {
 	}
 }
 
+func TestMockClient_BracketOrder(t *testing.T) {
+	ctx := context.Background()
+	client := NewMockClient()
+
+	order, err := client.CreateOrder(ctx, &CreateOrderRequest{
+		Symbol:      "AAPL",
+		Qty:         "5",
+		Side:        Buy,
+		Type:        Market,
+		TimeInForce: GTC,
+		OrderClass:  Bracket,
+		TakeProfit:  &TakeProfit{LimitPrice: "200.00"},
+		StopLoss:    &StopLoss{StopPrice: "120.00"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+
+	if order.Status != OrderStatusFilled {
+		t.Fatalf("order.Status = %v, want %v", order.Status, OrderStatusFilled)
+	}
+	if len(order.Legs) != 2 {
+		t.Fatalf("expected 2 legs, got %d", len(order.Legs))
+	}
+
+	takeProfit, stopLoss := order.Legs[0], order.Legs[1]
+	if takeProfit.OrderType != Limit || takeProfit.LimitPrice != "200.00" || takeProfit.Side != Sell {
+		t.Errorf("unexpected take-profit leg: %+v", takeProfit)
+	}
+	if stopLoss.OrderType != Stop || stopLoss.StopPrice != "120.00" || stopLoss.Side != Sell {
+		t.Errorf("unexpected stop-loss leg: %+v", stopLoss)
+	}
+	for _, leg := range order.Legs {
+		if leg.Status != OrderStatusNew {
+			t.Errorf("leg.Status = %v, want %v once the entry fills", leg.Status, OrderStatusNew)
+		}
+	}
+
+	if _, err := client.FillOrder(takeProfit.ID, "200.00"); err != nil {
+		t.Fatalf("FillOrder failed: %v", err)
+	}
+
+	sibling, _ := client.GetOrder(ctx, stopLoss.ID)
+	if sibling.Status != OrderStatusCanceled {
+		t.Errorf("stop-loss status = %v, want %v", sibling.Status, OrderStatusCanceled)
+	}
+}
+
+func TestMockClient_BracketOrder_CancelEntry(t *testing.T) {
+	ctx := context.Background()
+	client := NewMockClient()
+
+	order, _ := client.CreateOrder(ctx, &CreateOrderRequest{
+		Symbol:      "MSFT",
+		Qty:         "1",
+		Side:        Buy,
+		Type:        Limit,
+		LimitPrice:  "300.00",
+		TimeInForce: GTC,
+		OrderClass:  Bracket,
+		TakeProfit:  &TakeProfit{LimitPrice: "400.00"},
+		StopLoss:    &StopLoss{StopPrice: "250.00", LimitPrice: "245.00"},
+	})
+
+	for _, leg := range order.Legs {
+		if leg.Status != OrderStatusHeld {
+			t.Errorf("leg.Status = %v, want %v", leg.Status, OrderStatusHeld)
+		}
+	}
+
+	if err := client.CancelOrder(ctx, order.ID); err != nil {
+		t.Fatalf("CancelOrder failed: %v", err)
+	}
+
+	canceled, _ := client.GetOrder(ctx, order.ID)
+	for _, leg := range canceled.Legs {
+		if leg.Status != OrderStatusCanceled {
+			t.Errorf("leg.Status = %v, want %v", leg.Status, OrderStatusCanceled)
+		}
+	}
+}
+
+func TestMockClient_OCOOrder(t *testing.T) {
+	ctx := context.Background()
+	client := NewMockClient()
+
+	order, err := client.CreateOrder(ctx, &CreateOrderRequest{
+		Symbol:      "TSLA",
+		Qty:         "2",
+		Side:        Sell,
+		Type:        Limit,
+		TimeInForce: GTC,
+		OrderClass:  OCO,
+		TakeProfit:  &TakeProfit{LimitPrice: "300.00"},
+		StopLoss:    &StopLoss{StopPrice: "200.00"},
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder failed: %v", err)
+	}
+
+	if order.LimitPrice != "300.00" {
+		t.Errorf("order.LimitPrice = %v, want 300.00", order.LimitPrice)
+	}
+	if len(order.Legs) != 1 || order.Legs[0].Status != OrderStatusNew {
+		t.Fatalf("expected 1 live stop-loss leg, got %+v", order.Legs)
+	}
+
+	if _, err := client.FillOrder(order.Legs[0].ID, "199.00"); err != nil {
+		t.Fatalf("FillOrder failed: %v", err)
+	}
+
+	takeProfit, _ := client.GetOrder(ctx, order.ID)
+	if takeProfit.Status != OrderStatusCanceled {
+		t.Errorf("take-profit status = %v, want %v", takeProfit.Status, OrderStatusCanceled)
+	}
+}
+
+func TestMockClient_OTOOrder_RequiresOneLeg(t *testing.T) {
+	ctx := context.Background()
+	client := NewMockClient()
+
+	_, err := client.CreateOrder(ctx, &CreateOrderRequest{
+		Symbol:      "AAPL",
+		Qty:         "1",
+		Side:        Buy,
+		Type:        Market,
+		TimeInForce: Day,
+		OrderClass:  OTO,
+	})
+	if err == nil {
+		t.Error("expected an error for an OTO order without an exit leg")
+	}
+}
+
 func TestMockClient_GetAccount(t *testing.T) {
 	ctx := context.Background()
 	client := NewMockClient()
@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -14,7 +16,8 @@ import (
 type Config struct {
 	APIKey    string
 	SecretKey string
-	Paper     bool // Use paper trading environment
+	Paper     bool   // Use paper trading environment
+	BaseURL   string // Overrides the trading API URL, e.g. for the mock server
 }
 
 // Client is the Alpaca API client
@@ -32,6 +35,9 @@ func NewClient(cfg *Config) *Client {
 	if cfg.Paper {
 		baseURL = "https://paper-api.alpaca.markets"
 	}
+	if cfg.BaseURL != "" {
+		baseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	}
 
 	return &Client{
 		config: cfg,
@@ -66,13 +72,38 @@ func (c *Client) doRequest(ctx context.Context, method, url string, body any) (*
 	return c.httpClient.Do(req)
 }
 
+// APIError is an error response from the Alpaca API
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (status %d): %s", e.StatusCode, e.Body)
+}
+
+// IsNotFound reports whether err is a 404 from the Alpaca API, e.g. for an
+// unknown symbol
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsRejected reports whether err is a 4xx from the Alpaca API, meaning the
+// request was refused and had no effect. Transport errors and 5xx responses
+// leave the outcome unknown.
+func IsRejected(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode >= 400 && apiErr.StatusCode < 500
+}
+
 // decodeResponse decodes a JSON response into the target
 func decodeResponse(resp *http.Response, target any) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 400 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error (status %d): %s", resp.StatusCode, string(body))
+		return &APIError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	if target != nil {
@@ -16,6 +16,9 @@ type TradingClient interface {
 	CancelAllOrders(ctx context.Context) error
 	ReplaceOrder(ctx context.Context, orderID string, req *ReplaceOrderRequest) (*Order, error)
 
+	// Streaming
+	StreamTradeUpdates(ctx context.Context, handler TradeUpdateHandler) error
+
 	// Positions
 	ListPositions(ctx context.Context) ([]Position, error)
 	GetPosition(ctx context.Context, symbol string) (*Position, error)
@@ -15,13 +15,24 @@ type MockClient struct {
 	orders    map[string]*Order
 	positions map[string]*Position
 	account   *Account
+
+	// Exit legs of bracket, OCO and OTO orders, keyed by parent order ID
+	legs map[string][]string
+	// Parent order ID, keyed by leg order ID
+	parents map[string]string
+
+	// Trade update stream subscribers
+	subscribers map[chan TradeUpdate]struct{}
 }
 
 // NewMockClient creates a new mock Alpaca client
 func NewMockClient() *MockClient {
 	return &MockClient{
-		orders:    make(map[string]*Order),
-		positions: make(map[string]*Position),
+		orders:      make(map[string]*Order),
+		positions:   make(map[string]*Position),
+		legs:        make(map[string][]string),
+		parents:     make(map[string]string),
+		subscribers: make(map[chan TradeUpdate]struct{}),
 		account: &Account{
 			ID:             "mock-account-id",
 			AccountNumber:  "MOCK123456",
@@ -42,11 +53,27 @@ func (c *MockClient) GetAccount(ctx context.Context) (*Account, error) {
 	return c.account, nil
 }
 
-// CreateOrder creates a mock order
+// CreateOrder creates a mock order. Exit legs of bracket and OTO orders are
+// held until the entry fills; the legs of an OCO order are live at once.
 func (c *MockClient) CreateOrder(ctx context.Context, req *CreateOrderRequest) (*Order, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	switch req.OrderClass {
+	case Bracket:
+		if req.TakeProfit == nil || req.StopLoss == nil {
+			return nil, fmt.Errorf("bracket orders require take_profit and stop_loss")
+		}
+	case OCO:
+		if req.Type != Limit || req.TakeProfit == nil || req.StopLoss == nil {
+			return nil, fmt.Errorf("oco orders must be limit orders with take_profit and stop_loss")
+		}
+	case OTO:
+		if (req.TakeProfit == nil) == (req.StopLoss == nil) {
+			return nil, fmt.Errorf("oto orders require one of take_profit or stop_loss")
+		}
+	}
+
 	orderID := uuid.New().String()
 	clientOrderID := req.ClientOrderID
 	if clientOrderID == "" {
@@ -64,6 +91,7 @@ func (c *MockClient) CreateOrder(ctx context.Context, req *CreateOrderRequest) (
 		AssetClass:    "us_equity",
 		Qty:           req.Qty,
 		Notional:      req.Notional,
+		OrderClass:    req.OrderClass,
 		OrderType:     req.Type,
 		Side:          req.Side,
 		TimeInForce:   req.TimeInForce,
@@ -72,24 +100,175 @@ func (c *MockClient) CreateOrder(ctx context.Context, req *CreateOrderRequest) (
 		Status:        OrderStatusNew,
 		ExtendedHours: req.ExtendedHours,
 	}
+	if order.OrderClass == "" {
+		order.OrderClass = Simple
+	}
+	c.orders[orderID] = order
+	c.emit(TradeEventNew, order)
+
+	switch req.OrderClass {
+	case Bracket, OTO:
+		// Exits close the position the entry opens
+		exit := Sell
+		if req.Side == Sell {
+			exit = Buy
+		}
+		if req.TakeProfit != nil {
+			c.addLeg(order, exit, Limit, req.TakeProfit.LimitPrice, "", OrderStatusHeld)
+		}
+		if req.StopLoss != nil {
+			c.addLeg(order, exit, stopLossType(req.StopLoss), req.StopLoss.LimitPrice, req.StopLoss.StopPrice, OrderStatusHeld)
+		}
+	case OCO:
+		// The order itself is the take-profit; the stop-loss is its leg
+		order.LimitPrice = req.TakeProfit.LimitPrice
+		c.addLeg(order, req.Side, stopLossType(req.StopLoss), req.StopLoss.LimitPrice, req.StopLoss.StopPrice, OrderStatusNew)
+	}
 
 	// Simulate immediate fill for market orders
 	if req.Type == Market {
-		order.Status = OrderStatusFilled
-		order.FilledQty = order.Qty
+		filledQty := order.Qty
 		if order.Qty == "" && order.Notional != "" {
-			order.FilledQty = "1" // Simulate fractional fill
+			filledQty = "1" // Simulate fractional fill
 		}
-		order.FilledAvgPrice = "150.00" // Mock price
-		filledAt := now
-		order.FilledAt = &filledAt
+		c.fill(order, filledQty, "150.00") // Mock price
+	}
+
+	return c.withLegs(order), nil
+}
 
-		// Update positions for filled orders
-		c.updatePosition(order)
+func stopLossType(sl *StopLoss) OrderType {
+	if sl.LimitPrice != "" {
+		return StopLimit
 	}
+	return Stop
+}
 
-	c.orders[orderID] = order
-	return order, nil
+// addLeg creates an exit leg of parent. Callers must hold c.mu.
+func (c *MockClient) addLeg(parent *Order, side OrderSide, orderType OrderType, limitPrice, stopPrice string, status OrderStatus) {
+	now := time.Now()
+	leg := &Order{
+		ID:            uuid.New().String(),
+		ClientOrderID: uuid.New().String(),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		SubmittedAt:   now,
+		Symbol:        parent.Symbol,
+		AssetClass:    parent.AssetClass,
+		Qty:           parent.Qty,
+		OrderClass:    parent.OrderClass,
+		OrderType:     orderType,
+		Side:          side,
+		TimeInForce:   parent.TimeInForce,
+		LimitPrice:    limitPrice,
+		StopPrice:     stopPrice,
+		Status:        status,
+	}
+
+	c.orders[leg.ID] = leg
+	c.legs[parent.ID] = append(c.legs[parent.ID], leg.ID)
+	c.parents[leg.ID] = parent.ID
+	if status == OrderStatusNew {
+		c.emit(TradeEventNew, leg)
+	}
+}
+
+// withLegs returns a copy of order with its current legs attached. Callers
+// must hold c.mu.
+func (c *MockClient) withLegs(order *Order) *Order {
+	out := *order
+	out.Legs = nil
+	for _, id := range c.legs[order.ID] {
+		out.Legs = append(out.Legs, *c.orders[id])
+	}
+	return &out
+}
+
+// fill fills order in full and applies the order class rules: a filled
+// entry releases its held legs, and a filled exit cancels the exits linked
+// to it. Callers must hold c.mu.
+func (c *MockClient) fill(order *Order, qty, price string) {
+	now := time.Now()
+	order.Status = OrderStatusFilled
+	order.FilledQty = qty
+	order.FilledAvgPrice = price
+	order.FilledAt = &now
+	order.UpdatedAt = now
+
+	// Update positions for filled orders
+	c.updatePosition(order)
+	c.emit(TradeEventFill, order)
+
+	for _, id := range c.legs[order.ID] {
+		leg := c.orders[id]
+		if leg.Status == OrderStatusHeld {
+			leg.Status = OrderStatusNew
+			leg.Qty = order.FilledQty
+			leg.UpdatedAt = now
+			c.emit(TradeEventNew, leg)
+		}
+	}
+	c.cancelLinked(order)
+}
+
+// cancel cancels order and the orders that cannot fill without it. Callers
+// must hold c.mu.
+func (c *MockClient) cancel(order *Order) {
+	now := time.Now()
+	order.Status = OrderStatusCanceled
+	order.CanceledAt = &now
+	order.UpdatedAt = now
+	c.emit(TradeEventCanceled, order)
+
+	// Held legs can never be released once their entry is canceled
+	for _, id := range c.legs[order.ID] {
+		if leg := c.orders[id]; leg.Status == OrderStatusHeld {
+			c.cancel(leg)
+		}
+	}
+	c.cancelLinked(order)
+}
+
+// cancelLinked cancels the open exits that are one-cancels-other with order:
+// the other legs of its parent, and the parent itself or the order's own legs
+// when they form an OCO pair. Callers must hold c.mu.
+func (c *MockClient) cancelLinked(order *Order) {
+	var linked []string
+	if parentID, ok := c.parents[order.ID]; ok {
+		linked = append(linked, c.legs[parentID]...)
+		if c.orders[parentID].OrderClass == OCO {
+			linked = append(linked, parentID)
+		}
+	} else if order.OrderClass == OCO {
+		linked = c.legs[order.ID]
+	}
+
+	for _, id := range linked {
+		other := c.orders[id]
+		if id == order.ID || other.Status == OrderStatusHeld {
+			continue
+		}
+		if other.Status == OrderStatusNew || other.Status == OrderStatusPartiallyFilled {
+			c.cancel(other)
+		}
+	}
+}
+
+// FillOrder fills an open order at price (for testing)
+func (c *MockClient) FillOrder(orderID, price string) (*Order, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	order, exists := c.orders[orderID]
+	if !exists {
+		return nil, fmt.Errorf("order not found: %s", orderID)
+	}
+	if order.Status != OrderStatusNew && order.Status != OrderStatusPartiallyFilled {
+		return nil, fmt.Errorf("order is not open: %s", order.Status)
+	}
+
+	c.fill(order, order.Qty, price)
+	return c.withLegs(order), nil
 }
 
 // updatePosition updates the mock position after an order fill
@@ -128,7 +307,7 @@ func (c *MockClient) GetOrder(ctx context.Context, orderID string) (*Order, erro
 	if !exists {
 		return nil, fmt.Errorf("order not found: %s", orderID)
 	}
-	return order, nil
+	return c.withLegs(order), nil
 }
 
 // GetOrderByClientID retrieves a mock order by client order ID
@@ -138,7 +317,7 @@ func (c *MockClient) GetOrderByClientID(ctx context.Context, clientOrderID strin
 
 	for _, order := range c.orders {
 		if order.ClientOrderID == clientOrderID {
-			return order, nil
+			return c.withLegs(order), nil
 		}
 	}
 	return nil, fmt.Errorf("order not found with client ID: %s", clientOrderID)
@@ -178,9 +357,7 @@ func (c *MockClient) CancelOrder(ctx context.Context, orderID string) error {
 		return fmt.Errorf("cannot cancel filled order")
 	}
 
-	order.Status = OrderStatusCanceled
-	now := time.Now()
-	order.CanceledAt = &now
+	c.cancel(order)
 	return nil
 }
 
@@ -194,6 +371,7 @@ func (c *MockClient) CancelAllOrders(ctx context.Context) error {
 		if order.Status != OrderStatusFilled {
 			order.Status = OrderStatusCanceled
 			order.CanceledAt = &now
+			c.emit(TradeEventCanceled, order)
 		}
 	}
 	return nil
@@ -240,9 +418,53 @@ func (c *MockClient) ReplaceOrder(ctx context.Context, orderID string, req *Repl
 	order.ReplacedAt = &now
 
 	c.orders[newOrderID] = newOrder
+	c.emit(TradeEventReplaced, order)
+	c.emit(TradeEventNew, newOrder)
 	return newOrder, nil
 }
 
+// StreamTradeUpdates delivers mock order events until ctx is canceled
+func (c *MockClient) StreamTradeUpdates(ctx context.Context, handler TradeUpdateHandler) error {
+	updates := make(chan TradeUpdate, 100)
+
+	c.mu.Lock()
+	c.subscribers[updates] = struct{}{}
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.subscribers, updates)
+		c.mu.Unlock()
+	}()
+
+	handler.OnListening()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case update := <-updates:
+			handler.OnTradeUpdate(&update)
+		}
+	}
+}
+
+// emit sends an order event to stream subscribers. Callers must hold c.mu.
+func (c *MockClient) emit(event string, order *Order) {
+	update := TradeUpdate{
+		Event:     event,
+		Order:     *order,
+		Timestamp: time.Now(),
+	}
+	for ch := range c.subscribers {
+		select {
+		case ch <- update:
+		default:
+			// Drop updates for slow subscribers rather than block orders
+		}
+	}
+}
+
 // ListPositions returns all mock positions
 func (c *MockClient) ListPositions(ctx context.Context) ([]Position, error) {
 	c.mu.RLock()
@@ -55,8 +55,31 @@ const (
 	OrderStatusRejected        OrderStatus = "rejected"
 	OrderStatusSuspended       OrderStatus = "suspended"
 	OrderStatusCalculated      OrderStatus = "calculated"
+	OrderStatusHeld            OrderStatus = "held"
 )
 
+// OrderClass represents how an order is linked to other orders
+type OrderClass string
+
+const (
+	Simple  OrderClass = "simple"  // A single standalone order
+	Bracket OrderClass = "bracket" // An entry with a take-profit and a stop-loss exit
+	OCO     OrderClass = "oco"     // A take-profit and a stop-loss exit; one fill cancels the other
+	OTO     OrderClass = "oto"     // An entry with a single take-profit or stop-loss exit
+)
+
+// TakeProfit is the limit exit leg of an advanced order
+type TakeProfit struct {
+	LimitPrice string `json:"limit_price"`
+}
+
+// StopLoss is the stop exit leg of an advanced order. A limit price makes
+// the leg a stop-limit order.
+type StopLoss struct {
+	StopPrice  string `json:"stop_price"`
+	LimitPrice string `json:"limit_price,omitempty"`
+}
+
 // CreateOrderRequest represents an order submission request
 type CreateOrderRequest struct {
 	Symbol        string      `json:"symbol"`
@@ -69,6 +92,9 @@ type CreateOrderRequest struct {
 	StopPrice     string      `json:"stop_price,omitempty"`
 	ClientOrderID string      `json:"client_order_id,omitempty"`
 	ExtendedHours bool        `json:"extended_hours,omitempty"`
+	OrderClass    OrderClass  `json:"order_class,omitempty"`
+	TakeProfit    *TakeProfit `json:"take_profit,omitempty"`
+	StopLoss      *StopLoss   `json:"stop_loss,omitempty"`
 }
 
 // Order represents an Alpaca order
@@ -91,7 +117,7 @@ type Order struct {
 	Qty            string      `json:"qty"`
 	FilledQty      string      `json:"filled_qty"`
 	FilledAvgPrice string      `json:"filled_avg_price"`
-	OrderClass     string      `json:"order_class"`
+	OrderClass     OrderClass  `json:"order_class"`
 	OrderType      OrderType   `json:"type"`
 	Side           OrderSide   `json:"side"`
 	TimeInForce    TimeInForce `json:"time_in_force"`
@@ -100,6 +126,7 @@ type Order struct {
 	Status         OrderStatus `json:"status"`
 	ExtendedHours  bool        `json:"extended_hours"`
 	Notional       string      `json:"notional"`
+	Legs           []Order     `json:"legs"` // Exit legs of bracket, OCO and OTO orders
 }
 
 // CreateOrder submits a new order to Alpaca
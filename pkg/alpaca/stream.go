@@ -0,0 +1,128 @@
+package alpaca
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+// Trade update events sent on the trade_updates stream
+const (
+	TradeEventNew         = "new"
+	TradeEventFill        = "fill"
+	TradeEventPartialFill = "partial_fill"
+	TradeEventCanceled    = "canceled"
+	TradeEventExpired     = "expired"
+	TradeEventDoneForDay  = "done_for_day"
+	TradeEventReplaced    = "replaced"
+	TradeEventRejected    = "rejected"
+)
+
+// TradeUpdate is an order event from the trade_updates stream
+type TradeUpdate struct {
+	Event       string    `json:"event"`
+	ExecutionID string    `json:"execution_id,omitempty"`
+	Order       Order     `json:"order"`
+	Timestamp   time.Time `json:"timestamp"`
+	Price       string    `json:"price,omitempty"`
+	Qty         string    `json:"qty,omitempty"`
+	PositionQty string    `json:"position_qty,omitempty"`
+}
+
+// TradeUpdateHandler receives events from the trade_updates stream
+type TradeUpdateHandler interface {
+	// OnListening is called once the stream is authenticated and subscribed
+	OnListening()
+	// OnTradeUpdate is called for every order event, in stream order
+	OnTradeUpdate(update *TradeUpdate)
+}
+
+// streamMessage is the envelope of every message on the stream
+type streamMessage struct {
+	Stream string          `json:"stream"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// streamURL returns the websocket URL of the account stream
+func (c *Client) streamURL() string {
+	return "ws" + strings.TrimPrefix(c.baseURL, "http") + "/stream"
+}
+
+// StreamTradeUpdates connects to the trade_updates stream and delivers
+// events to handler. It blocks until ctx is canceled or the connection
+// drops, and returns the error that ended the stream.
+func (c *Client) StreamTradeUpdates(ctx context.Context, handler TradeUpdateHandler) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.streamURL(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to trade updates stream: %w", err)
+	}
+	defer conn.Close()
+
+	// Unblock reads when the caller gives up on the stream
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err := conn.WriteJSON(map[string]string{
+		"action": "auth",
+		"key":    c.config.APIKey,
+		"secret": c.config.SecretKey,
+	}); err != nil {
+		return fmt.Errorf("failed to authenticate stream: %w", err)
+	}
+
+	var auth streamMessage
+	if err := conn.ReadJSON(&auth); err != nil {
+		return streamError(ctx, err)
+	}
+	var authData struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(auth.Data, &authData); err != nil || auth.Stream != "authorization" || authData.Status != "authorized" {
+		return fmt.Errorf("trade updates stream authorization failed: %s", string(auth.Data))
+	}
+
+	if err := conn.WriteJSON(map[string]any{
+		"action": "listen",
+		"data":   map[string][]string{"streams": {"trade_updates"}},
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to trade updates: %w", err)
+	}
+
+	for {
+		// Alpaca sends binary frames; ReadJSON accepts both frame types
+		var msg streamMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			return streamError(ctx, err)
+		}
+
+		switch msg.Stream {
+		case "listening":
+			handler.OnListening()
+		case "trade_updates":
+			var update TradeUpdate
+			if err := json.Unmarshal(msg.Data, &update); err != nil {
+				return fmt.Errorf("failed to decode trade update: %w", err)
+			}
+			handler.OnTradeUpdate(&update)
+		}
+	}
+}
+
+// streamError prefers the context error when the stream was closed on purpose
+func streamError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("trade updates stream closed: %w", err)
+}
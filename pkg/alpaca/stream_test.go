@@ -0,0 +1,165 @@
+package alpaca
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+)
+
+type recordingHandler struct {
+	listening chan struct{}
+	updates   chan TradeUpdate
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{
+		listening: make(chan struct{}, 1),
+		updates:   make(chan TradeUpdate, 10),
+	}
+}
+
+func (h *recordingHandler) OnListening() {
+	h.listening <- struct{}{}
+}
+
+func (h *recordingHandler) OnTradeUpdate(update *TradeUpdate) {
+	h.updates <- *update
+}
+
+func (h *recordingHandler) next(t *testing.T) TradeUpdate {
+	t.Helper()
+	select {
+	case u := <-h.updates:
+		return u
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for trade update")
+		return TradeUpdate{}
+	}
+}
+
+func TestClient_StreamTradeUpdates(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stream" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var auth map[string]string
+		if err := conn.ReadJSON(&auth); err != nil || auth["key"] != "test-key" {
+			conn.WriteJSON(map[string]any{"stream": "authorization", "data": map[string]string{"status": "unauthorized"}})
+			return
+		}
+		conn.WriteJSON(map[string]any{"stream": "authorization", "data": map[string]string{"status": "authorized", "action": "authenticate"}})
+
+		var listen map[string]any
+		if err := conn.ReadJSON(&listen); err != nil {
+			return
+		}
+		conn.WriteJSON(map[string]any{"stream": "listening", "data": map[string][]string{"streams": {"trade_updates"}}})
+		conn.WriteJSON(map[string]any{
+			"stream": "trade_updates",
+			"data": map[string]any{
+				"event":        "fill",
+				"execution_id": "exec-1",
+				"order":        map[string]string{"id": "order-1", "status": "filled", "filled_qty": "2"},
+			},
+		})
+
+		// Hold the connection open until the client goes away
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	client := NewClient(&Config{APIKey: "test-key", SecretKey: "test-secret", BaseURL: server.URL})
+	handler := newRecordingHandler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.StreamTradeUpdates(ctx, handler) }()
+
+	select {
+	case <-handler.listening:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for subscription")
+	}
+
+	update := handler.next(t)
+	if update.Event != TradeEventFill {
+		t.Errorf("Event = %v, want %v", update.Event, TradeEventFill)
+	}
+	if update.Order.ID != "order-1" || update.Order.FilledQty != "2" {
+		t.Errorf("Order = %+v, want id order-1 with filled_qty 2", update.Order)
+	}
+
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Errorf("StreamTradeUpdates() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestClient_StreamTradeUpdates_Unauthorized(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var auth map[string]string
+		conn.ReadJSON(&auth)
+		conn.WriteJSON(map[string]any{"stream": "authorization", "data": map[string]string{"status": "unauthorized"}})
+	}))
+	defer server.Close()
+
+	client := NewClient(&Config{APIKey: "bad-key", SecretKey: "bad-secret", BaseURL: server.URL})
+	if err := client.StreamTradeUpdates(context.Background(), newRecordingHandler()); err == nil {
+		t.Error("StreamTradeUpdates() expected authorization error")
+	}
+}
+
+func TestMockClient_StreamTradeUpdates(t *testing.T) {
+	client := NewMockClient()
+	handler := newRecordingHandler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.StreamTradeUpdates(ctx, handler)
+
+	select {
+	case <-handler.listening:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for subscription")
+	}
+
+	order, err := client.CreateOrder(ctx, &CreateOrderRequest{
+		Symbol:      "AAPL",
+		Qty:         "1",
+		Side:        Buy,
+		Type:        Market,
+		TimeInForce: Day,
+	})
+	if err != nil {
+		t.Fatalf("CreateOrder() error = %v", err)
+	}
+
+	for _, want := range []string{TradeEventNew, TradeEventFill} {
+		update := handler.next(t)
+		if update.Event != want {
+			t.Errorf("Event = %v, want %v", update.Event, want)
+		}
+		if update.Order.ID != order.ID {
+			t.Errorf("Order.ID = %v, want %v", update.Order.ID, order.ID)
+		}
+	}
+}
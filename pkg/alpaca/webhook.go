@@ -0,0 +1,57 @@
+package alpaca
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Headers carried by signed order webhook deliveries
+const (
+	WebhookSignatureHeader = "X-Alpaca-Signature"
+	WebhookTimestampHeader = "X-Alpaca-Timestamp"
+	WebhookDeliveryHeader  = "X-Alpaca-Delivery-ID"
+)
+
+// Webhook verification errors
+var (
+	ErrWebhookUnsigned  = errors.New("webhook signature or timestamp missing")
+	ErrWebhookTimestamp = errors.New("webhook timestamp outside tolerance")
+	ErrWebhookSignature = errors.New("webhook signature mismatch")
+)
+
+// SignWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>" keyed
+// with secret. The timestamp is in unix seconds.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a delivery's signature and rejects timestamps more
+// than tolerance away from now, so captured deliveries cannot be replayed
+func VerifyWebhook(secret, signature, timestamp string, body []byte, tolerance time.Duration, now time.Time) error {
+	if signature == "" || timestamp == "" {
+		return ErrWebhookUnsigned
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrWebhookTimestamp
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > tolerance || skew < -tolerance {
+		return ErrWebhookTimestamp
+	}
+
+	expected := SignWebhook(secret, ts, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrWebhookSignature
+	}
+
+	return nil
+}
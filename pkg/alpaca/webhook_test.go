@@ -0,0 +1,93 @@
+package alpaca
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifyWebhook(t *testing.T) {
+	secret := "webhook-secret"
+	body := []byte(`{"event":"fill","order":{"id":"order-1"}}`)
+	now := time.Unix(1700000000, 0)
+	ts := now.Unix()
+	sig := SignWebhook(secret, ts, body)
+
+	tests := []struct {
+		name      string
+		signature string
+		timestamp string
+		body      []byte
+		wantErr   error
+	}{
+		{
+			name:      "valid signature",
+			signature: sig,
+			timestamp: strconv.FormatInt(ts, 10),
+			body:      body,
+		},
+		{
+			name:      "within tolerance",
+			signature: SignWebhook(secret, ts-240, body),
+			timestamp: strconv.FormatInt(ts-240, 10),
+			body:      body,
+		},
+		{
+			name:      "missing signature",
+			timestamp: strconv.FormatInt(ts, 10),
+			body:      body,
+			wantErr:   ErrWebhookUnsigned,
+		},
+		{
+			name:      "missing timestamp",
+			signature: sig,
+			body:      body,
+			wantErr:   ErrWebhookUnsigned,
+		},
+		{
+			name:      "malformed timestamp",
+			signature: sig,
+			timestamp: "yesterday",
+			body:      body,
+			wantErr:   ErrWebhookTimestamp,
+		},
+		{
+			name:      "stale timestamp",
+			signature: SignWebhook(secret, ts-600, body),
+			timestamp: strconv.FormatInt(ts-600, 10),
+			body:      body,
+			wantErr:   ErrWebhookTimestamp,
+		},
+		{
+			name:      "future timestamp",
+			signature: SignWebhook(secret, ts+600, body),
+			timestamp: strconv.FormatInt(ts+600, 10),
+			body:      body,
+			wantErr:   ErrWebhookTimestamp,
+		},
+		{
+			name:      "tampered body",
+			signature: sig,
+			timestamp: strconv.FormatInt(ts, 10),
+			body:      []byte(`{"event":"fill","order":{"id":"order-2"}}`),
+			wantErr:   ErrWebhookSignature,
+		},
+		{
+			name:      "wrong secret",
+			signature: SignWebhook("other-secret", ts, body),
+			timestamp: strconv.FormatInt(ts, 10),
+			body:      body,
+			wantErr:   ErrWebhookSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyWebhook(secret, tt.signature, tt.timestamp, tt.body, 5*time.Minute, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyWebhook() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
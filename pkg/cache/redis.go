@@ -48,6 +48,11 @@ func (c *RedisCache) Get(ctx context.Context, key string) (string, error) {
 	return val, err
 }
 
+// SetNX sets key only if it does not exist and reports whether it was set
+func (c *RedisCache) SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, value, ttl).Result()
+}
+
 func (c *RedisCache) Delete(ctx context.Context, key string) error {
 	return c.client.Del(ctx, key).Err()
 }
@@ -60,6 +65,22 @@ func (c *RedisCache) Expire(ctx context.Context, key string, ttl time.Duration)
 	return c.client.Expire(ctx, key, ttl).Err()
 }
 
+// HSet sets a field of the hash stored at key
+func (c *RedisCache) HSet(ctx context.Context, key, field, value string) error {
+	return c.client.HSet(ctx, key, field, value).Err()
+}
+
+// HGetAll returns every field of the hash stored at key, or an empty map if
+// it does not exist
+func (c *RedisCache) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return c.client.HGetAll(ctx, key).Result()
+}
+
+// HDel removes a field from the hash stored at key
+func (c *RedisCache) HDel(ctx context.Context, key, field string) error {
+	return c.client.HDel(ctx, key, field).Err()
+}
+
 func (c *RedisCache) Close() error {
 	return c.client.Close()
 }
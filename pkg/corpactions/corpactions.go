@@ -0,0 +1,98 @@
+package corpactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Action types
+const (
+	TypeCashDividend = "cash_dividend"
+	TypeStockSplit   = "stock_split"
+	TypeSymbolChange = "symbol_change"
+)
+
+// DateLayout is the layout of action dates in imports
+const DateLayout = "2006-01-02"
+
+// ErrInvalidAction is returned for an action missing the fields its type needs
+var ErrInvalidAction = errors.New("invalid corporate action")
+
+// Action is a corporate action announced for a symbol. Holders on the ex
+// date are entitled to it; dividends are paid on the payable date.
+type Action struct {
+	ID          string    `json:"id"` // Provider's ID, unique per action
+	Type        string    `json:"type"`
+	Symbol      string    `json:"symbol"`
+	NewSymbol   string    `json:"new_symbol,omitempty"` // Symbol changes
+	Rate        float64   `json:"rate,omitempty"`       // Cash per share, for dividends
+	SplitFrom   float64   `json:"split_from,omitempty"` // Old shares, for splits
+	SplitTo     float64   `json:"split_to,omitempty"`   // New shares they become
+	ExDate      time.Time `json:"ex_date"`
+	PayableDate time.Time `json:"payable_date,omitempty"` // Dividends
+}
+
+// Validate checks that an action has the fields its type needs
+func (a *Action) Validate() error {
+	if a.ID == "" || a.Symbol == "" || a.ExDate.IsZero() {
+		return fmt.Errorf("%w: id, symbol and ex_date are required", ErrInvalidAction)
+	}
+
+	switch a.Type {
+	case TypeCashDividend:
+		if a.Rate <= 0 || a.PayableDate.IsZero() {
+			return fmt.Errorf("%w: dividend %s needs a rate and payable_date", ErrInvalidAction, a.ID)
+		}
+		if a.PayableDate.Before(a.ExDate) {
+			return fmt.Errorf("%w: dividend %s is payable before its ex date", ErrInvalidAction, a.ID)
+		}
+	case TypeStockSplit:
+		if a.SplitFrom <= 0 || a.SplitTo <= 0 {
+			return fmt.Errorf("%w: split %s needs split_from and split_to", ErrInvalidAction, a.ID)
+		}
+	case TypeSymbolChange:
+		if a.NewSymbol == "" || a.NewSymbol == a.Symbol {
+			return fmt.Errorf("%w: symbol change %s needs a new symbol", ErrInvalidAction, a.ID)
+		}
+	default:
+		return fmt.Errorf("%w: unknown type %q", ErrInvalidAction, a.Type)
+	}
+	return nil
+}
+
+// Provider supplies announced corporate actions
+type Provider interface {
+	// Actions returns the actions with an ex date from one date to another,
+	// inclusive
+	Actions(ctx context.Context, from, to time.Time) ([]Action, error)
+}
+
+// Dividend is the cash paid on a holding
+type Dividend struct {
+	Gross       float64 `json:"gross"`
+	Withholding float64 `json:"withholding"` // Tax withheld at source
+	Net         float64 `json:"net"`         // Credited to the holder
+}
+
+// ComputeDividend prices the dividend on qty shares at rate per share, less
+// withholding tax at withholdingRate (e.g. 0.3). Amounts are in cents: the
+// gross is rounded down and the tax rounded to the nearest cent.
+func ComputeDividend(qty, rate, withholdingRate float64) Dividend {
+	gross := math.Floor(qty*rate*100+1e-6) / 100
+	tax := math.Round(gross*withholdingRate*100) / 100
+	return Dividend{Gross: gross, Withholding: tax, Net: math.Round((gross-tax)*100) / 100}
+}
+
+// ApplySplit returns a holding's quantity and average cost after a split of
+// from shares into to. The total cost is unchanged; the quantity is rounded
+// down to 8 decimal places and the average cost to 4, as holdings store them.
+func ApplySplit(qty, avgCost, from, to float64) (float64, float64) {
+	newQty := math.Floor(qty*to/from*1e8+1e-4) / 1e8
+	if newQty == 0 {
+		return 0, 0
+	}
+	return newQty, math.Round(qty*avgCost/newQty*1e4) / 1e4
+}
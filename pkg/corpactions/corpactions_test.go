@@ -0,0 +1,98 @@
+package corpactions
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(DateLayout, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestAction_Validate(t *testing.T) {
+	tests := []struct {
+		name   string
+		action Action
+		valid  bool
+	}{
+		{"dividend", Action{ID: "1", Type: TypeCashDividend, Symbol: "AAPL", Rate: 0.24, ExDate: date("2026-02-09"), PayableDate: date("2026-02-12")}, true},
+		{"dividend without rate", Action{ID: "1", Type: TypeCashDividend, Symbol: "AAPL", ExDate: date("2026-02-09"), PayableDate: date("2026-02-12")}, false},
+		{"dividend paid before ex date", Action{ID: "1", Type: TypeCashDividend, Symbol: "AAPL", Rate: 0.24, ExDate: date("2026-02-09"), PayableDate: date("2026-02-01")}, false},
+		{"split", Action{ID: "2", Type: TypeStockSplit, Symbol: "NVDA", SplitFrom: 1, SplitTo: 10, ExDate: date("2026-06-10")}, true},
+		{"split without ratio", Action{ID: "2", Type: TypeStockSplit, Symbol: "NVDA", SplitTo: 10, ExDate: date("2026-06-10")}, false},
+		{"symbol change", Action{ID: "3", Type: TypeSymbolChange, Symbol: "FB", NewSymbol: "META", ExDate: date("2026-06-09")}, true},
+		{"symbol change to itself", Action{ID: "3", Type: TypeSymbolChange, Symbol: "FB", NewSymbol: "FB", ExDate: date("2026-06-09")}, false},
+		{"missing ex date", Action{ID: "4", Type: TypeStockSplit, Symbol: "NVDA", SplitFrom: 1, SplitTo: 10}, false},
+		{"unknown type", Action{ID: "5", Type: "merger", Symbol: "X", ExDate: date("2026-06-09")}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.action.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidAction) {
+				t.Errorf("Validate() = %v, want ErrInvalidAction", err)
+			}
+		})
+	}
+}
+
+func TestComputeDividend(t *testing.T) {
+	d := ComputeDividend(10.5, 0.24, 0.3)
+	if d.Gross != 2.52 || d.Withholding != 0.76 || d.Net != 1.76 {
+		t.Errorf("ComputeDividend = %+v, want gross 2.52, withholding 0.76, net 1.76", d)
+	}
+
+	// Fractions of a cent are not paid
+	d = ComputeDividend(0.01, 0.24, 0.3)
+	if d.Gross != 0 || d.Net != 0 {
+		t.Errorf("ComputeDividend on a tiny holding = %+v, want nothing", d)
+	}
+
+	d = ComputeDividend(100, 0.5, 0)
+	if d.Net != 50 || d.Withholding != 0 {
+		t.Errorf("ComputeDividend without tax = %+v, want net 50", d)
+	}
+}
+
+func TestApplySplit(t *testing.T) {
+	qty, cost := ApplySplit(3, 900, 1, 10)
+	if qty != 30 || cost != 90 {
+		t.Errorf("10-for-1 split = %v @ %v, want 30 @ 90", qty, cost)
+	}
+
+	// Reverse split keeps fractional shares, rounded down
+	qty, cost = ApplySplit(7, 2, 10, 1)
+	if qty != 0.7 || cost != 20 {
+		t.Errorf("1-for-10 reverse split = %v @ %v, want 0.7 @ 20", qty, cost)
+	}
+
+	qty, cost = ApplySplit(0.5, 30, 2, 3)
+	if qty != 0.75 || cost != 20 {
+		t.Errorf("3-for-2 split = %v @ %v, want 0.75 @ 20", qty, cost)
+	}
+}
+
+func TestMockProvider_Actions(t *testing.T) {
+	p := NewMockProvider(
+		Action{ID: "1", ExDate: date("2026-01-05")},
+		Action{ID: "2", ExDate: date("2026-01-10")},
+	)
+	p.Add(Action{ID: "3", ExDate: date("2026-01-20")})
+
+	actions, err := p.Actions(context.Background(), date("2026-01-05"), date("2026-01-10"))
+	if err != nil {
+		t.Fatalf("Actions failed: %v", err)
+	}
+	if len(actions) != 2 || actions[0].ID != "1" || actions[1].ID != "2" {
+		t.Errorf("Actions = %+v, want 1 and 2", actions)
+	}
+}
@@ -0,0 +1,139 @@
+package corpactions
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FileProvider reads actions from a CSV file with a header row naming the
+// columns id, type, symbol, new_symbol, rate, split_from, split_to, ex_date
+// and payable_date. Only the columns an action's type needs must be filled;
+// dates are YYYY-MM-DD. The file is read on every call, so actions can be
+// added to it while the service runs.
+type FileProvider struct {
+	path string
+}
+
+// NewFileProvider creates a provider reading the CSV file at path
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{path: path}
+}
+
+// Actions returns the actions in the file with an ex date in the range
+func (p *FileProvider) Actions(ctx context.Context, from, to time.Time) ([]Action, error) {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open corporate actions file: %w", err)
+	}
+	defer f.Close()
+
+	actions, err := ParseCSV(f)
+	if err != nil {
+		return nil, err
+	}
+	return inRange(actions, from, to), nil
+}
+
+// ParseCSV parses and validates corporate actions in the CSV format read by
+// FileProvider
+func ParseCSV(r io.Reader) ([]Action, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read corporate actions header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	var actions []Action
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read corporate actions line %d: %w", line, err)
+		}
+
+		a, err := parseRecord(record, columns)
+		if err == nil {
+			err = a.Validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		actions = append(actions, *a)
+	}
+	return actions, nil
+}
+
+func parseRecord(record []string, columns map[string]int) (*Action, error) {
+	field := func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	number := func(name string) (float64, error) {
+		s := field(name)
+		if s == "" {
+			return 0, nil
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s %q", ErrInvalidAction, name, s)
+		}
+		return v, nil
+	}
+	date := func(name string) (time.Time, error) {
+		s := field(name)
+		if s == "" {
+			return time.Time{}, nil
+		}
+		t, err := time.Parse(DateLayout, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %s %q", ErrInvalidAction, name, s)
+		}
+		return t, nil
+	}
+
+	a := &Action{
+		ID:        field("id"),
+		Type:      strings.ToLower(field("type")),
+		Symbol:    strings.ToUpper(field("symbol")),
+		NewSymbol: strings.ToUpper(field("new_symbol")),
+	}
+
+	var err error
+	if a.Rate, err = number("rate"); err != nil {
+		return nil, err
+	}
+	if a.SplitFrom, err = number("split_from"); err != nil {
+		return nil, err
+	}
+	if a.SplitTo, err = number("split_to"); err != nil {
+		return nil, err
+	}
+	if a.ExDate, err = date("ex_date"); err != nil {
+		return nil, err
+	}
+	if a.PayableDate, err = date("payable_date"); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
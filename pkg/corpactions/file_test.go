@@ -0,0 +1,75 @@
+package corpactions
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleCSV = `id,type,symbol,new_symbol,rate,split_from,split_to,ex_date,payable_date
+div-1,cash_dividend,aapl,,0.24,,,2026-02-09,2026-02-12
+split-1,stock_split,NVDA,,,1,10,2026-06-10,
+chg-1,symbol_change,FB,meta,,,,2026-06-09,
+`
+
+func TestParseCSV(t *testing.T) {
+	actions, err := ParseCSV(strings.NewReader(sampleCSV))
+	if err != nil {
+		t.Fatalf("ParseCSV failed: %v", err)
+	}
+	if len(actions) != 3 {
+		t.Fatalf("got %d actions, want 3", len(actions))
+	}
+
+	div := actions[0]
+	if div.Type != TypeCashDividend || div.Symbol != "AAPL" || div.Rate != 0.24 ||
+		!div.ExDate.Equal(date("2026-02-09")) || !div.PayableDate.Equal(date("2026-02-12")) {
+		t.Errorf("dividend = %+v", div)
+	}
+	if split := actions[1]; split.SplitFrom != 1 || split.SplitTo != 10 {
+		t.Errorf("split = %+v", split)
+	}
+	if change := actions[2]; change.NewSymbol != "META" {
+		t.Errorf("symbol change = %+v", change)
+	}
+}
+
+func TestParseCSV_Invalid(t *testing.T) {
+	tests := []string{
+		"id,type,symbol,rate,ex_date,payable_date\ndiv-1,cash_dividend,AAPL,abc,2026-02-09,2026-02-12\n",
+		"id,type,symbol,rate,ex_date,payable_date\ndiv-1,cash_dividend,AAPL,0.24,09/02/2026,2026-02-12\n",
+		"id,type,symbol,ex_date\nsplit-1,stock_split,NVDA,2026-06-10\n",
+	}
+	for _, csv := range tests {
+		if _, err := ParseCSV(strings.NewReader(csv)); !errors.Is(err, ErrInvalidAction) {
+			t.Errorf("ParseCSV(%q) error = %v, want ErrInvalidAction", csv, err)
+		}
+	}
+
+	if actions, err := ParseCSV(strings.NewReader("")); err != nil || actions != nil {
+		t.Errorf("ParseCSV(\"\") = %v, %v, want no actions", actions, err)
+	}
+}
+
+func TestFileProvider_Actions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "actions.csv")
+	if err := os.WriteFile(path, []byte(sampleCSV), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewFileProvider(path)
+	actions, err := p.Actions(context.Background(), date("2026-06-01"), date("2026-06-30"))
+	if err != nil {
+		t.Fatalf("Actions failed: %v", err)
+	}
+	if len(actions) != 2 || actions[0].ID != "split-1" || actions[1].ID != "chg-1" {
+		t.Errorf("Actions = %+v, want split-1 and chg-1", actions)
+	}
+
+	if _, err := NewFileProvider(filepath.Join(t.TempDir(), "missing.csv")).Actions(context.Background(), date("2026-01-01"), date("2026-12-31")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -0,0 +1,45 @@
+package corpactions
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MockProvider is a provider backed by actions added in memory, for local
+// development and testing
+type MockProvider struct {
+	mu      sync.RWMutex
+	actions []Action
+}
+
+// NewMockProvider creates a mock provider announcing the given actions
+func NewMockProvider(actions ...Action) *MockProvider {
+	return &MockProvider{actions: actions}
+}
+
+// Add announces an action
+func (p *MockProvider) Add(a Action) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.actions = append(p.actions, a)
+}
+
+// Actions returns the announced actions with an ex date in the range
+func (p *MockProvider) Actions(ctx context.Context, from, to time.Time) ([]Action, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return inRange(p.actions, from, to), nil
+}
+
+// inRange returns the actions with an ex date from one date to another,
+// inclusive
+func inRange(actions []Action, from, to time.Time) []Action {
+	var matched []Action
+	for _, a := range actions {
+		if !a.ExDate.Before(from) && !a.ExDate.After(to) {
+			matched = append(matched, a)
+		}
+	}
+	return matched
+}
@@ -342,6 +342,18 @@ var (
 		Message:    "Daily transaction limit exceeded",
 		HTTPStatus: http.StatusBadRequest,
 	}
+
+	ErrFXQuoteNotFound = &AppError{
+		Code:       "PAYMENT_FX_QUOTE_NOT_FOUND",
+		Message:    "Exchange rate quote not found",
+		HTTPStatus: http.StatusNotFound,
+	}
+
+	ErrFXQuoteExpired = &AppError{
+		Code:       "PAYMENT_FX_QUOTE_EXPIRED",
+		Message:    "Exchange rate quote has expired or was already used",
+		HTTPStatus: http.StatusConflict,
+	}
 )
 
 // =============================================================================
@@ -414,6 +426,66 @@ var (
 		Message:    "Position not found",
 		HTTPStatus: http.StatusNotFound,
 	}
+
+	ErrOrderNotionalExceeded = &AppError{
+		Code:       "TRADING_ORDER_NOTIONAL_EXCEEDED",
+		Message:    "Order value exceeds the maximum allowed per order",
+		HTTPStatus: http.StatusBadRequest,
+	}
+
+	ErrSymbolRestricted = &AppError{
+		Code:       "TRADING_SYMBOL_RESTRICTED",
+		Message:    "Trading in this symbol is restricted",
+		HTTPStatus: http.StatusForbidden,
+	}
+
+	ErrSymbolNotFractionable = &AppError{
+		Code:       "TRADING_SYMBOL_NOT_FRACTIONABLE",
+		Message:    "Symbol does not support fractional or notional orders",
+		HTTPStatus: http.StatusBadRequest,
+	}
+
+	ErrTradingHalted = &AppError{
+		Code:       "TRADING_HALTED",
+		Message:    "Trading is temporarily halted",
+		HTTPStatus: http.StatusServiceUnavailable,
+	}
+
+	ErrSymbolHalted = &AppError{
+		Code:       "TRADING_SYMBOL_HALTED",
+		Message:    "Trading in this symbol is halted",
+		HTTPStatus: http.StatusConflict,
+	}
+
+	ErrSellOnly = &AppError{
+		Code:       "TRADING_SELL_ONLY",
+		Message:    "Only sell orders are accepted at the moment",
+		HTTPStatus: http.StatusConflict,
+	}
+
+	ErrAccountFrozen = &AppError{
+		Code:       "TRADING_ACCOUNT_FROZEN",
+		Message:    "Trading is frozen on your account",
+		HTTPStatus: http.StatusForbidden,
+	}
+
+	ErrQuoteStale = &AppError{
+		Code:       "TRADING_QUOTE_STALE",
+		Message:    "The latest price is too old to place a market order",
+		HTTPStatus: http.StatusConflict,
+	}
+
+	ErrSpreadTooWide = &AppError{
+		Code:       "TRADING_SPREAD_TOO_WIDE",
+		Message:    "The price spread is too wide for a market order; use a limit order",
+		HTTPStatus: http.StatusConflict,
+	}
+
+	ErrQuoteUnavailable = &AppError{
+		Code:       "TRADING_NO_QUOTE",
+		Message:    "There is no price to place a market order at; use a limit order",
+		HTTPStatus: http.StatusConflict,
+	}
 )
 
 // =============================================================================
@@ -152,6 +152,8 @@ func TestPredefinedErrors(t *testing.T) {
 		{"ErrMinimumAmount", ErrMinimumAmount, http.StatusBadRequest},
 		{"ErrMaximumAmount", ErrMaximumAmount, http.StatusBadRequest},
 		{"ErrDailyLimitExceeded", ErrDailyLimitExceeded, http.StatusBadRequest},
+		{"ErrFXQuoteNotFound", ErrFXQuoteNotFound, http.StatusNotFound},
+		{"ErrFXQuoteExpired", ErrFXQuoteExpired, http.StatusConflict},
 
 		// Trading errors
 		{"ErrTradingHoursClosed", ErrTradingHoursClosed, http.StatusBadRequest},
@@ -165,6 +167,16 @@ func TestPredefinedErrors(t *testing.T) {
 		{"ErrInvalidQuantity", ErrInvalidQuantity, http.StatusBadRequest},
 		{"ErrSymbolNotTradeable", ErrSymbolNotTradeable, http.StatusBadRequest},
 		{"ErrPositionNotFound", ErrPositionNotFound, http.StatusNotFound},
+		{"ErrOrderNotionalExceeded", ErrOrderNotionalExceeded, http.StatusBadRequest},
+		{"ErrSymbolRestricted", ErrSymbolRestricted, http.StatusForbidden},
+		{"ErrSymbolNotFractionable", ErrSymbolNotFractionable, http.StatusBadRequest},
+		{"ErrTradingHalted", ErrTradingHalted, http.StatusServiceUnavailable},
+		{"ErrSymbolHalted", ErrSymbolHalted, http.StatusConflict},
+		{"ErrSellOnly", ErrSellOnly, http.StatusConflict},
+		{"ErrAccountFrozen", ErrAccountFrozen, http.StatusForbidden},
+		{"ErrQuoteStale", ErrQuoteStale, http.StatusConflict},
+		{"ErrSpreadTooWide", ErrSpreadTooWide, http.StatusConflict},
+		{"ErrQuoteUnavailable", ErrQuoteUnavailable, http.StatusConflict},
 
 		// Provider errors
 		{"ErrMpesaUnavailable", ErrMpesaUnavailable, http.StatusServiceUnavailable},
@@ -108,6 +108,56 @@ const (
 	// Payload: OrderRejectedPayload
 	TopicOrderRejected = "equishare.orders.rejected"
 
+	// TopicOrderAmended is published when an open order is replaced with new terms
+	// Payload: OrderAmendedPayload
+	TopicOrderAmended = "equishare.orders.amended"
+
+	// TopicConditionalOrderTriggered is published when a conditional order's
+	// condition is met, with whether its order was placed
+	// Payload: ConditionalOrderTriggeredPayload
+	TopicConditionalOrderTriggered = "equishare.orders.conditional_triggered"
+
+	// Investment Plan Domain
+	// Published by: trading-service
+	// Consumed by: notification-service
+
+	// TopicPlanRun is published each time a recurring investment plan runs,
+	// whether the order was placed, retried, skipped or failed
+	// Payload: PlanRunPayload
+	TopicPlanRun = "equishare.plans.run"
+
+	// Corporate Action Domain
+	// Published by: trading-service
+	// Consumed by: notification-service, portfolio-service
+
+	// TopicCorporateActionApplied is published for each holder once a
+	// dividend is paid or a split or symbol change is applied to a holding
+	// Payload: CorporateActionAppliedPayload
+	TopicCorporateActionApplied = "equishare.corporate_actions.applied"
+
+	// Trading Control Domain
+	// Published by: trading-service
+	// Consumed by: ussd-service, notification-service, mobile clients
+
+	// TopicTradingHaltChanged is published when operations set or clear a
+	// global halt, symbol halt, sell-only mode or account freeze
+	// Payload: TradingHaltChangedPayload
+	TopicTradingHaltChanged = "equishare.trading.halts"
+
+	// TopicPositionDriftDetected is published when a position reconciliation
+	// finds user holdings that disagree with the broker's positions
+	// Payload: PositionDriftDetectedPayload
+	TopicPositionDriftDetected = "equishare.trading.position_drift"
+
+	// Account Domain
+	// Published by: trading-service
+	// Consumed by: user-service, notification-service
+
+	// TopicAccountClosureUpdated is published each time an account closure
+	// moves to a new step, and when the account is closed or the closure fails
+	// Payload: AccountClosureUpdatedPayload
+	TopicAccountClosureUpdated = "equishare.accounts.closure"
+
 	// Payment Domain
 	// Published by: payment-service
 	// Consumed by: notification-service, trading-service
@@ -208,6 +258,13 @@ var AllTopics = []string{
 	TopicOrderPartialFill,
 	TopicOrderCancelled,
 	TopicOrderRejected,
+	TopicOrderAmended,
+	TopicConditionalOrderTriggered,
+	TopicPlanRun,
+	TopicCorporateActionApplied,
+	TopicTradingHaltChanged,
+	TopicPositionDriftDetected,
+	TopicAccountClosureUpdated,
 	TopicPaymentInitiated,
 	TopicPaymentCompleted,
 	TopicPaymentFailed,
@@ -237,6 +294,23 @@ const (
 	EventTypeOrderPartialFill = "order.partial_fill.v1"
 	EventTypeOrderCancelled   = "order.cancelled.v1"
 	EventTypeOrderRejected    = "order.rejected.v1"
+	EventTypeOrderAmended     = "order.amended.v1"
+
+	// Conditional order events
+	EventTypeConditionalOrderTriggered = "conditional_order.triggered.v1"
+
+	// Investment plan events
+	EventTypePlanRun = "plan.run.v1"
+
+	// Corporate action events
+	EventTypeCorporateActionApplied = "corporate_action.applied.v1"
+
+	// Trading control events
+	EventTypeTradingHaltChanged    = "trading.halt.changed.v1"
+	EventTypePositionDriftDetected = "trading.position_drift.detected.v1"
+
+	// Account events
+	EventTypeAccountClosureUpdated = "account.closure.updated.v1"
 
 	// Payment events
 	EventTypePaymentInitiated = "payment.initiated.v1"
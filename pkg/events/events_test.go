@@ -15,6 +15,13 @@ func TestEventTopics(t *testing.T) {
 		{"TopicOrderPartialFill", TopicOrderPartialFill},
 		{"TopicOrderCancelled", TopicOrderCancelled},
 		{"TopicOrderRejected", TopicOrderRejected},
+		{"TopicOrderAmended", TopicOrderAmended},
+		{"TopicConditionalOrderTriggered", TopicConditionalOrderTriggered},
+		{"TopicPlanRun", TopicPlanRun},
+		{"TopicCorporateActionApplied", TopicCorporateActionApplied},
+		{"TopicTradingHaltChanged", TopicTradingHaltChanged},
+		{"TopicPositionDriftDetected", TopicPositionDriftDetected},
+		{"TopicAccountClosureUpdated", TopicAccountClosureUpdated},
 		{"TopicPaymentInitiated", TopicPaymentInitiated},
 		{"TopicPaymentCompleted", TopicPaymentCompleted},
 		{"TopicPaymentFailed", TopicPaymentFailed},
@@ -116,6 +123,13 @@ func TestEventTypes(t *testing.T) {
 	}{
 		{"EventTypeOrderCreated", EventTypeOrderCreated},
 		{"EventTypeOrderFilled", EventTypeOrderFilled},
+		{"EventTypeOrderAmended", EventTypeOrderAmended},
+		{"EventTypeConditionalOrderTriggered", EventTypeConditionalOrderTriggered},
+		{"EventTypePlanRun", EventTypePlanRun},
+		{"EventTypeCorporateActionApplied", EventTypeCorporateActionApplied},
+		{"EventTypeTradingHaltChanged", EventTypeTradingHaltChanged},
+		{"EventTypePositionDriftDetected", EventTypePositionDriftDetected},
+		{"EventTypeAccountClosureUpdated", EventTypeAccountClosureUpdated},
 		{"EventTypePaymentInitiated", EventTypePaymentInitiated},
 		{"EventTypePaymentCompleted", EventTypePaymentCompleted},
 		{"EventTypeKYCVerified", EventTypeKYCVerified},
@@ -69,6 +69,110 @@ type OrderRejectedPayload struct {
 	RejectReason string `json:"reject_reason"`
 }
 
+// OrderAmendedPayload is the payload for order.amended.v1 events
+type OrderAmendedPayload struct {
+	OrderID               string  `json:"order_id"`
+	UserID                string  `json:"user_id"`
+	Symbol                string  `json:"symbol"`
+	Qty                   float64 `json:"qty"`
+	LimitPrice            float64 `json:"limit_price,omitempty"`
+	StopPrice             float64 `json:"stop_price,omitempty"`
+	TimeInForce           string  `json:"time_in_force"`
+	AlpacaOrderID         string  `json:"alpaca_order_id"`
+	ReplacedAlpacaOrderID string  `json:"replaced_alpaca_order_id"`
+}
+
+// ConditionalOrderTriggeredPayload is the payload for
+// conditional_order.triggered.v1 events
+type ConditionalOrderTriggeredPayload struct {
+	ConditionalOrderID string    `json:"conditional_order_id"`
+	UserID             string    `json:"user_id"`
+	Symbol             string    `json:"symbol"`
+	Side               string    `json:"side"`
+	Condition          string    `json:"condition"`
+	Threshold          float64   `json:"threshold"`
+	TriggerPrice       float64   `json:"trigger_price"`
+	Status             string    `json:"status"` // placed, failed
+	OrderID            string    `json:"order_id,omitempty"`
+	Reason             string    `json:"reason,omitempty"`
+	TriggeredAt        time.Time `json:"triggered_at"`
+}
+
+// PlanRunPayload is the payload for plan.run.v1 events
+type PlanRunPayload struct {
+	PlanID       string    `json:"plan_id"`
+	UserID       string    `json:"user_id"`
+	Symbol       string    `json:"symbol"`
+	Amount       float64   `json:"amount"`
+	Currency     string    `json:"currency"`
+	Status       string    `json:"status"` // placed, retrying, skipped, failed
+	OrderID      string    `json:"order_id,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	NextRunAt    time.Time `json:"next_run_at"`
+}
+
+// CorporateActionAppliedPayload is the payload for
+// corporate_action.applied.v1 events
+type CorporateActionAppliedPayload struct {
+	ActionID    string  `json:"action_id"`
+	UserID      string  `json:"user_id"`
+	Type        string  `json:"type"` // cash_dividend, stock_split, symbol_change
+	Symbol      string  `json:"symbol"`
+	NewSymbol   string  `json:"new_symbol,omitempty"`
+	QtyBefore   float64 `json:"qty_before"`
+	QtyAfter    float64 `json:"qty_after"`
+	GrossAmount float64 `json:"gross_amount,omitempty"` // Dividends, in USD
+	Withholding float64 `json:"withholding,omitempty"`
+	NetAmount   float64 `json:"net_amount,omitempty"`
+	ExDate      string  `json:"ex_date"` // YYYY-MM-DD
+}
+
+// TradingHaltChangedPayload is the payload for trading.halt.changed.v1
+// events. Message is written for display to the affected users.
+type TradingHaltChangedPayload struct {
+	Action    string    `json:"action"` // set, cleared
+	Scope     string    `json:"scope"`  // global, symbol, sell_only, user
+	Symbol    string    `json:"symbol,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Message   string    `json:"message"`
+	Actor     string    `json:"actor"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// PositionDriftDetectedPayload is the payload for
+// trading.position_drift.detected.v1 events, an alert to operations
+type PositionDriftDetectedPayload struct {
+	ReconciliationID string          `json:"reconciliation_id"`
+	SymbolsChecked   int             `json:"symbols_checked"`
+	Drifts           []PositionDrift `json:"drifts"`
+	Message          string          `json:"message"`
+	DetectedAt       time.Time       `json:"detected_at"`
+}
+
+// PositionDrift is one symbol in a PositionDriftDetectedPayload
+type PositionDrift struct {
+	Symbol    string  `json:"symbol"`
+	LocalQty  float64 `json:"local_qty"`
+	BrokerQty float64 `json:"broker_qty"`
+	Drift     float64 `json:"drift"`
+}
+
+// AccountClosureUpdatedPayload is the payload for account.closure.updated.v1
+// events
+type AccountClosureUpdatedPayload struct {
+	ClosureID    string    `json:"closure_id"`
+	UserID       string    `json:"user_id"`
+	Status       string    `json:"status"` // canceling_orders, liquidating, awaiting_fills, converting, paying_out, awaiting_payout, closed, failed
+	ConvertedUSD float64   `json:"converted_usd"`
+	ConvertedKES float64   `json:"converted_kes"`
+	PaidOutKES   float64   `json:"paid_out_kes"`
+	PayoutKES    float64   `json:"payout_kes,omitempty"` // The M-Pesa payout in flight, while awaiting_payout
+	Reason       string    `json:"reason,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
 // PaymentInitiatedPayload is the payload for payment.initiated.v1 events
 type PaymentInitiatedPayload struct {
 	UserID            string  `json:"user_id"`
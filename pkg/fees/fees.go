@@ -0,0 +1,111 @@
+package fees
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidTiers is returned when a tier override list cannot be parsed
+var ErrInvalidTiers = errors.New("invalid fee tiers")
+
+// Schedule prices the commission on one trade. The fee is the flat amount
+// plus RateBps of the notional, raised to Minimum and capped at Maximum. A
+// zero Maximum means no cap.
+type Schedule struct {
+	Flat    float64 `json:"flat"`     // USD charged on every trade
+	RateBps int     `json:"rate_bps"` // Percentage of the notional, in basis points
+	Minimum float64 `json:"minimum"`  // Smallest fee charged, in USD
+	Maximum float64 `json:"maximum"`  // Largest fee charged, in USD; 0 for no cap
+}
+
+// Quote is the commission priced for a trade
+type Quote struct {
+	Tier     string   `json:"tier"`
+	Notional float64  `json:"notional"`
+	Fee      float64  `json:"fee"`
+	Schedule Schedule `json:"schedule"`
+}
+
+// Config holds the fee schedules
+type Config struct {
+	Default Schedule            // Applies to tiers without their own schedule
+	Tiers   map[string]Schedule // Per KYC tier; the "" entry applies to unverified users
+}
+
+// Engine prices commissions from the schedule of the user's KYC tier
+type Engine struct {
+	def   Schedule
+	tiers map[string]Schedule
+}
+
+// NewEngine creates a new fee engine
+func NewEngine(cfg *Config) *Engine {
+	tiers := make(map[string]Schedule, len(cfg.Tiers))
+	for tier, s := range cfg.Tiers {
+		tiers[tier] = s
+	}
+	return &Engine{def: cfg.Default, tiers: tiers}
+}
+
+// Schedule returns the schedule that applies to a KYC tier
+func (e *Engine) Schedule(tier string) Schedule {
+	if s, ok := e.tiers[tier]; ok {
+		return s
+	}
+	return e.def
+}
+
+// Quote prices the commission on a trade of the given USD notional for a
+// user in tier. The fee is rounded to the cent and never exceeds the
+// notional, so a sale always nets something; a zero notional is free.
+func (e *Engine) Quote(tier string, notional float64) Quote {
+	s := e.Schedule(tier)
+	q := Quote{Tier: tier, Notional: notional, Schedule: s}
+	if notional <= 0 {
+		return q
+	}
+
+	fee := s.Flat + notional*float64(s.RateBps)/10000
+	fee = max(fee, s.Minimum)
+	if s.Maximum > 0 {
+		fee = min(fee, s.Maximum)
+	}
+	fee = math.Round(fee*100) / 100
+
+	q.Fee = min(fee, math.Floor(notional*100)/100)
+	return q
+}
+
+// ParseTiers parses per-tier rate overrides such as "tier2:25,tier3:15" into
+// schedules that copy base with the given rate in basis points. The tier
+// name "unverified" stands for users with no KYC tier.
+func ParseTiers(s string, base Schedule) (map[string]Schedule, error) {
+	tiers := make(map[string]Schedule)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, rate, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTiers, part)
+		}
+		bps, err := strconv.Atoi(strings.TrimSpace(rate))
+		if err != nil || bps < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTiers, part)
+		}
+
+		name = strings.TrimSpace(name)
+		if name == "unverified" {
+			name = ""
+		}
+		schedule := base
+		schedule.RateBps = bps
+		tiers[name] = schedule
+	}
+	return tiers, nil
+}
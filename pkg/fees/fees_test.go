@@ -0,0 +1,82 @@
+package fees
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEngine_Quote(t *testing.T) {
+	e := NewEngine(&Config{
+		Default: Schedule{Flat: 0.25, RateBps: 50, Minimum: 1, Maximum: 20},
+		Tiers: map[string]Schedule{
+			"tier3": {RateBps: 10},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		tier     string
+		notional float64
+		want     float64
+	}{
+		{"minimum applies", "", 50, 1},
+		{"flat plus rate", "tier1", 1000, 5.25},
+		{"maximum caps", "tier2", 10000, 20},
+		{"tier schedule", "tier3", 1000, 1},
+		{"rounded to the cent", "tier3", 333.33, 0.33},
+		{"never above notional", "", 0.5, 0.5},
+		{"zero notional is free", "", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := e.Quote(tt.tier, tt.notional)
+			if q.Fee != tt.want {
+				t.Errorf("Fee = %v, want %v", q.Fee, tt.want)
+			}
+			if q.Tier != tt.tier || q.Notional != tt.notional {
+				t.Errorf("Quote = %+v, want tier %q notional %v", q, tt.tier, tt.notional)
+			}
+		})
+	}
+}
+
+func TestEngine_Schedule(t *testing.T) {
+	def := Schedule{RateBps: 50}
+	e := NewEngine(&Config{Default: def, Tiers: map[string]Schedule{"tier2": {RateBps: 25}}})
+
+	if got := e.Schedule("tier2"); got.RateBps != 25 {
+		t.Errorf("tier2 RateBps = %d, want 25", got.RateBps)
+	}
+	if got := e.Schedule("tier1"); got != def {
+		t.Errorf("tier1 schedule = %+v, want default %+v", got, def)
+	}
+}
+
+func TestParseTiers(t *testing.T) {
+	base := Schedule{Flat: 0.1, RateBps: 50, Minimum: 0.5}
+
+	tiers, err := ParseTiers("unverified:75, tier2:25,tier3:10,", base)
+	if err != nil {
+		t.Fatalf("ParseTiers failed: %v", err)
+	}
+	if len(tiers) != 3 {
+		t.Fatalf("got %d tiers, want 3", len(tiers))
+	}
+	if got := tiers[""]; got.RateBps != 75 || got.Flat != 0.1 || got.Minimum != 0.5 {
+		t.Errorf("unverified schedule = %+v", got)
+	}
+	if got := tiers["tier3"].RateBps; got != 10 {
+		t.Errorf("tier3 RateBps = %d, want 10", got)
+	}
+
+	if tiers, err := ParseTiers("", base); err != nil || len(tiers) != 0 {
+		t.Errorf("ParseTiers(\"\") = %v, %v, want no tiers", tiers, err)
+	}
+
+	for _, s := range []string{"tier2", "tier2:abc", "tier2:-5"} {
+		if _, err := ParseTiers(s, base); !errors.Is(err, ErrInvalidTiers) {
+			t.Errorf("ParseTiers(%q) error = %v, want ErrInvalidTiers", s, err)
+		}
+	}
+}
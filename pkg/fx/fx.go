@@ -0,0 +1,111 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Supported currencies, matching the currency enum in the database
+const (
+	KES = "KES"
+	USD = "USD"
+)
+
+var (
+	// ErrUnsupportedPair is returned when no rate is available for a pair
+	ErrUnsupportedPair = errors.New("unsupported currency pair")
+
+	// ErrInvalidAmount is returned when asked to convert a non-positive amount
+	ErrInvalidAmount = errors.New("amount must be positive")
+)
+
+// RateProvider supplies mid-market exchange rates
+type RateProvider interface {
+	// Rate returns how many units of quote currency one unit of base buys
+	Rate(ctx context.Context, base, quote string) (float64, error)
+}
+
+// Quote is a conversion offer at a locked rate, valid until ExpiresAt
+type Quote struct {
+	ID         string    `json:"id"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	FromAmount float64   `json:"from_amount"`
+	ToAmount   float64   `json:"to_amount"`
+	MidRate    float64   `json:"mid_rate"`   // Provider rate, To per From
+	Rate       float64   `json:"rate"`       // Rate offered after the spread, To per From
+	SpreadBps  int       `json:"spread_bps"` // Spread charged in basis points
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the quote can no longer be used at the given time
+func (q *Quote) Expired(now time.Time) bool {
+	return !now.Before(q.ExpiresAt)
+}
+
+// Config holds quoting configuration
+type Config struct {
+	SpreadBps int           // Spread charged on the mid rate, in basis points
+	TTL       time.Duration // How long a quote's rate stays locked
+}
+
+// Quoter prices conversions from a rate provider
+type Quoter struct {
+	provider  RateProvider
+	spreadBps int
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// NewQuoter creates a new quoter
+func NewQuoter(provider RateProvider, cfg *Config) *Quoter {
+	ttl := cfg.TTL
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &Quoter{
+		provider:  provider,
+		spreadBps: cfg.SpreadBps,
+		ttl:       ttl,
+		now:       time.Now,
+	}
+}
+
+// Quote prices converting amount of from currency into to currency. The
+// spread is taken from the rate, and the converted amount is rounded down to
+// the cent so a conversion never credits more than was paid for.
+func (q *Quoter) Quote(ctx context.Context, from, to string, amount float64) (*Quote, error) {
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+	if from == to {
+		return nil, fmt.Errorf("%w: %s/%s", ErrUnsupportedPair, from, to)
+	}
+
+	mid, err := q.provider.Rate(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := mid * (1 - float64(q.spreadBps)/10000)
+	now := q.now().UTC()
+
+	return &Quote{
+		ID:         uuid.New().String(),
+		From:       from,
+		To:         to,
+		FromAmount: amount,
+		ToAmount:   math.Floor(amount*rate*100) / 100,
+		MidRate:    mid,
+		Rate:       rate,
+		SpreadBps:  q.spreadBps,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(q.ttl),
+	}, nil
+}
@@ -0,0 +1,92 @@
+package fx
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMockProvider_Rate(t *testing.T) {
+	p := NewMockProvider(130)
+	ctx := context.Background()
+
+	rate, err := p.Rate(ctx, USD, KES)
+	if err != nil {
+		t.Fatalf("Rate failed: %v", err)
+	}
+	if rate != 130 {
+		t.Errorf("USD/KES = %v, want 130", rate)
+	}
+
+	inverse, err := p.Rate(ctx, KES, USD)
+	if err != nil {
+		t.Fatalf("Rate failed: %v", err)
+	}
+	if math.Abs(inverse-1.0/130) > 1e-12 {
+		t.Errorf("KES/USD = %v, want %v", inverse, 1.0/130)
+	}
+
+	if _, err := p.Rate(ctx, "EUR", USD); !errors.Is(err, ErrUnsupportedPair) {
+		t.Errorf("EUR/USD error = %v, want ErrUnsupportedPair", err)
+	}
+}
+
+func TestQuoter_Quote(t *testing.T) {
+	now := time.Date(2026, 1, 5, 12, 0, 0, 0, time.UTC)
+	q := NewQuoter(NewMockProvider(100), &Config{SpreadBps: 150, TTL: time.Minute})
+	q.now = func() time.Time { return now }
+
+	quote, err := q.Quote(context.Background(), KES, USD, 10000)
+	if err != nil {
+		t.Fatalf("Quote failed: %v", err)
+	}
+
+	if quote.ID == "" {
+		t.Error("expected quote ID")
+	}
+	if quote.MidRate != 0.01 {
+		t.Errorf("MidRate = %v, want 0.01", quote.MidRate)
+	}
+	if math.Abs(quote.Rate-0.00985) > 1e-12 {
+		t.Errorf("Rate = %v, want 0.00985", quote.Rate)
+	}
+	if quote.ToAmount != 98.5 {
+		t.Errorf("ToAmount = %v, want 98.5", quote.ToAmount)
+	}
+	if !quote.ExpiresAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("ExpiresAt = %v, want %v", quote.ExpiresAt, now.Add(time.Minute))
+	}
+	if quote.Expired(now.Add(59 * time.Second)) {
+		t.Error("quote should be valid before expiry")
+	}
+	if !quote.Expired(now.Add(time.Minute)) {
+		t.Error("quote should be expired at expiry")
+	}
+}
+
+func TestQuoter_QuoteRoundsDown(t *testing.T) {
+	q := NewQuoter(NewMockProvider(129.37), &Config{})
+
+	quote, err := q.Quote(context.Background(), KES, USD, 1000)
+	if err != nil {
+		t.Fatalf("Quote failed: %v", err)
+	}
+	// 1000 / 129.37 = 7.7297...
+	if quote.ToAmount != 7.72 {
+		t.Errorf("ToAmount = %v, want 7.72", quote.ToAmount)
+	}
+}
+
+func TestQuoter_QuoteInvalid(t *testing.T) {
+	q := NewQuoter(NewMockProvider(130), &Config{})
+	ctx := context.Background()
+
+	if _, err := q.Quote(ctx, KES, USD, 0); !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("zero amount error = %v, want ErrInvalidAmount", err)
+	}
+	if _, err := q.Quote(ctx, USD, USD, 10); !errors.Is(err, ErrUnsupportedPair) {
+		t.Errorf("same currency error = %v, want ErrUnsupportedPair", err)
+	}
+}
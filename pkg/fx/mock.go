@@ -0,0 +1,42 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MockProvider is a rate provider backed by fixed rates, for local
+// development and testing. Inverse pairs are derived automatically.
+type MockProvider struct {
+	mu    sync.RWMutex
+	rates map[string]float64
+}
+
+// NewMockProvider creates a mock provider quoting the given KES per USD rate
+func NewMockProvider(kesPerUSD float64) *MockProvider {
+	p := &MockProvider{rates: make(map[string]float64)}
+	p.SetRate(USD, KES, kesPerUSD)
+	return p
+}
+
+// SetRate sets how many units of quote currency one unit of base buys
+func (p *MockProvider) SetRate(base, quote string, rate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rates[base+"/"+quote] = rate
+}
+
+// Rate returns the mid rate for a pair
+func (p *MockProvider) Rate(ctx context.Context, base, quote string) (float64, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if rate, ok := p.rates[base+"/"+quote]; ok {
+		return rate, nil
+	}
+	if rate, ok := p.rates[quote+"/"+base]; ok && rate > 0 {
+		return 1 / rate, nil
+	}
+	return 0, fmt.Errorf("%w: %s/%s", ErrUnsupportedPair, base, quote)
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"strconv"
 	"strings"
 	"sync"
@@ -328,6 +329,46 @@ func GetUserID(c *fiber.Ctx) string {
 	return ""
 }
 
+// =============================================================================
+// Admin Auth Middleware
+// =============================================================================
+
+// AdminAuth protects operator endpoints with a shared bearer token. Each
+// request must name the operator making it in the X-Admin-Actor header, so
+// changes can be audited.
+func AdminAuth(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		authHeader := c.Get("Authorization")
+		if authHeader == "" {
+			return apperrors.ErrUnauthorized.WithDetails("Missing authorization header")
+		}
+
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return apperrors.ErrUnauthorized.WithDetails("Invalid authorization header format")
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(parts[1]), []byte(token)) != 1 {
+			return apperrors.ErrInvalidToken
+		}
+
+		actor := strings.TrimSpace(c.Get("X-Admin-Actor"))
+		if actor == "" {
+			return apperrors.ErrValidation.WithDetails("X-Admin-Actor header is required")
+		}
+
+		c.Locals("admin_actor", actor)
+		return c.Next()
+	}
+}
+
+// GetAdminActor returns the operator named on an admin request
+func GetAdminActor(c *fiber.Ctx) string {
+	if actor, ok := c.Locals("admin_actor").(string); ok {
+		return actor
+	}
+	return ""
+}
+
 // =============================================================================
 // 2FA Middleware
 // =============================================================================
@@ -440,6 +440,69 @@ func TestGetUserID(t *testing.T) {
 	}
 }
 
+func TestAdminAuth(t *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: response.ErrorHandler,
+	})
+	app.Use(AdminAuth("admin-token"))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(GetAdminActor(c))
+	})
+
+	tests := []struct {
+		name       string
+		auth       string
+		actor      string
+		wantStatus int
+	}{
+		{"missing authorization header", "", "ops@equishare", 401},
+		{"invalid authorization format", "admin-token", "ops@equishare", 401},
+		{"wrong token", "Bearer other-token", "ops@equishare", 401},
+		{"missing actor", "Bearer admin-token", "", 400},
+		{"valid", "Bearer admin-token", "ops@equishare", 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			if tt.actor != "" {
+				req.Header.Set("X-Admin-Actor", tt.actor)
+			}
+			resp, _ := app.Test(req)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Status = %v, want %v", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == 200 {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.actor {
+					t.Errorf("GetAdminActor = %q, want %q", body, tt.actor)
+				}
+			}
+		})
+	}
+
+	t.Run("empty token rejects everything", func(t *testing.T) {
+		app := fiber.New(fiber.Config{ErrorHandler: response.ErrorHandler})
+		app.Use(AdminAuth(""))
+		app.Get("/", func(c *fiber.Ctx) error { return c.SendString("ok") })
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer ")
+		req.Header.Set("X-Admin-Actor", "ops@equishare")
+		resp, _ := app.Test(req)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != 401 {
+			t.Errorf("Status = %v, want 401", resp.StatusCode)
+		}
+	})
+}
+
 func TestGetPhone(t *testing.T) {
 	jwtSecret := "test-secret"
 	app := fiber.New()
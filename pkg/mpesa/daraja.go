@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -321,6 +322,11 @@ const (
 	PromotionPayment B2CCommandID = "PromotionPayment"
 )
 
+// ErrB2CRejected is returned when M-Pesa refuses a B2C request outright. No
+// payment was started, so the request can safely be retried. Other B2C
+// errors leave it unknown whether the request was accepted.
+var ErrB2CRejected = errors.New("B2C request rejected")
+
 // B2C initiates a Business to Customer payment (withdrawal)
 func (c *Client) B2C(ctx context.Context, phone string, amount int, reference string, b2cConfig *B2CConfig) (*B2CResponse, error) {
 	token, err := c.getAccessToken(ctx)
@@ -367,7 +373,7 @@ func (c *Client) B2C(ctx context.Context, phone string, amount int, reference st
 	}
 
 	if b2cResp.ResponseCode != "0" {
-		return nil, fmt.Errorf("B2C request failed: %s", b2cResp.ResponseDescription)
+		return nil, fmt.Errorf("%w: %s", ErrB2CRejected, b2cResp.ResponseDescription)
 	}
 
 	return &b2cResp, nil
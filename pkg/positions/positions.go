@@ -0,0 +1,92 @@
+package positions
+
+import (
+	"math"
+	"sort"
+)
+
+// qtyUnits is the number of units per share holdings are stored in
+const qtyUnits = 1e8
+
+// Drift is a symbol whose position in the books differs from the broker's
+type Drift struct {
+	Symbol    string
+	LocalQty  float64 // Sum of user holdings
+	BrokerQty float64 // Position held at the broker
+	Diff      float64 // BrokerQty - LocalQty
+}
+
+// Compare returns the symbols whose local and broker quantities differ by
+// more than tolerance, sorted by symbol. A symbol missing on one side counts
+// as zero there.
+func Compare(local, broker map[string]float64, tolerance float64) []Drift {
+	symbols := make(map[string]bool, len(local)+len(broker))
+	for s := range local {
+		symbols[s] = true
+	}
+	for s := range broker {
+		symbols[s] = true
+	}
+
+	var drifts []Drift
+	for s := range symbols {
+		diff := round(broker[s] - local[s])
+		if math.Abs(diff) <= tolerance {
+			continue
+		}
+		drifts = append(drifts, Drift{Symbol: s, LocalQty: local[s], BrokerQty: broker[s], Diff: diff})
+	}
+
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].Symbol < drifts[j].Symbol })
+	return drifts
+}
+
+// Prorate scales qtys so they add up to total, each keeping its share of
+// the current sum. Quantities are rounded down to storage units and the
+// units left over go to the largest quantities, ties to the earliest. It
+// returns nil if qtys add up to zero.
+func Prorate(qtys []float64, total float64) []float64 {
+	units := make([]int64, len(qtys))
+	var sum int64
+	for i, q := range qtys {
+		units[i] = int64(math.Round(q * qtyUnits))
+		sum += units[i]
+	}
+	if sum <= 0 || len(qtys) == 0 {
+		return nil
+	}
+
+	target := int64(math.Round(total * qtyUnits))
+	scaled := make([]int64, len(qtys))
+	remainder := target
+	for i, u := range units {
+		scaled[i] = int64(float64(u) * float64(target) / float64(sum))
+		remainder -= scaled[i]
+	}
+
+	ranked := make([]int, len(qtys))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(a, b int) bool { return units[ranked[a]] > units[ranked[b]] })
+	for i := 0; remainder > 0; i = (i + 1) % len(ranked) {
+		scaled[ranked[i]]++
+		remainder--
+	}
+	for i := 0; remainder < 0; i = (i + 1) % len(ranked) {
+		if scaled[ranked[i]] > 0 {
+			scaled[ranked[i]]--
+			remainder++
+		}
+	}
+
+	out := make([]float64, len(qtys))
+	for i, u := range scaled {
+		out[i] = float64(u) / qtyUnits
+	}
+	return out
+}
+
+func round(qty float64) float64 {
+	return math.Round(qty*qtyUnits) / qtyUnits
+}
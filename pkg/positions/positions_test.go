@@ -0,0 +1,69 @@
+package positions
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	local := map[string]float64{"AAPL": 10.5, "MSFT": 3, "TSLA": 2}
+	broker := map[string]float64{"AAPL": 10.5, "MSFT": 2.75, "NVDA": 1}
+
+	drifts := Compare(local, broker, 1e-6)
+	want := []Drift{
+		{Symbol: "MSFT", LocalQty: 3, BrokerQty: 2.75, Diff: -0.25},
+		{Symbol: "NVDA", LocalQty: 0, BrokerQty: 1, Diff: 1},
+		{Symbol: "TSLA", LocalQty: 2, BrokerQty: 0, Diff: -2},
+	}
+	if len(drifts) != len(want) {
+		t.Fatalf("Compare = %+v, want %+v", drifts, want)
+	}
+	for i := range want {
+		if drifts[i] != want[i] {
+			t.Errorf("drift %d = %+v, want %+v", i, drifts[i], want[i])
+		}
+	}
+
+	// Differences within tolerance are not drift
+	if drifts := Compare(map[string]float64{"AAPL": 1.0000001}, map[string]float64{"AAPL": 1}, 1e-6); len(drifts) != 0 {
+		t.Errorf("Compare within tolerance = %+v, want none", drifts)
+	}
+}
+
+func TestProrate(t *testing.T) {
+	tests := []struct {
+		name  string
+		qtys  []float64
+		total float64
+		want  []float64
+	}{
+		{"shrink", []float64{6, 3, 1}, 5, []float64{3, 1.5, 0.5}},
+		{"grow", []float64{2, 2}, 5, []float64{2.5, 2.5}},
+		{"remainder to largest", []float64{1, 2}, 1, []float64{0.33333333, 0.66666667}},
+		{"to zero", []float64{1, 4}, 0, []float64{0, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Prorate(tt.qtys, tt.total)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Prorate = %v, want %v", got, tt.want)
+			}
+			var sum float64
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-12 {
+					t.Errorf("Prorate = %v, want %v", got, tt.want)
+					break
+				}
+				sum += got[i]
+			}
+			if math.Abs(sum-tt.total) > 1e-9 {
+				t.Errorf("Prorate sums to %v, want %v", sum, tt.total)
+			}
+		})
+	}
+
+	if got := Prorate([]float64{0, 0}, 5); got != nil {
+		t.Errorf("Prorate of nothing = %v, want nil", got)
+	}
+}
@@ -0,0 +1,127 @@
+package slippage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	// ErrStaleQuote is returned when the latest quote is older than the
+	// guard allows
+	ErrStaleQuote = errors.New("quote is stale")
+
+	// ErrWideSpread is returned when the bid-ask spread is wider than the
+	// guard allows
+	ErrWideSpread = errors.New("spread is too wide")
+
+	// ErrNoQuote is returned when the quote is missing the side an order
+	// would trade against
+	ErrNoQuote = errors.New("no quote to trade against")
+
+	// ErrInvalidSlippage is returned when an order asks for a protection
+	// band outside the allowed range
+	ErrInvalidSlippage = errors.New("invalid max slippage")
+)
+
+// Config holds the guards on market orders. A zero MaxQuoteAge or
+// MaxSpreadBps turns that guard off.
+type Config struct {
+	MaxQuoteAge        time.Duration // Oldest quote a market order is priced from
+	MaxSpreadBps       int           // Widest bid-ask spread, in basis points of the mid price
+	DefaultSlippageBps int           // Protection band for orders that do not give their own
+	MaxSlippageBps     int           // Widest band an order may ask for
+}
+
+// Quote is the top of book a market order is priced from
+type Quote struct {
+	Bid  float64
+	Ask  float64
+	Time time.Time
+}
+
+// SpreadBps returns the bid-ask spread in basis points of the mid price, or
+// 0 when the quote is one-sided
+func (q Quote) SpreadBps() float64 {
+	if q.Bid <= 0 || q.Ask <= 0 {
+		return 0
+	}
+	mid := (q.Bid + q.Ask) / 2
+	return (q.Ask - q.Bid) / mid * 10000
+}
+
+// Protection is the limit price a market order is sent at
+type Protection struct {
+	Reference   float64 // The ask for a buy, the bid for a sell
+	LimitPrice  float64 // Reference moved SlippageBps against the order, to the cent
+	SlippageBps int
+}
+
+// Guard checks the quote a market order is priced from and bounds how far
+// from it the order may fill
+type Guard struct {
+	cfg Config
+}
+
+// NewGuard creates a new slippage guard
+func NewGuard(cfg *Config) *Guard {
+	return &Guard{cfg: *cfg}
+}
+
+// Check returns an error explaining why a market order should not be priced
+// from the quote at now: it is too old, one-sided or its spread too wide
+func (g *Guard) Check(q Quote, now time.Time) error {
+	if g.cfg.MaxQuoteAge > 0 {
+		if q.Time.IsZero() {
+			return fmt.Errorf("%w: the quote has no timestamp", ErrStaleQuote)
+		}
+		if age := now.Sub(q.Time); age > g.cfg.MaxQuoteAge {
+			return fmt.Errorf("%w: the latest quote is %s old, the limit is %s",
+				ErrStaleQuote, age.Round(time.Second), g.cfg.MaxQuoteAge)
+		}
+	}
+
+	if q.Bid <= 0 || q.Ask <= 0 {
+		return fmt.Errorf("%w: the quote has no bid or no ask", ErrNoQuote)
+	}
+	if g.cfg.MaxSpreadBps > 0 {
+		if spread := q.SpreadBps(); spread > float64(g.cfg.MaxSpreadBps) {
+			return fmt.Errorf("%w: the spread is %.0f bps, the limit is %d bps",
+				ErrWideSpread, spread, g.cfg.MaxSpreadBps)
+		}
+	}
+
+	return nil
+}
+
+// Protect checks the quote and returns the limit price a market order on
+// side is sent at: slippageBps above the ask for a buy, below the bid for a
+// sell, rounded away from the quote to the cent so the order stays
+// marketable. A zero slippageBps uses the default band.
+func (g *Guard) Protect(side string, q Quote, slippageBps int, now time.Time) (*Protection, error) {
+	if slippageBps == 0 {
+		slippageBps = g.cfg.DefaultSlippageBps
+	}
+	if slippageBps < 1 || (g.cfg.MaxSlippageBps > 0 && slippageBps > g.cfg.MaxSlippageBps) {
+		return nil, fmt.Errorf("%w: must be between 1 and %d bps", ErrInvalidSlippage, g.cfg.MaxSlippageBps)
+	}
+	if err := g.Check(q, now); err != nil {
+		return nil, err
+	}
+
+	band := float64(slippageBps) / 10000
+	p := &Protection{SlippageBps: slippageBps}
+	if side == "buy" {
+		p.Reference = q.Ask
+		p.LimitPrice = math.Ceil(q.Ask*(1+band)*100-1e-6) / 100
+	} else {
+		p.Reference = q.Bid
+		p.LimitPrice = math.Floor(q.Bid*(1-band)*100+1e-6) / 100
+		if p.LimitPrice <= 0 {
+			return nil, fmt.Errorf("%w: the bid is too low to sell at a protected price", ErrNoQuote)
+		}
+	}
+
+	return p, nil
+}
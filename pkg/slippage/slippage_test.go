@@ -0,0 +1,129 @@
+package slippage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 3, 2, 15, 0, 0, 0, time.UTC)
+
+func TestGuard_Check(t *testing.T) {
+	g := NewGuard(&Config{MaxQuoteAge: 30 * time.Second, MaxSpreadBps: 100})
+
+	tests := []struct {
+		name    string
+		quote   Quote
+		wantErr error
+	}{
+		{"fresh and tight", Quote{Bid: 99.9, Ask: 100.1, Time: now.Add(-5 * time.Second)}, nil},
+		{"stale", Quote{Bid: 99.9, Ask: 100.1, Time: now.Add(-time.Minute)}, ErrStaleQuote},
+		{"no timestamp", Quote{Bid: 99.9, Ask: 100.1}, ErrStaleQuote},
+		{"no ask", Quote{Bid: 99.9, Time: now}, ErrNoQuote},
+		{"no bid", Quote{Ask: 100.1, Time: now}, ErrNoQuote},
+		{"wide spread", Quote{Bid: 98, Ask: 100, Time: now}, ErrWideSpread},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := g.Check(tt.quote, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestGuard_Check_Disabled(t *testing.T) {
+	g := NewGuard(&Config{})
+
+	if err := g.Check(Quote{Bid: 50, Ask: 100}, now); err != nil {
+		t.Errorf("Check() error = %v, want nil with guards off", err)
+	}
+	if err := g.Check(Quote{Ask: 100}, now); !errors.Is(err, ErrNoQuote) {
+		t.Errorf("Check() error = %v, want %v", err, ErrNoQuote)
+	}
+}
+
+func TestGuard_Protect(t *testing.T) {
+	g := NewGuard(&Config{MaxSpreadBps: 200, DefaultSlippageBps: 50, MaxSlippageBps: 500})
+	quote := Quote{Bid: 149.5, Ask: 150.5, Time: now}
+
+	tests := []struct {
+		name      string
+		side      string
+		bps       int
+		wantLimit float64
+		wantBps   int
+	}{
+		{"buy at default band", "buy", 0, 151.26, 50},
+		{"sell at default band", "sell", 0, 148.75, 50},
+		{"buy at own band", "buy", 100, 152.01, 100},
+		{"sell at own band", "sell", 100, 148.0, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := g.Protect(tt.side, quote, tt.bps, now)
+			if err != nil {
+				t.Fatalf("Protect() error = %v", err)
+			}
+			if p.LimitPrice != tt.wantLimit {
+				t.Errorf("LimitPrice = %v, want %v", p.LimitPrice, tt.wantLimit)
+			}
+			if p.SlippageBps != tt.wantBps {
+				t.Errorf("SlippageBps = %d, want %d", p.SlippageBps, tt.wantBps)
+			}
+		})
+	}
+}
+
+func TestGuard_Protect_Marketable(t *testing.T) {
+	g := NewGuard(&Config{DefaultSlippageBps: 1, MaxSlippageBps: 500})
+	quote := Quote{Bid: 10.00, Ask: 10.01, Time: now}
+
+	buy, err := g.Protect("buy", quote, 0, now)
+	if err != nil {
+		t.Fatalf("Protect(buy) error = %v", err)
+	}
+	if buy.LimitPrice < quote.Ask {
+		t.Errorf("buy LimitPrice = %v, want at least the ask %v", buy.LimitPrice, quote.Ask)
+	}
+
+	sell, err := g.Protect("sell", quote, 0, now)
+	if err != nil {
+		t.Fatalf("Protect(sell) error = %v", err)
+	}
+	if sell.LimitPrice > quote.Bid {
+		t.Errorf("sell LimitPrice = %v, want at most the bid %v", sell.LimitPrice, quote.Bid)
+	}
+}
+
+func TestGuard_Protect_InvalidSlippage(t *testing.T) {
+	g := NewGuard(&Config{DefaultSlippageBps: 50, MaxSlippageBps: 500})
+	quote := Quote{Bid: 149.5, Ask: 150.5, Time: now}
+
+	for _, bps := range []int{-1, 501} {
+		if _, err := g.Protect("buy", quote, bps, now); !errors.Is(err, ErrInvalidSlippage) {
+			t.Errorf("Protect(%d bps) error = %v, want %v", bps, err, ErrInvalidSlippage)
+		}
+	}
+}
+
+func TestGuard_Protect_RejectsBadQuote(t *testing.T) {
+	g := NewGuard(&Config{MaxQuoteAge: 30 * time.Second, DefaultSlippageBps: 50, MaxSlippageBps: 500})
+	stale := Quote{Bid: 149.5, Ask: 150.5, Time: now.Add(-time.Hour)}
+
+	if _, err := g.Protect("buy", stale, 0, now); !errors.Is(err, ErrStaleQuote) {
+		t.Errorf("Protect() error = %v, want %v", err, ErrStaleQuote)
+	}
+}
+
+func TestQuote_SpreadBps(t *testing.T) {
+	if got := (Quote{Bid: 99, Ask: 101}).SpreadBps(); got != 200 {
+		t.Errorf("SpreadBps() = %v, want 200", got)
+	}
+	if got := (Quote{Ask: 101}).SpreadBps(); got != 0 {
+		t.Errorf("SpreadBps() one-sided = %v, want 0", got)
+	}
+}
@@ -0,0 +1,160 @@
+package taxlots
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Lot selection methods
+const (
+	MethodFIFO     = "fifo"     // Oldest lots first
+	MethodLIFO     = "lifo"     // Newest lots first
+	MethodSpecific = "specific" // Lots chosen by the seller, then oldest first
+)
+
+// Holding periods
+const (
+	TermShort = "short"
+	TermLong  = "long"
+)
+
+// epsilon absorbs float error when comparing share quantities, well below
+// the 1e-8 share precision holdings are stored at
+const epsilon = 1e-9
+
+// ErrInvalidMethod is returned for an unknown lot selection method
+var ErrInvalidMethod = errors.New("invalid lot selection method")
+
+// Lot is a block of shares bought in one fill, at one price
+type Lot struct {
+	ID         string
+	Qty        float64 // Shares still held
+	Price      float64 // Cost per share
+	AcquiredAt time.Time
+}
+
+// Gain is the realized gain or loss on the shares of one lot sold
+type Gain struct {
+	LotID      string
+	Qty        float64
+	Proceeds   float64
+	CostBasis  float64
+	Fee        float64 // Share of the sale's commission
+	PL         float64 // Proceeds less cost basis and fee
+	AcquiredAt time.Time
+	Term       string
+}
+
+// ValidMethod reports whether method is a known lot selection method
+func ValidMethod(method string) bool {
+	switch method {
+	case MethodFIFO, MethodLIFO, MethodSpecific:
+		return true
+	}
+	return false
+}
+
+// Select picks the lots a sale of qty shares consumes. Each returned lot
+// carries the shares taken from it. The specific method takes the lots
+// named in ids in order, then falls back to FIFO for any shares they do not
+// cover, e.g. when a chosen lot was partly sold since. Shares no lot covers
+// are returned as unmatched.
+func Select(lots []Lot, qty float64, method string, ids []string) (selected []Lot, unmatched float64, err error) {
+	if !ValidMethod(method) {
+		return nil, 0, fmt.Errorf("%w: %q", ErrInvalidMethod, method)
+	}
+
+	ordered := slices.Clone(lots)
+	slices.SortStableFunc(ordered, func(a, b Lot) int {
+		return a.AcquiredAt.Compare(b.AcquiredAt)
+	})
+	switch method {
+	case MethodLIFO:
+		slices.Reverse(ordered)
+	case MethodSpecific:
+		// Chosen lots first in the order given, the rest oldest first
+		rank := func(l Lot) int {
+			if i := slices.Index(ids, l.ID); i >= 0 {
+				return i
+			}
+			return len(ids)
+		}
+		slices.SortStableFunc(ordered, func(a, b Lot) int {
+			return rank(a) - rank(b)
+		})
+	}
+
+	remaining := qty
+	for _, lot := range ordered {
+		if remaining <= epsilon {
+			break
+		}
+		if lot.Qty <= epsilon {
+			continue
+		}
+		take := min(lot.Qty, remaining)
+		if lot.Qty-remaining <= epsilon {
+			take = lot.Qty
+		}
+		lot.Qty = take
+		selected = append(selected, lot)
+		remaining -= take
+	}
+
+	if remaining <= epsilon {
+		remaining = 0
+	}
+	return selected, remaining, nil
+}
+
+// Realize computes the gain on each lot sold at price, splitting the sale's
+// fee across the lots by shares. Amounts are rounded to the cent; the last
+// lot takes the rounding remainder of the fee so the shares add up to it.
+func Realize(sold []Lot, price, fee float64, soldAt time.Time) []Gain {
+	var total float64
+	for _, lot := range sold {
+		total += lot.Qty
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	gains := make([]Gain, 0, len(sold))
+	feeLeft := roundCents(fee)
+	for i, lot := range sold {
+		share := roundCents(fee * lot.Qty / total)
+		if i == len(sold)-1 {
+			share = feeLeft
+		}
+		feeLeft = roundCents(feeLeft - share)
+
+		g := Gain{
+			LotID:      lot.ID,
+			Qty:        lot.Qty,
+			Proceeds:   roundCents(lot.Qty * price),
+			CostBasis:  roundCents(lot.Qty * lot.Price),
+			Fee:        share,
+			AcquiredAt: lot.AcquiredAt,
+			Term:       Term(lot.AcquiredAt, soldAt),
+		}
+		g.PL = roundCents(g.Proceeds - g.CostBasis - g.Fee)
+		gains = append(gains, g)
+	}
+	return gains
+}
+
+// Term returns the holding period of shares bought at acquired and sold at
+// sold: long when held for more than a year
+func Term(acquired, sold time.Time) string {
+	if sold.After(acquired.AddDate(1, 0, 0)) {
+		return TermLong
+	}
+	return TermShort
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
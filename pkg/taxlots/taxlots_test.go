@@ -0,0 +1,112 @@
+package taxlots
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+var lots = []Lot{
+	{ID: "b", Qty: 5, Price: 120, AcquiredAt: day("2025-03-01")},
+	{ID: "a", Qty: 10, Price: 100, AcquiredAt: day("2025-01-01")},
+	{ID: "c", Qty: 2, Price: 90, AcquiredAt: day("2025-06-01")},
+}
+
+func ids(selected []Lot) []string {
+	var out []string
+	for _, l := range selected {
+		out = append(out, l.ID)
+	}
+	return out
+}
+
+func TestSelect(t *testing.T) {
+	tests := []struct {
+		name      string
+		qty       float64
+		method    string
+		ids       []string
+		want      []string
+		lastQty   float64
+		unmatched float64
+	}{
+		{"fifo", 12, MethodFIFO, nil, []string{"a", "b"}, 2, 0},
+		{"lifo", 4, MethodLIFO, nil, []string{"c", "b"}, 2, 0},
+		{"specific", 6, MethodSpecific, []string{"b"}, []string{"b", "a"}, 1, 0},
+		{"specific in given order", 3, MethodSpecific, []string{"c", "b"}, []string{"c", "b"}, 1, 0},
+		{"more than held", 20, MethodFIFO, nil, []string{"a", "b", "c"}, 2, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, unmatched, err := Select(lots, tt.qty, tt.method, tt.ids)
+			if err != nil {
+				t.Fatalf("Select failed: %v", err)
+			}
+			got := ids(selected)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Select = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("Select = %v, want %v", got, tt.want)
+				}
+			}
+			if last := selected[len(selected)-1].Qty; last != tt.lastQty {
+				t.Errorf("last lot qty = %v, want %v", last, tt.lastQty)
+			}
+			if unmatched != tt.unmatched {
+				t.Errorf("unmatched = %v, want %v", unmatched, tt.unmatched)
+			}
+		})
+	}
+
+	// The caller's lots are left untouched
+	if lots[1].Qty != 10 {
+		t.Errorf("Select modified its input: %+v", lots)
+	}
+
+	if _, _, err := Select(lots, 1, "hifo", nil); !errors.Is(err, ErrInvalidMethod) {
+		t.Errorf("Select with unknown method error = %v, want ErrInvalidMethod", err)
+	}
+}
+
+func TestRealize(t *testing.T) {
+	sold := []Lot{
+		{ID: "a", Qty: 10, Price: 100, AcquiredAt: day("2024-01-01")},
+		{ID: "b", Qty: 5, Price: 120, AcquiredAt: day("2025-03-01")},
+	}
+	gains := Realize(sold, 110, 1, day("2025-06-01"))
+	if len(gains) != 2 {
+		t.Fatalf("got %d gains, want 2", len(gains))
+	}
+
+	a, b := gains[0], gains[1]
+	if a.Proceeds != 1100 || a.CostBasis != 1000 || a.Fee != 0.67 || a.PL != 99.33 || a.Term != TermLong {
+		t.Errorf("gain on lot a = %+v", a)
+	}
+	if b.Proceeds != 550 || b.CostBasis != 600 || b.Fee != 0.33 || b.PL != -50.33 || b.Term != TermShort {
+		t.Errorf("gain on lot b = %+v", b)
+	}
+
+	if gains := Realize(nil, 110, 1, day("2025-06-01")); gains != nil {
+		t.Errorf("Realize(nil) = %+v, want nil", gains)
+	}
+}
+
+func TestTerm(t *testing.T) {
+	if got := Term(day("2025-01-01"), day("2026-01-01")); got != TermShort {
+		t.Errorf("exactly one year = %q, want short", got)
+	}
+	if got := Term(day("2025-01-01"), day("2026-01-02")); got != TermLong {
+		t.Errorf("over one year = %q, want long", got)
+	}
+}
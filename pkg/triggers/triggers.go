@@ -0,0 +1,94 @@
+// Package triggers evaluates the price conditions behind conditional orders.
+package triggers
+
+import (
+	"errors"
+	"math"
+)
+
+// Condition kinds
+const (
+	// KindPriceAbove fires once the price reaches the threshold or more
+	KindPriceAbove = "price_above"
+
+	// KindPriceBelow fires once the price falls to the threshold or less
+	KindPriceBelow = "price_below"
+
+	// KindChangeFromOpen fires once the price has moved the threshold
+	// percentage from the day's open: -5 is a 5% drop, 5 a 5% rise
+	KindChangeFromOpen = "change_from_open"
+
+	// KindPositionGain fires once the price is the threshold percentage
+	// above the position's average cost, or below it for a negative threshold
+	KindPositionGain = "position_gain"
+)
+
+// ErrInvalidKind is returned for an unknown condition kind
+var ErrInvalidKind = errors.New("invalid condition kind")
+
+// ErrInvalidThreshold is returned for a threshold that can never be met
+var ErrInvalidThreshold = errors.New("invalid condition threshold")
+
+// Condition is a test on a symbol's price
+type Condition struct {
+	Kind      string
+	Threshold float64
+}
+
+// Validate checks the condition's kind and threshold
+func (c Condition) Validate() error {
+	switch c.Kind {
+	case KindPriceAbove, KindPriceBelow:
+		if c.Threshold <= 0 {
+			return ErrInvalidThreshold
+		}
+	case KindChangeFromOpen, KindPositionGain:
+		if c.Threshold == 0 || c.Threshold <= -100 {
+			return ErrInvalidThreshold
+		}
+	default:
+		return ErrInvalidKind
+	}
+	return nil
+}
+
+// NeedsReference reports whether the condition is measured against a
+// reference price: the day's open or the position's average cost
+func (c Condition) NeedsReference() bool {
+	return c.Kind == KindChangeFromOpen || c.Kind == KindPositionGain
+}
+
+// Met reports whether price satisfies the condition. reference is the day's
+// open or the position's average cost; relative conditions are never met
+// without one.
+func (c Condition) Met(price, reference float64) bool {
+	if price <= 0 {
+		return false
+	}
+
+	switch c.Kind {
+	case KindPriceAbove:
+		return price >= c.Threshold
+	case KindPriceBelow:
+		return price <= c.Threshold
+	case KindChangeFromOpen, KindPositionGain:
+		change := Change(price, reference)
+		if math.IsNaN(change) {
+			return false
+		}
+		if c.Threshold < 0 {
+			return change <= c.Threshold
+		}
+		return change >= c.Threshold
+	}
+	return false
+}
+
+// Change is the percentage move from reference to price, rounded to
+// hundredths of a percent. It is NaN without a reference.
+func Change(price, reference float64) float64 {
+	if reference <= 0 {
+		return math.NaN()
+	}
+	return math.Round((price-reference)/reference*10000) / 100
+}
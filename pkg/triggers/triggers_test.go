@@ -0,0 +1,71 @@
+package triggers
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		cond Condition
+		want error
+	}{
+		{"price above", Condition{KindPriceAbove, 150}, nil},
+		{"price below zero", Condition{KindPriceBelow, 0}, ErrInvalidThreshold},
+		{"drop from open", Condition{KindChangeFromOpen, -5}, nil},
+		{"no change", Condition{KindChangeFromOpen, 0}, ErrInvalidThreshold},
+		{"loss beyond total", Condition{KindPositionGain, -100}, ErrInvalidThreshold},
+		{"gain", Condition{KindPositionGain, 20}, nil},
+		{"unknown kind", Condition{"volume_above", 1}, ErrInvalidKind},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cond.Validate(); !errors.Is(err, tt.want) {
+				t.Errorf("Validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMet(t *testing.T) {
+	tests := []struct {
+		name      string
+		cond      Condition
+		price     float64
+		reference float64
+		want      bool
+	}{
+		{"above reached", Condition{KindPriceAbove, 150}, 150, 0, true},
+		{"above not reached", Condition{KindPriceAbove, 150}, 149.99, 0, false},
+		{"below reached", Condition{KindPriceBelow, 100}, 99.5, 0, true},
+		{"below not reached", Condition{KindPriceBelow, 100}, 100.01, 0, false},
+		{"dropped 5% from open", Condition{KindChangeFromOpen, -5}, 190, 200, true},
+		{"dropped 4% from open", Condition{KindChangeFromOpen, -5}, 192, 200, false},
+		{"rose 5% from open", Condition{KindChangeFromOpen, 5}, 210, 200, true},
+		{"no open", Condition{KindChangeFromOpen, -5}, 190, 0, false},
+		{"gained 20%", Condition{KindPositionGain, 20}, 120, 100, true},
+		{"gained 19%", Condition{KindPositionGain, 20}, 119, 100, false},
+		{"lost 10%", Condition{KindPositionGain, -10}, 90, 100, true},
+		{"no price", Condition{KindPriceBelow, 100}, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cond.Met(tt.price, tt.reference); got != tt.want {
+				t.Errorf("Met(%v, %v) = %v, want %v", tt.price, tt.reference, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChange(t *testing.T) {
+	if got := Change(104.5, 100); got != 4.5 {
+		t.Errorf("Change(104.5, 100) = %v, want 4.5", got)
+	}
+	if got := Change(100, 0); !math.IsNaN(got) {
+		t.Errorf("Change without reference = %v, want NaN", got)
+	}
+}
@@ -277,6 +277,7 @@ func (h *Handler) GetTransactions(c *fiber.Ctx) error {
 			"id":          tx.ID,
 			"type":        tx.Type,
 			"amount":      tx.Amount,
+			"fee":         tx.Fee,
 			"currency":    tx.Currency,
 			"status":      tx.Status,
 			"description": description,
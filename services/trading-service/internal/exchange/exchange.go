@@ -0,0 +1,224 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/fx"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// QuoteStore holds locked quotes until they expire or are used
+type QuoteStore interface {
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	Get(ctx context.Context, key string) (string, error)
+	SetNX(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// lockedQuote is a quote as stored, tied to the user it was issued to
+type lockedQuote struct {
+	UserID string    `json:"user_id"`
+	Quote  *fx.Quote `json:"quote"`
+}
+
+// Exchanger quotes and executes conversions between a user's wallets
+type Exchanger struct {
+	quoter *fx.Quoter
+	store  QuoteStore
+	uow    *repository.UnitOfWork
+}
+
+// New creates a new exchanger
+func New(quoter *fx.Quoter, store QuoteStore, uow *repository.UnitOfWork) *Exchanger {
+	return &Exchanger{quoter: quoter, store: store, uow: uow}
+}
+
+func quoteKey(id string) string { return "fx:quote:" + id }
+func usedKey(id string) string  { return "fx:quote:" + id + ":used" }
+
+// Quote prices a conversion for a user and locks its rate until the quote
+// expires
+func (e *Exchanger) Quote(ctx context.Context, userID, from, to string, amount float64) (*fx.Quote, error) {
+	quote, err := e.quoter.Quote(ctx, from, to, amount)
+	if err != nil {
+		if errors.Is(err, fx.ErrInvalidAmount) || errors.Is(err, fx.ErrUnsupportedPair) {
+			return nil, apperrors.ErrValidation.WithDetails(err.Error())
+		}
+		logger.Error().Err(err).Str("from", from).Str("to", to).Msg("Failed to get exchange rate")
+		return nil, apperrors.ErrServiceUnavailable.WithDetails("Exchange rates unavailable")
+	}
+
+	data, err := json.Marshal(lockedQuote{UserID: userID, Quote: quote})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal quote: %w", err)
+	}
+	if err := e.store.Set(ctx, quoteKey(quote.ID), string(data), time.Until(quote.ExpiresAt)); err != nil {
+		logger.Error().Err(err).Str("quote_id", quote.ID).Msg("Failed to store quote")
+		return nil, apperrors.ErrInternal
+	}
+
+	return quote, nil
+}
+
+// GetQuote returns a user's unexpired quote
+func (e *Exchanger) GetQuote(ctx context.Context, userID, quoteID string) (*fx.Quote, error) {
+	data, err := e.store.Get(ctx, quoteKey(quoteID))
+	if err != nil {
+		logger.Error().Err(err).Str("quote_id", quoteID).Msg("Failed to load quote")
+		return nil, apperrors.ErrInternal
+	}
+	if data == "" {
+		return nil, apperrors.ErrFXQuoteExpired
+	}
+
+	var locked lockedQuote
+	if err := json.Unmarshal([]byte(data), &locked); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal quote: %w", err)
+	}
+	if locked.UserID != userID {
+		return nil, apperrors.ErrFXQuoteNotFound
+	}
+	if locked.Quote.Expired(time.Now()) {
+		return nil, apperrors.ErrFXQuoteExpired
+	}
+
+	return locked.Quote, nil
+}
+
+// Convert executes a quote: the from wallet is debited and the to wallet
+// credited at the quoted rate, with a paired ledger entry for each side. A
+// quote can only be converted once.
+func (e *Exchanger) Convert(ctx context.Context, userID string, quote *fx.Quote) error {
+	if quote.Expired(time.Now()) {
+		return apperrors.ErrFXQuoteExpired
+	}
+
+	first, err := e.store.SetNX(ctx, usedKey(quote.ID), userID, time.Until(quote.ExpiresAt)+time.Minute)
+	if err != nil {
+		logger.Error().Err(err).Str("quote_id", quote.ID).Msg("Failed to claim quote")
+		return apperrors.ErrInternal
+	}
+	if !first {
+		return apperrors.ErrFXQuoteExpired
+	}
+
+	description := fmt.Sprintf("Convert %.2f %s to %.2f %s", quote.FromAmount, quote.From, quote.ToAmount, quote.To)
+	err = e.uow.Do(ctx, func(tx *repository.Tx) error {
+		return transfer(ctx, tx, userID, quote.From, quote.To, quote.FromAmount, quote.ToAmount, quote.ID, description, quoteMetadata(quote))
+	})
+	if err != nil {
+		// Nothing moved, so the quote can be retried until it expires
+		if delErr := e.store.Delete(ctx, usedKey(quote.ID)); delErr != nil {
+			logger.Warn().Err(delErr).Str("quote_id", quote.ID).Msg("Failed to release quote")
+		}
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			return apperrors.ErrInsufficientFunds
+		}
+		logger.Error().Err(err).Str("user_id", userID).Str("quote_id", quote.ID).Msg("Failed to convert currency")
+		return apperrors.ErrInternal.WithDetails("Failed to convert currency")
+	}
+
+	logger.Info().
+		Str("user_id", userID).
+		Str("quote_id", quote.ID).
+		Str("from", quote.From).
+		Str("to", quote.To).
+		Float64("from_amount", quote.FromAmount).
+		Float64("to_amount", quote.ToAmount).
+		Float64("rate", quote.Rate).
+		Msg("Currency converted")
+
+	return nil
+}
+
+// Reverse undoes the conversion of a quote whose proceeds were not used, e.g.
+// for an order that could not be placed. The converted amount moves back at
+// the quoted rate, so the user gets back exactly what was converted.
+func (e *Exchanger) Reverse(ctx context.Context, userID string, quote *fx.Quote) error {
+	metadata := quoteMetadata(quote)
+	metadata["reversal"] = true
+	description := fmt.Sprintf("Reverse conversion of %.2f %s to %.2f %s", quote.FromAmount, quote.From, quote.ToAmount, quote.To)
+
+	err := e.uow.Do(ctx, func(tx *repository.Tx) error {
+		return transfer(ctx, tx, userID, quote.To, quote.From, quote.ToAmount, quote.FromAmount, quote.ID, description, metadata)
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Str("quote_id", quote.ID).Msg("Failed to reverse currency conversion")
+		return apperrors.ErrInternal.WithDetails("Failed to reverse currency conversion")
+	}
+
+	logger.Info().
+		Str("user_id", userID).
+		Str("quote_id", quote.ID).
+		Float64("from_amount", quote.FromAmount).
+		Float64("to_amount", quote.ToAmount).
+		Msg("Currency conversion reversed")
+
+	return nil
+}
+
+// quoteMetadata is the ledger metadata of a conversion at quote
+func quoteMetadata(quote *fx.Quote) map[string]any {
+	return map[string]any{
+		"fx_quote_id": quote.ID,
+		"from":        quote.From,
+		"to":          quote.To,
+		"from_amount": quote.FromAmount,
+		"to_amount":   quote.ToAmount,
+		"mid_rate":    quote.MidRate,
+		"rate":        quote.Rate,
+		"spread_bps":  quote.SpreadBps,
+	}
+}
+
+// transfer debits fromAmount from the user's from wallet and credits
+// toAmount to their to wallet, with a paired ledger entry for each side: a
+// negative amount on the debited wallet and a positive one on the credited
+// wallet
+func transfer(ctx context.Context, tx *repository.Tx, userID, from, to string, fromAmount, toAmount float64, reference, description string, metadata map[string]any) error {
+	fromWallet, err := tx.Wallets.GetByUserAndCurrency(ctx, userID, from)
+	if err != nil {
+		return err
+	}
+	toWallet, err := tx.Wallets.GetByUserAndCurrency(ctx, userID, to)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Wallets.Debit(ctx, fromWallet.ID, fromAmount); err != nil {
+		return err
+	}
+	if err := tx.Wallets.Credit(ctx, toWallet.ID, toAmount); err != nil {
+		return err
+	}
+
+	if err := tx.Ledger.Add(ctx, &types.LedgerEntry{
+		UserID:      userID,
+		WalletID:    fromWallet.ID,
+		Type:        "transfer",
+		Amount:      -fromAmount,
+		Currency:    from,
+		Reference:   reference,
+		Description: description,
+		Metadata:    metadata,
+	}); err != nil {
+		return err
+	}
+	return tx.Ledger.Add(ctx, &types.LedgerEntry{
+		UserID:      userID,
+		WalletID:    toWallet.ID,
+		Type:        "transfer",
+		Amount:      toAmount,
+		Currency:    to,
+		Reference:   reference,
+		Description: description,
+		Metadata:    metadata,
+	})
+}
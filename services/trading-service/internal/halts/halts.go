@@ -0,0 +1,292 @@
+package halts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// Halt scopes
+const (
+	ScopeGlobal   = "global"    // No orders at all
+	ScopeSymbol   = "symbol"    // No orders in one symbol
+	ScopeSellOnly = "sell_only" // No buy orders
+	ScopeUser     = "user"      // No orders from one account
+)
+
+// flagsKey is the Redis hash holding the active halts, one field per halt
+const flagsKey = "trading:halts"
+
+// FlagStore holds the active halts
+type FlagStore interface {
+	HSet(ctx context.Context, key, field, value string) error
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	HDel(ctx context.Context, key, field string) error
+}
+
+// Status is the set of halts that apply to one order
+type Status struct {
+	Global   *types.TradingHalt
+	SellOnly *types.TradingHalt
+	Symbol   *types.TradingHalt
+	User     *types.TradingHalt
+}
+
+// Blocking returns the halt that stops an order on side, or nil if it may
+// be placed. A global halt takes precedence, then an account freeze.
+func (s *Status) Blocking(side string) *types.TradingHalt {
+	switch {
+	case s.Global != nil:
+		return s.Global
+	case s.User != nil:
+		return s.User
+	case s.Symbol != nil:
+		return s.Symbol
+	case s.SellOnly != nil && side == "buy":
+		return s.SellOnly
+	}
+	return nil
+}
+
+// Controller sets, clears and looks up trading halts. Every change is
+// audited and published through the outbox.
+type Controller struct {
+	store    FlagStore
+	haltRepo *repository.HaltRepository
+	uow      *repository.UnitOfWork
+}
+
+// New creates a new halt controller
+func New(store FlagStore, haltRepo *repository.HaltRepository, uow *repository.UnitOfWork) *Controller {
+	return &Controller{store: store, haltRepo: haltRepo, uow: uow}
+}
+
+func field(scope, target string) string {
+	if target == "" {
+		return scope
+	}
+	return scope + ":" + target
+}
+
+// Normalize validates a halt's scope and target, upper-casing symbols
+func Normalize(scope, target string) (string, error) {
+	target = strings.TrimSpace(target)
+	switch scope {
+	case ScopeGlobal, ScopeSellOnly:
+		if target != "" {
+			return "", apperrors.ErrValidation.WithDetails(fmt.Sprintf("The %s halt does not take a target", scope))
+		}
+	case ScopeSymbol:
+		if target == "" {
+			return "", apperrors.ErrValidation.WithDetails("A symbol halt needs the symbol as target")
+		}
+		target = strings.ToUpper(target)
+	case ScopeUser:
+		if target == "" {
+			return "", apperrors.ErrValidation.WithDetails("An account freeze needs the user ID as target")
+		}
+	default:
+		return "", apperrors.ErrValidation.WithDetails("Scope must be 'global', 'symbol', 'sell_only' or 'user'")
+	}
+	return target, nil
+}
+
+// List returns the active halts, ordered by scope and target
+func (c *Controller) List(ctx context.Context) ([]types.TradingHalt, error) {
+	fields, err := c.store.HGetAll(ctx, flagsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load trading halts: %w", err)
+	}
+
+	halts := make([]types.TradingHalt, 0, len(fields))
+	for name, data := range fields {
+		var h types.TradingHalt
+		if err := json.Unmarshal([]byte(data), &h); err != nil {
+			logger.Warn().Err(err).Str("field", name).Msg("Skipping unreadable trading halt")
+			continue
+		}
+		halts = append(halts, h)
+	}
+	slices.SortFunc(halts, func(a, b types.TradingHalt) int {
+		return strings.Compare(field(a.Scope, a.Target), field(b.Scope, b.Target))
+	})
+	return halts, nil
+}
+
+// Applicable returns the halts that apply to an order by userID in symbol
+func (c *Controller) Applicable(ctx context.Context, userID, symbol string) (*Status, error) {
+	halts, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status Status
+	for i := range halts {
+		h := &halts[i]
+		switch {
+		case h.Scope == ScopeGlobal:
+			status.Global = h
+		case h.Scope == ScopeSellOnly:
+			status.SellOnly = h
+		case h.Scope == ScopeSymbol && strings.EqualFold(h.Target, symbol):
+			status.Symbol = h
+		case h.Scope == ScopeUser && h.Target == userID:
+			status.User = h
+		}
+	}
+	return &status, nil
+}
+
+// Set activates a halt, replacing any with the same scope and target
+func (c *Controller) Set(ctx context.Context, req *types.SetTradingHaltRequest, actor string) (*types.TradingHalt, error) {
+	target, err := Normalize(req.Scope, req.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	halt := &types.TradingHalt{
+		Scope:  req.Scope,
+		Target: target,
+		Reason: strings.TrimSpace(req.Reason),
+		SetBy:  actor,
+		SetAt:  time.Now().UTC(),
+	}
+	data, err := json.Marshal(halt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal trading halt: %w", err)
+	}
+
+	// The flag is written last so a failed audit leaves it unchanged
+	err = c.record(ctx, "set", halt, actor, func() error {
+		return c.store.HSet(ctx, flagsKey, field(halt.Scope, halt.Target), string(data))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Warn().
+		Str("scope", halt.Scope).
+		Str("target", halt.Target).
+		Str("reason", halt.Reason).
+		Str("actor", actor).
+		Msg("Trading halt set")
+	return halt, nil
+}
+
+// Clear lifts a halt. It fails with ErrNotFound if the halt is not active.
+func (c *Controller) Clear(ctx context.Context, scope, target, actor string) (*types.TradingHalt, error) {
+	target, err := Normalize(scope, target)
+	if err != nil {
+		return nil, err
+	}
+
+	halts, err := c.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	idx := slices.IndexFunc(halts, func(h types.TradingHalt) bool {
+		return h.Scope == scope && h.Target == target
+	})
+	if idx < 0 {
+		return nil, apperrors.ErrNotFound.WithDetails("Trading halt is not active")
+	}
+	halt := &halts[idx]
+
+	err = c.record(ctx, "cleared", halt, actor, func() error {
+		return c.store.HDel(ctx, flagsKey, field(halt.Scope, halt.Target))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Warn().
+		Str("scope", halt.Scope).
+		Str("target", halt.Target).
+		Str("actor", actor).
+		Msg("Trading halt cleared")
+	return halt, nil
+}
+
+// History returns the most recent halt changes, newest first
+func (c *Controller) History(ctx context.Context, limit int) ([]types.TradingHaltEvent, error) {
+	return c.haltRepo.ListEvents(ctx, limit)
+}
+
+// record audits a halt change and queues its event, then applies it to the
+// flag store. A failed write rolls back the audit and event.
+func (c *Controller) record(ctx context.Context, action string, halt *types.TradingHalt, actor string, apply func() error) error {
+	return c.uow.Do(ctx, func(tx *repository.Tx) error {
+		if err := tx.Halts.AddEvent(ctx, &types.TradingHaltEvent{
+			Action: action,
+			Scope:  halt.Scope,
+			Target: halt.Target,
+			Reason: halt.Reason,
+			Actor:  actor,
+		}); err != nil {
+			return err
+		}
+
+		payload := events.TradingHaltChangedPayload{
+			Action:    action,
+			Scope:     halt.Scope,
+			Reason:    halt.Reason,
+			Message:   Message(halt.Scope, halt.Target, action == "set"),
+			Actor:     actor,
+			ChangedAt: time.Now().UTC(),
+		}
+		switch halt.Scope {
+		case ScopeSymbol:
+			payload.Symbol = halt.Target
+		case ScopeUser:
+			payload.UserID = halt.Target
+		}
+		if err := tx.Outbox.Add(ctx, events.TopicTradingHaltChanged, events.NewEvent(
+			events.EventTypeTradingHaltChanged,
+			"trading-service",
+			payload,
+		)); err != nil {
+			return err
+		}
+
+		if err := apply(); err != nil {
+			return fmt.Errorf("failed to update trading halts: %w", err)
+		}
+		return nil
+	})
+}
+
+// Message is the text shown to users when a halt is set or lifted
+func Message(scope, target string, active bool) string {
+	switch scope {
+	case ScopeGlobal:
+		if active {
+			return "Trading is temporarily halted. Open orders stay in place and you can still cancel them."
+		}
+		return "Trading has resumed."
+	case ScopeSymbol:
+		if active {
+			return fmt.Sprintf("Trading in %s is halted.", target)
+		}
+		return fmt.Sprintf("Trading in %s has resumed.", target)
+	case ScopeSellOnly:
+		if active {
+			return "Only sell orders are accepted at the moment."
+		}
+		return "Buy orders are accepted again."
+	case ScopeUser:
+		if active {
+			return "Trading is frozen on your account. Please contact support."
+		}
+		return "Trading on your account has been restored."
+	}
+	return ""
+}
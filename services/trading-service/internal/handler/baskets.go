@@ -0,0 +1,403 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/risk"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+const (
+	// maxBasketLegs caps how many symbols a basket can invest in
+	maxBasketLegs = 10
+
+	// minBasketLegAmount is Alpaca's minimum notional order
+	minBasketLegAmount = 1.0
+)
+
+// PlaceBasket invests one USD amount across several symbols by weight. The
+// total and the commission on each leg are locked once and each symbol is
+// placed as its own notional market order; the share of any leg that cannot
+// be placed is unlocked again.
+func (h *Handler) PlaceBasket(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	var req types.PlaceBasketRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+
+	idempotencyKey := c.Get("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		return apperrors.ErrValidation.WithDetails("Idempotency-Key must be at most 255 characters")
+	}
+
+	amounts, err := validateBasketRequest(&req)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Context()
+
+	// A retried request returns the basket created by the first attempt
+	if idempotencyKey != "" {
+		existing, err := h.basketRepo.GetByIdempotencyKey(ctx, userID, idempotencyKey)
+		if err != nil {
+			logger.Error().Err(err).Str("user_id", userID).Msg("Failed to look up idempotency key")
+			return apperrors.ErrInternal
+		}
+		if existing != nil {
+			return h.replayBasket(c, existing, &req)
+		}
+	}
+
+	user, err := h.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get user")
+		return apperrors.ErrInternal
+	}
+	if !user.IsActive {
+		return apperrors.ErrForbidden.WithDetails("Account is deactivated")
+	}
+
+	// A closing account only sells what it holds
+	closing, err := h.closureRepo.InProgress(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to check account closure")
+		return apperrors.ErrInternal
+	}
+	if closing {
+		return apperrors.ErrForbidden.WithDetails("Account is being closed")
+	}
+
+	// Every leg must pass the quote guard and risk checks before anything is
+	// locked; earlier legs count toward the daily limit of later ones
+	var pending float64
+	for i, leg := range req.Legs {
+		if err := h.protectMarketOrder(ctx, &types.PlaceOrderRequest{
+			Symbol: leg.Symbol,
+			Side:   "buy",
+			Type:   "market",
+			Amount: amounts[i],
+		}); err != nil {
+			return err
+		}
+		if err := h.risk.Check(ctx, &risk.Order{
+			User:        user,
+			Symbol:      leg.Symbol,
+			Side:        "buy",
+			Type:        "market",
+			TimeInForce: "day",
+			Amount:      amounts[i],
+			Notional:    amounts[i],
+			Pending:     pending,
+		}); err != nil {
+			return err
+		}
+		pending += amounts[i]
+	}
+
+	// Each leg is its own trade, so each pays its own commission
+	locks := make([]float64, len(req.Legs))
+	var lockAmount float64
+	for i := range req.Legs {
+		locks[i] = amounts[i] + h.commission(user, amounts[i])
+		lockAmount += locks[i]
+	}
+
+	wallet, err := h.walletRepo.GetByUserAndCurrency(ctx, userID, "USD")
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get wallet")
+		return apperrors.ErrInternal
+	}
+	if wallet.AvailableBalance() < lockAmount {
+		return apperrors.ErrInsufficientFunds
+	}
+	if err := h.walletRepo.Lock(ctx, wallet.ID, lockAmount); err != nil {
+		return apperrors.ErrInternal.WithDetails("Failed to lock funds")
+	}
+
+	basket := &types.Basket{
+		UserID: userID,
+		Name:   req.Name,
+		Amount: req.Amount,
+		Status: "pending",
+		Source: req.Source,
+	}
+	if basket.Source == "" {
+		basket.Source = "api"
+	}
+	if idempotencyKey != "" {
+		basket.IdempotencyKey = &idempotencyKey
+	}
+
+	if err := h.basketRepo.Create(ctx, basket); err != nil {
+		h.walletRepo.Unlock(ctx, wallet.ID, lockAmount)
+		if errors.Is(err, repository.ErrDuplicateBasket) {
+			// A concurrent retry with the same key created the basket first
+			if existing, err := h.basketRepo.GetByIdempotencyKey(ctx, userID, idempotencyKey); err == nil && existing != nil {
+				return h.replayBasket(c, existing, &req)
+			}
+		}
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to create basket")
+		return apperrors.ErrInternal
+	}
+
+	expiresAt := h.orderExpiry(ctx, "day")
+
+	orders := make([]types.Order, 0, len(req.Legs))
+	var placed int
+	var unplaced float64
+	for i, leg := range req.Legs {
+		order, ok := h.placeBasketLeg(ctx, basket, leg.Symbol, amounts[i], locks[i], expiresAt)
+		if ok {
+			placed++
+		} else {
+			unplaced += locks[i]
+		}
+		orders = append(orders, *order)
+	}
+
+	// Legs that never reached the broker give their share of the lock back
+	if unplaced > 0 {
+		if err := h.walletRepo.Unlock(ctx, wallet.ID, unplaced); err != nil {
+			logger.Error().Err(err).Str("basket_id", basket.ID).Float64("amount", unplaced).Msg("Failed to unlock funds for unplaced basket legs")
+		}
+	}
+
+	switch placed {
+	case len(req.Legs):
+		basket.Status = "placed"
+	case 0:
+		basket.Status = "failed"
+	default:
+		basket.Status = "partially_placed"
+	}
+	if err := h.basketRepo.UpdateStatus(ctx, basket.ID, basket.Status); err != nil {
+		logger.Error().Err(err).Str("basket_id", basket.ID).Msg("Failed to update basket status")
+	}
+	basket.SetOrders(orders)
+
+	logger.Info().
+		Str("user_id", userID).
+		Str("basket_id", basket.ID).
+		Float64("amount", basket.Amount).
+		Int("legs", len(req.Legs)).
+		Int("placed", placed).
+		Str("status", basket.Status).
+		Msg("Basket placed")
+
+	if placed == 0 {
+		return apperrors.ErrServiceUnavailable.WithDetails("Failed to place basket")
+	}
+	return c.Status(fiber.StatusCreated).JSON(basket)
+}
+
+// placeBasketLeg submits one leg of a basket as a notional market buy paid
+// from its share of the basket's lock, and saves it. A leg the broker did not
+// accept is saved as failed and reported as not placed.
+func (h *Handler) placeBasketLeg(ctx context.Context, basket *types.Basket, symbol string, amount, lockAmount float64, expiresAt *time.Time) (*types.Order, bool) {
+	// Derived from the basket so a lost response can be looked up again
+	clientOrderID := uuid.NewSHA1(uuid.NameSpaceOID, []byte(basket.ID+":"+symbol)).String()
+
+	order := &types.Order{
+		UserID:        basket.UserID,
+		ClientOrderID: clientOrderID,
+		Symbol:        symbol,
+		Side:          "buy",
+		Type:          "market",
+		Amount:        amount,
+		LockedAmount:  lockAmount,
+		TimeInForce:   "day",
+		ExpiresAt:     expiresAt,
+		Status:        "pending",
+		Source:        basket.Source,
+		OrderClass:    "simple",
+		BasketID:      &basket.ID,
+	}
+
+	alpacaOrder, err := h.alpaca.CreateOrder(ctx, &alpaca.CreateOrderRequest{
+		Symbol:        symbol,
+		Notional:      fmt.Sprintf("%.2f", amount),
+		Side:          alpaca.Buy,
+		Type:          alpaca.Market,
+		TimeInForce:   alpaca.Day,
+		ClientOrderID: clientOrderID,
+	})
+	if err != nil {
+		// The order may still have reached Alpaca, so look it up before
+		// treating it as failed
+		existing, lookupErr := h.alpaca.GetOrderByClientID(ctx, clientOrderID)
+		if lookupErr != nil {
+			logger.Error().Err(err).Str("basket_id", basket.ID).Str("symbol", symbol).Msg("Failed to create Alpaca order for basket leg")
+			alpacaOrder = nil
+		} else {
+			alpacaOrder = existing
+		}
+	}
+
+	if alpacaOrder == nil {
+		reason := "Failed to submit order to broker"
+		order.Status = "failed"
+		order.LockedAmount = 0
+		order.FailedReason = &reason
+	} else {
+		order.AlpacaOrderID = alpacaOrder.ID
+	}
+
+	if err := h.orderRepo.Create(ctx, order); err != nil {
+		logger.Error().Err(err).Str("basket_id", basket.ID).Str("symbol", symbol).Msg("Failed to save basket order to database")
+		// A placed order stays placed at Alpaca, log but continue
+	}
+
+	if alpacaOrder == nil {
+		return order, false
+	}
+
+	h.publishOrderCreated(ctx, order)
+	return order, true
+}
+
+// replayBasket returns the basket created by the first request for a retried
+// placement, provided the retry asks for the same amount
+func (h *Handler) replayBasket(c *fiber.Ctx, basket *types.Basket, req *types.PlaceBasketRequest) error {
+	if basket.Amount != req.Amount {
+		return apperrors.ErrConflict.WithDetails("Idempotency-Key was already used for a different basket")
+	}
+
+	orders, err := h.orderRepo.ListByBasket(c.Context(), basket.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("basket_id", basket.ID).Msg("Failed to list basket orders")
+		return apperrors.ErrInternal
+	}
+	basket.SetOrders(orders)
+
+	logger.Info().
+		Str("user_id", basket.UserID).
+		Str("basket_id", basket.ID).
+		Msg("Replayed idempotent basket placement")
+
+	c.Set("Idempotent-Replayed", "true")
+	return c.Status(fiber.StatusCreated).JSON(basket)
+}
+
+// GetBasket retrieves a basket with its orders and aggregate fill status
+func (h *Handler) GetBasket(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	basketID := c.Params("id")
+
+	ctx := c.Context()
+
+	basket, err := h.basketRepo.GetByID(ctx, basketID)
+	if err != nil {
+		if errors.Is(err, repository.ErrBasketNotFound) {
+			return apperrors.ErrNotFound.WithDetails("Basket not found")
+		}
+		logger.Error().Err(err).Str("basket_id", basketID).Msg("Failed to get basket")
+		return apperrors.ErrInternal
+	}
+
+	if basket.UserID != userID {
+		return apperrors.ErrForbidden.WithDetails("Not your basket")
+	}
+
+	orders, err := h.orderRepo.ListByBasket(ctx, basket.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("basket_id", basketID).Msg("Failed to list basket orders")
+		return apperrors.ErrInternal
+	}
+	basket.SetOrders(orders)
+
+	return c.JSON(basket)
+}
+
+// ListBaskets retrieves the user's most recent baskets
+func (h *Handler) ListBaskets(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	ctx := c.Context()
+
+	baskets, err := h.basketRepo.ListByUser(ctx, userID, c.QueryInt("limit", 20))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list baskets")
+		return apperrors.ErrInternal
+	}
+
+	for i := range baskets {
+		orders, err := h.orderRepo.ListByBasket(ctx, baskets[i].ID)
+		if err != nil {
+			logger.Error().Err(err).Str("basket_id", baskets[i].ID).Msg("Failed to list basket orders")
+			return apperrors.ErrInternal
+		}
+		baskets[i].SetOrders(orders)
+	}
+
+	return c.JSON(fiber.Map{
+		"baskets": baskets,
+		"count":   len(baskets),
+	})
+}
+
+// validateBasketRequest checks the request and returns the USD amount of
+// each leg. Amounts are rounded down to the cent, with the remainder going to
+// the last leg so the legs add up to the total exactly.
+func validateBasketRequest(req *types.PlaceBasketRequest) ([]float64, error) {
+	req.Amount = math.Round(req.Amount*100) / 100
+	if req.Amount <= 0 {
+		return nil, apperrors.ErrValidation.WithDetails("Amount must be positive")
+	}
+	if len(req.Legs) == 0 {
+		return nil, apperrors.ErrValidation.WithDetails("At least one leg is required")
+	}
+	if len(req.Legs) > maxBasketLegs {
+		return nil, apperrors.ErrValidation.WithDetails(fmt.Sprintf("A basket can have at most %d legs", maxBasketLegs))
+	}
+
+	seen := make(map[string]bool, len(req.Legs))
+	var totalWeight float64
+	for i := range req.Legs {
+		leg := &req.Legs[i]
+		leg.Symbol = strings.ToUpper(strings.TrimSpace(leg.Symbol))
+		if leg.Symbol == "" {
+			return nil, apperrors.ErrValidation.WithDetails("Symbol is required for every leg")
+		}
+		if seen[leg.Symbol] {
+			return nil, apperrors.ErrValidation.WithDetails("Symbols must be unique: " + leg.Symbol)
+		}
+		seen[leg.Symbol] = true
+		if leg.Weight <= 0 {
+			return nil, apperrors.ErrValidation.WithDetails("Weights must be positive")
+		}
+		totalWeight += leg.Weight
+	}
+	if math.Abs(totalWeight-100) > 0.01 {
+		return nil, apperrors.ErrValidation.WithDetails("Weights must add up to 100")
+	}
+
+	amounts := make([]float64, len(req.Legs))
+	var allocated float64
+	for i, leg := range req.Legs {
+		if i == len(req.Legs)-1 {
+			amounts[i] = math.Round((req.Amount-allocated)*100) / 100
+		} else {
+			amounts[i] = math.Floor(req.Amount*leg.Weight+1e-6) / 100
+			allocated += amounts[i]
+		}
+		if amounts[i] < minBasketLegAmount {
+			return nil, apperrors.ErrValidation.WithDetails(fmt.Sprintf("Each leg must be at least $%.2f", minBasketLegAmount))
+		}
+	}
+
+	return amounts, nil
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/mpesa"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// CloseAccount starts closing the user's account. The closure worker cancels
+// open orders, sells every holding and pays the balance out to the user's
+// M-Pesa number before the account is deactivated.
+func (h *Handler) CloseAccount(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	var req types.CloseAccountRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+	if !req.Confirm {
+		return apperrors.ErrValidation.WithDetails("Closing the account sells every holding; set confirm to true to proceed")
+	}
+
+	ctx := c.Context()
+
+	user, err := h.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get user")
+		return apperrors.ErrInternal
+	}
+	if !user.IsActive {
+		return apperrors.ErrForbidden.WithDetails("Account is deactivated")
+	}
+
+	closure := &types.AccountClosure{UserID: userID, Phone: user.Phone}
+	if err := h.closureRepo.Create(ctx, closure); err != nil {
+		if errors.Is(err, repository.ErrClosureInProgress) {
+			return apperrors.ErrConflict.WithDetails("Account closure already in progress")
+		}
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to create account closure")
+		return apperrors.ErrInternal
+	}
+
+	if h.publisher != nil {
+		h.publisher.Publish(ctx, events.TopicAccountClosureUpdated, events.NewEvent(
+			events.EventTypeAccountClosureUpdated,
+			"trading-service",
+			events.AccountClosureUpdatedPayload{
+				ClosureID: closure.ID,
+				UserID:    closure.UserID,
+				Status:    closure.Status,
+				UpdatedAt: time.Now().UTC(),
+			},
+		))
+	}
+
+	logger.Info().Str("closure_id", closure.ID).Str("user_id", userID).Msg("Account closure requested")
+
+	return c.Status(fiber.StatusAccepted).JSON(closure)
+}
+
+// GetAccountClosure returns the progress of the user's latest account closure
+func (h *Handler) GetAccountClosure(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	closure, err := h.closureRepo.GetLatestByUser(c.Context(), userID)
+	if errors.Is(err, repository.ErrClosureNotFound) {
+		return apperrors.ErrNotFound.WithDetails("No account closure requested")
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get account closure")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(closure)
+}
+
+// MpesaB2CResult records the result of an account closure payout. The
+// closure worker acts on it; M-Pesa only needs the callback acknowledged.
+func (h *Handler) MpesaB2CResult(c *fiber.Ctx) error {
+	accepted := fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"}
+
+	if subtle.ConstantTimeCompare([]byte(c.Query("token")), []byte(h.b2cCallbackToken)) != 1 {
+		logger.Warn().Str("ip", c.IP()).Msg("Rejected M-Pesa B2C result with invalid token")
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+
+	var callback mpesa.B2CCallback
+	if err := c.BodyParser(&callback); err != nil {
+		logger.Error().Err(err).Msg("Failed to parse M-Pesa B2C result")
+		return c.JSON(accepted)
+	}
+	data := mpesa.ParseB2CCallback(&callback)
+
+	logger.Info().
+		Str("conversation_id", data.ConversationID).
+		Int("result_code", data.ResultCode).
+		Str("result_desc", data.ResultDesc).
+		Msg("Received M-Pesa B2C result")
+
+	recorded, err := h.closureRepo.RecordPayoutResult(c.Context(), data.ConversationID, data.ResultCode, data.ResultDesc, data.TransactionReceipt)
+	if err != nil {
+		logger.Error().Err(err).Str("conversation_id", data.ConversationID).Msg("Failed to record M-Pesa B2C result")
+	} else if !recorded {
+		logger.Warn().Str("conversation_id", data.ConversationID).Msg("M-Pesa B2C result matched no payout in flight")
+	}
+
+	return c.JSON(accepted)
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/triggers"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// CreateConditionalOrder stores an order to be placed once a price condition
+// on its symbol is met
+func (h *Handler) CreateConditionalOrder(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	var req types.CreateConditionalOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+	if req.Order == nil {
+		return apperrors.ErrValidation.WithDetails("Order is required")
+	}
+
+	cond := triggers.Condition{Kind: req.Condition, Threshold: req.Threshold}
+	if err := cond.Validate(); err != nil {
+		if errors.Is(err, triggers.ErrInvalidKind) {
+			return apperrors.ErrValidation.WithDetails("Condition must be 'price_above', 'price_below', 'change_from_open' or 'position_gain'")
+		}
+		if cond.NeedsReference() {
+			return apperrors.ErrValidation.WithDetails("Threshold must be a non-zero percentage above -100")
+		}
+		return apperrors.ErrValidation.WithDetails("Threshold must be a positive price")
+	}
+
+	order := req.Order
+	order.Symbol = strings.ToUpper(strings.TrimSpace(order.Symbol))
+	if err := validateOrderRequest(order); err != nil {
+		return err
+	}
+	// A KES amount is converted at a quote taken when the order is placed;
+	// a quote locked now would have expired by then
+	if order.FXQuoteID != "" {
+		return apperrors.ErrValidation.WithDetails("Conditional orders do not take an fx_quote_id")
+	}
+	now := time.Now().UTC()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return apperrors.ErrValidation.WithDetails("expires_at must be in the future")
+	}
+
+	ctx := c.Context()
+
+	if cond.Kind == triggers.KindPositionGain {
+		if err := h.checkPosition(ctx, userID, order.Symbol); err != nil {
+			return err
+		}
+	}
+
+	asset, err := h.alpaca.GetAsset(ctx, order.Symbol)
+	if alpaca.IsNotFound(err) {
+		return apperrors.ErrInvalidSymbol
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("symbol", order.Symbol).Msg("Failed to get asset")
+		return apperrors.ErrAlpacaUnavailable
+	}
+	if !asset.Tradable {
+		return apperrors.ErrSymbolNotTradeable
+	}
+
+	conditional := &types.ConditionalOrder{
+		UserID:    userID,
+		Symbol:    order.Symbol,
+		Condition: cond.Kind,
+		Threshold: cond.Threshold,
+		Order:     *order,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := h.conditionalRepo.Create(ctx, conditional); err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to create conditional order")
+		return apperrors.ErrInternal
+	}
+
+	logger.Info().
+		Str("user_id", userID).
+		Str("conditional_order_id", conditional.ID).
+		Str("symbol", conditional.Symbol).
+		Str("condition", conditional.Condition).
+		Float64("threshold", conditional.Threshold).
+		Msg("Conditional order created")
+
+	return c.Status(fiber.StatusCreated).JSON(conditional)
+}
+
+// ListConditionalOrders retrieves the user's conditional orders, optionally
+// in one status
+func (h *Handler) ListConditionalOrders(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	orders, err := h.conditionalRepo.ListByUser(c.Context(), userID, c.Query("status"), c.QueryInt("limit", 50))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list conditional orders")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"conditional_orders": orders,
+		"count":              len(orders),
+	})
+}
+
+// GetConditionalOrder retrieves a conditional order
+func (h *Handler) GetConditionalOrder(c *fiber.Ctx) error {
+	conditional, err := h.userConditionalOrder(c.Context(), c.Locals("user_id").(string), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(conditional)
+}
+
+// CancelConditionalOrder cancels a conditional order that has not triggered.
+// An order already placed is canceled through the orders endpoint.
+func (h *Handler) CancelConditionalOrder(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	conditional, err := h.userConditionalOrder(ctx, c.Locals("user_id").(string), c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if conditional.Status == "canceled" {
+		return c.JSON(conditional)
+	}
+
+	canceled, err := h.conditionalRepo.Cancel(ctx, conditional.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("conditional_order_id", conditional.ID).Msg("Failed to cancel conditional order")
+		return apperrors.ErrInternal
+	}
+	if canceled == nil {
+		return apperrors.ErrValidation.WithDetails("Conditional order has already " + conditional.Status)
+	}
+
+	logger.Info().Str("conditional_order_id", canceled.ID).Msg("Conditional order canceled")
+
+	return c.JSON(canceled)
+}
+
+// userConditionalOrder loads a conditional order and checks it belongs to
+// the user
+func (h *Handler) userConditionalOrder(ctx context.Context, userID, id string) (*types.ConditionalOrder, error) {
+	conditional, err := h.conditionalRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrConditionalOrderNotFound) {
+			return nil, apperrors.ErrNotFound.WithDetails("Conditional order not found")
+		}
+		logger.Error().Err(err).Str("conditional_order_id", id).Msg("Failed to get conditional order")
+		return nil, apperrors.ErrInternal
+	}
+
+	if conditional.UserID != userID {
+		return nil, apperrors.ErrForbidden.WithDetails("Not your conditional order")
+	}
+
+	return conditional, nil
+}
+
+// checkPosition verifies the user holds shares in symbol, which a
+// position_gain condition is measured against
+func (h *Handler) checkPosition(ctx context.Context, userID, symbol string) error {
+	holdings, err := h.holdingRepo.ListByUser(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to list holdings")
+		return apperrors.ErrInternal
+	}
+	if !slices.ContainsFunc(holdings, func(hl types.Holding) bool { return hl.Symbol == symbol }) {
+		return apperrors.ErrValidation.WithDetails("position_gain needs a position in " + symbol)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+)
+
+// ListCorporateActions retrieves the dividends, splits and symbol changes
+// applied to the user's holdings, most recent first
+func (h *Handler) ListCorporateActions(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	entries, err := h.actionRepo.ListByUser(c.Context(), userID, c.QueryInt("limit", 50))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list corporate actions")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"corporate_actions": entries,
+		"count":             len(entries),
+	})
+}
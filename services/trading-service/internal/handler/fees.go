@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"math"
+
+	"github.com/gofiber/fiber/v2"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// QuoteOrder prices an order without placing it: its estimated cost, the
+// commission on that cost under the user's fee schedule, and what a buy
+// would lock or a sell is expected to net. A KES buy is quoted with a locked
+// conversion that can be placed with its fx_quote_id.
+func (h *Handler) QuoteOrder(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	var req types.PlaceOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+	if err := validateOrderRequest(&req); err != nil {
+		return err
+	}
+
+	ctx := c.Context()
+
+	user, err := h.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get user")
+		return apperrors.ErrInternal
+	}
+
+	resp := types.OrderQuoteResponse{
+		Symbol:  req.Symbol,
+		Side:    req.Side,
+		Type:    req.Type,
+		FeeTier: user.TradingTier(),
+	}
+	if h.fees != nil {
+		resp.Schedule = h.fees.Schedule(user.TradingTier())
+	}
+
+	if req.AmountKES > 0 {
+		fxQuote, err := h.kesQuote(ctx, userID, &req)
+		if err != nil {
+			return err
+		}
+		req.Amount, err = h.investableAmount(user, fxQuote.ToAmount)
+		if err != nil {
+			return err
+		}
+		resp.AmountKES = req.AmountKES
+		resp.FXQuoteID = fxQuote.ID
+	}
+
+	cost, err := h.estimateCost(ctx, &req)
+	if err != nil {
+		return err
+	}
+
+	resp.Notional = cost
+	resp.Fee = h.commission(user, cost)
+	if req.Side == "buy" {
+		resp.Total = cost + resp.Fee
+	} else {
+		resp.Total = cost - resp.Fee
+	}
+
+	return c.JSON(resp)
+}
+
+// commission quotes the fee on a trade of the given USD notional for a user,
+// at the tier they trade under
+func (h *Handler) commission(user *types.User, notional float64) float64 {
+	if h.fees == nil {
+		return 0
+	}
+	return h.fees.Quote(user.TradingTier(), notional).Fee
+}
+
+// investableAmount returns the part of a converted KES amount left to invest
+// once the commission on it is set aside, so a KES buy is paid for in full by
+// its conversion
+func (h *Handler) investableAmount(user *types.User, converted float64) (float64, error) {
+	amount := math.Floor((converted-h.commission(user, converted))*100) / 100
+	if amount <= 0 {
+		return 0, apperrors.ErrValidation.WithDetails("amount_kes does not cover the commission")
+	}
+	return amount, nil
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/middleware"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// ListHalts retrieves the active trading halts
+func (h *Handler) ListHalts(c *fiber.Ctx) error {
+	active, err := h.halts.List(c.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list trading halts")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"halts": active,
+		"count": len(active),
+	})
+}
+
+// SetHalt sets a global halt, symbol halt, sell-only mode or account freeze
+func (h *Handler) SetHalt(c *fiber.Ctx) error {
+	var req types.SetTradingHaltRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+
+	halt, err := h.halts.Set(c.Context(), &req, middleware.GetAdminActor(c))
+	if err != nil {
+		return haltError(err, "Failed to set trading halt")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(halt)
+}
+
+// ClearHalt lifts a trading halt. The target is the symbol or user ID for
+// symbol halts and account freezes.
+func (h *Handler) ClearHalt(c *fiber.Ctx) error {
+	halt, err := h.halts.Clear(c.Context(), c.Params("scope"), c.Params("target"), middleware.GetAdminActor(c))
+	if err != nil {
+		return haltError(err, "Failed to clear trading halt")
+	}
+
+	return c.JSON(halt)
+}
+
+// ListHaltEvents retrieves the audit trail of halt changes, newest first
+func (h *Handler) ListHaltEvents(c *fiber.Ctx) error {
+	history, err := h.halts.History(c.Context(), c.QueryInt("limit", 100))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list trading halt events")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"events": history,
+		"count":  len(history),
+	})
+}
+
+// haltError passes validation errors through and hides the rest
+func haltError(err error, msg string) error {
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	logger.Error().Err(err).Msg(msg)
+	return apperrors.ErrInternal
+}
@@ -2,8 +2,12 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -11,19 +15,58 @@ import (
 	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
 	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
 	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/fees"
+	"github.com/Rohianon/equishare-global-trading/pkg/fx"
 	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/slippage"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/exchange"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/halts"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/reconcile"
 	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/risk"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/settlement"
 	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
 )
 
+const (
+	// gtcMaxAge mirrors Alpaca, which cancels GTC orders after 90 days
+	gtcMaxAge = 90 * 24 * time.Hour
+
+	// immediateOrderGrace is how long IOC/FOK orders may stay open locally
+	// while waiting for the broker's cancel or fill webhook
+	immediateOrderGrace = 5 * time.Minute
+)
+
 // Handler handles trading HTTP requests
 type Handler struct {
-	userRepo    *repository.UserRepository
-	walletRepo  *repository.WalletRepository
-	orderRepo   *repository.OrderRepository
-	holdingRepo *repository.HoldingRepository
-	alpaca      alpaca.TradingClient
-	publisher   events.Publisher
+	userRepo        *repository.UserRepository
+	walletRepo      *repository.WalletRepository
+	orderRepo       *repository.OrderRepository
+	holdingRepo     *repository.HoldingRepository
+	planRepo        *repository.PlanRepository
+	basketRepo      *repository.BasketRepository
+	omnibusRepo     *repository.OmnibusRepository
+	actionRepo      *repository.CorporateActionRepository
+	lotRepo         *repository.TaxLotRepository
+	conditionalRepo *repository.ConditionalOrderRepository
+	closureRepo     *repository.AccountClosureRepository
+	settler         *settlement.Settler
+	risk            *risk.Engine
+	exchanger       *exchange.Exchanger
+	halts           *halts.Controller
+	reconciler      *reconcile.Reconciler
+	fees            *fees.Engine
+	slippage        *slippage.Guard
+	alpaca          alpaca.TradingClient
+	publisher       events.Publisher
+
+	// omnibusMaxAmount is the largest notional market buy queued for
+	// aggregation into an omnibus order; 0 submits every order directly
+	omnibusMaxAmount float64
+
+	// b2cCallbackToken authenticates M-Pesa B2C result callbacks, which
+	// M-Pesa cannot sign; it is part of the registered result URL
+	b2cCallbackToken string
 }
 
 // New creates a new trading handler
@@ -32,16 +75,49 @@ func New(
 	walletRepo *repository.WalletRepository,
 	orderRepo *repository.OrderRepository,
 	holdingRepo *repository.HoldingRepository,
+	planRepo *repository.PlanRepository,
+	basketRepo *repository.BasketRepository,
+	omnibusRepo *repository.OmnibusRepository,
+	actionRepo *repository.CorporateActionRepository,
+	lotRepo *repository.TaxLotRepository,
+	conditionalRepo *repository.ConditionalOrderRepository,
+	closureRepo *repository.AccountClosureRepository,
+	settler *settlement.Settler,
+	riskEngine *risk.Engine,
+	exchanger *exchange.Exchanger,
+	haltController *halts.Controller,
+	reconciler *reconcile.Reconciler,
+	feeEngine *fees.Engine,
+	slippageGuard *slippage.Guard,
 	alpacaClient alpaca.TradingClient,
 	publisher events.Publisher,
+	omnibusMaxAmount float64,
+	b2cCallbackToken string,
 ) *Handler {
 	return &Handler{
-		userRepo:    userRepo,
-		walletRepo:  walletRepo,
-		orderRepo:   orderRepo,
-		holdingRepo: holdingRepo,
-		alpaca:      alpacaClient,
-		publisher:   publisher,
+		userRepo:        userRepo,
+		walletRepo:      walletRepo,
+		orderRepo:       orderRepo,
+		holdingRepo:     holdingRepo,
+		planRepo:        planRepo,
+		basketRepo:      basketRepo,
+		omnibusRepo:     omnibusRepo,
+		actionRepo:      actionRepo,
+		lotRepo:         lotRepo,
+		conditionalRepo: conditionalRepo,
+		closureRepo:     closureRepo,
+		settler:         settler,
+		risk:            riskEngine,
+		exchanger:       exchanger,
+		halts:           haltController,
+		reconciler:      reconciler,
+		fees:            feeEngine,
+		slippage:        slippageGuard,
+		alpaca:          alpacaClient,
+		publisher:       publisher,
+
+		omnibusMaxAmount: omnibusMaxAmount,
+		b2cCallbackToken: b2cCallbackToken,
 	}
 }
 
@@ -54,75 +130,191 @@ func (h *Handler) PlaceOrder(c *fiber.Ctx) error {
 		return apperrors.ErrValidation.WithDetails("Invalid request body")
 	}
 
-	if req.Symbol == "" {
-		return apperrors.ErrValidation.WithDetails("Symbol is required")
+	idempotencyKey := c.Get("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		return apperrors.ErrValidation.WithDetails("Idempotency-Key must be at most 255 characters")
 	}
-	if req.Side != "buy" && req.Side != "sell" {
-		return apperrors.ErrValidation.WithDetails("Side must be 'buy' or 'sell'")
+	// Only the account closure worker places closure sells
+	if req.Source == risk.SourceClosure {
+		return apperrors.ErrValidation.WithDetails("Invalid source")
 	}
-	if req.Amount <= 0 && req.Qty <= 0 {
-		return apperrors.ErrValidation.WithDetails("Amount or qty is required")
+
+	placed, err := h.Place(c.Context(), userID, idempotencyKey, &req)
+	if err != nil {
+		return err
 	}
 
-	ctx := c.Context()
+	if placed.Replayed {
+		c.Set("Idempotent-Replayed", "true")
+		return c.Status(fiber.StatusCreated).JSON(orderResponse(placed.Order, placed.Order.Status, "Order already placed"))
+	}
+	resp := orderResponse(placed.Order, placed.BrokerStatus, "Order placed successfully")
+	resp.EstimatedFee = placed.EstimatedFee
+	return c.Status(fiber.StatusCreated).JSON(resp)
+}
+
+// Placement is the outcome of placing an order
+type Placement struct {
+	Order        *types.Order
+	BrokerStatus string  // Order status reported by Alpaca
+	EstimatedFee float64 // Commission quoted at the estimated cost, charged at fill
+	Replayed     bool    // Placed by an earlier request with the same idempotency key
+}
+
+// Place validates, risk-checks, funds and submits an order for a user. It
+// backs the PlaceOrder endpoint and orders placed on a schedule.
+func (h *Handler) Place(ctx context.Context, userID, idempotencyKey string, req *types.PlaceOrderRequest) (*Placement, error) {
+	if err := validateOrderRequest(req); err != nil {
+		return nil, err
+	}
+
+	// A retried request returns the order created by the first attempt
+	if idempotencyKey != "" {
+		existing, err := h.orderRepo.GetByIdempotencyKey(ctx, userID, idempotencyKey)
+		if err != nil {
+			logger.Error().Err(err).Str("user_id", userID).Msg("Failed to look up idempotency key")
+			return nil, apperrors.ErrInternal
+		}
+		if existing != nil {
+			return replayOrder(existing, req)
+		}
+	}
 
 	// Verify user exists and is active
 	user, err := h.userRepo.GetByID(ctx, userID)
 	if err != nil {
 		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get user")
-		return apperrors.ErrInternal
+		return nil, apperrors.ErrInternal
 	}
 	if !user.IsActive {
-		return apperrors.ErrForbidden.WithDetails("Account is deactivated")
+		return nil, apperrors.ErrForbidden.WithDetails("Account is deactivated")
+	}
+
+	// A closing account only sells what it holds
+	if req.Side == "buy" {
+		closing, err := h.closureRepo.InProgress(ctx, userID)
+		if err != nil {
+			logger.Error().Err(err).Str("user_id", userID).Msg("Failed to check account closure")
+			return nil, apperrors.ErrInternal
+		}
+		if closing {
+			return nil, apperrors.ErrForbidden.WithDetails("Account is being closed")
+		}
+	}
+
+	// Risk rules judge the order the user placed, not the protective limit
+	// order a market order is sent as
+	orderType := req.Type
+	if err := h.protectMarketOrder(ctx, req); err != nil {
+		return nil, err
+	}
+
+	// Price a KES buy in USD up front so risk checks see its value; the
+	// conversion itself only runs once the order has passed them
+	var fxQuote *fx.Quote
+	if req.AmountKES > 0 {
+		fxQuote, err = h.kesQuote(ctx, userID, req)
+		if err != nil {
+			return nil, err
+		}
+		req.Amount, err = h.investableAmount(user, fxQuote.ToAmount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Run pre-trade risk checks before touching funds
+	cost, err := h.estimateCost(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.risk.Check(ctx, &risk.Order{
+		User:        user,
+		Symbol:      req.Symbol,
+		Side:        req.Side,
+		Type:        orderType,
+		TimeInForce: req.TimeInForce,
+		Qty:         req.Qty,
+		Amount:      req.Amount,
+		Notional:    cost,
+		Source:      req.Source,
+	}); err != nil {
+		return nil, err
+	}
+
+	// Once converted, the USD is either spent on this order or converted
+	// back: every return before the order is queued or live at Alpaca
+	// reverses the conversion
+	var funded bool
+	if fxQuote != nil {
+		if err := h.exchanger.Convert(ctx, userID, fxQuote); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if !funded {
+				h.reverseConversion(ctx, userID, fxQuote)
+			}
+		}()
 	}
 
 	// Get USD wallet for balance checks
 	wallet, err := h.walletRepo.GetByUserAndCurrency(ctx, userID, "USD")
 	if err != nil {
 		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get wallet")
-		return apperrors.ErrInternal
+		return nil, apperrors.ErrInternal
 	}
 
-	// For buy orders, check and lock funds
+	// For buy orders, check and lock funds for the cost and its commission
+	fee := h.commission(user, cost)
+	var lockAmount float64
 	if req.Side == "buy" {
-		amount := req.Amount
-		if amount <= 0 {
-			// If qty specified, estimate the amount (we'll use actual at fill)
-			quote, err := h.alpaca.GetQuote(ctx, req.Symbol)
-			if err != nil {
-				return apperrors.ErrServiceUnavailable.WithDetails("Failed to get quote")
-			}
-			amount = req.Qty * quote.AskPrice * 1.01 // Add 1% buffer
-		}
+		lockAmount = cost + fee
 
-		if wallet.AvailableBalance() < amount {
-			return apperrors.ErrValidation.WithDetails("Insufficient balance")
+		if wallet.AvailableBalance() < lockAmount {
+			return nil, apperrors.ErrInsufficientFunds
 		}
 
-		if err := h.walletRepo.Lock(ctx, wallet.ID, amount); err != nil {
-			return apperrors.ErrInternal.WithDetails("Failed to lock funds")
+		if err := h.walletRepo.Lock(ctx, wallet.ID, lockAmount); err != nil {
+			return nil, apperrors.ErrInternal.WithDetails("Failed to lock funds")
 		}
 	}
 
 	// For sell orders, check holdings
 	if req.Side == "sell" {
 		if req.Qty <= 0 {
-			return apperrors.ErrValidation.WithDetails("Qty is required for sell orders")
+			return nil, apperrors.ErrValidation.WithDetails("Qty is required for sell orders")
 		}
 
 		hasSufficient, err := h.holdingRepo.HasSufficientQty(ctx, userID, req.Symbol, req.Qty)
 		if err != nil || !hasSufficient {
-			return apperrors.ErrValidation.WithDetails("Insufficient shares to sell")
+			return nil, apperrors.ErrValidation.WithDetails("Insufficient shares to sell")
+		}
+
+		if err := h.checkLots(ctx, userID, req); err != nil {
+			return nil, err
 		}
 	}
 
+	// Small notional buys wait to be submitted together in an omnibus order
+	if h.aggregates(req) {
+		placed, err := h.queueOrder(ctx, userID, idempotencyKey, req, wallet, lockAmount)
+		if err == nil && !placed.Replayed {
+			placed.EstimatedFee = fee
+			funded = true
+		}
+		return placed, err
+	}
+
 	// Submit order to Alpaca
 	clientOrderID := uuid.New().String()
+	if idempotencyKey != "" {
+		clientOrderID = idempotentClientOrderID(userID, idempotencyKey)
+	}
 	alpacaReq := &alpaca.CreateOrderRequest{
 		Symbol:        req.Symbol,
 		Side:          alpaca.OrderSide(req.Side),
-		Type:          alpaca.Market,
-		TimeInForce:   alpaca.Day,
+		Type:          alpaca.OrderType(req.Type),
+		TimeInForce:   alpaca.TimeInForce(req.TimeInForce),
 		ClientOrderID: clientOrderID,
 	}
 
@@ -131,70 +323,549 @@ func (h *Handler) PlaceOrder(c *fiber.Ctx) error {
 	} else {
 		alpacaReq.Qty = fmt.Sprintf("%.6f", req.Qty)
 	}
+	if req.LimitPrice > 0 {
+		alpacaReq.LimitPrice = fmt.Sprintf("%.2f", req.LimitPrice)
+	}
+	if req.StopPrice > 0 {
+		alpacaReq.StopPrice = fmt.Sprintf("%.2f", req.StopPrice)
+	}
+	if req.OrderClass != "simple" {
+		alpacaReq.OrderClass = alpaca.OrderClass(req.OrderClass)
+		if req.TakeProfit != nil {
+			alpacaReq.TakeProfit = &alpaca.TakeProfit{LimitPrice: fmt.Sprintf("%.2f", req.TakeProfit.LimitPrice)}
+		}
+		if req.StopLoss != nil {
+			alpacaReq.StopLoss = &alpaca.StopLoss{StopPrice: fmt.Sprintf("%.2f", req.StopLoss.StopPrice)}
+			if req.StopLoss.LimitPrice > 0 {
+				alpacaReq.StopLoss.LimitPrice = fmt.Sprintf("%.2f", req.StopLoss.LimitPrice)
+			}
+		}
+	}
 
 	alpacaOrder, err := h.alpaca.CreateOrder(ctx, alpacaReq)
 	if err != nil {
-		logger.Error().Err(err).Str("user_id", userID).Str("symbol", req.Symbol).Msg("Failed to create Alpaca order")
-		// Unlock funds on failure
-		if req.Side == "buy" {
-			h.walletRepo.Unlock(ctx, wallet.ID, req.Amount)
+		// The order may still have reached Alpaca (timeout, dropped connection),
+		// so look it up by client order ID before treating it as failed
+		existing, lookupErr := h.alpaca.GetOrderByClientID(ctx, clientOrderID)
+		if lookupErr != nil {
+			logger.Error().Err(err).Str("user_id", userID).Str("symbol", req.Symbol).Msg("Failed to create Alpaca order")
+			// Unlock funds on failure
+			if lockAmount > 0 {
+				h.walletRepo.Unlock(ctx, wallet.ID, lockAmount)
+			}
+			return nil, apperrors.ErrServiceUnavailable.WithDetails("Failed to place order")
 		}
-		return apperrors.ErrServiceUnavailable.WithDetails("Failed to place order")
+
+		logger.Warn().Err(err).
+			Str("client_order_id", clientOrderID).
+			Str("alpaca_order_id", existing.ID).
+			Msg("Alpaca order exists despite create error")
+		alpacaOrder = existing
 	}
+	funded = true
 
 	// Save order to database
 	order := &types.Order{
 		UserID:        userID,
 		AlpacaOrderID: alpacaOrder.ID,
+		ClientOrderID: clientOrderID,
 		Symbol:        req.Symbol,
 		Side:          req.Side,
-		Type:          "market",
+		Type:          req.Type,
 		Amount:        req.Amount,
 		Qty:           req.Qty,
+		LockedAmount:  lockAmount,
+		TimeInForce:   req.TimeInForce,
+		ExpiresAt:     h.orderExpiry(ctx, req.TimeInForce),
 		Status:        "pending",
 		Source:        req.Source,
+		OrderClass:    req.OrderClass,
+		LotMethod:     req.LotMethod,
+		LotIDs:        req.LotIDs,
+	}
+	if req.LimitPrice > 0 {
+		order.LimitPrice = &req.LimitPrice
+	}
+	if req.StopPrice > 0 {
+		order.StopPrice = &req.StopPrice
+	}
+	if idempotencyKey != "" {
+		order.IdempotencyKey = &idempotencyKey
 	}
 	if order.Source == "" {
 		order.Source = "api"
 	}
 
 	if err := h.orderRepo.Create(ctx, order); err != nil {
+		if errors.Is(err, repository.ErrDuplicateOrder) && idempotencyKey != "" {
+			// A concurrent retry with the same key saved the order first; keep
+			// its lock and conversion only
+			if lockAmount > 0 {
+				h.walletRepo.Unlock(ctx, wallet.ID, lockAmount)
+			}
+			funded = false
+			if existing, err := h.orderRepo.GetByIdempotencyKey(ctx, userID, idempotencyKey); err == nil && existing != nil {
+				return replayOrder(existing, req)
+			}
+		}
 		logger.Error().Err(err).Msg("Failed to save order to database")
 		// Order was placed with Alpaca, log but continue
+	} else if len(alpacaOrder.Legs) > 0 {
+		order.Legs = h.saveLegs(ctx, order, alpacaOrder.Legs)
 	}
 
-	// Publish order created event
-	if h.publisher != nil {
-		h.publisher.Publish(ctx, events.TopicOrderCreated, events.NewEvent(
-			events.EventTypeOrderCreated,
-			"trading-service",
-			map[string]any{
-				"order_id":        order.ID,
-				"user_id":         userID,
-				"symbol":          req.Symbol,
-				"side":            req.Side,
-				"amount":          req.Amount,
-				"alpaca_order_id": alpacaOrder.ID,
-			},
-		))
-	}
+	h.publishOrderCreated(ctx, order)
 
 	logger.Info().
 		Str("user_id", userID).
 		Str("order_id", order.ID).
 		Str("symbol", req.Symbol).
 		Str("side", req.Side).
+		Str("type", req.Type).
 		Msg("Order placed successfully")
 
-	return c.Status(fiber.StatusCreated).JSON(types.PlaceOrderResponse{
-		OrderID:       order.ID,
-		AlpacaOrderID: alpacaOrder.ID,
+	return &Placement{Order: order, BrokerStatus: string(alpacaOrder.Status), EstimatedFee: fee}, nil
+}
+
+// reverseConversion converts back the KES converted for an order that was not
+// placed. A failure leaves the user holding USD, so it is logged for support.
+func (h *Handler) reverseConversion(ctx context.Context, userID string, quote *fx.Quote) {
+	if err := h.exchanger.Reverse(ctx, userID, quote); err != nil {
+		logger.Error().Err(err).
+			Str("user_id", userID).
+			Str("quote_id", quote.ID).
+			Float64("usd", quote.ToAmount).
+			Msg("KES converted for an unplaced order was not converted back")
+	}
+}
+
+// aggregates reports whether an order is queued for the omnibus aggregator
+// rather than submitted on its own
+func (h *Handler) aggregates(req *types.PlaceOrderRequest) bool {
+	return h.omnibusMaxAmount > 0 && req.Side == "buy" && req.Type == "market" &&
+		req.OrderClass == "simple" && req.Amount > 0 && req.Amount <= h.omnibusMaxAmount
+}
+
+// queueOrder saves a funded order as queued for the omnibus aggregator. It
+// has no Alpaca order of its own; its shares are allocated from the omnibus
+// fill.
+func (h *Handler) queueOrder(ctx context.Context, userID, idempotencyKey string, req *types.PlaceOrderRequest, wallet *types.Wallet, lockAmount float64) (*Placement, error) {
+	order := &types.Order{
+		UserID:        userID,
+		ClientOrderID: uuid.New().String(),
 		Symbol:        req.Symbol,
 		Side:          req.Side,
+		Type:          req.Type,
 		Amount:        req.Amount,
-		Status:        string(alpacaOrder.Status),
-		Message:       "Order placed successfully",
-	})
+		LockedAmount:  lockAmount,
+		TimeInForce:   req.TimeInForce,
+		Status:        "queued",
+		Source:        req.Source,
+		OrderClass:    req.OrderClass,
+	}
+	if idempotencyKey != "" {
+		order.IdempotencyKey = &idempotencyKey
+		order.ClientOrderID = idempotentClientOrderID(userID, idempotencyKey)
+	}
+	if order.Source == "" {
+		order.Source = "api"
+	}
+
+	if err := h.orderRepo.Create(ctx, order); err != nil {
+		h.walletRepo.Unlock(ctx, wallet.ID, lockAmount)
+		if errors.Is(err, repository.ErrDuplicateOrder) && idempotencyKey != "" {
+			// A concurrent retry with the same key saved the order first
+			if existing, err := h.orderRepo.GetByIdempotencyKey(ctx, userID, idempotencyKey); err == nil && existing != nil {
+				return replayOrder(existing, req)
+			}
+		}
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to save queued order")
+		return nil, apperrors.ErrInternal.WithDetails("Failed to place order")
+	}
+
+	h.publishOrderCreated(ctx, order)
+
+	logger.Info().
+		Str("user_id", userID).
+		Str("order_id", order.ID).
+		Str("symbol", req.Symbol).
+		Float64("amount", req.Amount).
+		Msg("Order queued for aggregation")
+
+	return &Placement{Order: order, BrokerStatus: "queued"}, nil
+}
+
+// saveLegs saves the exit legs Alpaca created for an advanced order as child
+// orders of it. Legs held until the entry fills are saved as held.
+func (h *Handler) saveLegs(ctx context.Context, parent *types.Order, alpacaLegs []alpaca.Order) []types.Order {
+	legs := make([]types.Order, 0, len(alpacaLegs))
+	for _, l := range alpacaLegs {
+		legName := "stop_loss"
+		if l.OrderType == alpaca.Limit {
+			legName = "take_profit"
+		}
+		status := "pending"
+		if l.Status == alpaca.OrderStatusHeld {
+			status = "held"
+		}
+
+		leg := types.Order{
+			UserID:        parent.UserID,
+			AlpacaOrderID: l.ID,
+			ClientOrderID: l.ClientOrderID,
+			Symbol:        parent.Symbol,
+			Side:          string(l.Side),
+			Type:          string(l.OrderType),
+			Qty:           parent.Qty,
+			TimeInForce:   parent.TimeInForce,
+			ExpiresAt:     parent.ExpiresAt,
+			Status:        status,
+			Source:        parent.Source,
+			OrderClass:    parent.OrderClass,
+			ParentOrderID: &parent.ID,
+			Leg:           &legName,
+		}
+		if price, err := strconv.ParseFloat(l.LimitPrice, 64); err == nil && price > 0 {
+			leg.LimitPrice = &price
+		}
+		if price, err := strconv.ParseFloat(l.StopPrice, 64); err == nil && price > 0 {
+			leg.StopPrice = &price
+		}
+
+		if err := h.orderRepo.Create(ctx, &leg); err != nil {
+			logger.Error().Err(err).
+				Str("order_id", parent.ID).
+				Str("alpaca_order_id", l.ID).
+				Msg("Failed to save order leg to database")
+			continue
+		}
+		legs = append(legs, leg)
+	}
+	return legs
+}
+
+// publishOrderCreated publishes the order-created event for a placed order
+func (h *Handler) publishOrderCreated(ctx context.Context, order *types.Order) {
+	if h.publisher == nil {
+		return
+	}
+
+	var limitPrice float64
+	if order.LimitPrice != nil {
+		limitPrice = *order.LimitPrice
+	}
+	h.publisher.Publish(ctx, events.TopicOrderCreated, events.NewEvent(
+		events.EventTypeOrderCreated,
+		"trading-service",
+		map[string]any{
+			"order_id":        order.ID,
+			"user_id":         order.UserID,
+			"symbol":          order.Symbol,
+			"side":            order.Side,
+			"type":            order.Type,
+			"amount":          order.Amount,
+			"qty":             order.Qty,
+			"limit_price":     limitPrice,
+			"alpaca_order_id": order.AlpacaOrderID,
+		},
+	))
+}
+
+// replayOrder returns the order created by the first request for a retried
+// placement, provided the retry asks for the same order. The USD amount of a
+// KES order depends on the rate at the time, so it is not compared.
+func replayOrder(order *types.Order, req *types.PlaceOrderRequest) (*Placement, error) {
+	// A market order sent as a protective limit order is saved as a limit order
+	sameType := order.Type == req.Type || (order.MaxSlippageBps != nil && req.Type == "market")
+	if order.Symbol != req.Symbol || order.Side != req.Side || !sameType ||
+		(req.AmountKES == 0 && order.Amount != req.Amount) || order.Qty != req.Qty {
+		return nil, apperrors.ErrConflict.WithDetails("Idempotency-Key was already used for a different order")
+	}
+
+	logger.Info().
+		Str("user_id", order.UserID).
+		Str("order_id", order.ID).
+		Msg("Replayed idempotent order placement")
+
+	return &Placement{Order: order, Replayed: true}, nil
+}
+
+// orderResponse builds the placement response for a saved order
+func orderResponse(order *types.Order, status, message string) types.PlaceOrderResponse {
+	resp := types.PlaceOrderResponse{
+		OrderID:       order.ID,
+		AlpacaOrderID: order.AlpacaOrderID,
+		Symbol:        order.Symbol,
+		Side:          order.Side,
+		Type:          order.Type,
+		Amount:        order.Amount,
+		Qty:           order.Qty,
+		TimeInForce:   order.TimeInForce,
+		ExpiresAt:     order.ExpiresAt,
+		OrderClass:    order.OrderClass,
+		Legs:          order.Legs,
+		Commission:    order.Commission,
+		Status:        status,
+		Message:       message,
+	}
+	if order.LimitPrice != nil {
+		resp.LimitPrice = *order.LimitPrice
+	}
+	if order.StopPrice != nil {
+		resp.StopPrice = *order.StopPrice
+	}
+	if order.MaxSlippageBps != nil {
+		resp.MaxSlippageBps = *order.MaxSlippageBps
+	}
+	return resp
+}
+
+// idempotentClientOrderID derives a stable Alpaca client order ID from the
+// user's Idempotency-Key. Keys are scoped per user, but client order IDs must
+// be unique across the whole Alpaca account.
+func idempotentClientOrderID(userID, key string) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(userID+":"+key)).String()
+}
+
+// validateOrderRequest checks the request fields and defaults the order type to market
+func validateOrderRequest(req *types.PlaceOrderRequest) error {
+	if req.Symbol == "" {
+		return apperrors.ErrValidation.WithDetails("Symbol is required")
+	}
+	if req.Side != "buy" && req.Side != "sell" {
+		return apperrors.ErrValidation.WithDetails("Side must be 'buy' or 'sell'")
+	}
+	if req.Amount <= 0 && req.Qty <= 0 && req.AmountKES <= 0 {
+		return apperrors.ErrValidation.WithDetails("Amount, qty or amount_kes is required")
+	}
+	if req.AmountKES > 0 {
+		if req.Side != "buy" {
+			return apperrors.ErrValidation.WithDetails("amount_kes is only supported for buy orders")
+		}
+		if req.Amount > 0 || req.Qty > 0 {
+			return apperrors.ErrValidation.WithDetails("Specify only one of amount, qty or amount_kes")
+		}
+	} else if req.FXQuoteID != "" {
+		return apperrors.ErrValidation.WithDetails("fx_quote_id requires amount_kes")
+	}
+	if req.LimitPrice < 0 || req.StopPrice < 0 {
+		return apperrors.ErrValidation.WithDetails("Prices must be positive")
+	}
+	if err := validateLotSelection(req); err != nil {
+		return err
+	}
+
+	if req.Type == "" {
+		req.Type = "market"
+	}
+	if req.TimeInForce == "" {
+		req.TimeInForce = "day"
+	}
+
+	switch req.TimeInForce {
+	case "day", "gtc", "ioc", "fok":
+	default:
+		return apperrors.ErrValidation.WithDetails("Time in force must be 'day', 'gtc', 'ioc' or 'fok'")
+	}
+
+	// Alpaca only accepts fractional (notional) orders as day orders
+	if (req.Amount > 0 || req.AmountKES > 0) && req.TimeInForce != "day" {
+		return apperrors.ErrValidation.WithDetails("Amount-based orders only support 'day' time in force")
+	}
+
+	if err := validateOrderClass(req); err != nil {
+		return err
+	}
+
+	if req.MaxSlippageBps != 0 && (req.Type != "market" || req.Qty <= 0) {
+		return apperrors.ErrValidation.WithDetails("max_slippage_bps only applies to market orders by qty")
+	}
+
+	switch req.Type {
+	case "market":
+		if req.LimitPrice > 0 || req.StopPrice > 0 {
+			return apperrors.ErrValidation.WithDetails("Market orders do not take a limit or stop price")
+		}
+		return nil
+	case "limit":
+		if req.LimitPrice <= 0 {
+			return apperrors.ErrValidation.WithDetails("Limit price is required for limit orders")
+		}
+		if req.StopPrice > 0 {
+			return apperrors.ErrValidation.WithDetails("Limit orders do not take a stop price")
+		}
+	case "stop":
+		if req.StopPrice <= 0 {
+			return apperrors.ErrValidation.WithDetails("Stop price is required for stop orders")
+		}
+		if req.LimitPrice > 0 {
+			return apperrors.ErrValidation.WithDetails("Stop orders do not take a limit price, use stop_limit")
+		}
+	case "stop_limit":
+		if req.LimitPrice <= 0 || req.StopPrice <= 0 {
+			return apperrors.ErrValidation.WithDetails("Limit and stop prices are required for stop_limit orders")
+		}
+	default:
+		return apperrors.ErrInvalidOrderType.WithDetails("Type must be 'market', 'limit', 'stop' or 'stop_limit'")
+	}
+
+	// Alpaca only accepts notional (dollar amount) orders for market orders
+	if req.Qty <= 0 || req.Amount > 0 || req.AmountKES > 0 {
+		return apperrors.ErrValidation.WithDetails("Limit and stop orders must specify qty, not amount")
+	}
+
+	return nil
+}
+
+// validateOrderClass checks the exit legs of bracket, OCO and OTO orders and
+// defaults the order class to simple. Bracket and OTO orders are buys whose
+// exits sell the shares bought; an OCO order sells shares already held, at a
+// take-profit limit or a stop-loss, whichever triggers first.
+func validateOrderClass(req *types.PlaceOrderRequest) error {
+	switch req.OrderClass {
+	case "", "simple":
+		req.OrderClass = "simple"
+		if req.TakeProfit != nil || req.StopLoss != nil {
+			return apperrors.ErrValidation.WithDetails("take_profit and stop_loss require a bracket, oco or oto order class")
+		}
+		return nil
+	case "bracket", "oco", "oto":
+	default:
+		return apperrors.ErrValidation.WithDetails("Order class must be 'simple', 'bracket', 'oco' or 'oto'")
+	}
+
+	// Alpaca only accepts whole-share day or GTC orders with legs
+	if req.Amount > 0 || req.AmountKES > 0 || req.Qty <= 0 || req.Qty != math.Trunc(req.Qty) {
+		return apperrors.ErrValidation.WithDetails("Bracket, OCO and OTO orders must specify a whole number of shares")
+	}
+	if req.TimeInForce != "day" && req.TimeInForce != "gtc" {
+		return apperrors.ErrValidation.WithDetails("Bracket, OCO and OTO orders only support 'day' or 'gtc' time in force")
+	}
+
+	if req.TakeProfit != nil && req.TakeProfit.LimitPrice <= 0 {
+		return apperrors.ErrValidation.WithDetails("take_profit.limit_price is required")
+	}
+	if req.StopLoss != nil {
+		if req.StopLoss.StopPrice <= 0 {
+			return apperrors.ErrValidation.WithDetails("stop_loss.stop_price is required")
+		}
+		if req.StopLoss.LimitPrice < 0 {
+			return apperrors.ErrValidation.WithDetails("Prices must be positive")
+		}
+		// A sell stop-limit at a limit above its stop could never fill as a stop-loss
+		if req.StopLoss.LimitPrice > req.StopLoss.StopPrice {
+			return apperrors.ErrValidation.WithDetails("stop_loss.limit_price must not be above stop_loss.stop_price")
+		}
+	}
+
+	switch req.OrderClass {
+	case "bracket":
+		if req.TakeProfit == nil || req.StopLoss == nil {
+			return apperrors.ErrValidation.WithDetails("Bracket orders require take_profit and stop_loss")
+		}
+	case "oto":
+		if (req.TakeProfit == nil) == (req.StopLoss == nil) {
+			return apperrors.ErrValidation.WithDetails("OTO orders require exactly one of take_profit or stop_loss")
+		}
+	case "oco":
+		if req.TakeProfit == nil || req.StopLoss == nil {
+			return apperrors.ErrValidation.WithDetails("OCO orders require take_profit and stop_loss")
+		}
+		if req.Side != "sell" || req.Type != "limit" {
+			return apperrors.ErrValidation.WithDetails("OCO orders must be limit sell orders")
+		}
+		// The OCO order itself is the take-profit
+		if req.LimitPrice == 0 {
+			req.LimitPrice = req.TakeProfit.LimitPrice
+		}
+		if req.LimitPrice != req.TakeProfit.LimitPrice {
+			return apperrors.ErrValidation.WithDetails("limit_price must match take_profit.limit_price for OCO orders")
+		}
+	}
+
+	if req.OrderClass != "oco" {
+		if req.Side != "buy" {
+			return apperrors.ErrValidation.WithDetails("Bracket and OTO orders must be buy orders")
+		}
+		// Exits must sit either side of the entry so neither triggers at once
+		if req.LimitPrice > 0 {
+			if req.TakeProfit != nil && req.TakeProfit.LimitPrice <= req.LimitPrice {
+				return apperrors.ErrValidation.WithDetails("take_profit.limit_price must be above the entry limit price")
+			}
+			if req.StopLoss != nil && req.StopLoss.StopPrice >= req.LimitPrice {
+				return apperrors.ErrValidation.WithDetails("stop_loss.stop_price must be below the entry limit price")
+			}
+		}
+	}
+
+	if req.TakeProfit != nil && req.StopLoss != nil && req.TakeProfit.LimitPrice <= req.StopLoss.StopPrice {
+		return apperrors.ErrValidation.WithDetails("take_profit.limit_price must be above stop_loss.stop_price")
+	}
+
+	return nil
+}
+
+// kesQuote returns the KES to USD quote for a buy order paid from the KES
+// wallet: the locked quote the user asked for, or a fresh one
+func (h *Handler) kesQuote(ctx context.Context, userID string, req *types.PlaceOrderRequest) (*fx.Quote, error) {
+	if req.FXQuoteID == "" {
+		return h.exchanger.Quote(ctx, userID, fx.KES, fx.USD, req.AmountKES)
+	}
+
+	quote, err := h.exchanger.GetQuote(ctx, userID, req.FXQuoteID)
+	if err != nil {
+		return nil, err
+	}
+	if quote.From != fx.KES || quote.To != fx.USD || quote.FromAmount != req.AmountKES {
+		return nil, apperrors.ErrValidation.WithDetails("fx_quote_id does not match amount_kes")
+	}
+	return quote, nil
+}
+
+// estimateCost returns the estimated USD value of an order, which is also what
+// a buy order reserves. Limit-priced orders can never fill above the limit, so
+// the limit price bounds the cost exactly.
+func (h *Handler) estimateCost(ctx context.Context, req *types.PlaceOrderRequest) (float64, error) {
+	switch {
+	case req.Amount > 0:
+		return req.Amount, nil
+	case req.LimitPrice > 0:
+		return req.Qty * req.LimitPrice, nil
+	case req.StopPrice > 0:
+		// Stop orders become market orders at the stop price
+		return req.Qty * req.StopPrice * 1.01, nil // Add 1% buffer
+	}
+
+	// If qty specified, estimate the amount (we'll use actual at fill)
+	quote, err := h.alpaca.GetQuote(ctx, req.Symbol)
+	if err != nil {
+		return 0, apperrors.ErrServiceUnavailable.WithDetails("Failed to get quote")
+	}
+	return req.Qty * quote.AskPrice * 1.01, nil // Add 1% buffer
+}
+
+// orderExpiry returns when an order stops being live at the broker, so the
+// expiry sweeper can release funds if the final webhook never arrives.
+func (h *Handler) orderExpiry(ctx context.Context, timeInForce string) *time.Time {
+	now := time.Now()
+	var expiresAt time.Time
+
+	switch timeInForce {
+	case "gtc":
+		expiresAt = now.Add(gtcMaxAge)
+	case "ioc", "fok":
+		expiresAt = now.Add(immediateOrderGrace)
+	default:
+		clock, err := h.alpaca.GetClock(ctx)
+		if err != nil || clock.NextClose.IsZero() {
+			logger.Warn().Err(err).Msg("Failed to get market clock, using default day order expiry")
+			expiresAt = now.Add(24 * time.Hour)
+		} else {
+			expiresAt = clock.NextClose
+		}
+	}
+
+	return &expiresAt
 }
 
 // CancelOrder cancels an open order
@@ -213,7 +884,13 @@ func (h *Handler) CancelOrder(c *fiber.Ctx) error {
 		return apperrors.ErrForbidden.WithDetails("Not your order")
 	}
 
-	if order.Status != "pending" && order.Status != "new" {
+	if order.Status == "queued" {
+		return h.cancelQueued(c, order)
+	}
+	if order.OmnibusOrderID != nil {
+		return apperrors.ErrValidation.WithDetails("Order has been submitted with others and cannot be canceled")
+	}
+	if order.Status != "pending" && order.Status != "new" && order.Status != "held" {
 		return apperrors.ErrValidation.WithDetails("Order cannot be canceled")
 	}
 
@@ -222,33 +899,292 @@ func (h *Handler) CancelOrder(c *fiber.Ctx) error {
 		return apperrors.ErrServiceUnavailable.WithDetails("Failed to cancel order")
 	}
 
-	if err := h.orderRepo.UpdateStatus(ctx, orderID, "canceled"); err != nil {
-		logger.Error().Err(err).Msg("Failed to update order status")
+	// The order stays open, pending cancel, until Alpaca confirms the cancel
+	// or reports a fill; the settler then releases only what did not fill
+	if err := h.orderRepo.MarkCancelRequested(ctx, orderID); err != nil {
+		logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to mark cancel requested")
+	}
+	if _, err := h.settler.Sync(ctx, order, settlement.SourceAPI); err != nil {
+		// The broker's update or the reconciler settles it
+		logger.Warn().Err(err).Str("order_id", orderID).Msg("Failed to settle canceled order")
 	}
 
-	// Unlock funds for buy orders
-	if order.Side == "buy" && order.Amount > 0 {
-		wallet, _ := h.walletRepo.GetByUserAndCurrency(ctx, userID, "USD")
-		if wallet != nil {
-			h.walletRepo.Unlock(ctx, wallet.ID, order.Amount)
-		}
+	current, err := h.orderRepo.GetByID(ctx, orderID)
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to get canceled order")
+		return apperrors.ErrInternal
 	}
 
-	logger.Info().Str("order_id", orderID).Msg("Order canceled")
+	resp := types.CancelOrderResponse{OrderID: orderID, Status: current.Status}
+	switch {
+	case current.Status == settlement.StatusCanceled:
+		resp.Message = "Order canceled successfully"
+	case current.Status == settlement.StatusFilled:
+		resp.Message = "Order filled before it could be canceled"
+	case settlement.IsTerminal(current.Status):
+		resp.Message = "Order closed before it could be canceled"
+	default:
+		resp.Status = "pending_cancel"
+		resp.Message = "Cancel requested; the order is canceled once the broker confirms"
+	}
+
+	logger.Info().Str("order_id", orderID).Str("status", resp.Status).Msg("Order cancel requested")
+
+	return c.JSON(resp)
+}
+
+// cancelQueued cancels an order still waiting for the omnibus aggregator.
+// It never reached Alpaca, so only its lock is released.
+func (h *Handler) cancelQueued(c *fiber.Ctx, order *types.Order) error {
+	canceled, err := h.settler.CancelQueued(c.Context(), order, settlement.SourceAPI, "Canceled by user")
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to cancel queued order")
+		return apperrors.ErrInternal
+	}
+	if !canceled {
+		return apperrors.ErrValidation.WithDetails("Order has been submitted with others and cannot be canceled")
+	}
+
+	logger.Info().Str("order_id", order.ID).Msg("Queued order canceled")
 
 	return c.JSON(types.CancelOrderResponse{
-		OrderID: orderID,
+		OrderID: order.ID,
 		Status:  "canceled",
 		Message: "Order canceled successfully",
 	})
 }
 
+// AmendOrder changes the qty, prices or time in force of an open order
+func (h *Handler) AmendOrder(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	orderID := c.Params("id")
+
+	var req types.AmendOrderRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+	if req.Qty == 0 && req.LimitPrice == 0 && req.StopPrice == 0 && req.TimeInForce == "" {
+		return apperrors.ErrValidation.WithDetails("Nothing to amend")
+	}
+
+	ctx := c.Context()
+
+	order, err := h.orderRepo.GetByID(ctx, orderID)
+	if err != nil {
+		return apperrors.ErrNotFound.WithDetails("Order not found")
+	}
+
+	if order.UserID != userID {
+		return apperrors.ErrForbidden.WithDetails("Not your order")
+	}
+
+	if order.Status != "pending" && order.Status != "new" {
+		return apperrors.ErrValidation.WithDetails("Order cannot be amended")
+	}
+	if order.CancelRequestedAt != nil {
+		return apperrors.ErrValidation.WithDetails("Order is being canceled")
+	}
+	if order.PendingReplace != nil {
+		return apperrors.ErrValidation.WithDetails("Order is being amended")
+	}
+	if order.Type == "market" || order.Amount > 0 {
+		return apperrors.ErrValidation.WithDetails("Only qty-based limit and stop orders can be amended")
+	}
+	if order.OrderClass != "simple" {
+		return apperrors.ErrValidation.WithDetails("Bracket, OCO and OTO orders cannot be amended")
+	}
+
+	// Merge the changes onto the current terms and validate as a new order
+	amended := types.PlaceOrderRequest{
+		Symbol:      order.Symbol,
+		Side:        order.Side,
+		Type:        order.Type,
+		Qty:         order.Qty,
+		TimeInForce: order.TimeInForce,
+	}
+	if order.LimitPrice != nil {
+		amended.LimitPrice = *order.LimitPrice
+	}
+	if order.StopPrice != nil {
+		amended.StopPrice = *order.StopPrice
+	}
+	if req.Qty != 0 {
+		amended.Qty = req.Qty
+	}
+	if req.LimitPrice != 0 {
+		amended.LimitPrice = req.LimitPrice
+	}
+	if req.StopPrice != 0 {
+		amended.StopPrice = req.StopPrice
+	}
+	if req.TimeInForce != "" {
+		amended.TimeInForce = req.TimeInForce
+	}
+
+	if err := validateOrderRequest(&amended); err != nil {
+		return err
+	}
+
+	user, err := h.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get user")
+		return apperrors.ErrInternal
+	}
+
+	// New terms are a new order as far as risk checks are concerned, less
+	// what the order already counts towards the daily limit
+	cost, err := h.estimateCost(ctx, &amended)
+	if err != nil {
+		return err
+	}
+	if err := h.risk.Check(ctx, &risk.Order{
+		User:        user,
+		Symbol:      order.Symbol,
+		Side:        order.Side,
+		Type:        order.Type,
+		TimeInForce: amended.TimeInForce,
+		Qty:         amended.Qty,
+		Notional:    cost,
+		Replaces:    order,
+	}); err != nil {
+		return err
+	}
+
+	if order.Side == "sell" {
+		hasSufficient, err := h.holdingRepo.HasSufficientQty(ctx, userID, order.Symbol, amended.Qty)
+		if err != nil || !hasSufficient {
+			return apperrors.ErrValidation.WithDetails("Insufficient shares to sell")
+		}
+	}
+
+	// Lock any rise in the order's maximum cost and commission now; a fall is
+	// released once the amendment is saved
+	var wallet *types.Wallet
+	var lockAmount, lockDelta float64
+	if order.Side == "buy" {
+		lockAmount = cost + h.commission(user, cost)
+		lockDelta = lockAmount - order.LockedAmount
+
+		wallet, err = h.walletRepo.GetByUserAndCurrency(ctx, userID, "USD")
+		if err != nil {
+			logger.Error().Err(err).Str("user_id", userID).Msg("Failed to get wallet")
+			return apperrors.ErrInternal
+		}
+
+		if lockDelta > 0 {
+			if wallet.AvailableBalance() < lockDelta {
+				return apperrors.ErrInsufficientFunds
+			}
+			if err := h.walletRepo.Lock(ctx, wallet.ID, lockDelta); err != nil {
+				return apperrors.ErrInternal.WithDetails("Failed to lock funds")
+			}
+		}
+	}
+
+	// Record the new terms before replacing the order at Alpaca, so the
+	// reconciler can save them if the replacement goes through but saving it
+	// here does not
+	pending := &types.PendingReplace{
+		ClientOrderID: uuid.New().String(),
+		Qty:           amended.Qty,
+		TimeInForce:   amended.TimeInForce,
+		ExpiresAt:     order.ExpiresAt,
+		LockedAmount:  order.LockedAmount,
+	}
+	if amended.LimitPrice > 0 {
+		pending.LimitPrice = &amended.LimitPrice
+	}
+	if amended.StopPrice > 0 {
+		pending.StopPrice = &amended.StopPrice
+	}
+	if amended.TimeInForce != order.TimeInForce {
+		pending.ExpiresAt = h.orderExpiry(ctx, amended.TimeInForce)
+	}
+	if order.Side == "buy" {
+		pending.LockedAmount = lockAmount
+		pending.ExtraLocked = max(lockDelta, 0)
+	}
+	if err := h.orderRepo.SetPendingReplace(ctx, orderID, pending); err != nil {
+		logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to record pending amendment")
+		if lockDelta > 0 {
+			h.walletRepo.Unlock(ctx, wallet.ID, lockDelta)
+		}
+		return apperrors.ErrInternal
+	}
+
+	replaceReq := &alpaca.ReplaceOrderRequest{
+		Qty:           fmt.Sprintf("%.6f", amended.Qty),
+		TimeInForce:   alpaca.TimeInForce(amended.TimeInForce),
+		ClientOrderID: pending.ClientOrderID,
+	}
+	if amended.LimitPrice > 0 {
+		replaceReq.LimitPrice = fmt.Sprintf("%.2f", amended.LimitPrice)
+	}
+	if amended.StopPrice > 0 {
+		replaceReq.StopPrice = fmt.Sprintf("%.2f", amended.StopPrice)
+	}
+
+	alpacaOrder, err := h.alpaca.ReplaceOrder(ctx, order.AlpacaOrderID, replaceReq)
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to replace Alpaca order")
+		if !alpaca.IsRejected(err) {
+			// The replacement may still have gone through; the reconciler
+			// saves or drops the pending amendment, and the funds locked for
+			// it, once Alpaca reports whether the order was replaced
+			return apperrors.ErrServiceUnavailable.WithDetails("Amendment not confirmed; the order will be updated once Alpaca reports it")
+		}
+		if err := h.settler.DropReplace(ctx, order); err != nil && !errors.Is(err, settlement.ErrAlreadySettled) {
+			logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to drop pending amendment")
+		}
+		return apperrors.ErrServiceUnavailable.WithDetails("Failed to amend order")
+	}
+
+	previousAlpacaOrderID := order.AlpacaOrderID
+	if err := h.settler.FinishReplace(ctx, order, alpacaOrder.ID); err != nil {
+		// The replacement is live at Alpaca; the reconciler saves it from the
+		// pending amendment
+		logger.Error().Err(err).
+			Str("order_id", orderID).
+			Str("alpaca_order_id", alpacaOrder.ID).
+			Msg("Failed to save amended order, left for the reconciler")
+		return apperrors.ErrInternal.WithDetails("Order was amended but not yet saved")
+	}
+
+	if h.publisher != nil {
+		h.publisher.Publish(ctx, events.TopicOrderAmended, events.NewEvent(
+			events.EventTypeOrderAmended,
+			"trading-service",
+			events.OrderAmendedPayload{
+				OrderID:               order.ID,
+				UserID:                userID,
+				Symbol:                order.Symbol,
+				Qty:                   amended.Qty,
+				LimitPrice:            amended.LimitPrice,
+				StopPrice:             amended.StopPrice,
+				TimeInForce:           amended.TimeInForce,
+				AlpacaOrderID:         alpacaOrder.ID,
+				ReplacedAlpacaOrderID: previousAlpacaOrderID,
+			},
+		))
+	}
+
+	logger.Info().
+		Str("order_id", orderID).
+		Str("alpaca_order_id", alpacaOrder.ID).
+		Str("replaces", previousAlpacaOrderID).
+		Msg("Order amended")
+
+	return c.JSON(order)
+}
+
 // GetOrder retrieves an order by ID
 func (h *Handler) GetOrder(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
 	orderID := c.Params("id")
 
-	order, err := h.orderRepo.GetByID(c.Context(), orderID)
+	ctx := c.Context()
+
+	order, err := h.orderRepo.GetByID(ctx, orderID)
 	if err != nil {
 		return apperrors.ErrNotFound.WithDetails("Order not found")
 	}
@@ -257,27 +1193,76 @@ func (h *Handler) GetOrder(c *fiber.Ctx) error {
 		return apperrors.ErrForbidden.WithDetails("Not your order")
 	}
 
+	if order.OrderClass != "simple" && order.ParentOrderID == nil {
+		order.Legs, err = h.orderRepo.ListLegs(ctx, order.ID)
+		if err != nil {
+			logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to list order legs")
+			return apperrors.ErrInternal
+		}
+	}
+
 	return c.JSON(order)
 }
 
-// ListOrders retrieves orders for the user
-func (h *Handler) ListOrders(c *fiber.Ctx) error {
+// GetOrderHistory retrieves the status transitions of an order, oldest first
+func (h *Handler) GetOrderHistory(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
-	status := c.Query("status")
-	limit := c.QueryInt("limit", 50)
+	orderID := c.Params("id")
+
+	ctx := c.Context()
+
+	order, err := h.orderRepo.GetByID(ctx, orderID)
+	if err != nil {
+		return apperrors.ErrNotFound.WithDetails("Order not found")
+	}
+
+	if order.UserID != userID {
+		return apperrors.ErrForbidden.WithDetails("Not your order")
+	}
 
-	orders, err := h.orderRepo.ListByUser(c.Context(), userID, status, limit)
+	history, err := h.orderRepo.ListEvents(ctx, orderID)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to list orders")
+		logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to list order events")
 		return apperrors.ErrInternal
 	}
 
 	return c.JSON(fiber.Map{
-		"orders": orders,
-		"count":  len(orders),
+		"order_id": orderID,
+		"status":   order.Status,
+		"events":   history,
+		"count":    len(history),
 	})
 }
 
+// GetOrderAllocation retrieves the shares allocated to an order from the
+// omnibus order it was aggregated into
+func (h *Handler) GetOrderAllocation(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	orderID := c.Params("id")
+
+	ctx := c.Context()
+
+	order, err := h.orderRepo.GetByID(ctx, orderID)
+	if err != nil {
+		return apperrors.ErrNotFound.WithDetails("Order not found")
+	}
+
+	if order.UserID != userID {
+		return apperrors.ErrForbidden.WithDetails("Not your order")
+	}
+
+	allocation, err := h.omnibusRepo.GetAllocationByOrder(ctx, orderID)
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", orderID).Msg("Failed to get omnibus allocation")
+		return apperrors.ErrInternal
+	}
+	if allocation == nil {
+		return apperrors.ErrNotFound.WithDetails("Order has not been allocated")
+	}
+
+	return c.JSON(allocation)
+}
+
 // GetPortfolio retrieves the user's portfolio
 func (h *Handler) GetPortfolio(c *fiber.Ctx) error {
 	userID := c.Locals("user_id").(string)
@@ -385,6 +1370,24 @@ func (h *Handler) SearchAssets(c *fiber.Ctx) error {
 	return c.JSON(types.SearchResponse{Assets: results})
 }
 
+// CreateFXQuote quotes a conversion between the user's wallets and locks the
+// rate until the quote expires
+func (h *Handler) CreateFXQuote(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	var req types.FXQuoteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+
+	quote, err := h.exchanger.Quote(c.Context(), userID, strings.ToUpper(req.From), strings.ToUpper(req.To), req.Amount)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(quote)
+}
+
 // AlpacaWebhook handles order updates from Alpaca
 func (h *Handler) AlpacaWebhook(c *fiber.Ctx) error {
 	var event types.AlpacaWebhookEvent
@@ -401,126 +1404,38 @@ func (h *Handler) AlpacaWebhook(c *fiber.Ctx) error {
 		Str("status", event.Order.Status).
 		Msg("Received Alpaca webhook")
 
+	var orderID string
 	order, err := h.orderRepo.GetByAlpacaOrderID(ctx, event.Order.ID)
-	if err != nil {
-		logger.Warn().Str("alpaca_order_id", event.Order.ID).Msg("Order not found in database")
-		return c.SendStatus(fiber.StatusOK)
-	}
-
-	switch event.Event {
-	case "fill":
-		h.handleOrderFill(ctx, order, &event.Order)
-	case "partial_fill":
-		h.handlePartialFill(ctx, order, &event.Order)
-	case "canceled":
-		h.handleOrderCanceled(ctx, order)
-	case "rejected":
-		h.handleOrderRejected(ctx, order, "Order rejected by exchange")
-	}
-
-	return c.SendStatus(fiber.StatusOK)
-}
-
-func (h *Handler) handleOrderFill(ctx context.Context, order *types.Order, alpacaOrder *types.AlpacaOrderUpdate) {
-	filledQty, _ := strconv.ParseFloat(alpacaOrder.FilledQty, 64)
-	filledAvgPrice, _ := strconv.ParseFloat(alpacaOrder.FilledAvgPrice, 64)
-
-	if err := h.orderRepo.UpdateFill(ctx, order.AlpacaOrderID, filledQty, filledAvgPrice, "filled"); err != nil {
-		logger.Error().Err(err).Msg("Failed to update order fill")
-	}
-
-	// Update holdings
-	if order.Side == "buy" {
-		h.holdingRepo.Upsert(ctx, order.UserID, order.Symbol, filledQty, filledAvgPrice)
-
-		// Debit wallet
-		wallet, _ := h.walletRepo.GetByUserAndCurrency(ctx, order.UserID, "USD")
-		if wallet != nil {
-			totalCost := filledQty * filledAvgPrice
-			h.walletRepo.DebitLocked(ctx, wallet.ID, totalCost)
-			// Unlock any excess that was locked
-			if order.Amount > totalCost {
-				h.walletRepo.Unlock(ctx, wallet.ID, order.Amount-totalCost)
-			}
-		}
+	if err == nil {
+		orderID = order.ID
+		err = h.settler.Apply(ctx, order, event.Event, &event.Order, settlement.SourceWebhook)
 	} else {
-		// Sell order - reduce holdings and credit wallet
-		h.holdingRepo.ReduceQty(ctx, order.UserID, order.Symbol, filledQty)
-
-		wallet, _ := h.walletRepo.GetByUserAndCurrency(ctx, order.UserID, "USD")
-		if wallet != nil {
-			proceeds := filledQty * filledAvgPrice
-			h.walletRepo.Credit(ctx, wallet.ID, proceeds)
+		// Omnibus orders have no order row of their own
+		omnibus, lookupErr := h.omnibusRepo.GetByAlpacaOrderID(ctx, event.Order.ID)
+		if lookupErr != nil {
+			logger.Warn().Str("alpaca_order_id", event.Order.ID).Msg("Order not found in database")
+			return c.SendStatus(fiber.StatusOK)
 		}
+		orderID = omnibus.ID
+		err = h.settler.ApplyOmnibus(ctx, omnibus, event.Event, &event.Order, settlement.SourceWebhook)
 	}
 
-	// Publish event
-	if h.publisher != nil {
-		h.publisher.Publish(ctx, events.TopicOrderFilled, events.NewEvent(
-			events.EventTypeOrderFilled,
-			"trading-service",
-			map[string]any{
-				"order_id":         order.ID,
-				"user_id":          order.UserID,
-				"symbol":           order.Symbol,
-				"side":             order.Side,
-				"filled_qty":       filledQty,
-				"filled_avg_price": filledAvgPrice,
-			},
-		))
-	}
-
-	logger.Info().
-		Str("order_id", order.ID).
-		Float64("filled_qty", filledQty).
-		Float64("filled_avg_price", filledAvgPrice).
-		Msg("Order filled")
-}
-
-func (h *Handler) handlePartialFill(ctx context.Context, order *types.Order, alpacaOrder *types.AlpacaOrderUpdate) {
-	filledQty, _ := strconv.ParseFloat(alpacaOrder.FilledQty, 64)
-	filledAvgPrice, _ := strconv.ParseFloat(alpacaOrder.FilledAvgPrice, 64)
-
-	if err := h.orderRepo.UpdateFill(ctx, order.AlpacaOrderID, filledQty, filledAvgPrice, "partial_fill"); err != nil {
-		logger.Error().Err(err).Msg("Failed to update partial fill")
-	}
-
-	logger.Info().
-		Str("order_id", order.ID).
-		Float64("filled_qty", filledQty).
-		Msg("Order partially filled")
-}
-
-func (h *Handler) handleOrderCanceled(ctx context.Context, order *types.Order) {
-	if err := h.orderRepo.UpdateCanceled(ctx, order.AlpacaOrderID); err != nil {
-		logger.Error().Err(err).Msg("Failed to update order canceled")
-	}
-
-	// Unlock funds for buy orders
-	if order.Side == "buy" && order.Amount > 0 {
-		wallet, _ := h.walletRepo.GetByUserAndCurrency(ctx, order.UserID, "USD")
-		if wallet != nil {
-			h.walletRepo.Unlock(ctx, wallet.ID, order.Amount)
+	if err != nil {
+		if errors.Is(err, settlement.ErrAlreadySettled) {
+			logger.Info().Str("order_id", orderID).Str("event", event.Event).Msg("Order already settled, ignoring update")
+			return c.SendStatus(fiber.StatusOK)
 		}
-	}
-
-	logger.Info().Str("order_id", order.ID).Msg("Order canceled via webhook")
-}
-
-func (h *Handler) handleOrderRejected(ctx context.Context, order *types.Order, reason string) {
-	if err := h.orderRepo.UpdateFailed(ctx, order.AlpacaOrderID, reason); err != nil {
-		logger.Error().Err(err).Msg("Failed to update order rejected")
-	}
-
-	// Unlock funds for buy orders
-	if order.Side == "buy" && order.Amount > 0 {
-		wallet, _ := h.walletRepo.GetByUserAndCurrency(ctx, order.UserID, "USD")
-		if wallet != nil {
-			h.walletRepo.Unlock(ctx, wallet.ID, order.Amount)
+		if errors.Is(err, settlement.ErrUnfundedFill) {
+			// Redelivery cannot pay for the fill either
+			logger.Error().Err(err).Str("order_id", orderID).Str("event", event.Event).Msg("Fill cannot be paid for and needs a human")
+			return c.SendStatus(fiber.StatusOK)
 		}
+		// Nothing was applied; let Alpaca redeliver the update
+		logger.Error().Err(err).Str("order_id", orderID).Str("event", event.Event).Msg("Failed to settle order update")
+		return c.SendStatus(fiber.StatusInternalServerError)
 	}
 
-	logger.Info().Str("order_id", order.ID).Str("reason", reason).Msg("Order rejected")
+	return c.SendStatus(fiber.StatusOK)
 }
 
 func containsIgnoreCase(s, substr string) bool {
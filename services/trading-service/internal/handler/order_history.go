@@ -0,0 +1,303 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+const (
+	// maxOrdersPerPage caps the per_page of the order history
+	maxOrdersPerPage = 200
+
+	// exportBatchSize is how many orders an export reads per query
+	exportBatchSize = 500
+
+	// exportTimeout bounds how long an export may stream
+	exportTimeout = 5 * time.Minute
+)
+
+// orderCSVHeader is the header row of an order export
+var orderCSVHeader = []string{
+	"id", "created_at", "symbol", "side", "type", "time_in_force", "qty", "amount",
+	"limit_price", "stop_price", "max_slippage_bps", "filled_qty", "filled_avg_price", "commission",
+	"status", "source", "order_class", "failed_reason", "filled_at", "canceled_at",
+}
+
+// ListOrders returns a page of the user's order history, newest first. The
+// next page is requested with the returned next_cursor.
+func (h *Handler) ListOrders(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	filter, err := orderFilter(c, userID)
+	if err != nil {
+		return err
+	}
+
+	// limit is the name per_page had before the history was paginated
+	perPage := c.QueryInt("per_page", c.QueryInt("limit", 50))
+	if perPage < 1 || perPage > maxOrdersPerPage {
+		return apperrors.ErrValidation.WithDetails(fmt.Sprintf("per_page must be between 1 and %d", maxOrdersPerPage))
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		filter.After, err = decodeOrderCursor(cursor)
+		if err != nil {
+			return apperrors.ErrValidation.WithDetails("Invalid cursor")
+		}
+	}
+
+	// One extra order tells whether there is a next page
+	filter.Limit = perPage + 1
+	orders, err := h.orderRepo.ListByUser(c.Context(), filter)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list orders")
+		return apperrors.ErrInternal
+	}
+
+	hasMore := len(orders) > perPage
+	var nextCursor string
+	if hasMore {
+		orders = orders[:perPage]
+		nextCursor = encodeOrderCursor(&orders[perPage-1])
+	}
+
+	return c.JSON(fiber.Map{
+		"orders":      orders,
+		"count":       len(orders),
+		"per_page":    perPage,
+		"has_more":    hasMore,
+		"next_cursor": nextCursor,
+	})
+}
+
+// ExportOrders streams the user's order history, filtered as ListOrders is,
+// as CSV or JSON lines
+func (h *Handler) ExportOrders(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	filter, err := orderFilter(c, userID)
+	if err != nil {
+		return err
+	}
+
+	format := c.Query("format", "csv")
+	var write func(w *bufio.Writer, orders []types.Order) error
+	switch format {
+	case "csv":
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		write = writeOrdersCSV()
+	case "jsonl":
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		write = writeOrdersJSONLines
+	default:
+		return apperrors.ErrValidation.WithDetails("Format must be 'csv' or 'jsonl'")
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="orders-%s.%s"`, time.Now().UTC().Format("20060102"), format))
+
+	// The body is written after the handler returns, so the export runs on
+	// its own context rather than the request's
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+
+		filter.Limit = exportBatchSize
+		exported := 0
+		for {
+			orders, err := h.orderRepo.ListByUser(ctx, filter)
+			if err != nil {
+				logger.Error().Err(err).Str("user_id", userID).Int("exported", exported).Msg("Failed to export orders")
+				return
+			}
+			if err := write(w, orders); err != nil {
+				// Usually the client went away
+				logger.Warn().Err(err).Str("user_id", userID).Int("exported", exported).Msg("Order export interrupted")
+				return
+			}
+			exported += len(orders)
+			if len(orders) < exportBatchSize {
+				break
+			}
+			last := orders[len(orders)-1]
+			filter.After = &repository.OrderCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+		}
+
+		logger.Info().Str("user_id", userID).Str("format", format).Int("exported", exported).Msg("Orders exported")
+	})
+
+	return nil
+}
+
+// orderFilter reads the order history filters shared by ListOrders and
+// ExportOrders. Dates are RFC 3339 timestamps or calendar days in UTC; a day
+// given as to is included in full.
+func orderFilter(c *fiber.Ctx, userID string) (*repository.OrderFilter, error) {
+	filter := &repository.OrderFilter{
+		UserID: userID,
+		Status: c.Query("status"),
+		Symbol: strings.ToUpper(strings.TrimSpace(c.Query("symbol"))),
+		Side:   c.Query("side"),
+		Source: c.Query("source"),
+	}
+	if filter.Side != "" && filter.Side != "buy" && filter.Side != "sell" {
+		return nil, apperrors.ErrValidation.WithDetails("Side must be 'buy' or 'sell'")
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, _, err := parseHistoryTime(from)
+		if err != nil {
+			return nil, apperrors.ErrValidation.WithDetails("from must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, isDate, err := parseHistoryTime(to)
+		if err != nil {
+			return nil, apperrors.ErrValidation.WithDetails("to must be an RFC 3339 timestamp or a YYYY-MM-DD date")
+		}
+		if isDate {
+			t = t.AddDate(0, 0, 1)
+		}
+		filter.To = &t
+	}
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return nil, apperrors.ErrValidation.WithDetails("from must be before to")
+	}
+
+	return filter, nil
+}
+
+// parseHistoryTime parses a timestamp or a calendar day, reporting which
+func parseHistoryTime(s string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return t, false, err
+}
+
+// encodeOrderCursor returns the opaque cursor of the page after order
+func encodeOrderCursor(order *types.Order) string {
+	raw := order.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + order.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOrderCursor(cursor string) (*repository.OrderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return &repository.OrderCursor{CreatedAt: t, ID: id}, nil
+}
+
+// writeOrdersCSV returns a writer of CSV rows that starts with the header
+func writeOrdersCSV() func(w *bufio.Writer, orders []types.Order) error {
+	header := true
+	return func(w *bufio.Writer, orders []types.Order) error {
+		cw := csv.NewWriter(w)
+		if header {
+			if err := cw.Write(orderCSVHeader); err != nil {
+				return err
+			}
+			header = false
+		}
+		for i := range orders {
+			if err := cw.Write(orderCSVRow(&orders[i])); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+}
+
+func orderCSVRow(o *types.Order) []string {
+	return []string{
+		o.ID,
+		o.CreatedAt.UTC().Format(time.RFC3339),
+		o.Symbol,
+		o.Side,
+		o.Type,
+		o.TimeInForce,
+		formatFloat(o.Qty),
+		formatFloat(o.Amount),
+		formatOptionalFloat(o.LimitPrice),
+		formatOptionalFloat(o.StopPrice),
+		formatOptionalInt(o.MaxSlippageBps),
+		formatFloat(o.FilledQty),
+		formatFloat(o.FilledAvgPrice),
+		formatFloat(o.Commission),
+		o.Status,
+		o.Source,
+		o.OrderClass,
+		formatOptionalString(o.FailedReason),
+		formatOptionalTime(o.FilledAt),
+		formatOptionalTime(o.CanceledAt),
+	}
+}
+
+// writeOrdersJSONLines writes one JSON object per order
+func writeOrdersJSONLines(w *bufio.Writer, orders []types.Order) error {
+	enc := json.NewEncoder(w)
+	for i := range orders {
+		if err := enc.Encode(&orders[i]); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatOptionalFloat(v *float64) string {
+	if v == nil {
+		return ""
+	}
+	return formatFloat(*v)
+}
+
+func formatOptionalInt(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
+
+func formatOptionalString(v *string) string {
+	if v == nil {
+		return ""
+	}
+	return *v
+}
+
+func formatOptionalTime(v *time.Time) string {
+	if v == nil {
+		return ""
+	}
+	return v.UTC().Format(time.RFC3339)
+}
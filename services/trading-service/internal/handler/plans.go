@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// CreatePlan creates a recurring investment plan
+func (h *Handler) CreatePlan(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	var req types.CreatePlanRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	req.Currency = strings.ToUpper(req.Currency)
+	if req.Currency == "" {
+		req.Currency = "USD"
+	}
+	if req.Symbol == "" {
+		return apperrors.ErrValidation.WithDetails("Symbol is required")
+	}
+	if req.Currency != "KES" && req.Currency != "USD" {
+		return apperrors.ErrValidation.WithDetails("Currency must be 'KES' or 'USD'")
+	}
+	if err := validatePlanTerms(req.Amount, req.Frequency); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	nextRunAt := now
+	if req.StartAt != nil {
+		if req.StartAt.Before(now) {
+			return apperrors.ErrValidation.WithDetails("start_at must not be in the past")
+		}
+		nextRunAt = req.StartAt.UTC()
+	}
+
+	ctx := c.Context()
+
+	// Reject symbols the broker cannot take fractional orders in up front,
+	// rather than failing every run
+	asset, err := h.alpaca.GetAsset(ctx, req.Symbol)
+	if alpaca.IsNotFound(err) {
+		return apperrors.ErrInvalidSymbol
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("symbol", req.Symbol).Msg("Failed to get asset")
+		return apperrors.ErrAlpacaUnavailable
+	}
+	if !asset.Tradable || !asset.Fractionable {
+		return apperrors.ErrSymbolNotFractionable
+	}
+
+	plan := &types.InvestmentPlan{
+		UserID:    userID,
+		Symbol:    req.Symbol,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+		Frequency: req.Frequency,
+		Status:    "active",
+		NextRunAt: nextRunAt,
+	}
+	if err := h.planRepo.Create(ctx, plan); err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to create investment plan")
+		return apperrors.ErrInternal
+	}
+
+	logger.Info().
+		Str("user_id", userID).
+		Str("plan_id", plan.ID).
+		Str("symbol", plan.Symbol).
+		Str("frequency", plan.Frequency).
+		Msg("Investment plan created")
+
+	return c.Status(fiber.StatusCreated).JSON(plan)
+}
+
+// ListPlans retrieves the user's investment plans
+func (h *Handler) ListPlans(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+
+	plans, err := h.planRepo.ListByUser(c.Context(), userID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list investment plans")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"plans": plans,
+		"count": len(plans),
+	})
+}
+
+// GetPlan retrieves an investment plan and its recent runs
+func (h *Handler) GetPlan(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	plan, err := h.userPlan(ctx, c.Locals("user_id").(string), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	runs, err := h.planRepo.ListRuns(ctx, plan.ID, c.QueryInt("runs", 20))
+	if err != nil {
+		logger.Error().Err(err).Str("plan_id", plan.ID).Msg("Failed to list investment plan runs")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"plan": plan,
+		"runs": runs,
+	})
+}
+
+// UpdatePlan changes the amount or frequency of a plan, or pauses and
+// resumes it
+func (h *Handler) UpdatePlan(c *fiber.Ctx) error {
+	var req types.UpdatePlanRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+	if req.Amount == 0 && req.Frequency == "" && req.Status == "" {
+		return apperrors.ErrValidation.WithDetails("Nothing to update")
+	}
+
+	ctx := c.Context()
+
+	plan, err := h.userPlan(ctx, c.Locals("user_id").(string), c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if plan.Status == "canceled" {
+		return apperrors.ErrValidation.WithDetails("Plan has been canceled")
+	}
+
+	if req.Amount != 0 {
+		plan.Amount = req.Amount
+	}
+	if req.Frequency != "" {
+		plan.Frequency = req.Frequency
+	}
+	if err := validatePlanTerms(plan.Amount, plan.Frequency); err != nil {
+		return err
+	}
+
+	switch req.Status {
+	case "":
+	case "paused":
+		plan.Status = "paused"
+	case "active":
+		// Periods missed while paused are skipped, not run on resume
+		if plan.Status == "paused" {
+			plan.Advance(time.Now().UTC())
+		}
+		plan.Status = "active"
+	default:
+		return apperrors.ErrValidation.WithDetails("Status must be 'active' or 'paused'")
+	}
+
+	if err := h.planRepo.Update(ctx, plan); err != nil {
+		logger.Error().Err(err).Str("plan_id", plan.ID).Msg("Failed to update investment plan")
+		return apperrors.ErrInternal
+	}
+
+	logger.Info().Str("plan_id", plan.ID).Str("status", plan.Status).Msg("Investment plan updated")
+
+	return c.JSON(plan)
+}
+
+// CancelPlan stops an investment plan. Its run history is kept.
+func (h *Handler) CancelPlan(c *fiber.Ctx) error {
+	ctx := c.Context()
+
+	plan, err := h.userPlan(ctx, c.Locals("user_id").(string), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	if plan.Status != "canceled" {
+		plan.Status = "canceled"
+		if err := h.planRepo.Update(ctx, plan); err != nil {
+			logger.Error().Err(err).Str("plan_id", plan.ID).Msg("Failed to cancel investment plan")
+			return apperrors.ErrInternal
+		}
+		logger.Info().Str("plan_id", plan.ID).Msg("Investment plan canceled")
+	}
+
+	return c.JSON(plan)
+}
+
+// userPlan loads a plan and checks it belongs to the user
+func (h *Handler) userPlan(ctx context.Context, userID, planID string) (*types.InvestmentPlan, error) {
+	plan, err := h.planRepo.GetByID(ctx, planID)
+	if err != nil {
+		if errors.Is(err, repository.ErrPlanNotFound) {
+			return nil, apperrors.ErrNotFound.WithDetails("Plan not found")
+		}
+		logger.Error().Err(err).Str("plan_id", planID).Msg("Failed to get investment plan")
+		return nil, apperrors.ErrInternal
+	}
+
+	if plan.UserID != userID {
+		return nil, apperrors.ErrForbidden.WithDetails("Not your plan")
+	}
+
+	return plan, nil
+}
+
+func validatePlanTerms(amount float64, frequency string) error {
+	if amount <= 0 {
+		return apperrors.ErrValidation.WithDetails("Amount must be positive")
+	}
+	if frequency != "weekly" && frequency != "monthly" {
+		return apperrors.ErrValidation.WithDetails("Frequency must be 'weekly' or 'monthly'")
+	}
+	return nil
+}
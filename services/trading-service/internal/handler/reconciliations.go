@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/middleware"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/reconcile"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// ListReconciliations retrieves the most recent position reconciliations
+func (h *Handler) ListReconciliations(c *fiber.Ctx) error {
+	recs, err := h.reconciler.List(c.Context(), c.QueryInt("limit", 30))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list position reconciliations")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"reconciliations": recs,
+		"count":           len(recs),
+	})
+}
+
+// GetReconciliation retrieves a position reconciliation and its drift report
+func (h *Handler) GetReconciliation(c *fiber.Ctx) error {
+	rec, err := h.reconciler.Get(c.Context(), c.Params("id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrReconciliationNotFound) {
+			return apperrors.ErrNotFound.WithDetails("Reconciliation not found")
+		}
+		logger.Error().Err(err).Str("reconciliation_id", c.Params("id")).Msg("Failed to get position reconciliation")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(rec)
+}
+
+// RunReconciliation reconciles positions against the broker now, outside
+// the nightly schedule
+func (h *Handler) RunReconciliation(c *fiber.Ctx) error {
+	rec, err := h.reconciler.Run(c.Context(), reconcile.TriggerManual, middleware.GetAdminActor(c))
+	if err != nil {
+		logger.Error().Err(err).Msg("Position reconciliation failed")
+		return apperrors.ErrServiceUnavailable.WithDetails("Reconciliation failed, see the reconciliation history")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(rec)
+}
+
+// CorrectDrift sets the holdings behind a drift to the broker's position.
+// The request must repeat the drift's symbol and broker qty.
+func (h *Handler) CorrectDrift(c *fiber.Ctx) error {
+	var req types.CorrectPositionDriftRequest
+	if err := c.BodyParser(&req); err != nil {
+		return apperrors.ErrValidation.WithDetails("Invalid request body")
+	}
+
+	drift, err := h.reconciler.Correct(c.Context(), c.Params("id"), &req, middleware.GetAdminActor(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(drift)
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/slippage"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// protectMarketOrder checks the quote a market order is priced from and
+// sends an order by qty as a limit order a bounded distance through it, so
+// a thin book cannot fill it far from the price the user saw. Orders by
+// amount cannot be limit orders at Alpaca and are only checked.
+func (h *Handler) protectMarketOrder(ctx context.Context, req *types.PlaceOrderRequest) error {
+	if req.Type != "market" {
+		return nil
+	}
+
+	quote, err := h.alpaca.GetQuote(ctx, req.Symbol)
+	if err != nil {
+		return apperrors.ErrServiceUnavailable.WithDetails("Failed to get quote")
+	}
+	q := slippage.Quote{Bid: quote.BidPrice, Ask: quote.AskPrice}
+	if ts, err := time.Parse(time.RFC3339Nano, quote.Timestamp); err == nil {
+		q.Time = ts
+	}
+
+	now := time.Now()
+	if req.Qty <= 0 {
+		return slippageError(req.Symbol, h.slippage.Check(q, now))
+	}
+
+	protection, err := h.slippage.Protect(req.Side, q, req.MaxSlippageBps, now)
+	if err != nil {
+		return slippageError(req.Symbol, err)
+	}
+
+	logger.Debug().
+		Str("symbol", req.Symbol).
+		Str("side", req.Side).
+		Float64("reference", protection.Reference).
+		Float64("limit_price", protection.LimitPrice).
+		Int("slippage_bps", protection.SlippageBps).
+		Msg("Market order sent as protective limit order")
+
+	req.Type = "limit"
+	req.LimitPrice = protection.LimitPrice
+	req.MaxSlippageBps = protection.SlippageBps
+	return nil
+}
+
+// slippageError explains why a market order was refused
+func slippageError(symbol string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, slippage.ErrInvalidSlippage):
+		return apperrors.ErrValidation.WithDetails(fmt.Sprintf("max_slippage_bps %s", reasonAfter(err, slippage.ErrInvalidSlippage)))
+	case errors.Is(err, slippage.ErrStaleQuote):
+		return apperrors.ErrQuoteStale.WithDetails(fmt.Sprintf("%s: %s", symbol, reasonAfter(err, slippage.ErrStaleQuote)))
+	case errors.Is(err, slippage.ErrWideSpread):
+		return apperrors.ErrSpreadTooWide.WithDetails(fmt.Sprintf("%s: %s", symbol, reasonAfter(err, slippage.ErrWideSpread)))
+	case errors.Is(err, slippage.ErrNoQuote):
+		return apperrors.ErrQuoteUnavailable.WithDetails(fmt.Sprintf("%s: %s", symbol, reasonAfter(err, slippage.ErrNoQuote)))
+	}
+	return err
+}
+
+// reasonAfter returns the explanation a guard wrapped around its sentinel
+func reasonAfter(err, sentinel error) string {
+	msg := err.Error()
+	prefix := sentinel.Error() + ": "
+	if len(msg) > len(prefix) && msg[:len(prefix)] == prefix {
+		return msg[len(prefix):]
+	}
+	return msg
+}
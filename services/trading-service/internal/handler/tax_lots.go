@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/taxlots"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// ListTaxLots retrieves the user's open tax lots, optionally in one symbol
+func (h *Handler) ListTaxLots(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	symbol := strings.ToUpper(c.Query("symbol"))
+
+	lots, err := h.lotRepo.ListOpen(c.Context(), userID, symbol)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list tax lots")
+		return apperrors.ErrInternal
+	}
+
+	return c.JSON(fiber.Map{
+		"lots":  lots,
+		"count": len(lots),
+	})
+}
+
+// GetRealizedGains retrieves the user's realized gains, totaled per symbol
+// and year, along with the most recent sales behind them. The symbol and year
+// query parameters narrow both.
+func (h *Handler) GetRealizedGains(c *fiber.Ctx) error {
+	userID := c.Locals("user_id").(string)
+	ctx := c.Context()
+
+	symbol := strings.ToUpper(c.Query("symbol"))
+	year := c.QueryInt("year", 0)
+	if year < 0 {
+		return apperrors.ErrValidation.WithDetails("Year must be positive")
+	}
+
+	summary, err := h.lotRepo.SummarizeGains(ctx, userID, symbol, year)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to summarize realized gains")
+		return apperrors.ErrInternal
+	}
+
+	gains, err := h.lotRepo.ListGains(ctx, userID, symbol, year, c.QueryInt("limit", 100))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list realized gains")
+		return apperrors.ErrInternal
+	}
+
+	var totalPL float64
+	for _, s := range summary {
+		totalPL += s.RealizedPL
+	}
+
+	return c.JSON(fiber.Map{
+		"summary":     summary,
+		"gains":       gains,
+		"count":       len(gains),
+		"realized_pl": totalPL,
+	})
+}
+
+// validateLotSelection checks how a sell picks its tax lots, defaulting to
+// FIFO
+func validateLotSelection(req *types.PlaceOrderRequest) error {
+	if req.LotMethod == "" {
+		req.LotMethod = taxlots.MethodFIFO
+	}
+	if !taxlots.ValidMethod(req.LotMethod) {
+		return apperrors.ErrValidation.WithDetails("Lot method must be 'fifo', 'lifo' or 'specific'")
+	}
+
+	if req.Side != "sell" {
+		if len(req.LotIDs) > 0 {
+			return apperrors.ErrValidation.WithDetails("lot_ids only apply to sell orders")
+		}
+		return nil
+	}
+	if req.LotMethod == taxlots.MethodSpecific && len(req.LotIDs) == 0 {
+		return apperrors.ErrValidation.WithDetails("lot_ids are required for the specific lot method")
+	}
+	if req.LotMethod != taxlots.MethodSpecific && len(req.LotIDs) > 0 {
+		return apperrors.ErrValidation.WithDetails("lot_ids require the specific lot method")
+	}
+	return nil
+}
+
+// checkLots verifies that the lots chosen for a specific-lot sell are the
+// user's open lots in the symbol and hold the shares being sold
+func (h *Handler) checkLots(ctx context.Context, userID string, req *types.PlaceOrderRequest) error {
+	if req.LotMethod != taxlots.MethodSpecific {
+		return nil
+	}
+
+	open, err := h.lotRepo.ListOpen(ctx, userID, req.Symbol)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to list tax lots")
+		return apperrors.ErrInternal
+	}
+
+	var chosen float64
+	for i, id := range req.LotIDs {
+		if slices.Contains(req.LotIDs[:i], id) {
+			return apperrors.ErrValidation.WithDetails("Lot " + id + " is listed more than once")
+		}
+		idx := slices.IndexFunc(open, func(l types.TaxLot) bool { return l.ID == id })
+		if idx < 0 {
+			return apperrors.ErrValidation.WithDetails("Lot " + id + " is not an open lot in " + req.Symbol)
+		}
+		chosen += open[idx].RemainingQty
+	}
+	if chosen < req.Qty {
+		return apperrors.ErrValidation.WithDetails("Chosen lots hold fewer shares than the order sells")
+	}
+	return nil
+}
@@ -0,0 +1,339 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/pkg/positions"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// Reconciliation triggers
+const (
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+var (
+	reconciliations = metrics.RegisterCounter(
+		"trading_position_reconciliations_total",
+		"Position reconciliations by trigger and status (completed, failed)",
+		[]string{"trigger", "status"},
+	)
+
+	positionDrift = metrics.RegisterGauge(
+		"trading_position_drift_shares",
+		"Broker position minus user holdings per symbol, as of the last reconciliation",
+		[]string{"symbol"},
+	)
+)
+
+// Reconciler compares user holdings, summed per symbol, with the positions
+// held at the broker and keeps a drift report of every symbol that
+// disagrees. Holdings are only ever corrected by an operator, one drift at a
+// time.
+type Reconciler struct {
+	uow         *repository.UnitOfWork
+	reconRepo   *repository.ReconciliationRepository
+	holdingRepo *repository.HoldingRepository
+	alpaca      alpaca.TradingClient
+	tolerance   float64
+}
+
+// New creates a new position reconciler. Differences of up to tolerance
+// shares are not reported.
+func New(
+	uow *repository.UnitOfWork,
+	reconRepo *repository.ReconciliationRepository,
+	holdingRepo *repository.HoldingRepository,
+	alpacaClient alpaca.TradingClient,
+	tolerance float64,
+) *Reconciler {
+	return &Reconciler{
+		uow:         uow,
+		reconRepo:   reconRepo,
+		holdingRepo: holdingRepo,
+		alpaca:      alpacaClient,
+		tolerance:   tolerance,
+	}
+}
+
+// Run reconciles every symbol and records the drift report. A scheduled run
+// returns nil, without error, if today's run was already started elsewhere.
+func (r *Reconciler) Run(ctx context.Context, trigger, actor string) (*types.PositionReconciliation, error) {
+	now := time.Now().UTC()
+	rec := &types.PositionReconciliation{
+		Trigger:   trigger,
+		RunDate:   now.Truncate(24 * time.Hour),
+		StartedBy: actor,
+	}
+	started, err := r.reconRepo.Start(ctx, rec)
+	if err != nil {
+		return nil, err
+	}
+	if !started {
+		return nil, nil
+	}
+
+	drifts, totals, err := r.compare(ctx)
+	if err != nil {
+		r.fail(ctx, rec, err)
+		return nil, err
+	}
+
+	rec.Status = "completed"
+	rec.SymbolsChecked = len(totals)
+	rec.DriftCount = len(drifts)
+
+	err = r.uow.Do(ctx, func(tx *repository.Tx) error {
+		for _, d := range drifts {
+			drift := &types.PositionDrift{
+				ReconciliationID: rec.ID,
+				Symbol:           d.Symbol,
+				LocalQty:         d.LocalQty,
+				BrokerQty:        d.BrokerQty,
+				Drift:            d.Diff,
+				Holders:          totals[d.Symbol].Holders,
+			}
+			if err := tx.Reconciliations.AddDrift(ctx, drift); err != nil {
+				return err
+			}
+			rec.Drifts = append(rec.Drifts, *drift)
+		}
+		if err := tx.Reconciliations.Finish(ctx, rec); err != nil {
+			return err
+		}
+		if len(drifts) == 0 {
+			return nil
+		}
+		return tx.Outbox.Add(ctx, events.TopicPositionDriftDetected, events.NewEvent(
+			events.EventTypePositionDriftDetected,
+			"trading-service",
+			alert(rec, drifts),
+		))
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to record position reconciliation: %w", err)
+		rec.Drifts = nil
+		r.fail(ctx, rec, err)
+		return nil, err
+	}
+
+	positionDrift.Reset()
+	for _, d := range drifts {
+		positionDrift.WithLabelValues(d.Symbol).Set(d.Diff)
+	}
+	reconciliations.WithLabelValues(trigger, rec.Status).Inc()
+
+	log := logger.Info()
+	if len(drifts) > 0 {
+		log = logger.Warn()
+	}
+	log.
+		Str("reconciliation_id", rec.ID).
+		Str("trigger", trigger).
+		Int("symbols_checked", rec.SymbolsChecked).
+		Int("drift_count", rec.DriftCount).
+		Msg("Position reconciliation completed")
+
+	return rec, nil
+}
+
+// fail records a reconciliation as failed. A failed scheduled run is tried
+// again on the scheduler's next check.
+func (r *Reconciler) fail(ctx context.Context, rec *types.PositionReconciliation, cause error) {
+	msg := cause.Error()
+	rec.Status = "failed"
+	rec.SymbolsChecked = 0
+	rec.DriftCount = 0
+	rec.Error = &msg
+	if err := r.reconRepo.Finish(ctx, rec); err != nil {
+		logger.Error().Err(err).Str("reconciliation_id", rec.ID).Msg("Failed to record failed position reconciliation")
+	}
+	reconciliations.WithLabelValues(rec.Trigger, rec.Status).Inc()
+}
+
+// compare sums holdings per symbol and compares them with the broker's
+// positions. The totals are keyed by every symbol held on either side.
+func (r *Reconciler) compare(ctx context.Context) ([]positions.Drift, map[string]types.HoldingTotal, error) {
+	held, err := r.holdingRepo.TotalsBySymbol(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	broker, err := r.brokerPositions(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	totals := make(map[string]types.HoldingTotal, len(held))
+	local := make(map[string]float64, len(held))
+	for _, t := range held {
+		totals[t.Symbol] = t
+		local[t.Symbol] = t.Qty
+	}
+	for symbol := range broker {
+		if _, ok := totals[symbol]; !ok {
+			totals[symbol] = types.HoldingTotal{Symbol: symbol}
+		}
+	}
+
+	return positions.Compare(local, broker, r.tolerance), totals, nil
+}
+
+// brokerPositions returns the shares held at the broker per symbol
+func (r *Reconciler) brokerPositions(ctx context.Context) (map[string]float64, error) {
+	list, err := r.alpaca.ListPositions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list broker positions: %w", err)
+	}
+
+	broker := make(map[string]float64, len(list))
+	for _, p := range list {
+		qty, err := strconv.ParseFloat(p.Qty, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid broker qty %q for %s: %w", p.Qty, p.Symbol, err)
+		}
+		broker[p.Symbol] += qty
+	}
+	return broker, nil
+}
+
+// List returns the most recent reconciliations, newest first
+func (r *Reconciler) List(ctx context.Context, limit int) ([]types.PositionReconciliation, error) {
+	return r.reconRepo.List(ctx, limit)
+}
+
+// Get returns a reconciliation with its drift report
+func (r *Reconciler) Get(ctx context.Context, id string) (*types.PositionReconciliation, error) {
+	rec, err := r.reconRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rec.Drifts, err = r.reconRepo.ListDrifts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Correct brings the holdings in a drift's symbol in line with the broker,
+// scaling every holder's shares and open tax lots pro rata. The operator
+// confirms by repeating the symbol and broker qty of the drift, and the
+// correction is refused if either side has moved since the report was taken.
+func (r *Reconciler) Correct(ctx context.Context, driftID string, req *types.CorrectPositionDriftRequest, actor string) (*types.PositionDrift, error) {
+	// The broker is asked before any rows are locked
+	broker, err := r.brokerPositions(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list broker positions")
+		return nil, apperrors.ErrAlpacaUnavailable
+	}
+
+	var drift *types.PositionDrift
+	err = r.uow.Do(ctx, func(tx *repository.Tx) error {
+		drift, err = tx.Reconciliations.GetDriftForUpdate(ctx, driftID)
+		if errors.Is(err, repository.ErrDriftNotFound) {
+			return apperrors.ErrNotFound.WithDetails("Position drift not found")
+		}
+		if err != nil {
+			return err
+		}
+		if drift.Status == "corrected" {
+			return apperrors.ErrConflict.WithDetails("Position drift has already been corrected")
+		}
+
+		if !strings.EqualFold(strings.TrimSpace(req.ConfirmSymbol), drift.Symbol) ||
+			req.ConfirmBrokerQty == nil || !equalQty(*req.ConfirmBrokerQty, drift.BrokerQty) {
+			return apperrors.ErrValidation.WithDetails("Confirm the correction with the drift's symbol and broker qty")
+		}
+		if !equalQty(broker[drift.Symbol], drift.BrokerQty) {
+			return apperrors.ErrConflict.WithDetails("The broker position has changed since this report, run a new reconciliation")
+		}
+
+		holdings, err := tx.Holdings.ListBySymbolForUpdate(ctx, drift.Symbol)
+		if err != nil {
+			return err
+		}
+		qtys := make([]float64, len(holdings))
+		var local float64
+		for i, h := range holdings {
+			qtys[i] = h.Qty
+			local += h.Qty
+		}
+		if !equalQty(local, drift.LocalQty) {
+			return apperrors.ErrConflict.WithDetails("Holdings have changed since this report, run a new reconciliation")
+		}
+
+		corrected := positions.Prorate(qtys, drift.BrokerQty)
+		if corrected == nil {
+			return apperrors.ErrValidation.WithDetails("No user holds " + drift.Symbol + ", so there are no holdings to correct")
+		}
+		for i, h := range holdings {
+			if err := tx.Holdings.CorrectQty(ctx, h.ID, corrected[i]); err != nil {
+				return err
+			}
+			// Lots follow their holding so sells keep consuming what is held
+			if h.Qty > 0 {
+				if err := tx.Lots.ScaleOpen(ctx, h.UserID, h.Symbol, h.Qty, corrected[i]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Reconciliations.MarkCorrected(ctx, drift, actor, strings.TrimSpace(req.Note))
+	})
+	if err != nil {
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			return nil, err
+		}
+		logger.Error().Err(err).Str("drift_id", driftID).Msg("Failed to correct position drift")
+		return nil, apperrors.ErrInternal
+	}
+
+	logger.Warn().
+		Str("drift_id", drift.ID).
+		Str("symbol", drift.Symbol).
+		Float64("local_qty", drift.LocalQty).
+		Float64("broker_qty", drift.BrokerQty).
+		Int("holders", drift.Holders).
+		Str("actor", actor).
+		Msg("Holdings corrected to broker position")
+
+	return drift, nil
+}
+
+// equalQty compares share quantities at the precision holdings are stored at
+func equalQty(a, b float64) bool {
+	return math.Abs(a-b) < 5e-9
+}
+
+// alert builds the operations alert for the drifts a reconciliation found
+func alert(rec *types.PositionReconciliation, drifts []positions.Drift) events.PositionDriftDetectedPayload {
+	payload := events.PositionDriftDetectedPayload{
+		ReconciliationID: rec.ID,
+		SymbolsChecked:   rec.SymbolsChecked,
+		Message: fmt.Sprintf("Position reconciliation found %d of %d symbols out of line with the broker",
+			len(drifts), rec.SymbolsChecked),
+		DetectedAt: time.Now().UTC(),
+	}
+	for _, d := range drifts {
+		payload.Drifts = append(payload.Drifts, events.PositionDrift{
+			Symbol:    d.Symbol,
+			LocalQty:  d.LocalQty,
+			BrokerQty: d.BrokerQty,
+			Drift:     d.Diff,
+		})
+	}
+	return payload
+}
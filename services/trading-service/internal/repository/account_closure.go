@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+var (
+	// ErrClosureNotFound is returned when a user has no account closure
+	ErrClosureNotFound = errors.New("account closure not found")
+
+	// ErrClosureInProgress is returned by Create when the user already has a
+	// closure in progress
+	ErrClosureInProgress = errors.New("account closure already in progress")
+)
+
+// AccountClosureRepository handles account closure database operations
+type AccountClosureRepository struct {
+	db DBTX
+}
+
+// NewAccountClosureRepository creates a new account closure repository
+func NewAccountClosureRepository(db *pgxpool.Pool) *AccountClosureRepository {
+	return &AccountClosureRepository{db: db}
+}
+
+const closureColumns = `id, user_id, phone, status, sell_round, converted_usd, converted_kes, paid_out_kes,
+	payout_amount, payout_attempts, payout_conversation_id, payout_requested_at, payout_result_code,
+	payout_result_desc, payout_receipt, residual_kes, reason, next_check_at, closed_at, created_at, updated_at`
+
+func scanClosure(row pgx.Row) (*types.AccountClosure, error) {
+	var c types.AccountClosure
+	err := row.Scan(
+		&c.ID, &c.UserID, &c.Phone, &c.Status, &c.SellRound, &c.ConvertedUSD, &c.ConvertedKES, &c.PaidOutKES,
+		&c.PayoutAmount, &c.PayoutAttempts, &c.PayoutConversationID, &c.PayoutRequestedAt, &c.PayoutResultCode,
+		&c.PayoutResultDesc, &c.PayoutReceipt, &c.ResidualKES, &c.Reason, &c.NextCheckAt, &c.ClosedAt,
+		&c.CreatedAt, &c.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Create starts an account closure
+func (r *AccountClosureRepository) Create(ctx context.Context, c *types.AccountClosure) error {
+	created, err := scanClosure(r.db.QueryRow(ctx, fmt.Sprintf(`
+		INSERT INTO account_closures (user_id, phone)
+		VALUES ($1, $2)
+		RETURNING %s
+	`, closureColumns), c.UserID, c.Phone))
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrClosureInProgress
+		}
+		return fmt.Errorf("failed to create account closure: %w", err)
+	}
+
+	*c = *created
+	return nil
+}
+
+// GetLatestByUser retrieves the user's most recent account closure
+func (r *AccountClosureRepository) GetLatestByUser(ctx context.Context, userID string) (*types.AccountClosure, error) {
+	c, err := scanClosure(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM account_closures
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, closureColumns), userID))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrClosureNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account closure: %w", err)
+	}
+
+	return c, nil
+}
+
+// InProgress reports whether the user has an account closure in progress
+func (r *AccountClosureRepository) InProgress(ctx context.Context, userID string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM account_closures
+			WHERE user_id = $1 AND status NOT IN ('closed', 'failed')
+		)
+	`, userID).Scan(&exists)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to check account closure: %w", err)
+	}
+
+	return exists, nil
+}
+
+// ClaimDue returns closures in progress that are due a check and leases them
+// until leaseUntil, so a closure being worked on is not picked up again by
+// another tick or replica
+func (r *AccountClosureRepository) ClaimDue(ctx context.Context, leaseUntil time.Time, limit int) ([]types.AccountClosure, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		UPDATE account_closures SET next_check_at = $1
+		WHERE id IN (
+			SELECT id FROM account_closures
+			WHERE status NOT IN ('closed', 'failed') AND next_check_at <= NOW()
+			ORDER BY next_check_at ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING %s
+	`, closureColumns), leaseUntil, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due account closures: %w", err)
+	}
+	defer rows.Close()
+
+	var closures []types.AccountClosure
+	for rows.Next() {
+		c, err := scanClosure(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan account closure: %w", err)
+		}
+		closures = append(closures, *c)
+	}
+
+	return closures, nil
+}
+
+// Save saves a closure's progress. The payout request and its result are
+// only written by StartPayout, SetPayoutConversation and RecordPayoutResult.
+func (r *AccountClosureRepository) Save(ctx context.Context, c *types.AccountClosure) error {
+	err := r.db.QueryRow(ctx, `
+		UPDATE account_closures
+		SET status = $1, sell_round = $2, converted_usd = $3, converted_kes = $4, paid_out_kes = $5,
+		    payout_amount = $6, payout_attempts = $7, residual_kes = $8, reason = $9, next_check_at = $10,
+		    closed_at = $11, updated_at = NOW()
+		WHERE id = $12
+		RETURNING updated_at
+	`, c.Status, c.SellRound, c.ConvertedUSD, c.ConvertedKES, c.PaidOutKES,
+		c.PayoutAmount, c.PayoutAttempts, c.ResidualKES, c.Reason, c.NextCheckAt,
+		c.ClosedAt, c.ID,
+	).Scan(&c.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to save account closure: %w", err)
+	}
+
+	return nil
+}
+
+// StartPayout records a payout as requested and clears the result of any
+// earlier one
+func (r *AccountClosureRepository) StartPayout(ctx context.Context, c *types.AccountClosure) error {
+	err := r.db.QueryRow(ctx, `
+		UPDATE account_closures
+		SET status = 'awaiting_payout', payout_amount = $1, payout_requested_at = NOW(),
+		    payout_conversation_id = NULL, payout_result_code = NULL, payout_result_desc = NULL,
+		    payout_receipt = NULL, reason = NULL, next_check_at = $2, updated_at = NOW()
+		WHERE id = $3
+		RETURNING status, payout_requested_at, updated_at
+	`, c.PayoutAmount, c.NextCheckAt, c.ID).Scan(&c.Status, &c.PayoutRequestedAt, &c.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to start account closure payout: %w", err)
+	}
+
+	c.PayoutConversationID = nil
+	c.PayoutResultCode = nil
+	c.PayoutResultDesc = nil
+	c.PayoutReceipt = nil
+	c.Reason = nil
+	return nil
+}
+
+// SetPayoutConversation records the M-Pesa conversation ID of the payout in
+// flight, which its result is matched on
+func (r *AccountClosureRepository) SetPayoutConversation(ctx context.Context, id, conversationID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE account_closures
+		SET payout_conversation_id = $1, updated_at = NOW()
+		WHERE id = $2 AND status = 'awaiting_payout'
+	`, conversationID, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to set account closure payout conversation: %w", err)
+	}
+
+	return nil
+}
+
+// RecordPayoutResult records the M-Pesa result of a payout in flight and
+// makes its closure due. It returns false if no closure is awaiting a payout
+// with the conversation ID, or its result was already recorded.
+func (r *AccountClosureRepository) RecordPayoutResult(ctx context.Context, conversationID string, code int, desc, receipt string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE account_closures
+		SET payout_result_code = $1, payout_result_desc = $2, payout_receipt = NULLIF($3, ''),
+		    next_check_at = NOW(), updated_at = NOW()
+		WHERE payout_conversation_id = $4 AND status = 'awaiting_payout' AND payout_result_code IS NULL
+	`, code, desc, receipt, conversationID)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to record account closure payout result: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
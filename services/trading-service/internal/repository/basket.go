@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+var (
+	// ErrBasketNotFound is returned when a basket does not exist
+	ErrBasketNotFound = errors.New("basket not found")
+
+	// ErrDuplicateBasket is returned by Create when the user already placed a
+	// basket with the same idempotency key
+	ErrDuplicateBasket = errors.New("basket already exists")
+)
+
+// BasketRepository handles basket database operations
+type BasketRepository struct {
+	db DBTX
+}
+
+// NewBasketRepository creates a new basket repository
+func NewBasketRepository(db *pgxpool.Pool) *BasketRepository {
+	return &BasketRepository{db: db}
+}
+
+const basketColumns = `id, user_id, COALESCE(name, ''), amount, status, idempotency_key,
+	COALESCE(source, ''), created_at, updated_at`
+
+func scanBasket(row pgx.Row) (*types.Basket, error) {
+	var basket types.Basket
+	err := row.Scan(
+		&basket.ID, &basket.UserID, &basket.Name, &basket.Amount, &basket.Status,
+		&basket.IdempotencyKey, &basket.Source, &basket.CreatedAt, &basket.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &basket, nil
+}
+
+// Create creates a new basket
+func (r *BasketRepository) Create(ctx context.Context, basket *types.Basket) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO baskets (user_id, name, amount, status, idempotency_key, source)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at, updated_at
+	`, basket.UserID, basket.Name, basket.Amount, basket.Status, basket.IdempotencyKey, basket.Source,
+	).Scan(&basket.ID, &basket.CreatedAt, &basket.UpdatedAt)
+
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrDuplicateBasket
+		}
+		return fmt.Errorf("failed to create basket: %w", err)
+	}
+
+	return nil
+}
+
+// GetByID retrieves a basket by ID
+func (r *BasketRepository) GetByID(ctx context.Context, basketID string) (*types.Basket, error) {
+	basket, err := scanBasket(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM baskets WHERE id = $1
+	`, basketColumns), basketID))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrBasketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get basket: %w", err)
+	}
+
+	return basket, nil
+}
+
+// GetByIdempotencyKey retrieves a user's basket by the Idempotency-Key it was
+// placed with. It returns nil without an error if no such basket exists.
+func (r *BasketRepository) GetByIdempotencyKey(ctx context.Context, userID, key string) (*types.Basket, error) {
+	basket, err := scanBasket(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM baskets WHERE user_id = $1 AND idempotency_key = $2
+	`, basketColumns), userID, key))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get basket by idempotency key: %w", err)
+	}
+
+	return basket, nil
+}
+
+// ListByUser retrieves a user's most recent baskets
+func (r *BasketRepository) ListByUser(ctx context.Context, userID string, limit int) ([]types.Basket, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM baskets
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, basketColumns), userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list baskets: %w", err)
+	}
+	defer rows.Close()
+
+	var baskets []types.Basket
+	for rows.Next() {
+		basket, err := scanBasket(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan basket: %w", err)
+		}
+		baskets = append(baskets, *basket)
+	}
+
+	return baskets, nil
+}
+
+// UpdateStatus records the outcome of submitting a basket's orders
+func (r *BasketRepository) UpdateStatus(ctx context.Context, basketID, status string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE baskets SET status = $1, updated_at = NOW() WHERE id = $2
+	`, status, basketID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update basket status: %w", err)
+	}
+
+	return nil
+}
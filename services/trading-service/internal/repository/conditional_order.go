@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// ErrConditionalOrderNotFound is returned when a conditional order does not exist
+var ErrConditionalOrderNotFound = errors.New("conditional order not found")
+
+// ConditionalOrderRepository handles conditional order database operations
+type ConditionalOrderRepository struct {
+	db DBTX
+}
+
+// NewConditionalOrderRepository creates a new conditional order repository
+func NewConditionalOrderRepository(db *pgxpool.Pool) *ConditionalOrderRepository {
+	return &ConditionalOrderRepository{db: db}
+}
+
+const conditionalOrderColumns = `id, user_id, symbol, condition, threshold, order_request, status,
+	expires_at, triggered_at, trigger_price, order_id, reason, created_at, updated_at`
+
+func scanConditionalOrder(row pgx.Row) (*types.ConditionalOrder, error) {
+	var o types.ConditionalOrder
+	err := row.Scan(
+		&o.ID, &o.UserID, &o.Symbol, &o.Condition, &o.Threshold, &o.Order, &o.Status,
+		&o.ExpiresAt, &o.TriggeredAt, &o.TriggerPrice, &o.OrderID, &o.Reason, &o.CreatedAt, &o.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func scanConditionalOrders(rows pgx.Rows) ([]types.ConditionalOrder, error) {
+	defer rows.Close()
+
+	var orders []types.ConditionalOrder
+	for rows.Next() {
+		o, err := scanConditionalOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan conditional order: %w", err)
+		}
+		orders = append(orders, *o)
+	}
+
+	return orders, nil
+}
+
+// Create creates a new active conditional order
+func (r *ConditionalOrderRepository) Create(ctx context.Context, o *types.ConditionalOrder) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO conditional_orders (user_id, symbol, condition, threshold, order_request, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, created_at, updated_at
+	`, o.UserID, o.Symbol, o.Condition, o.Threshold, o.Order, o.ExpiresAt,
+	).Scan(&o.ID, &o.Status, &o.CreatedAt, &o.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create conditional order: %w", err)
+	}
+
+	return nil
+}
+
+// GetByID retrieves a conditional order by ID
+func (r *ConditionalOrderRepository) GetByID(ctx context.Context, id string) (*types.ConditionalOrder, error) {
+	o, err := scanConditionalOrder(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM conditional_orders WHERE id = $1
+	`, conditionalOrderColumns), id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrConditionalOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conditional order: %w", err)
+	}
+
+	return o, nil
+}
+
+// ListByUser retrieves a user's conditional orders, newest first. An empty
+// status lists every status.
+func (r *ConditionalOrderRepository) ListByUser(ctx context.Context, userID, status string, limit int) ([]types.ConditionalOrder, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM conditional_orders
+		WHERE user_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, conditionalOrderColumns), userID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conditional orders: %w", err)
+	}
+
+	return scanConditionalOrders(rows)
+}
+
+// ListActive retrieves the unexpired active conditional orders in a symbol,
+// oldest first
+func (r *ConditionalOrderRepository) ListActive(ctx context.Context, symbol string) ([]types.ConditionalOrder, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM conditional_orders
+		WHERE symbol = $1 AND status = 'active' AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at ASC
+	`, conditionalOrderColumns), symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active conditional orders: %w", err)
+	}
+
+	return scanConditionalOrders(rows)
+}
+
+// ActiveSymbols returns the symbols with at least one active conditional order
+func (r *ConditionalOrderRepository) ActiveSymbols(ctx context.Context) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT DISTINCT symbol FROM conditional_orders
+		WHERE status = 'active' AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY symbol ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conditional order symbols: %w", err)
+	}
+	defer rows.Close()
+
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, fmt.Errorf("failed to scan conditional order symbol: %w", err)
+		}
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols, nil
+}
+
+// Claim marks an active conditional order as triggered at price. Only one
+// caller can claim an order: it returns nil, without error, if the order was
+// already triggered, canceled or expired.
+func (r *ConditionalOrderRepository) Claim(ctx context.Context, id string, price float64) (*types.ConditionalOrder, error) {
+	o, err := scanConditionalOrder(r.db.QueryRow(ctx, fmt.Sprintf(`
+		UPDATE conditional_orders
+		SET status = 'triggered', triggered_at = NOW(), trigger_price = $2, updated_at = NOW()
+		WHERE id = $1 AND status = 'active' AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING %s
+	`, conditionalOrderColumns), id, price))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim conditional order: %w", err)
+	}
+
+	return o, nil
+}
+
+// Complete records the outcome of placing a triggered order: placed with its
+// order ID, or failed with the reason
+func (r *ConditionalOrderRepository) Complete(ctx context.Context, id, status string, orderID, reason *string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE conditional_orders
+		SET status = $2, order_id = $3, reason = $4, updated_at = NOW()
+		WHERE id = $1 AND status = 'triggered'
+	`, id, status, orderID, reason)
+
+	if err != nil {
+		return fmt.Errorf("failed to complete conditional order: %w", err)
+	}
+
+	return nil
+}
+
+// ListStuck retrieves conditional orders triggered before the given time
+// whose placement was never recorded
+func (r *ConditionalOrderRepository) ListStuck(ctx context.Context, before time.Time) ([]types.ConditionalOrder, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM conditional_orders
+		WHERE status = 'triggered' AND triggered_at < $1
+		ORDER BY triggered_at ASC
+	`, conditionalOrderColumns), before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stuck conditional orders: %w", err)
+	}
+
+	return scanConditionalOrders(rows)
+}
+
+// Cancel cancels an active conditional order. It returns nil, without error,
+// if the order is no longer active.
+func (r *ConditionalOrderRepository) Cancel(ctx context.Context, id string) (*types.ConditionalOrder, error) {
+	o, err := scanConditionalOrder(r.db.QueryRow(ctx, fmt.Sprintf(`
+		UPDATE conditional_orders SET status = 'canceled', updated_at = NOW()
+		WHERE id = $1 AND status = 'active'
+		RETURNING %s
+	`, conditionalOrderColumns), id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel conditional order: %w", err)
+	}
+
+	return o, nil
+}
+
+// CancelByUser cancels all of a user's active conditional orders and returns
+// how many were canceled
+func (r *ConditionalOrderRepository) CancelByUser(ctx context.Context, userID string) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE conditional_orders SET status = 'canceled', updated_at = NOW()
+		WHERE user_id = $1 AND status = 'active'
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel conditional orders: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// ExpireDue expires active conditional orders past their expiry and returns
+// how many were expired
+func (r *ConditionalOrderRepository) ExpireDue(ctx context.Context) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE conditional_orders SET status = 'expired', updated_at = NOW()
+		WHERE status = 'active' AND expires_at <= NOW()
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to expire conditional orders: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
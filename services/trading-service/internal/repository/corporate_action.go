@@ -0,0 +1,213 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// ErrCorporateActionNotFound is returned when a corporate action does not exist
+var ErrCorporateActionNotFound = errors.New("corporate action not found")
+
+// CorporateActionRepository handles corporate action database operations
+type CorporateActionRepository struct {
+	db DBTX
+}
+
+// NewCorporateActionRepository creates a new corporate action repository
+func NewCorporateActionRepository(db *pgxpool.Pool) *CorporateActionRepository {
+	return &CorporateActionRepository{db: db}
+}
+
+const corporateActionColumns = `id, provider_ref, type, symbol, new_symbol, rate, split_from, split_to,
+	ex_date, payable_date, status, holders, processed_at, created_at, updated_at`
+
+func scanCorporateAction(row pgx.Row) (*types.CorporateAction, error) {
+	var a types.CorporateAction
+	err := row.Scan(
+		&a.ID, &a.ProviderRef, &a.Type, &a.Symbol, &a.NewSymbol, &a.Rate, &a.SplitFrom, &a.SplitTo,
+		&a.ExDate, &a.PayableDate, &a.Status, &a.Holders, &a.ProcessedAt, &a.CreatedAt, &a.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Create saves a corporate action announced by the provider. It reports
+// false without an error if the action was already ingested.
+func (r *CorporateActionRepository) Create(ctx context.Context, a *types.CorporateAction) (bool, error) {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO corporate_actions (provider_ref, type, symbol, new_symbol, rate, split_from, split_to,
+		                               ex_date, payable_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (provider_ref) DO NOTHING
+		RETURNING id, status, created_at, updated_at
+	`, a.ProviderRef, a.Type, a.Symbol, a.NewSymbol, a.Rate, a.SplitFrom, a.SplitTo,
+		a.ExDate, a.PayableDate,
+	).Scan(&a.ID, &a.Status, &a.CreatedAt, &a.UpdatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to create corporate action: %w", err)
+	}
+
+	return true, nil
+}
+
+// ListDue retrieves the actions with a step due on or before the given date:
+// pending actions past their ex date and entitled dividends past their
+// payable date. Actions are returned in ex date order so that actions on the
+// same symbol apply in sequence.
+func (r *CorporateActionRepository) ListDue(ctx context.Context, today time.Time, limit int) ([]types.CorporateAction, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM corporate_actions
+		WHERE (status = 'pending' AND ex_date <= $1)
+		   OR (status = 'entitled' AND payable_date <= $1)
+		ORDER BY ex_date ASC, created_at ASC
+		LIMIT $2
+	`, corporateActionColumns), today, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due corporate actions: %w", err)
+	}
+	defer rows.Close()
+
+	var actions []types.CorporateAction
+	for rows.Next() {
+		a, err := scanCorporateAction(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan corporate action: %w", err)
+		}
+		actions = append(actions, *a)
+	}
+
+	return actions, nil
+}
+
+// GetByIDForUpdate retrieves a corporate action by ID and locks the row
+// until the surrounding transaction ends
+func (r *CorporateActionRepository) GetByIDForUpdate(ctx context.Context, id string) (*types.CorporateAction, error) {
+	a, err := scanCorporateAction(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM corporate_actions WHERE id = $1 FOR UPDATE
+	`, corporateActionColumns), id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCorporateActionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get corporate action: %w", err)
+	}
+
+	return a, nil
+}
+
+// UpdateStatus records the step a corporate action has reached and how many
+// holdings it applied to
+func (r *CorporateActionRepository) UpdateStatus(ctx context.Context, id, status string, holders int) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE corporate_actions
+		SET status = $1, holders = $2, processed_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+	`, status, holders, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update corporate action status: %w", err)
+	}
+
+	return nil
+}
+
+// AddEntry records what a holder received from a corporate action
+func (r *CorporateActionRepository) AddEntry(ctx context.Context, e *types.CorporateActionEntry) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO corporate_action_entries (action_id, user_id, symbol, qty_before, qty_after,
+		                                      avg_cost_before, avg_cost_after, gross_amount,
+		                                      withholding_tax, net_amount, paid_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at
+	`, e.ActionID, e.UserID, e.Symbol, e.QtyBefore, e.QtyAfter, e.AvgCostBefore, e.AvgCostAfter,
+		e.GrossAmount, e.WithholdingTax, e.NetAmount, e.PaidAt,
+	).Scan(&e.ID, &e.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to add corporate action entry: %w", err)
+	}
+
+	return nil
+}
+
+const corporateActionEntryColumns = `e.id, e.action_id, e.user_id, a.type, e.symbol, a.new_symbol, a.ex_date,
+	e.qty_before, e.qty_after, e.avg_cost_before, e.avg_cost_after, e.gross_amount, e.withholding_tax,
+	e.net_amount, e.paid_at, e.created_at`
+
+func scanCorporateActionEntries(rows pgx.Rows) ([]types.CorporateActionEntry, error) {
+	defer rows.Close()
+
+	var entries []types.CorporateActionEntry
+	for rows.Next() {
+		var e types.CorporateActionEntry
+		err := rows.Scan(
+			&e.ID, &e.ActionID, &e.UserID, &e.Type, &e.Symbol, &e.NewSymbol, &e.ExDate,
+			&e.QtyBefore, &e.QtyAfter, &e.AvgCostBefore, &e.AvgCostAfter, &e.GrossAmount, &e.WithholdingTax,
+			&e.NetAmount, &e.PaidAt, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan corporate action entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// ListUnpaidForUpdate retrieves the dividend entitlements of an action not
+// yet paid and locks them until the surrounding transaction ends
+func (r *CorporateActionRepository) ListUnpaidForUpdate(ctx context.Context, actionID string) ([]types.CorporateActionEntry, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM corporate_action_entries e
+		JOIN corporate_actions a ON a.id = e.action_id
+		WHERE e.action_id = $1 AND e.paid_at IS NULL
+		ORDER BY e.user_id
+		FOR UPDATE OF e
+	`, corporateActionEntryColumns), actionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unpaid corporate action entries: %w", err)
+	}
+
+	return scanCorporateActionEntries(rows)
+}
+
+// MarkPaid records that a dividend entitlement was credited
+func (r *CorporateActionRepository) MarkPaid(ctx context.Context, entryID string) error {
+	_, err := r.db.Exec(ctx, `UPDATE corporate_action_entries SET paid_at = NOW() WHERE id = $1`, entryID)
+	if err != nil {
+		return fmt.Errorf("failed to mark corporate action entry paid: %w", err)
+	}
+
+	return nil
+}
+
+// ListByUser retrieves the corporate actions applied to a user's holdings,
+// most recent first
+func (r *CorporateActionRepository) ListByUser(ctx context.Context, userID string, limit int) ([]types.CorporateActionEntry, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM corporate_action_entries e
+		JOIN corporate_actions a ON a.id = e.action_id
+		WHERE e.user_id = $1
+		ORDER BY a.ex_date DESC, e.created_at DESC
+		LIMIT $2
+	`, corporateActionEntryColumns), userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list corporate action entries: %w", err)
+	}
+
+	return scanCorporateActionEntries(rows)
+}
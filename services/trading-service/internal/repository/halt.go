@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// HaltRepository handles the audit trail of trading halts
+type HaltRepository struct {
+	db DBTX
+}
+
+// NewHaltRepository creates a new halt repository
+func NewHaltRepository(db *pgxpool.Pool) *HaltRepository {
+	return &HaltRepository{db: db}
+}
+
+// AddEvent records a halt being set or cleared
+func (r *HaltRepository) AddEvent(ctx context.Context, e *types.TradingHaltEvent) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO trading_halt_events (action, scope, target, reason, actor)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, e.Action, e.Scope, e.Target, e.Reason, e.Actor).Scan(&e.ID, &e.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to add trading halt event: %w", err)
+	}
+
+	return nil
+}
+
+// ListEvents retrieves the most recent halt changes, newest first
+func (r *HaltRepository) ListEvents(ctx context.Context, limit int) ([]types.TradingHaltEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, action, scope, target, reason, actor, created_at
+		FROM trading_halt_events
+		ORDER BY created_at DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list trading halt events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []types.TradingHaltEvent
+	for rows.Next() {
+		var e types.TradingHaltEvent
+		if err := rows.Scan(&e.ID, &e.Action, &e.Scope, &e.Target, &e.Reason, &e.Actor, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan trading halt event: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	return events, nil
+}
@@ -11,7 +11,7 @@ import (
 
 // HoldingRepository handles holding database operations
 type HoldingRepository struct {
-	db *pgxpool.Pool
+	db DBTX
 }
 
 // NewHoldingRepository creates a new holding repository
@@ -22,15 +22,16 @@ func NewHoldingRepository(db *pgxpool.Pool) *HoldingRepository {
 // Upsert creates or updates a holding (after order fill)
 func (r *HoldingRepository) Upsert(ctx context.Context, userID, symbol string, qty, avgPrice float64) error {
 	_, err := r.db.Exec(ctx, `
-		INSERT INTO holdings (user_id, symbol, quantity, average_cost)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO holdings (user_id, symbol, quantity, avg_cost_basis, total_cost_basis)
+		VALUES ($1, $2, $3, $4, $3 * $4)
 		ON CONFLICT (user_id, symbol) DO UPDATE SET
 			quantity = holdings.quantity + EXCLUDED.quantity,
-			average_cost = CASE
+			avg_cost_basis = CASE
 				WHEN holdings.quantity + EXCLUDED.quantity = 0 THEN 0
-				ELSE (holdings.quantity * holdings.average_cost + EXCLUDED.quantity * EXCLUDED.average_cost)
+				ELSE (holdings.total_cost_basis + EXCLUDED.total_cost_basis)
 				     / (holdings.quantity + EXCLUDED.quantity)
 			END,
+			total_cost_basis = holdings.total_cost_basis + EXCLUDED.total_cost_basis,
 			updated_at = NOW()
 	`, userID, symbol, qty, avgPrice)
 
@@ -45,7 +46,7 @@ func (r *HoldingRepository) Upsert(ctx context.Context, userID, symbol string, q
 func (r *HoldingRepository) GetByUserAndSymbol(ctx context.Context, userID, symbol string) (*types.Holding, error) {
 	var holding types.Holding
 	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, symbol, quantity, average_cost, created_at, updated_at
+		SELECT id, user_id, symbol, quantity, avg_cost_basis, created_at, updated_at
 		FROM holdings WHERE user_id = $1 AND symbol = $2
 	`, userID, symbol).Scan(
 		&holding.ID, &holding.UserID, &holding.Symbol, &holding.Qty,
@@ -62,7 +63,7 @@ func (r *HoldingRepository) GetByUserAndSymbol(ctx context.Context, userID, symb
 // ListByUser retrieves all holdings for a user
 func (r *HoldingRepository) ListByUser(ctx context.Context, userID string) ([]types.Holding, error) {
 	rows, err := r.db.Query(ctx, `
-		SELECT id, user_id, symbol, quantity, average_cost, created_at, updated_at
+		SELECT id, user_id, symbol, quantity, avg_cost_basis, created_at, updated_at
 		FROM holdings WHERE user_id = $1 AND quantity > 0
 		ORDER BY symbol ASC
 	`, userID)
@@ -87,11 +88,97 @@ func (r *HoldingRepository) ListByUser(ctx context.Context, userID string) ([]ty
 	return holdings, nil
 }
 
+// ListBySymbolForUpdate retrieves every open holding in a symbol and locks
+// the rows until the surrounding transaction ends
+func (r *HoldingRepository) ListBySymbolForUpdate(ctx context.Context, symbol string) ([]types.Holding, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, symbol, quantity, avg_cost_basis, created_at, updated_at
+		FROM holdings WHERE symbol = $1 AND quantity > 0
+		ORDER BY user_id
+		FOR UPDATE
+	`, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list holdings by symbol: %w", err)
+	}
+	defer rows.Close()
+
+	var holdings []types.Holding
+	for rows.Next() {
+		var holding types.Holding
+		err := rows.Scan(
+			&holding.ID, &holding.UserID, &holding.Symbol, &holding.Qty,
+			&holding.AvgEntryPrice, &holding.CreatedAt, &holding.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan holding: %w", err)
+		}
+		holdings = append(holdings, holding)
+	}
+
+	return holdings, nil
+}
+
+// SetPosition replaces the quantity and average cost of a holding, keeping
+// its total cost (after a stock split)
+func (r *HoldingRepository) SetPosition(ctx context.Context, id string, qty, avgCost float64) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE holdings SET quantity = $1, avg_cost_basis = $2, updated_at = NOW()
+		WHERE id = $3
+	`, qty, avgCost, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to set holding position: %w", err)
+	}
+
+	return nil
+}
+
+// CorrectQty sets the quantity of a holding, keeping its average cost (after
+// a reconciliation against the broker)
+func (r *HoldingRepository) CorrectQty(ctx context.Context, id string, qty float64) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE holdings
+		SET quantity = $1, total_cost_basis = avg_cost_basis * $1, updated_at = NOW()
+		WHERE id = $2
+	`, qty, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to correct holding qty: %w", err)
+	}
+
+	return nil
+}
+
+// TotalsBySymbol sums the shares users hold in each symbol
+func (r *HoldingRepository) TotalsBySymbol(ctx context.Context) ([]types.HoldingTotal, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT symbol, SUM(quantity), COUNT(*)
+		FROM holdings WHERE quantity > 0
+		GROUP BY symbol
+		ORDER BY symbol ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to total holdings: %w", err)
+	}
+	defer rows.Close()
+
+	var totals []types.HoldingTotal
+	for rows.Next() {
+		var t types.HoldingTotal
+		if err := rows.Scan(&t.Symbol, &t.Qty, &t.Holders); err != nil {
+			return nil, fmt.Errorf("failed to scan holding total: %w", err)
+		}
+		totals = append(totals, t)
+	}
+
+	return totals, nil
+}
+
 // ReduceQty reduces the quantity of a holding (for sell orders)
 func (r *HoldingRepository) ReduceQty(ctx context.Context, userID, symbol string, qty float64) error {
 	_, err := r.db.Exec(ctx, `
 		UPDATE holdings
-		SET quantity = quantity - $1, updated_at = NOW()
+		SET quantity = quantity - $1, total_cost_basis = avg_cost_basis * (quantity - $1), updated_at = NOW()
 		WHERE user_id = $2 AND symbol = $3
 	`, qty, userID, symbol)
 
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// LedgerRepository records wallet movements in the transactions table
+type LedgerRepository struct {
+	db DBTX
+}
+
+// NewLedgerRepository creates a new ledger repository
+func NewLedgerRepository(db *pgxpool.Pool) *LedgerRepository {
+	return &LedgerRepository{db: db}
+}
+
+// Add records a completed ledger entry
+func (r *LedgerRepository) Add(ctx context.Context, entry *types.LedgerEntry) error {
+	metadata, err := json.Marshal(entry.Metadata)
+	if err != nil {
+		return fmt.Errorf("failed to marshal ledger metadata: %w", err)
+	}
+
+	err = r.db.QueryRow(ctx, `
+		INSERT INTO transactions (user_id, wallet_id, type, status, amount, fee, currency, provider,
+		                          provider_ref, description, metadata, completed_at)
+		VALUES ($1, $2, $3, 'completed', $4, $5, $6, 'internal', $7, $8, $9, NOW())
+		RETURNING id
+	`, entry.UserID, entry.WalletID, entry.Type, entry.Amount, entry.Fee, entry.Currency,
+		entry.Reference, entry.Description, metadata,
+	).Scan(&entry.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to add ledger entry: %w", err)
+	}
+
+	return nil
+}
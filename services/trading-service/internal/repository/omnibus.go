@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// ErrOmnibusOrderNotFound is returned when an omnibus order does not exist
+var ErrOmnibusOrderNotFound = errors.New("omnibus order not found")
+
+// OmnibusRepository handles omnibus order and allocation database operations
+type OmnibusRepository struct {
+	db DBTX
+}
+
+// NewOmnibusRepository creates a new omnibus order repository
+func NewOmnibusRepository(db *pgxpool.Pool) *OmnibusRepository {
+	return &OmnibusRepository{db: db}
+}
+
+const omnibusColumns = `id, symbol, notional, order_count, alpaca_order_id, client_order_id, status,
+	filled_qty, filled_avg_price, settled_at, created_at, updated_at`
+
+func scanOmnibus(row pgx.Row) (*types.OmnibusOrder, error) {
+	var o types.OmnibusOrder
+	err := row.Scan(
+		&o.ID, &o.Symbol, &o.Notional, &o.OrderCount, &o.AlpacaOrderID, &o.ClientOrderID, &o.Status,
+		&o.FilledQty, &o.FilledAvgPrice, &o.SettledAt, &o.CreatedAt, &o.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// Create creates a new omnibus order
+func (r *OmnibusRepository) Create(ctx context.Context, o *types.OmnibusOrder) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO omnibus_orders (symbol, notional, order_count, client_order_id, status)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, updated_at
+	`, o.Symbol, o.Notional, o.OrderCount, o.ClientOrderID, o.Status,
+	).Scan(&o.ID, &o.CreatedAt, &o.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create omnibus order: %w", err)
+	}
+
+	return nil
+}
+
+// GetByAlpacaOrderID retrieves an omnibus order by Alpaca order ID
+func (r *OmnibusRepository) GetByAlpacaOrderID(ctx context.Context, alpacaOrderID string) (*types.OmnibusOrder, error) {
+	o, err := scanOmnibus(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM omnibus_orders WHERE alpaca_order_id = $1
+	`, omnibusColumns), alpacaOrderID))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOmnibusOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get omnibus order by alpaca ID: %w", err)
+	}
+
+	return o, nil
+}
+
+// GetByIDForUpdate retrieves an omnibus order by ID and locks the row until
+// the surrounding transaction ends
+func (r *OmnibusRepository) GetByIDForUpdate(ctx context.Context, id string) (*types.OmnibusOrder, error) {
+	o, err := scanOmnibus(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM omnibus_orders WHERE id = $1 FOR UPDATE
+	`, omnibusColumns), id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrOmnibusOrderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get omnibus order: %w", err)
+	}
+
+	return o, nil
+}
+
+// ListOpen retrieves omnibus orders not yet settled that have not been
+// updated since the given time, oldest first
+func (r *OmnibusRepository) ListOpen(ctx context.Context, before time.Time, limit int) ([]types.OmnibusOrder, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM omnibus_orders
+		WHERE status IN ('pending', 'submitted') AND updated_at <= $1
+		ORDER BY updated_at ASC
+		LIMIT $2
+	`, omnibusColumns), before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list open omnibus orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []types.OmnibusOrder
+	for rows.Next() {
+		o, err := scanOmnibus(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan omnibus order: %w", err)
+		}
+		orders = append(orders, *o)
+	}
+
+	return orders, nil
+}
+
+// MarkSubmitted records the Alpaca order placed for an omnibus order
+func (r *OmnibusRepository) MarkSubmitted(ctx context.Context, id, alpacaOrderID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE omnibus_orders SET alpaca_order_id = $1, status = 'submitted', updated_at = NOW()
+		WHERE id = $2 AND status = 'pending'
+	`, alpacaOrderID, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark omnibus order submitted: %w", err)
+	}
+
+	return nil
+}
+
+// MarkChecked records that an open omnibus order was checked against the
+// broker, so it is not checked again until it goes stale
+func (r *OmnibusRepository) MarkChecked(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `UPDATE omnibus_orders SET updated_at = NOW() WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark omnibus order checked: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateSettled saves the final status and fill of an omnibus order
+func (r *OmnibusRepository) UpdateSettled(ctx context.Context, o *types.OmnibusOrder) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE omnibus_orders
+		SET status = $1, filled_qty = $2, filled_avg_price = $3, settled_at = NOW(), updated_at = NOW()
+		WHERE id = $4
+	`, o.Status, o.FilledQty, o.FilledAvgPrice, o.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update settled omnibus order: %w", err)
+	}
+
+	return nil
+}
+
+// AddAllocation records the shares allocated to one order of an omnibus fill
+func (r *OmnibusRepository) AddAllocation(ctx context.Context, a *types.OmnibusAllocation) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO omnibus_allocations (omnibus_order_id, order_id, user_id, amount, weight, exact_qty,
+		                                 allocated_qty, rounding_qty, price, value, refunded)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at
+	`, a.OmnibusOrderID, a.OrderID, a.UserID, a.Amount, a.Weight, a.ExactQty,
+		a.AllocatedQty, a.RoundingQty, a.Price, a.Value, a.Refunded,
+	).Scan(&a.ID, &a.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to add omnibus allocation: %w", err)
+	}
+
+	return nil
+}
+
+// GetAllocationByOrder retrieves the allocation made to an order. It returns
+// nil without an error if the order has not been allocated.
+func (r *OmnibusRepository) GetAllocationByOrder(ctx context.Context, orderID string) (*types.OmnibusAllocation, error) {
+	var a types.OmnibusAllocation
+	err := r.db.QueryRow(ctx, `
+		SELECT id, omnibus_order_id, order_id, user_id, amount, weight, exact_qty, allocated_qty,
+		       rounding_qty, price, value, refunded, created_at
+		FROM omnibus_allocations WHERE order_id = $1
+	`, orderID).Scan(
+		&a.ID, &a.OmnibusOrderID, &a.OrderID, &a.UserID, &a.Amount, &a.Weight, &a.ExactQty,
+		&a.AllocatedQty, &a.RoundingQty, &a.Price, &a.Value, &a.Refunded, &a.CreatedAt,
+	)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get omnibus allocation: %w", err)
+	}
+
+	return &a, nil
+}
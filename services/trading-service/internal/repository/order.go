@@ -2,8 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
@@ -11,7 +15,7 @@ import (
 
 // OrderRepository handles order database operations
 type OrderRepository struct {
-	db *pgxpool.Pool
+	db DBTX
 }
 
 // NewOrderRepository creates a new order repository
@@ -19,17 +23,60 @@ func NewOrderRepository(db *pgxpool.Pool) *OrderRepository {
 	return &OrderRepository{db: db}
 }
 
-// Create creates a new order
+// ErrDuplicateOrder is returned by Create when an order with the same client
+// order ID or idempotency key already exists
+var ErrDuplicateOrder = errors.New("order already exists")
+
+// orderColumns is the list of columns to select for an order.
+const orderColumns = `id, user_id, alpaca_order_id, COALESCE(client_order_id, ''), idempotency_key,
+	symbol, side, type, amount, qty, limit_price, stop_price, max_slippage_bps, locked_amount, time_in_force, expires_at, replaces_alpaca_order_id,
+	order_class, parent_order_id, leg, basket_id, omnibus_order_id, filled_qty, filled_avg_price, COALESCE(commission, 0), lot_method, lot_ids, status, source, failed_reason, filled_at, canceled_at,
+	cancel_requested_at, pending_replace, created_at, updated_at`
+
+func scanOrder(row pgx.Row) (*types.Order, error) {
+	var order types.Order
+	err := row.Scan(
+		&order.ID, &order.UserID, &order.AlpacaOrderID, &order.ClientOrderID, &order.IdempotencyKey,
+		&order.Symbol, &order.Side, &order.Type, &order.Amount, &order.Qty, &order.LimitPrice, &order.StopPrice, &order.MaxSlippageBps,
+		&order.LockedAmount, &order.TimeInForce, &order.ExpiresAt, &order.ReplacesAlpacaOrderID,
+		&order.OrderClass, &order.ParentOrderID, &order.Leg, &order.BasketID, &order.OmnibusOrderID, &order.FilledQty, &order.FilledAvgPrice, &order.Commission, &order.LotMethod, &order.LotIDs, &order.Status, &order.Source, &order.FailedReason,
+		&order.FilledAt, &order.CanceledAt, &order.CancelRequestedAt, &order.PendingReplace, &order.CreatedAt, &order.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// Create creates a new order and records its initial status as the first
+// entry in its history
 func (r *OrderRepository) Create(ctx context.Context, order *types.Order) error {
 	err := r.db.QueryRow(ctx, `
-		INSERT INTO orders (user_id, alpaca_order_id, symbol, side, type, amount, qty, status, source)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		RETURNING id, created_at, updated_at
-	`, order.UserID, order.AlpacaOrderID, order.Symbol, order.Side, order.Type,
-		order.Amount, order.Qty, order.Status, order.Source,
-	).Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
+		WITH created AS (
+			INSERT INTO orders (user_id, alpaca_order_id, client_order_id, idempotency_key, symbol,
+			                    side, type, amount, qty, limit_price, stop_price, max_slippage_bps, locked_amount,
+			                    time_in_force, expires_at, status, source, order_class, parent_order_id, leg,
+			                    basket_id, failed_reason, lot_method, lot_ids)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19,
+			        $20, $21, $22, COALESCE(NULLIF($23, ''), 'fifo'), $24)
+			RETURNING id, status, failed_reason, lot_method, created_at, updated_at
+		), event AS (
+			INSERT INTO order_events (order_id, to_status, source, reason)
+			SELECT id, status, 'api', failed_reason FROM created
+		)
+		SELECT id, lot_method, created_at, updated_at FROM created
+	`, order.UserID, order.AlpacaOrderID, order.ClientOrderID, order.IdempotencyKey, order.Symbol,
+		order.Side, order.Type, order.Amount, order.Qty, order.LimitPrice, order.StopPrice, order.MaxSlippageBps,
+		order.LockedAmount, order.TimeInForce, order.ExpiresAt, order.Status, order.Source,
+		order.OrderClass, order.ParentOrderID, order.Leg, order.BasketID, order.FailedReason,
+		order.LotMethod, order.LotIDs,
+	).Scan(&order.ID, &order.LotMethod, &order.CreatedAt, &order.UpdatedAt)
 
 	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrDuplicateOrder
+		}
 		return fmt.Errorf("failed to create order: %w", err)
 	}
 
@@ -38,88 +85,391 @@ func (r *OrderRepository) Create(ctx context.Context, order *types.Order) error
 
 // GetByID retrieves an order by ID
 func (r *OrderRepository) GetByID(ctx context.Context, orderID string) (*types.Order, error) {
-	var order types.Order
-	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, alpaca_order_id, symbol, side, type, amount, qty,
-		       filled_qty, filled_avg_price, status, source, failed_reason,
-		       filled_at, canceled_at, created_at, updated_at
-		FROM orders WHERE id = $1
-	`, orderID).Scan(
-		&order.ID, &order.UserID, &order.AlpacaOrderID, &order.Symbol, &order.Side,
-		&order.Type, &order.Amount, &order.Qty, &order.FilledQty, &order.FilledAvgPrice,
-		&order.Status, &order.Source, &order.FailedReason,
-		&order.FilledAt, &order.CanceledAt, &order.CreatedAt, &order.UpdatedAt,
-	)
+	order, err := scanOrder(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders WHERE id = $1
+	`, orderColumns), orderID))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get order: %w", err)
 	}
 
-	return &order, nil
+	return order, nil
 }
 
 // GetByAlpacaOrderID retrieves an order by Alpaca order ID
 func (r *OrderRepository) GetByAlpacaOrderID(ctx context.Context, alpacaOrderID string) (*types.Order, error) {
-	var order types.Order
-	err := r.db.QueryRow(ctx, `
-		SELECT id, user_id, alpaca_order_id, symbol, side, type, amount, qty,
-		       filled_qty, filled_avg_price, status, source, failed_reason,
-		       filled_at, canceled_at, created_at, updated_at
-		FROM orders WHERE alpaca_order_id = $1
-	`, alpacaOrderID).Scan(
-		&order.ID, &order.UserID, &order.AlpacaOrderID, &order.Symbol, &order.Side,
-		&order.Type, &order.Amount, &order.Qty, &order.FilledQty, &order.FilledAvgPrice,
-		&order.Status, &order.Source, &order.FailedReason,
-		&order.FilledAt, &order.CanceledAt, &order.CreatedAt, &order.UpdatedAt,
-	)
+	order, err := scanOrder(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders WHERE alpaca_order_id = $1
+	`, orderColumns), alpacaOrderID))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get order by alpaca ID: %w", err)
 	}
 
-	return &order, nil
+	return order, nil
 }
 
-// ListByUser retrieves orders for a user
-func (r *OrderRepository) ListByUser(ctx context.Context, userID string, status string, limit int) ([]types.Order, error) {
-	query := `
-		SELECT id, user_id, alpaca_order_id, symbol, side, type, amount, qty,
-		       filled_qty, filled_avg_price, status, source, failed_reason,
-		       filled_at, canceled_at, created_at, updated_at
-		FROM orders WHERE user_id = $1
-	`
-	args := []any{userID}
+// GetByAlpacaOrderIDForUpdate retrieves an order by Alpaca order ID and locks
+// the row until the surrounding transaction ends
+func (r *OrderRepository) GetByAlpacaOrderIDForUpdate(ctx context.Context, alpacaOrderID string) (*types.Order, error) {
+	order, err := scanOrder(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders WHERE alpaca_order_id = $1 FOR UPDATE
+	`, orderColumns), alpacaOrderID))
 
-	if status != "" {
-		query += " AND status = $2"
-		args = append(args, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order by alpaca ID: %w", err)
 	}
 
-	query += " ORDER BY created_at DESC"
+	return order, nil
+}
+
+// GetByIdempotencyKey retrieves a user's order by the Idempotency-Key it was
+// placed with. It returns nil without an error if no such order exists.
+func (r *OrderRepository) GetByIdempotencyKey(ctx context.Context, userID, key string) (*types.Order, error) {
+	order, err := scanOrder(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders WHERE user_id = $1 AND idempotency_key = $2
+	`, orderColumns), userID, key))
 
-	if limit > 0 {
-		query += fmt.Sprintf(" LIMIT %d", limit)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order by idempotency key: %w", err)
 	}
 
-	rows, err := r.db.Query(ctx, query, args...)
+	return order, nil
+}
+
+// ListLegs retrieves the exit legs of an advanced order
+func (r *OrderRepository) ListLegs(ctx context.Context, parentOrderID string) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders WHERE parent_order_id = $1 ORDER BY leg ASC
+	`, orderColumns), parentOrderID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list orders: %w", err)
+		return nil, fmt.Errorf("failed to list order legs: %w", err)
+	}
+	defer rows.Close()
+
+	var legs []types.Order
+	for rows.Next() {
+		leg, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order leg: %w", err)
+		}
+		legs = append(legs, *leg)
+	}
+
+	return legs, nil
+}
+
+// ListByBasket retrieves the orders placed for a basket
+func (r *OrderRepository) ListByBasket(ctx context.Context, basketID string) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders WHERE basket_id = $1 ORDER BY created_at ASC
+	`, orderColumns), basketID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list basket orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []types.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, *order)
+	}
+
+	return orders, nil
+}
+
+// ListByOmnibusForUpdate retrieves the orders aggregated into an omnibus
+// order, oldest first, and locks them until the surrounding transaction ends
+func (r *OrderRepository) ListByOmnibusForUpdate(ctx context.Context, omnibusOrderID string) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders WHERE omnibus_order_id = $1 ORDER BY created_at ASC, id ASC FOR UPDATE
+	`, orderColumns), omnibusOrderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list omnibus orders: %w", err)
 	}
 	defer rows.Close()
 
 	var orders []types.Order
 	for rows.Next() {
-		var order types.Order
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, *order)
+	}
+
+	return orders, nil
+}
+
+// ClaimQueued locks orders queued for aggregation, grouped by symbol and
+// oldest first. Rows locked by another aggregator are skipped.
+func (r *OrderRepository) ClaimQueued(ctx context.Context, limit int) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders
+		WHERE status = 'queued'
+		ORDER BY symbol ASC, created_at ASC, id ASC
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, orderColumns), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim queued orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []types.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, *order)
+	}
+
+	return orders, nil
+}
+
+// AssignOmnibus moves queued orders into an omnibus order
+func (r *OrderRepository) AssignOmnibus(ctx context.Context, omnibusOrderID string, orderIDs []string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE orders SET omnibus_order_id = $1, status = 'pending', updated_at = NOW()
+		WHERE id = ANY($2) AND status = 'queued'
+	`, omnibusOrderID, orderIDs)
+
+	if err != nil {
+		return fmt.Errorf("failed to assign omnibus order: %w", err)
+	}
+
+	return nil
+}
+
+// CancelQueued cancels an order still waiting to be aggregated. It returns
+// false if the order has already left the queue.
+func (r *OrderRepository) CancelQueued(ctx context.Context, orderID string) (bool, error) {
+	result, err := r.db.Exec(ctx, `
+		UPDATE orders SET status = 'canceled', locked_amount = 0, canceled_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND status = 'queued'
+	`, orderID)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel queued order: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// UpdateAllocated closes an aggregated order with the shares allocated to it
+// from its omnibus fill. Its lock has been spent or released in full.
+func (r *OrderRepository) UpdateAllocated(ctx context.Context, orderID string, filledQty, filledAvgPrice float64, status string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE orders
+		SET filled_qty = $1, filled_avg_price = $2, locked_amount = 0, status = $3,
+		    filled_at = CASE WHEN $1 > 0 THEN NOW() END,
+		    canceled_at = CASE WHEN $3 = 'canceled' THEN NOW() END,
+		    updated_at = NOW()
+		WHERE id = $4
+	`, filledQty, filledAvgPrice, status, orderID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update allocated order: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateFailedByID marks an order that never reached the broker as failed
+func (r *OrderRepository) UpdateFailedByID(ctx context.Context, orderID, reason string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE orders SET status = 'failed', failed_reason = $1, locked_amount = 0, updated_at = NOW()
+		WHERE id = $2
+	`, reason, orderID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update order failed: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseLegs makes the held exit legs of an order live once it has filled
+// and returns the legs released, as they were before
+func (r *OrderRepository) ReleaseLegs(ctx context.Context, parentOrderID string) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		WITH held AS (
+			SELECT %s FROM orders WHERE parent_order_id = $1 AND status = 'held' FOR UPDATE
+		), released AS (
+			UPDATE orders SET status = 'new', updated_at = NOW()
+			WHERE id IN (SELECT id FROM held)
+		)
+		SELECT * FROM held
+	`, orderColumns), parentOrderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release order legs: %w", err)
+	}
+	defer rows.Close()
+
+	var legs []types.Order
+	for rows.Next() {
+		leg, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order leg: %w", err)
+		}
+		legs = append(legs, *leg)
+	}
+
+	return legs, nil
+}
+
+// AddEvent records a status transition in an order's history
+func (r *OrderRepository) AddEvent(ctx context.Context, event *types.OrderEvent) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO order_events (order_id, from_status, to_status, source, reason, filled_qty)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, event.OrderID, event.FromStatus, event.ToStatus, event.Source, event.Reason, event.FilledQty,
+	).Scan(&event.ID, &event.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to add order event: %w", err)
+	}
+
+	return nil
+}
+
+// ListEvents retrieves an order's status history, oldest first
+func (r *OrderRepository) ListEvents(ctx context.Context, orderID string) ([]types.OrderEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, order_id, from_status, to_status, source, reason, filled_qty, created_at
+		FROM order_events
+		WHERE order_id = $1
+		ORDER BY created_at ASC, id ASC
+	`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list order events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []types.OrderEvent
+	for rows.Next() {
+		var event types.OrderEvent
 		err := rows.Scan(
-			&order.ID, &order.UserID, &order.AlpacaOrderID, &order.Symbol, &order.Side,
-			&order.Type, &order.Amount, &order.Qty, &order.FilledQty, &order.FilledAvgPrice,
-			&order.Status, &order.Source, &order.FailedReason,
-			&order.FilledAt, &order.CanceledAt, &order.CreatedAt, &order.UpdatedAt,
+			&event.ID, &event.OrderID, &event.FromStatus, &event.ToStatus, &event.Source,
+			&event.Reason, &event.FilledQty, &event.CreatedAt,
 		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order event: %w", err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// OrderFilter selects orders from a user's history
+type OrderFilter struct {
+	UserID string
+	Status string
+	Symbol string
+	Side   string
+	Source string
+	From   *time.Time // Created at or after
+	To     *time.Time // Created before
+
+	// After continues the history from the last order of a previous page
+	After *OrderCursor
+	Limit int
+}
+
+// OrderCursor is the position of an order in a user's history, which is
+// ordered newest first by created_at and then id
+type OrderCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// ListByUser retrieves a user's orders matching the filter, newest first
+func (r *OrderRepository) ListByUser(ctx context.Context, f *OrderFilter) ([]types.Order, error) {
+	query := fmt.Sprintf(`
+		SELECT %s FROM orders WHERE user_id = $1
+	`, orderColumns)
+	args := []any{f.UserID}
+
+	where := func(cond string, arg any) {
+		args = append(args, arg)
+		query += fmt.Sprintf(" AND "+cond, len(args))
+	}
+	if f.Status != "" {
+		where("status = $%d", f.Status)
+	}
+	if f.Symbol != "" {
+		where("symbol = $%d", f.Symbol)
+	}
+	if f.Side != "" {
+		where("side = $%d", f.Side)
+	}
+	if f.Source != "" {
+		where("source = $%d", f.Source)
+	}
+	if f.From != nil {
+		where("created_at >= $%d", *f.From)
+	}
+	if f.To != nil {
+		where("created_at < $%d", *f.To)
+	}
+	if f.After != nil {
+		args = append(args, f.After.CreatedAt, f.After.ID)
+		query += fmt.Sprintf(" AND (created_at, id) < ($%d, $%d)", len(args)-1, len(args))
+	}
+
+	query += " ORDER BY created_at DESC, id DESC"
+
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []types.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, *order)
+	}
+
+	return orders, nil
+}
+
+// ListOpenByUser retrieves a user's orders that are not yet final, oldest
+// first
+func (r *OrderRepository) ListOpenByUser(ctx context.Context, userID string) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders
+		WHERE user_id = $1 AND status IN ('queued', 'pending', 'held', 'new', 'partial_fill')
+		ORDER BY created_at ASC
+	`, orderColumns), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list open orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []types.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan order: %w", err)
 		}
-		orders = append(orders, order)
+		orders = append(orders, *order)
 	}
 
 	return orders, nil
@@ -138,13 +488,49 @@ func (r *OrderRepository) UpdateStatus(ctx context.Context, orderID, status stri
 	return nil
 }
 
-// UpdateFill updates the order with fill information
-func (r *OrderRepository) UpdateFill(ctx context.Context, alpacaOrderID string, filledQty, filledAvgPrice float64, status string) error {
+// SetPendingReplace records an amendment about to be sent to Alpaca, or
+// clears it when pending is nil. Recording one counts as an update, so the
+// reconciler leaves the order alone while the replace is in flight.
+func (r *OrderRepository) SetPendingReplace(ctx context.Context, orderID string, pending *types.PendingReplace) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE orders
+		SET pending_replace = $1, reconciled_at = NULL, updated_at = NOW()
+		WHERE id = $2
+	`, pending, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to set pending replace: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateAmended saves the new terms and broker order ID after an order is
+// replaced, and clears the pending amendment
+func (r *OrderRepository) UpdateAmended(ctx context.Context, order *types.Order) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE orders
+		SET alpaca_order_id = $1, replaces_alpaca_order_id = $2, qty = $3, limit_price = $4,
+		    stop_price = $5, time_in_force = $6, expires_at = $7, locked_amount = $8,
+		    pending_replace = NULL, updated_at = NOW()
+		WHERE id = $9
+	`, order.AlpacaOrderID, order.ReplacesAlpacaOrderID, order.Qty, order.LimitPrice,
+		order.StopPrice, order.TimeInForce, order.ExpiresAt, order.LockedAmount, order.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update amended order: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateFill updates the order with fill information and the funds still
+// locked for the unfilled remainder
+func (r *OrderRepository) UpdateFill(ctx context.Context, alpacaOrderID string, filledQty, filledAvgPrice, lockedAmount float64, status string) error {
 	_, err := r.db.Exec(ctx, `
 		UPDATE orders
-		SET filled_qty = $1, filled_avg_price = $2, status = $3, filled_at = NOW(), updated_at = NOW()
-		WHERE alpaca_order_id = $4
-	`, filledQty, filledAvgPrice, status, alpacaOrderID)
+		SET filled_qty = $1, filled_avg_price = $2, locked_amount = $3, status = $4, filled_at = NOW(), updated_at = NOW()
+		WHERE alpaca_order_id = $5
+	`, filledQty, filledAvgPrice, lockedAmount, status, alpacaOrderID)
 
 	if err != nil {
 		return fmt.Errorf("failed to update order fill: %w", err)
@@ -153,6 +539,20 @@ func (r *OrderRepository) UpdateFill(ctx context.Context, alpacaOrderID string,
 	return nil
 }
 
+// AddCommission adds a fee charged on an order to its commission
+func (r *OrderRepository) AddCommission(ctx context.Context, orderID string, fee float64) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE orders SET commission = COALESCE(commission, 0) + $1, updated_at = NOW()
+		WHERE id = $2
+	`, fee, orderID)
+
+	if err != nil {
+		return fmt.Errorf("failed to add order commission: %w", err)
+	}
+
+	return nil
+}
+
 // UpdateCanceled marks the order as canceled
 func (r *OrderRepository) UpdateCanceled(ctx context.Context, alpacaOrderID string) error {
 	_, err := r.db.Exec(ctx, `
@@ -180,3 +580,118 @@ func (r *OrderRepository) UpdateFailed(ctx context.Context, alpacaOrderID, reaso
 
 	return nil
 }
+
+// ListExpired retrieves open orders whose expiry time has passed
+func (r *OrderRepository) ListExpired(ctx context.Context, limit int) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders
+		WHERE status IN ('pending', 'new', 'partial_fill')
+		  AND expires_at IS NOT NULL AND expires_at <= NOW()
+		ORDER BY expires_at ASC
+		LIMIT $1
+	`, orderColumns), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expired orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []types.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, *order)
+	}
+
+	return orders, nil
+}
+
+// ListStale retrieves open broker orders, including held exit legs, that
+// have not been updated or reconciled since the given time, oldest first
+func (r *OrderRepository) ListStale(ctx context.Context, before time.Time, limit int) ([]types.Order, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM orders
+		WHERE status IN ('pending', 'new', 'partial_fill', 'held')
+		  AND alpaca_order_id IS NOT NULL AND alpaca_order_id <> ''
+		  AND COALESCE(reconciled_at, updated_at) <= $1
+		ORDER BY COALESCE(reconciled_at, updated_at) ASC
+		LIMIT $2
+	`, orderColumns), before, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale orders: %w", err)
+	}
+	defer rows.Close()
+
+	var orders []types.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan order: %w", err)
+		}
+		orders = append(orders, *order)
+	}
+
+	return orders, nil
+}
+
+// MarkCancelRequested records that the user asked to cancel an order. The
+// first request is kept.
+func (r *OrderRepository) MarkCancelRequested(ctx context.Context, orderID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE orders SET cancel_requested_at = COALESCE(cancel_requested_at, NOW()) WHERE id = $1
+	`, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to mark cancel requested: %w", err)
+	}
+
+	return nil
+}
+
+// MarkReconciled records that an open order was checked against the broker
+func (r *OrderRepository) MarkReconciled(ctx context.Context, orderID string) error {
+	_, err := r.db.Exec(ctx, `UPDATE orders SET reconciled_at = NOW() WHERE id = $1`, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to mark order reconciled: %w", err)
+	}
+
+	return nil
+}
+
+// MarkExpired marks an open order as expired. It returns false if the order
+// was already moved to another state (e.g. filled or canceled by a webhook).
+func (r *OrderRepository) MarkExpired(ctx context.Context, orderID string) (bool, error) {
+	result, err := r.db.Exec(ctx, `
+		UPDATE orders SET status = 'expired', updated_at = NOW()
+		WHERE id = $1 AND status IN ('pending', 'new', 'partial_fill')
+	`, orderID)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to mark order expired: %w", err)
+	}
+
+	return result.RowsAffected() > 0, nil
+}
+
+// DailyNotional returns the USD value a user has traded since the given time:
+// the filled value of every order plus the outstanding value of open orders.
+// Open exit legs are left out; they close the position their entry opened.
+func (r *OrderRepository) DailyNotional(ctx context.Context, userID string, since time.Time) (float64, error) {
+	var total float64
+	err := r.db.QueryRow(ctx, `
+		SELECT COALESCE(SUM(
+			CASE WHEN status IN ('queued', 'pending', 'new', 'partial_fill') AND parent_order_id IS NULL
+				THEN GREATEST(amount, locked_amount, qty * COALESCE(limit_price, stop_price, filled_avg_price, 0), filled_qty * filled_avg_price)
+				ELSE filled_qty * filled_avg_price
+			END
+		), 0)
+		FROM orders
+		WHERE user_id = $1 AND created_at >= $2
+	`, userID, since).Scan(&total)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to get daily notional: %w", err)
+	}
+
+	return total, nil
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// OutboxRepository handles the transactional event outbox. Events are written
+// in the same transaction as the state change they describe and published to
+// Kafka by the outbox relay once committed.
+type OutboxRepository struct {
+	db DBTX
+}
+
+// NewOutboxRepository creates a new outbox repository
+func NewOutboxRepository(db *pgxpool.Pool) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// Add queues an event for publishing
+func (r *OutboxRepository) Add(ctx context.Context, topic string, event *events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox event: %w", err)
+	}
+
+	_, err = r.db.Exec(ctx, `
+		INSERT INTO outbox_events (id, topic, event)
+		VALUES ($1, $2, $3)
+	`, event.EventID, topic, data)
+
+	if err != nil {
+		return fmt.Errorf("failed to add outbox event: %w", err)
+	}
+
+	return nil
+}
+
+// ListPending locks and returns unpublished events, oldest first. Rows locked
+// by another relay are skipped, so it must be called inside a unit of work.
+func (r *OutboxRepository) ListPending(ctx context.Context, limit int) ([]types.OutboxEvent, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, topic, event, attempts, created_at
+		FROM outbox_events
+		WHERE published_at IS NULL
+		ORDER BY created_at ASC
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list outbox events: %w", err)
+	}
+	defer rows.Close()
+
+	var pending []types.OutboxEvent
+	for rows.Next() {
+		var e types.OutboxEvent
+		var data []byte
+		if err := rows.Scan(&e.ID, &e.Topic, &data, &e.Attempts, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox event: %w", err)
+		}
+		if err := json.Unmarshal(data, &e.Event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal outbox event %s: %w", e.ID, err)
+		}
+		pending = append(pending, e)
+	}
+
+	return pending, nil
+}
+
+// MarkPublished records that an event was delivered to Kafka
+func (r *OutboxRepository) MarkPublished(ctx context.Context, id string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE outbox_events SET published_at = NOW(), attempts = attempts + 1 WHERE id = $1
+	`, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event published: %w", err)
+	}
+
+	return nil
+}
+
+// MarkFailed records a failed publish attempt
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id string, publishErr error) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE outbox_events SET attempts = attempts + 1, last_error = $1 WHERE id = $2
+	`, publishErr.Error(), id)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// ErrPlanNotFound is returned when an investment plan does not exist
+var ErrPlanNotFound = errors.New("investment plan not found")
+
+// PlanRepository handles investment plan database operations
+type PlanRepository struct {
+	db DBTX
+}
+
+// NewPlanRepository creates a new investment plan repository
+func NewPlanRepository(db *pgxpool.Pool) *PlanRepository {
+	return &PlanRepository{db: db}
+}
+
+const planColumns = `id, user_id, symbol, amount, currency, frequency, status, next_run_at,
+	retry_at, attempts, last_run_at, created_at, updated_at`
+
+func scanPlan(row pgx.Row) (*types.InvestmentPlan, error) {
+	var plan types.InvestmentPlan
+	err := row.Scan(
+		&plan.ID, &plan.UserID, &plan.Symbol, &plan.Amount, &plan.Currency, &plan.Frequency,
+		&plan.Status, &plan.NextRunAt, &plan.RetryAt, &plan.Attempts, &plan.LastRunAt,
+		&plan.CreatedAt, &plan.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+// Create creates a new investment plan
+func (r *PlanRepository) Create(ctx context.Context, plan *types.InvestmentPlan) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO investment_plans (user_id, symbol, amount, currency, frequency, status, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`, plan.UserID, plan.Symbol, plan.Amount, plan.Currency, plan.Frequency, plan.Status, plan.NextRunAt,
+	).Scan(&plan.ID, &plan.CreatedAt, &plan.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create investment plan: %w", err)
+	}
+
+	return nil
+}
+
+// GetByID retrieves an investment plan by ID
+func (r *PlanRepository) GetByID(ctx context.Context, planID string) (*types.InvestmentPlan, error) {
+	plan, err := scanPlan(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM investment_plans WHERE id = $1
+	`, planColumns), planID))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPlanNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get investment plan: %w", err)
+	}
+
+	return plan, nil
+}
+
+// ListByUser retrieves a user's investment plans, excluding canceled ones
+func (r *PlanRepository) ListByUser(ctx context.Context, userID string) ([]types.InvestmentPlan, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM investment_plans
+		WHERE user_id = $1 AND status <> 'canceled'
+		ORDER BY created_at DESC
+	`, planColumns), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list investment plans: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []types.InvestmentPlan
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan investment plan: %w", err)
+		}
+		plans = append(plans, *plan)
+	}
+
+	return plans, nil
+}
+
+// Update saves a plan's terms, status and next run
+func (r *PlanRepository) Update(ctx context.Context, plan *types.InvestmentPlan) error {
+	err := r.db.QueryRow(ctx, `
+		UPDATE investment_plans
+		SET amount = $1, frequency = $2, status = $3, next_run_at = $4, updated_at = NOW()
+		WHERE id = $5
+		RETURNING updated_at
+	`, plan.Amount, plan.Frequency, plan.Status, plan.NextRunAt, plan.ID,
+	).Scan(&plan.UpdatedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrPlanNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update investment plan: %w", err)
+	}
+
+	return nil
+}
+
+// CancelByUser cancels all of a user's plans that are not yet canceled and
+// returns how many were canceled
+func (r *PlanRepository) CancelByUser(ctx context.Context, userID string) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+		UPDATE investment_plans SET status = 'canceled', updated_at = NOW()
+		WHERE user_id = $1 AND status <> 'canceled'
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel investment plans: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// UpdateSchedule saves the outcome of a run: the next scheduled run, any
+// pending retry and the attempt count. The plan's terms and status are left
+// alone so changes the user made during the run are kept.
+func (r *PlanRepository) UpdateSchedule(ctx context.Context, plan *types.InvestmentPlan) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE investment_plans
+		SET next_run_at = $1, retry_at = $2, attempts = $3, last_run_at = $4, updated_at = NOW()
+		WHERE id = $5
+	`, plan.NextRunAt, plan.RetryAt, plan.Attempts, plan.LastRunAt, plan.ID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update investment plan schedule: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimDue returns active plans whose run or retry is due and leases them
+// until leaseUntil, so a plan being run is not picked up again by another
+// scheduler tick or replica
+func (r *PlanRepository) ClaimDue(ctx context.Context, leaseUntil time.Time, limit int) ([]types.InvestmentPlan, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		UPDATE investment_plans SET retry_at = $1
+		WHERE id IN (
+			SELECT id FROM investment_plans
+			WHERE status = 'active' AND COALESCE(retry_at, next_run_at) <= NOW()
+			ORDER BY COALESCE(retry_at, next_run_at) ASC
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING %s
+	`, planColumns), leaseUntil, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim due investment plans: %w", err)
+	}
+	defer rows.Close()
+
+	var plans []types.InvestmentPlan
+	for rows.Next() {
+		plan, err := scanPlan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan investment plan: %w", err)
+		}
+		plans = append(plans, *plan)
+	}
+
+	return plans, nil
+}
+
+// AddRun records an attempt to run a plan
+func (r *PlanRepository) AddRun(ctx context.Context, run *types.PlanRun) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO investment_plan_runs (plan_id, scheduled_for, attempt, status, order_id, reason)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, run.PlanID, run.ScheduledFor, run.Attempt, run.Status, run.OrderID, run.Reason,
+	).Scan(&run.ID, &run.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to add investment plan run: %w", err)
+	}
+
+	return nil
+}
+
+// ListRuns retrieves a plan's most recent runs
+func (r *PlanRepository) ListRuns(ctx context.Context, planID string, limit int) ([]types.PlanRun, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, plan_id, scheduled_for, attempt, status, order_id, reason, created_at
+		FROM investment_plan_runs
+		WHERE plan_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, planID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list investment plan runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []types.PlanRun
+	for rows.Next() {
+		var run types.PlanRun
+		err := rows.Scan(
+			&run.ID, &run.PlanID, &run.ScheduledFor, &run.Attempt, &run.Status,
+			&run.OrderID, &run.Reason, &run.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan investment plan run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,215 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+var (
+	// ErrReconciliationNotFound is returned when a position reconciliation
+	// does not exist
+	ErrReconciliationNotFound = errors.New("position reconciliation not found")
+
+	// ErrDriftNotFound is returned when a position drift does not exist
+	ErrDriftNotFound = errors.New("position drift not found")
+)
+
+// ReconciliationRepository handles position reconciliation and drift report
+// database operations
+type ReconciliationRepository struct {
+	db DBTX
+}
+
+// NewReconciliationRepository creates a new reconciliation repository
+func NewReconciliationRepository(db *pgxpool.Pool) *ReconciliationRepository {
+	return &ReconciliationRepository{db: db}
+}
+
+const reconciliationColumns = `id, trigger, run_date, status, symbols_checked, drift_count, error,
+	started_by, started_at, completed_at`
+
+const driftColumns = `id, reconciliation_id, symbol, local_qty, broker_qty, drift, holders, status,
+	corrected_by, corrected_at, correction_note, created_at`
+
+func scanReconciliation(row pgx.Row) (*types.PositionReconciliation, error) {
+	var r types.PositionReconciliation
+	err := row.Scan(
+		&r.ID, &r.Trigger, &r.RunDate, &r.Status, &r.SymbolsChecked, &r.DriftCount, &r.Error,
+		&r.StartedBy, &r.StartedAt, &r.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func scanDrift(row pgx.Row) (*types.PositionDrift, error) {
+	var d types.PositionDrift
+	err := row.Scan(
+		&d.ID, &d.ReconciliationID, &d.Symbol, &d.LocalQty, &d.BrokerQty, &d.Drift, &d.Holders, &d.Status,
+		&d.CorrectedBy, &d.CorrectedAt, &d.CorrectionNote, &d.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Start records a reconciliation as running. A scheduled run is only started
+// once per run date, unless that run failed: it returns false if another run
+// already took the date.
+func (r *ReconciliationRepository) Start(ctx context.Context, rec *types.PositionReconciliation) (bool, error) {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO position_reconciliations (trigger, run_date, started_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (run_date) WHERE trigger = 'scheduled' DO UPDATE
+		SET status = 'running', error = NULL, started_by = EXCLUDED.started_by,
+		    started_at = NOW(), completed_at = NULL
+		WHERE position_reconciliations.status = 'failed'
+		RETURNING id, status, started_at
+	`, rec.Trigger, rec.RunDate, rec.StartedBy).Scan(&rec.ID, &rec.Status, &rec.StartedAt)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to start position reconciliation: %w", err)
+	}
+
+	return true, nil
+}
+
+// Finish records the outcome of a reconciliation
+func (r *ReconciliationRepository) Finish(ctx context.Context, rec *types.PositionReconciliation) error {
+	err := r.db.QueryRow(ctx, `
+		UPDATE position_reconciliations
+		SET status = $1, symbols_checked = $2, drift_count = $3, error = $4, completed_at = NOW()
+		WHERE id = $5
+		RETURNING completed_at
+	`, rec.Status, rec.SymbolsChecked, rec.DriftCount, rec.Error, rec.ID).Scan(&rec.CompletedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to finish position reconciliation: %w", err)
+	}
+
+	return nil
+}
+
+// AddDrift adds a symbol to a reconciliation's drift report
+func (r *ReconciliationRepository) AddDrift(ctx context.Context, d *types.PositionDrift) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO position_drifts (reconciliation_id, symbol, local_qty, broker_qty, drift, holders)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, status, created_at
+	`, d.ReconciliationID, d.Symbol, d.LocalQty, d.BrokerQty, d.Drift, d.Holders,
+	).Scan(&d.ID, &d.Status, &d.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to add position drift: %w", err)
+	}
+
+	return nil
+}
+
+// GetByID retrieves a reconciliation by ID
+func (r *ReconciliationRepository) GetByID(ctx context.Context, id string) (*types.PositionReconciliation, error) {
+	rec, err := scanReconciliation(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM position_reconciliations WHERE id = $1
+	`, reconciliationColumns), id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrReconciliationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get position reconciliation: %w", err)
+	}
+
+	return rec, nil
+}
+
+// List retrieves the most recent reconciliations, newest first
+func (r *ReconciliationRepository) List(ctx context.Context, limit int) ([]types.PositionReconciliation, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM position_reconciliations
+		ORDER BY started_at DESC
+		LIMIT $1
+	`, reconciliationColumns), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list position reconciliations: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []types.PositionReconciliation
+	for rows.Next() {
+		rec, err := scanReconciliation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan position reconciliation: %w", err)
+		}
+		recs = append(recs, *rec)
+	}
+
+	return recs, nil
+}
+
+// ListDrifts retrieves a reconciliation's drift report, ordered by symbol
+func (r *ReconciliationRepository) ListDrifts(ctx context.Context, reconciliationID string) ([]types.PositionDrift, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM position_drifts
+		WHERE reconciliation_id = $1
+		ORDER BY symbol ASC
+	`, driftColumns), reconciliationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list position drifts: %w", err)
+	}
+	defer rows.Close()
+
+	var drifts []types.PositionDrift
+	for rows.Next() {
+		d, err := scanDrift(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan position drift: %w", err)
+		}
+		drifts = append(drifts, *d)
+	}
+
+	return drifts, nil
+}
+
+// GetDriftForUpdate retrieves a drift and locks it until the surrounding
+// transaction ends
+func (r *ReconciliationRepository) GetDriftForUpdate(ctx context.Context, id string) (*types.PositionDrift, error) {
+	d, err := scanDrift(r.db.QueryRow(ctx, fmt.Sprintf(`
+		SELECT %s FROM position_drifts WHERE id = $1 FOR UPDATE
+	`, driftColumns), id))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDriftNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get position drift: %w", err)
+	}
+
+	return d, nil
+}
+
+// MarkCorrected records that an operator corrected the holdings behind a drift
+func (r *ReconciliationRepository) MarkCorrected(ctx context.Context, d *types.PositionDrift, actor, note string) error {
+	err := r.db.QueryRow(ctx, `
+		UPDATE position_drifts
+		SET status = 'corrected', corrected_by = $1, corrected_at = NOW(), correction_note = NULLIF($2, '')
+		WHERE id = $3
+		RETURNING status, corrected_by, corrected_at, correction_note
+	`, actor, note, d.ID).Scan(&d.Status, &d.CorrectedBy, &d.CorrectedAt, &d.CorrectionNote)
+
+	if err != nil {
+		return fmt.Errorf("failed to mark position drift corrected: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// TaxLotRepository handles tax lot and realized gain database operations
+type TaxLotRepository struct {
+	db DBTX
+}
+
+// NewTaxLotRepository creates a new tax lot repository
+func NewTaxLotRepository(db *pgxpool.Pool) *TaxLotRepository {
+	return &TaxLotRepository{db: db}
+}
+
+const taxLotColumns = `id, user_id, symbol, order_id, qty, remaining_qty, cost_per_share,
+	remaining_qty * cost_per_share, acquired_at, closed_at, created_at, updated_at`
+
+func scanTaxLots(rows pgx.Rows) ([]types.TaxLot, error) {
+	defer rows.Close()
+
+	var lots []types.TaxLot
+	for rows.Next() {
+		var l types.TaxLot
+		err := rows.Scan(
+			&l.ID, &l.UserID, &l.Symbol, &l.OrderID, &l.Qty, &l.RemainingQty, &l.CostPerShare,
+			&l.CostBasis, &l.AcquiredAt, &l.ClosedAt, &l.CreatedAt, &l.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tax lot: %w", err)
+		}
+		lots = append(lots, l)
+	}
+
+	return lots, nil
+}
+
+// Create opens a lot for the shares bought in a fill
+func (r *TaxLotRepository) Create(ctx context.Context, lot *types.TaxLot) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO tax_lots (user_id, symbol, order_id, qty, remaining_qty, cost_per_share, acquired_at)
+		VALUES ($1, $2, $3, $4, $4, $5, $6)
+		RETURNING id, remaining_qty, created_at, updated_at
+	`, lot.UserID, lot.Symbol, lot.OrderID, lot.Qty, lot.CostPerShare, lot.AcquiredAt,
+	).Scan(&lot.ID, &lot.RemainingQty, &lot.CreatedAt, &lot.UpdatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create tax lot: %w", err)
+	}
+
+	return nil
+}
+
+// ListOpen retrieves a user's open lots, oldest first. An empty symbol lists
+// the lots in every symbol.
+func (r *TaxLotRepository) ListOpen(ctx context.Context, userID, symbol string) ([]types.TaxLot, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM tax_lots
+		WHERE user_id = $1 AND remaining_qty > 0 AND ($2 = '' OR symbol = $2)
+		ORDER BY symbol ASC, acquired_at ASC, created_at ASC
+	`, taxLotColumns), userID, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tax lots: %w", err)
+	}
+
+	return scanTaxLots(rows)
+}
+
+// ListOpenForUpdate retrieves a user's open lots in a symbol, oldest first,
+// and locks them until the surrounding transaction ends
+func (r *TaxLotRepository) ListOpenForUpdate(ctx context.Context, userID, symbol string) ([]types.TaxLot, error) {
+	rows, err := r.db.Query(ctx, fmt.Sprintf(`
+		SELECT %s FROM tax_lots
+		WHERE user_id = $1 AND symbol = $2 AND remaining_qty > 0
+		ORDER BY acquired_at ASC, created_at ASC
+		FOR UPDATE
+	`, taxLotColumns), userID, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tax lots: %w", err)
+	}
+
+	return scanTaxLots(rows)
+}
+
+// Consume takes sold shares out of a lot, closing it once none remain
+func (r *TaxLotRepository) Consume(ctx context.Context, lotID string, qty float64) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE tax_lots
+		SET remaining_qty = GREATEST(remaining_qty - $1, 0),
+		    closed_at = CASE WHEN remaining_qty - $1 <= 0 THEN NOW() ELSE closed_at END,
+		    updated_at = NOW()
+		WHERE id = $2
+	`, qty, lotID)
+
+	if err != nil {
+		return fmt.Errorf("failed to consume tax lot: %w", err)
+	}
+
+	return nil
+}
+
+// ApplySplit scales the open lots in a symbol by a from-for-to split,
+// keeping the cost of each lot
+func (r *TaxLotRepository) ApplySplit(ctx context.Context, symbol string, from, to float64) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE tax_lots
+		SET qty = qty * $2 / $3,
+		    remaining_qty = FLOOR(remaining_qty * $2 / $3 * 100000000) / 100000000,
+		    cost_per_share = ROUND(cost_per_share * $3 / $2, 4),
+		    updated_at = NOW()
+		WHERE symbol = $1 AND remaining_qty > 0
+	`, symbol, to, from)
+
+	if err != nil {
+		return fmt.Errorf("failed to split tax lots: %w", err)
+	}
+
+	return nil
+}
+
+// ScaleOpen scales a user's open lots in a symbol by corrected/qty, the
+// ratio their holding was corrected by, keeping the cost per share of each
+// lot (after a reconciliation against the broker). Lots corrected to nothing
+// are closed.
+func (r *TaxLotRepository) ScaleOpen(ctx context.Context, userID, symbol string, qty, corrected float64) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE tax_lots
+		SET qty = CASE WHEN $4::float8 > 0 THEN qty * $4 / $3 ELSE qty END,
+		    remaining_qty = FLOOR(remaining_qty * $4 / $3 * 100000000) / 100000000,
+		    closed_at = CASE WHEN $4::float8 > 0 THEN closed_at ELSE NOW() END,
+		    updated_at = NOW()
+		WHERE user_id = $1 AND symbol = $2 AND remaining_qty > 0
+	`, userID, symbol, qty, corrected)
+
+	if err != nil {
+		return fmt.Errorf("failed to scale tax lots: %w", err)
+	}
+
+	return nil
+}
+
+// ChangeSymbol moves the open lots in a symbol to its new symbol, keeping
+// their acquisition dates
+func (r *TaxLotRepository) ChangeSymbol(ctx context.Context, symbol, newSymbol string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE tax_lots SET symbol = $2, updated_at = NOW()
+		WHERE symbol = $1 AND remaining_qty > 0
+	`, symbol, newSymbol)
+
+	if err != nil {
+		return fmt.Errorf("failed to change tax lot symbol: %w", err)
+	}
+
+	return nil
+}
+
+// ListHoldersAt reconstructs each user's holding in a symbol as it stood at
+// a point in time: the shares held now, less the lots acquired since and
+// plus the shares sold since. Users who have sold out since are included;
+// their average cost is left at zero.
+func (r *TaxLotRepository) ListHoldersAt(ctx context.Context, symbol string, at time.Time) ([]types.Holding, error) {
+	rows, err := r.db.Query(ctx, `
+		WITH moves AS (
+			SELECT user_id, quantity AS qty FROM holdings WHERE symbol = $1
+			UNION ALL
+			SELECT user_id, -qty FROM tax_lots WHERE symbol = $1 AND acquired_at >= $2
+			UNION ALL
+			SELECT user_id, qty FROM realized_gains WHERE symbol = $1 AND sold_at >= $2
+		)
+		SELECT m.user_id, SUM(m.qty), COALESCE(MAX(h.avg_cost_basis), 0)
+		FROM moves m
+		LEFT JOIN holdings h ON h.user_id = m.user_id AND h.symbol = $1
+		GROUP BY m.user_id
+		HAVING SUM(m.qty) > 0
+		ORDER BY m.user_id
+	`, symbol, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list holders: %w", err)
+	}
+	defer rows.Close()
+
+	var holdings []types.Holding
+	for rows.Next() {
+		holding := types.Holding{Symbol: symbol}
+		if err := rows.Scan(&holding.UserID, &holding.Qty, &holding.AvgEntryPrice); err != nil {
+			return nil, fmt.Errorf("failed to scan holder: %w", err)
+		}
+		holdings = append(holdings, holding)
+	}
+
+	return holdings, nil
+}
+
+// AddGain records the gain realized on the shares of a lot sold
+func (r *TaxLotRepository) AddGain(ctx context.Context, g *types.RealizedGain) error {
+	err := r.db.QueryRow(ctx, `
+		INSERT INTO realized_gains (user_id, symbol, order_id, lot_id, qty, proceeds, cost_basis, fee,
+		                            realized_pl, term, acquired_at, sold_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING id, created_at
+	`, g.UserID, g.Symbol, g.OrderID, g.LotID, g.Qty, g.Proceeds, g.CostBasis, g.Fee,
+		g.RealizedPL, g.Term, g.AcquiredAt, g.SoldAt,
+	).Scan(&g.ID, &g.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to add realized gain: %w", err)
+	}
+
+	return nil
+}
+
+// ListGains retrieves a user's realized gains, most recent first. An empty
+// symbol and a zero year match every symbol and year.
+func (r *TaxLotRepository) ListGains(ctx context.Context, userID, symbol string, year, limit int) ([]types.RealizedGain, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, symbol, order_id, lot_id, qty, proceeds, cost_basis, fee, realized_pl,
+		       term, acquired_at, sold_at, created_at
+		FROM realized_gains
+		WHERE user_id = $1 AND ($2 = '' OR symbol = $2)
+		  AND ($3 = 0 OR EXTRACT(YEAR FROM sold_at AT TIME ZONE 'UTC') = $3)
+		ORDER BY sold_at DESC, created_at DESC
+		LIMIT $4
+	`, userID, symbol, year, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list realized gains: %w", err)
+	}
+	defer rows.Close()
+
+	var gains []types.RealizedGain
+	for rows.Next() {
+		var g types.RealizedGain
+		err := rows.Scan(
+			&g.ID, &g.UserID, &g.Symbol, &g.OrderID, &g.LotID, &g.Qty, &g.Proceeds, &g.CostBasis, &g.Fee,
+			&g.RealizedPL, &g.Term, &g.AcquiredAt, &g.SoldAt, &g.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan realized gain: %w", err)
+		}
+		gains = append(gains, g)
+	}
+
+	return gains, nil
+}
+
+// SummarizeGains totals a user's realized gains per symbol and year, most
+// recent year first. An empty symbol and a zero year match every symbol and
+// year.
+func (r *TaxLotRepository) SummarizeGains(ctx context.Context, userID, symbol string, year int) ([]types.RealizedGainSummary, error) {
+	rows, err := r.db.Query(ctx, `
+		SELECT symbol, EXTRACT(YEAR FROM sold_at AT TIME ZONE 'UTC')::INT AS year,
+		       SUM(qty), SUM(proceeds), SUM(cost_basis), SUM(fee), SUM(realized_pl),
+		       COALESCE(SUM(realized_pl) FILTER (WHERE term = 'short'), 0),
+		       COALESCE(SUM(realized_pl) FILTER (WHERE term = 'long'), 0)
+		FROM realized_gains
+		WHERE user_id = $1 AND ($2 = '' OR symbol = $2)
+		  AND ($3 = 0 OR EXTRACT(YEAR FROM sold_at AT TIME ZONE 'UTC') = $3)
+		GROUP BY symbol, year
+		ORDER BY year DESC, symbol ASC
+	`, userID, symbol, year)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize realized gains: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []types.RealizedGainSummary
+	for rows.Next() {
+		var s types.RealizedGainSummary
+		err := rows.Scan(
+			&s.Symbol, &s.Year, &s.Qty, &s.Proceeds, &s.CostBasis, &s.Fees, &s.RealizedPL,
+			&s.ShortTermPL, &s.LongTermPL,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan realized gain summary: %w", err)
+		}
+		summaries = append(summaries, s)
+	}
+
+	return summaries, nil
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DBTX is implemented by both the connection pool and a transaction, so the
+// same repository code can run standalone or inside a unit of work
+type DBTX interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Tx exposes repositories bound to a single database transaction
+type Tx struct {
+	Orders          *OrderRepository
+	Holdings        *HoldingRepository
+	Wallets         *WalletRepository
+	Outbox          *OutboxRepository
+	Ledger          *LedgerRepository
+	Plans           *PlanRepository
+	Omnibus         *OmnibusRepository
+	Users           *UserRepository
+	Actions         *CorporateActionRepository
+	Lots            *TaxLotRepository
+	Halts           *HaltRepository
+	Conditionals    *ConditionalOrderRepository
+	Reconciliations *ReconciliationRepository
+	Closures        *AccountClosureRepository
+}
+
+// UnitOfWork runs repository operations atomically
+type UnitOfWork struct {
+	db *pgxpool.Pool
+}
+
+// NewUnitOfWork creates a new unit of work
+func NewUnitOfWork(db *pgxpool.Pool) *UnitOfWork {
+	return &UnitOfWork{db: db}
+}
+
+// Do runs fn in a transaction. The transaction commits if fn returns nil and
+// rolls back otherwise.
+func (u *UnitOfWork) Do(ctx context.Context, fn func(tx *Tx) error) error {
+	return pgx.BeginFunc(ctx, u.db, func(tx pgx.Tx) error {
+		return fn(&Tx{
+			Orders:          &OrderRepository{db: tx},
+			Holdings:        &HoldingRepository{db: tx},
+			Wallets:         &WalletRepository{db: tx},
+			Outbox:          &OutboxRepository{db: tx},
+			Ledger:          &LedgerRepository{db: tx},
+			Plans:           &PlanRepository{db: tx},
+			Omnibus:         &OmnibusRepository{db: tx},
+			Users:           &UserRepository{db: tx},
+			Actions:         &CorporateActionRepository{db: tx},
+			Lots:            &TaxLotRepository{db: tx},
+			Halts:           &HaltRepository{db: tx},
+			Conditionals:    &ConditionalOrderRepository{db: tx},
+			Reconciliations: &ReconciliationRepository{db: tx},
+			Closures:        &AccountClosureRepository{db: tx},
+		})
+	})
+}
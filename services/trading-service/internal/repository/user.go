@@ -11,7 +11,7 @@ import (
 
 // UserRepository handles user database operations for trading
 type UserRepository struct {
-	db *pgxpool.Pool
+	db DBTX
 }
 
 // NewUserRepository creates a new user repository
@@ -23,9 +23,9 @@ func NewUserRepository(db *pgxpool.Pool) *UserRepository {
 func (r *UserRepository) GetByID(ctx context.Context, userID string) (*types.User, error) {
 	var user types.User
 	err := r.db.QueryRow(ctx, `
-		SELECT id, phone, is_active, is_kyc_verified
+		SELECT id, phone, is_active, is_kyc_verified, COALESCE(kyc_tier::text, '')
 		FROM users WHERE id = $1
-	`, userID).Scan(&user.ID, &user.Phone, &user.IsActive, &user.IsKYCVerified)
+	`, userID).Scan(&user.ID, &user.Phone, &user.IsActive, &user.IsKYCVerified, &user.KYCTier)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
@@ -33,3 +33,16 @@ func (r *UserRepository) GetByID(ctx context.Context, userID string) (*types.Use
 
 	return &user, nil
 }
+
+// Deactivate marks a user's account inactive
+func (r *UserRepository) Deactivate(ctx context.Context, userID string) error {
+	_, err := r.db.Exec(ctx, `
+		UPDATE users SET is_active = false, updated_at = NOW() WHERE id = $1
+	`, userID)
+
+	if err != nil {
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+
+	return nil
+}
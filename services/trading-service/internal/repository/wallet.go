@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -9,9 +10,13 @@ import (
 	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
 )
 
+// ErrInsufficientBalance is returned when a wallet's available balance does
+// not cover a debit
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
 // WalletRepository handles wallet database operations for trading
 type WalletRepository struct {
-	db *pgxpool.Pool
+	db DBTX
 }
 
 // NewWalletRepository creates a new wallet repository
@@ -101,3 +106,22 @@ func (r *WalletRepository) Credit(ctx context.Context, walletID string, amount f
 
 	return nil
 }
+
+// Debit debits available funds from the wallet (for currency conversions)
+func (r *WalletRepository) Debit(ctx context.Context, walletID string, amount float64) error {
+	result, err := r.db.Exec(ctx, `
+		UPDATE wallets
+		SET balance = balance - $1, updated_at = NOW()
+		WHERE id = $2 AND balance - locked_balance >= $1
+	`, amount, walletID)
+
+	if err != nil {
+		return fmt.Errorf("failed to debit wallet: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrInsufficientBalance
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package risk
+
+import (
+	"context"
+	"errors"
+
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+var rejectedOrders = metrics.RegisterCounter(
+	"trading_risk_rejections_total",
+	"Orders rejected by pre-trade risk checks by rule",
+	[]string{"rule"},
+)
+
+// Order is an order about to be submitted to the broker
+type Order struct {
+	User        *types.User
+	Symbol      string
+	Side        string
+	Type        string
+	TimeInForce string
+	Qty         float64
+	Amount      float64
+	Notional    float64      // Estimated USD value of the order
+	Pending     float64      // USD value of orders placed with it but not yet saved, e.g. earlier legs of a basket
+	Replaces    *types.Order // Open order an amendment gives new terms to, already counted towards limits
+	Source      string       // Who placed the order; see SourceClosure
+}
+
+// SourceClosure marks the sells that liquidate an account being closed. They
+// are exempt from the order value limits, which would otherwise leave a large
+// holding unsellable.
+const SourceClosure = "closure"
+
+// liquidating reports whether the order sells off a closing account
+func (o *Order) liquidating() bool {
+	return o.Source == SourceClosure && o.Side == "sell"
+}
+
+// Rule is a single pre-trade check. Rules reject an order by returning an
+// *apperrors.AppError carrying a Rejection; any other error means the rule
+// could not be evaluated.
+type Rule interface {
+	Name() string
+	Check(ctx context.Context, order *Order) error
+}
+
+// Rejection is the structured detail returned with a rejected order
+type Rejection struct {
+	Rule   string  `json:"rule"`
+	Reason string  `json:"reason"`
+	Limit  float64 `json:"limit,omitempty"`
+	Value  float64 `json:"value,omitempty"`
+}
+
+// reject builds a rejection error for a rule
+func reject(err *apperrors.AppError, rule, reason string, limit, value float64) error {
+	return err.WithDetails(Rejection{
+		Rule:   rule,
+		Reason: reason,
+		Limit:  limit,
+		Value:  value,
+	})
+}
+
+// Engine runs pre-trade rules in order and stops at the first rejection
+type Engine struct {
+	rules []Rule
+}
+
+// NewEngine creates a new risk engine
+func NewEngine(rules ...Rule) *Engine {
+	return &Engine{rules: rules}
+}
+
+// Check returns nil if every rule accepts the order
+func (e *Engine) Check(ctx context.Context, order *Order) error {
+	for _, rule := range e.rules {
+		err := rule.Check(ctx, order)
+		if err == nil {
+			continue
+		}
+
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			rejectedOrders.WithLabelValues(rule.Name()).Inc()
+			logger.Info().
+				Str("user_id", order.User.ID).
+				Str("symbol", order.Symbol).
+				Str("rule", rule.Name()).
+				Float64("notional", order.Notional).
+				Msg("Order rejected by pre-trade check")
+			return err
+		}
+
+		// Fail closed when a rule cannot be evaluated
+		logger.Error().Err(err).Str("rule", rule.Name()).Msg("Pre-trade check failed")
+		return apperrors.ErrServiceUnavailable.WithDetails("Pre-trade checks unavailable")
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package risk
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/halts"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// DailyTradeLimitsKES mirrors the daily trade limits user-service advertises
+// per KYC tier. The "" entry applies to unverified users.
+var DailyTradeLimitsKES = map[string]float64{
+	"":      20000,
+	"tier1": 100000,
+	"tier2": 1000000,
+	"tier3": 10000000,
+}
+
+// =============================================================================
+// Trading Halts
+// =============================================================================
+
+// HaltSource reports the trading halts that apply to an order
+type HaltSource interface {
+	Applicable(ctx context.Context, userID, symbol string) (*halts.Status, error)
+}
+
+// Halts rejects orders stopped by a global halt, an account freeze, a symbol
+// halt or, for buys, sell-only mode
+type Halts struct {
+	Source HaltSource
+}
+
+func (r *Halts) Name() string { return "trading_halts" }
+
+func (r *Halts) Check(ctx context.Context, order *Order) error {
+	status, err := r.Source.Applicable(ctx, order.User.ID, order.Symbol)
+	if err != nil {
+		return err
+	}
+
+	halt := status.Blocking(order.Side)
+	if halt == nil {
+		return nil
+	}
+
+	reason := halts.Message(halt.Scope, halt.Target, true)
+	switch halt.Scope {
+	case halts.ScopeGlobal:
+		return reject(apperrors.ErrTradingHalted, r.Name(), reason, 0, 0)
+	case halts.ScopeUser:
+		return reject(apperrors.ErrAccountFrozen, r.Name(), reason, 0, 0)
+	case halts.ScopeSymbol:
+		return reject(apperrors.ErrSymbolHalted, r.Name(), reason, 0, 0)
+	default:
+		return reject(apperrors.ErrSellOnly, r.Name(), reason, 0, 0)
+	}
+}
+
+// =============================================================================
+// Max Order Notional
+// =============================================================================
+
+// MaxNotional rejects orders worth more than a fixed USD amount
+type MaxNotional struct {
+	Limit float64
+}
+
+func (r *MaxNotional) Name() string { return "max_order_notional" }
+
+func (r *MaxNotional) Check(ctx context.Context, order *Order) error {
+	if order.liquidating() {
+		return nil
+	}
+	if order.Notional > r.Limit {
+		return reject(apperrors.ErrOrderNotionalExceeded, r.Name(), "Order value exceeds the per-order maximum", r.Limit, order.Notional)
+	}
+	return nil
+}
+
+// =============================================================================
+// Daily Trade Limit
+// =============================================================================
+
+// NotionalSource reports how much a user has traded since a point in time
+type NotionalSource interface {
+	DailyNotional(ctx context.Context, userID string, since time.Time) (float64, error)
+}
+
+// DailyLimit caps the USD notional a user can trade per day by KYC tier
+type DailyLimit struct {
+	Orders    NotionalSource
+	LimitsKES map[string]float64
+	KESPerUSD float64
+}
+
+func (r *DailyLimit) Name() string { return "daily_trade_limit" }
+
+func (r *DailyLimit) Check(ctx context.Context, order *Order) error {
+	if order.liquidating() {
+		return nil
+	}
+	tier := order.User.TradingTier()
+	limitKES, ok := r.LimitsKES[tier]
+	if !ok {
+		limitKES = r.LimitsKES[""]
+	}
+	limit := limitKES / r.KESPerUSD
+
+	now := time.Now().UTC()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	traded, err := r.Orders.DailyNotional(ctx, order.User.ID, startOfDay)
+	if err != nil {
+		return err
+	}
+
+	total := traded + order.Pending + order.Notional
+	if replaced := order.Replaces; replaced != nil && !replaced.CreatedAt.Before(startOfDay) {
+		// An amendment replaces the value its order already counted
+		total -= openNotional(replaced)
+	}
+	if total > limit {
+		reason := "Order would exceed the daily trade limit for your KYC tier"
+		if tier == "" {
+			reason = "Order would exceed the daily trade limit for unverified accounts"
+		}
+		return reject(apperrors.ErrOrderLimitExceeded, r.Name(), reason, limit, total)
+	}
+	return nil
+}
+
+// openNotional is the value DailyNotional counts for an open order
+func openNotional(o *types.Order) float64 {
+	price := 0.0
+	switch {
+	case o.LimitPrice != nil:
+		price = *o.LimitPrice
+	case o.StopPrice != nil:
+		price = *o.StopPrice
+	}
+	return max(o.Amount, o.LockedAmount, o.Qty*price, o.FilledQty*o.FilledAvgPrice)
+}
+
+// =============================================================================
+// Symbol Deny-List
+// =============================================================================
+
+// DenyList rejects orders in restricted symbols
+type DenyList struct {
+	symbols map[string]struct{}
+}
+
+// NewDenyList creates a deny-list from a list of symbols
+func NewDenyList(symbols []string) *DenyList {
+	d := &DenyList{symbols: make(map[string]struct{}, len(symbols))}
+	for _, s := range symbols {
+		if s = strings.ToUpper(strings.TrimSpace(s)); s != "" {
+			d.symbols[s] = struct{}{}
+		}
+	}
+	return d
+}
+
+func (r *DenyList) Name() string { return "symbol_deny_list" }
+
+func (r *DenyList) Check(ctx context.Context, order *Order) error {
+	if _, denied := r.symbols[strings.ToUpper(order.Symbol)]; denied {
+		return reject(apperrors.ErrSymbolRestricted, r.Name(), "Symbol is on the restricted list", 0, 0)
+	}
+	return nil
+}
+
+// =============================================================================
+// Asset Tradability
+// =============================================================================
+
+// Tradable rejects assets the broker will not accept, and fractional or
+// notional orders for assets that cannot be traded fractionally
+type Tradable struct {
+	Alpaca alpaca.TradingClient
+}
+
+func (r *Tradable) Name() string { return "asset_tradable" }
+
+func (r *Tradable) Check(ctx context.Context, order *Order) error {
+	asset, err := r.Alpaca.GetAsset(ctx, order.Symbol)
+	if alpaca.IsNotFound(err) {
+		return reject(apperrors.ErrInvalidSymbol, r.Name(), "Asset not found", 0, 0)
+	}
+	if err != nil {
+		// The broker could not say; the engine fails closed
+		return err
+	}
+	if !asset.Tradable || asset.Status != "active" {
+		return reject(apperrors.ErrSymbolNotTradeable, r.Name(), "Asset is not tradable", 0, 0)
+	}
+
+	fractional := order.Amount > 0 || order.Qty != math.Trunc(order.Qty)
+	if fractional && !asset.Fractionable {
+		return reject(apperrors.ErrSymbolNotFractionable, r.Name(), "Asset only supports whole-share orders", 0, 0)
+	}
+	return nil
+}
+
+// =============================================================================
+// Market Hours
+// =============================================================================
+
+// MarketHours rejects orders that need an open market while it is closed.
+// Limit and stop orders that rest on the book are queued for the next open.
+type MarketHours struct {
+	Alpaca alpaca.TradingClient
+}
+
+func (r *MarketHours) Name() string { return "market_hours" }
+
+func (r *MarketHours) Check(ctx context.Context, order *Order) error {
+	immediate := order.Type == "market" || order.TimeInForce == "ioc" || order.TimeInForce == "fok"
+	if !immediate {
+		return nil
+	}
+
+	clock, err := r.Alpaca.GetClock(ctx)
+	if err != nil {
+		return err
+	}
+	if !clock.IsOpen {
+		return reject(apperrors.ErrTradingHoursClosed, r.Name(), "Market is closed until "+clock.NextOpen.Format(time.RFC3339), 0, 0)
+	}
+	return nil
+}
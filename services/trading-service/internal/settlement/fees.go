@@ -0,0 +1,53 @@
+package settlement
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// commissionDue returns the part of an order's commission not yet charged.
+// The fee is priced on the whole value filled so far under the user's fee
+// schedule, so an order filled in several parts pays what one fill would.
+func (s *Settler) commissionDue(ctx context.Context, tx *repository.Tx, order *types.Order, filledValue float64) (float64, error) {
+	if s.fees == nil {
+		return 0, nil
+	}
+
+	user, err := tx.Users.GetByID(ctx, order.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	quote := s.fees.Quote(user.TradingTier(), filledValue)
+	return max(quote.Fee-order.Commission, 0), nil
+}
+
+// recordFee adds a fee charged on an order to its commission and records it
+// as a fee entry in the wallet history. The caller moves the funds.
+func recordFee(ctx context.Context, tx *repository.Tx, order *types.Order, walletID string, fee, filledValue float64) error {
+	if err := tx.Orders.AddCommission(ctx, order.ID, fee); err != nil {
+		return err
+	}
+	order.Commission += fee
+
+	return tx.Ledger.Add(ctx, &types.LedgerEntry{
+		UserID:      order.UserID,
+		WalletID:    walletID,
+		Type:        "fee",
+		Amount:      -fee,
+		Fee:         fee,
+		Currency:    "USD",
+		Reference:   order.ID,
+		Description: fmt.Sprintf("Commission on %s %s", order.Side, order.Symbol),
+		Metadata: map[string]any{
+			"order_id":     order.ID,
+			"symbol":       order.Symbol,
+			"side":         order.Side,
+			"filled_value": filledValue,
+			"commission":   order.Commission,
+		},
+	})
+}
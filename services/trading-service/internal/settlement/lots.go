@@ -0,0 +1,87 @@
+package settlement
+
+import (
+	"context"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/taxlots"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// openLot opens a tax lot for the shares a buy fill added to the holding
+func openLot(ctx context.Context, tx *repository.Tx, order *types.Order, qty, price float64, acquiredAt time.Time) error {
+	return tx.Lots.Create(ctx, &types.TaxLot{
+		UserID:       order.UserID,
+		Symbol:       order.Symbol,
+		OrderID:      &order.ID,
+		Qty:          qty,
+		CostPerShare: price,
+		AcquiredAt:   acquiredAt,
+	})
+}
+
+// realizeSale consumes the lots a sell fill sold, chosen by the order's lot
+// method, and records the gain realized on each. fee is the commission
+// charged on the fill. Shares no open lot covers are costed at the holding's
+// average cost.
+func realizeSale(ctx context.Context, tx *repository.Tx, order *types.Order, qty, value, fee float64, soldAt time.Time) error {
+	open, err := tx.Lots.ListOpenForUpdate(ctx, order.UserID, order.Symbol)
+	if err != nil {
+		return err
+	}
+
+	lots := make([]taxlots.Lot, len(open))
+	for i, l := range open {
+		lots[i] = taxlots.Lot{ID: l.ID, Qty: l.RemainingQty, Price: l.CostPerShare, AcquiredAt: l.AcquiredAt}
+	}
+
+	method := order.LotMethod
+	if method == "" {
+		method = taxlots.MethodFIFO
+	}
+	sold, unmatched, err := taxlots.Select(lots, qty, method, order.LotIDs)
+	if err != nil {
+		return err
+	}
+
+	if unmatched > 0 {
+		holding, err := tx.Holdings.GetByUserAndSymbol(ctx, order.UserID, order.Symbol)
+		if err != nil {
+			return err
+		}
+		logger.Warn().
+			Str("order_id", order.ID).
+			Str("symbol", order.Symbol).
+			Float64("unmatched_qty", unmatched).
+			Msg("Sold shares not covered by tax lots, costing at average")
+		sold = append(sold, taxlots.Lot{Qty: unmatched, Price: holding.AvgEntryPrice, AcquiredAt: holding.CreatedAt})
+	}
+
+	for _, g := range taxlots.Realize(sold, value/qty, fee, soldAt) {
+		gain := &types.RealizedGain{
+			UserID:     order.UserID,
+			Symbol:     order.Symbol,
+			OrderID:    order.ID,
+			Qty:        g.Qty,
+			Proceeds:   g.Proceeds,
+			CostBasis:  g.CostBasis,
+			Fee:        g.Fee,
+			RealizedPL: g.PL,
+			Term:       g.Term,
+			AcquiredAt: g.AcquiredAt,
+			SoldAt:     soldAt,
+		}
+		if g.LotID != "" {
+			if err := tx.Lots.Consume(ctx, g.LotID, g.Qty); err != nil {
+				return err
+			}
+			gain.LotID = &g.LotID
+		}
+		if err := tx.Lots.AddGain(ctx, gain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package settlement
+
+import (
+	"context"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// qtyUnits is the number of allocation units per share. Alpaca reports
+// fractional fills to 9 decimal places, so no allocation is finer than that.
+const qtyUnits = 1e9
+
+// Allocation is the part of an omnibus fill credited to one of its orders
+type Allocation struct {
+	Order       *types.Order
+	Weight      float64 // Order amount over the omnibus notional
+	ExactQty    float64 // Pro-rata share of the filled qty before rounding
+	Qty         float64 // Shares allocated after rounding
+	RoundingQty float64 // Shares added by distributing the rounding remainder
+	Value       float64 // Amount paid for the allocated shares
+	Refund      float64 // Lock released because it was not spent
+}
+
+// Allocate splits the filled qty of an omnibus order across its orders in
+// proportion to their amounts. Each order first gets its pro-rata share
+// rounded down to whole allocation units; the units left over go one each to
+// the orders with the largest rounding loss, ties going to the earliest
+// order. The split is computed in integers, so the shares allocated always
+// add up to the fill and are the same however often it is computed. orders
+// must be sorted oldest first.
+func Allocate(orders []types.Order, filledQty, price float64) []Allocation {
+	cents := make([]uint64, len(orders))
+	var totalCents uint64
+	for i, o := range orders {
+		cents[i] = uint64(math.Round(o.Amount * 100))
+		totalCents += cents[i]
+	}
+
+	var totalUnits uint64
+	if totalCents > 0 {
+		totalUnits = uint64(math.Round(filledQty * qtyUnits))
+	}
+	units := make([]uint64, len(orders))
+	losses := make([]uint64, len(orders))
+	remainder := totalUnits
+
+	allocations := make([]Allocation, len(orders))
+	for i := range orders {
+		a := &allocations[i]
+		a.Order = &orders[i]
+		if totalCents == 0 {
+			continue
+		}
+		a.Weight = float64(cents[i]) / float64(totalCents)
+		a.ExactQty = filledQty * a.Weight
+
+		// totalUnits * cents / totalCents, without overflowing on large fills
+		hi, lo := bits.Mul64(totalUnits, cents[i])
+		units[i], losses[i] = bits.Div64(hi, lo, totalCents)
+		remainder -= units[i]
+	}
+
+	ranked := make([]int, len(orders))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(a, b int) bool {
+		return losses[ranked[a]] > losses[ranked[b]]
+	})
+	for _, i := range ranked[:min(remainder, uint64(len(ranked)))] {
+		units[i]++
+		allocations[i].RoundingQty = 1 / qtyUnits
+	}
+
+	for i := range allocations {
+		a := &allocations[i]
+		a.Qty = float64(units[i]) / qtyUnits
+		// An order never pays more than it locked, even for the unit it
+		// gained from rounding
+		a.Value = min(a.Qty*price, a.Order.LockedAmount)
+		a.Refund = max(a.Order.LockedAmount-a.Value, 0)
+	}
+
+	return allocations
+}
+
+// ApplyOmnibus settles an update for an omnibus order across the orders
+// aggregated into it. Only final updates are settled: the fill, or what was
+// filled before a cancel, is allocated once the omnibus order closes.
+func (s *Settler) ApplyOmnibus(ctx context.Context, omnibus *types.OmnibusOrder, event string, update *types.AlpacaOrderUpdate, source string) error {
+	filledQty, _ := strconv.ParseFloat(update.FilledQty, 64)
+	filledAvgPrice, _ := strconv.ParseFloat(update.FilledAvgPrice, 64)
+
+	switch event {
+	case EventFill:
+		return s.settleOmnibus(ctx, omnibus.ID, StatusFilled, filledQty, filledAvgPrice, update.FilledAt, source)
+	case EventCanceled, EventExpired:
+		return s.settleOmnibus(ctx, omnibus.ID, StatusCanceled, filledQty, filledAvgPrice, update.FilledAt, source)
+	case EventRejected:
+		return s.FailOmnibus(ctx, omnibus.ID, "Order rejected by exchange", source)
+	}
+	return nil
+}
+
+// settleOmnibus allocates the filled qty of a closed omnibus order to its
+// orders. Each order's holding, wallet, status, filled event and allocation
+// record commit or roll back together with the rest.
+func (s *Settler) settleOmnibus(ctx context.Context, omnibusID, status string, filledQty, filledAvgPrice float64, filledAt *time.Time, source string) error {
+	var allocations []Allocation
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		omnibus, err := lockOpenOmnibus(ctx, tx, omnibusID)
+		if err != nil {
+			return err
+		}
+
+		orders, err := tx.Orders.ListByOmnibusForUpdate(ctx, omnibusID)
+		if err != nil {
+			return err
+		}
+
+		allocations = Allocate(orders, filledQty, filledAvgPrice)
+		for i := range allocations {
+			if err := s.applyAllocation(ctx, tx, omnibus, &allocations[i], status, filledAvgPrice, filledAt, source); err != nil {
+				return err
+			}
+		}
+
+		omnibus.Status = status
+		omnibus.FilledQty = filledQty
+		omnibus.FilledAvgPrice = filledAvgPrice
+		return tx.Omnibus.UpdateSettled(ctx, omnibus)
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().
+		Str("omnibus_order_id", omnibusID).
+		Str("status", status).
+		Int("orders", len(allocations)).
+		Float64("filled_qty", filledQty).
+		Float64("filled_avg_price", filledAvgPrice).
+		Msg("Omnibus order allocated")
+
+	return nil
+}
+
+// applyAllocation credits an order with its allocated shares and charges its
+// commission from the part of its lock the shares did not use
+func (s *Settler) applyAllocation(ctx context.Context, tx *repository.Tx, omnibus *types.OmnibusOrder, a *Allocation, status string, price float64, filledAt *time.Time, source string) error {
+	order := a.Order
+	order.FilledQty = a.Qty
+	if err := transition(ctx, tx, order, status, source, ""); err != nil {
+		return err
+	}
+
+	wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, order.UserID, "USD")
+	if err != nil {
+		return err
+	}
+
+	if a.Qty > 0 {
+		if err := tx.Holdings.Upsert(ctx, order.UserID, order.Symbol, a.Qty, price); err != nil {
+			return err
+		}
+		acquiredAt := time.Now().UTC()
+		if filledAt != nil {
+			acquiredAt = filledAt.UTC()
+		}
+		if err := openLot(ctx, tx, order, a.Qty, price, acquiredAt); err != nil {
+			return err
+		}
+		if err := tx.Wallets.DebitLocked(ctx, wallet.ID, a.Value); err != nil {
+			return err
+		}
+
+		fee, err := s.commissionDue(ctx, tx, order, a.Value)
+		if err != nil {
+			return err
+		}
+		fee = min(fee, a.Refund)
+		if fee > 0 {
+			if err := tx.Wallets.DebitLocked(ctx, wallet.ID, fee); err != nil {
+				return err
+			}
+			if err := recordFee(ctx, tx, order, wallet.ID, fee, a.Value); err != nil {
+				return err
+			}
+			a.Refund -= fee
+		}
+	}
+	if a.Refund > 0 {
+		if err := tx.Wallets.Unlock(ctx, wallet.ID, a.Refund); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Orders.UpdateAllocated(ctx, order.ID, a.Qty, price, status); err != nil {
+		return err
+	}
+
+	if err := tx.Omnibus.AddAllocation(ctx, &types.OmnibusAllocation{
+		OmnibusOrderID: omnibus.ID,
+		OrderID:        order.ID,
+		UserID:         order.UserID,
+		Amount:         order.Amount,
+		Weight:         a.Weight,
+		ExactQty:       a.ExactQty,
+		AllocatedQty:   a.Qty,
+		RoundingQty:    a.RoundingQty,
+		Price:          price,
+		Value:          a.Value,
+		Refunded:       a.Refund,
+	}); err != nil {
+		return err
+	}
+
+	if status != StatusFilled {
+		return nil
+	}
+
+	at := time.Now().UTC()
+	if filledAt != nil {
+		at = filledAt.UTC()
+	}
+	return tx.Outbox.Add(ctx, events.TopicOrderFilled, events.NewEvent(
+		events.EventTypeOrderFilled,
+		"trading-service",
+		events.OrderFilledPayload{
+			OrderID:        order.ID,
+			UserID:         order.UserID,
+			Symbol:         order.Symbol,
+			Side:           order.Side,
+			FilledQty:      a.Qty,
+			FilledAvgPrice: price,
+			TotalValue:     a.Value,
+			FilledAt:       at,
+		},
+	))
+}
+
+// FailOmnibus marks an omnibus order that was rejected or could not be
+// submitted as failed, along with its orders, and releases their locks
+func (s *Settler) FailOmnibus(ctx context.Context, omnibusID, reason, source string) error {
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		omnibus, err := lockOpenOmnibus(ctx, tx, omnibusID)
+		if err != nil {
+			return err
+		}
+
+		orders, err := tx.Orders.ListByOmnibusForUpdate(ctx, omnibusID)
+		if err != nil {
+			return err
+		}
+		for i := range orders {
+			if err := transition(ctx, tx, &orders[i], StatusFailed, source, reason); err != nil {
+				return err
+			}
+			if err := unlockFunds(ctx, tx, &orders[i]); err != nil {
+				return err
+			}
+			if err := tx.Orders.UpdateFailedByID(ctx, orders[i].ID, reason); err != nil {
+				return err
+			}
+		}
+
+		omnibus.Status = "failed"
+		return tx.Omnibus.UpdateSettled(ctx, omnibus)
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Str("omnibus_order_id", omnibusID).Str("reason", reason).Msg("Omnibus order failed")
+	return nil
+}
+
+// lockOpenOmnibus locks the omnibus order row so concurrent updates for it
+// serialize, and fails with ErrAlreadySettled if it is already final
+func lockOpenOmnibus(ctx context.Context, tx *repository.Tx, omnibusID string) (*types.OmnibusOrder, error) {
+	omnibus, err := tx.Omnibus.GetByIDForUpdate(ctx, omnibusID)
+	if err != nil {
+		return nil, err
+	}
+	if omnibus.Status != "pending" && omnibus.Status != "submitted" {
+		return nil, ErrAlreadySettled
+	}
+	return omnibus, nil
+}
@@ -0,0 +1,691 @@
+package settlement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/fees"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// Order update events, as sent by Alpaca in webhooks and trade updates
+const (
+	EventFill        = "fill"
+	EventPartialFill = "partial_fill"
+	EventCanceled    = "canceled"
+	EventExpired     = "expired"
+	EventRejected    = "rejected"
+)
+
+// ErrAlreadySettled is returned when an update targets an order that has
+// already reached a terminal state, e.g. a redelivered fill
+var ErrAlreadySettled = errors.New("order already settled")
+
+// ErrUnfundedFill is returned when a buy fill costs more than the order
+// locked and the wallet's available balance cannot cover the difference.
+// Redelivering the update does not help; the order needs a human.
+var ErrUnfundedFill = errors.New("fill exceeds the funds available to pay for it")
+
+// Settler applies broker order updates to local orders, holdings and
+// wallets, and charges commissions on what fills. Each transition runs in a
+// single transaction.
+type Settler struct {
+	uow    *repository.UnitOfWork
+	alpaca alpaca.TradingClient
+	fees   *fees.Engine
+}
+
+// New creates a new settler. A nil fee engine charges no commissions.
+func New(uow *repository.UnitOfWork, alpacaClient alpaca.TradingClient, feeEngine *fees.Engine) *Settler {
+	return &Settler{uow: uow, alpaca: alpacaClient, fees: feeEngine}
+}
+
+// Apply settles an order update event received from source. Unknown events
+// are ignored.
+func (s *Settler) Apply(ctx context.Context, order *types.Order, event string, update *types.AlpacaOrderUpdate, source string) error {
+	switch event {
+	case EventFill:
+		return s.Fill(ctx, order, update, source)
+	case EventPartialFill:
+		return s.PartialFill(ctx, order, update, source)
+	case EventCanceled, EventExpired:
+		return s.Cancel(ctx, order, update, source)
+	case EventRejected:
+		return s.Reject(ctx, order, "Order rejected by exchange", source)
+	}
+	return nil
+}
+
+// IsTerminal reports whether an order status is final
+func IsTerminal(status string) bool {
+	switch status {
+	case "filled", "canceled", "expired", "failed":
+		return true
+	}
+	return false
+}
+
+// EventForStatus maps a broker order status to the update event that brings
+// a local order in line with it. Working statuses map to "".
+func EventForStatus(status alpaca.OrderStatus) string {
+	switch status {
+	case alpaca.OrderStatusFilled:
+		return EventFill
+	case alpaca.OrderStatusPartiallyFilled:
+		return EventPartialFill
+	case alpaca.OrderStatusCanceled, alpaca.OrderStatusDoneForDay:
+		return EventCanceled
+	case alpaca.OrderStatusExpired:
+		return EventExpired
+	case alpaca.OrderStatusRejected:
+		return EventRejected
+	}
+	return ""
+}
+
+// UpdateFromOrder converts a broker order into an order update
+func UpdateFromOrder(o *alpaca.Order) *types.AlpacaOrderUpdate {
+	return &types.AlpacaOrderUpdate{
+		ID:             o.ID,
+		ClientOrderID:  o.ClientOrderID,
+		Symbol:         o.Symbol,
+		Side:           string(o.Side),
+		Type:           string(o.OrderType),
+		Qty:            o.Qty,
+		FilledQty:      o.FilledQty,
+		FilledAvgPrice: o.FilledAvgPrice,
+		Status:         string(o.Status),
+		CreatedAt:      o.CreatedAt,
+		UpdatedAt:      o.UpdatedAt,
+		FilledAt:       o.FilledAt,
+		CanceledAt:     o.CanceledAt,
+		FailedAt:       o.FailedAt,
+	}
+}
+
+// Fill settles a completed order. Any shares not yet settled by partial
+// fills, the release of the unspent lock and the order-filled event commit or
+// roll back together.
+func (s *Settler) Fill(ctx context.Context, order *types.Order, update *types.AlpacaOrderUpdate, source string) error {
+	filledQty, _ := strconv.ParseFloat(update.FilledQty, 64)
+	filledAvgPrice, _ := strconv.ParseFloat(update.FilledAvgPrice, 64)
+
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		current, err := lockOpenOrder(ctx, tx, order.AlpacaOrderID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := s.applyFill(ctx, tx, current, filledQty, filledAvgPrice); err != nil {
+			return err
+		}
+		if err := transition(ctx, tx, current, StatusFilled, source, ""); err != nil {
+			return err
+		}
+
+		// Unlock any excess that was locked
+		if err := unlockFunds(ctx, tx, current); err != nil {
+			return err
+		}
+
+		if err := tx.Orders.UpdateFill(ctx, order.AlpacaOrderID, current.FilledQty, current.FilledAvgPrice, 0, "filled"); err != nil {
+			return err
+		}
+
+		// The exits of a bracket or OTO order go live once its entry fills
+		released, err := tx.Orders.ReleaseLegs(ctx, current.ID)
+		if err != nil {
+			return err
+		}
+		for i := range released {
+			if err := transition(ctx, tx, &released[i], StatusNew, source, "Entry order filled"); err != nil {
+				return err
+			}
+		}
+
+		filledAt := time.Now().UTC()
+		if update.FilledAt != nil {
+			filledAt = update.FilledAt.UTC()
+		}
+
+		return tx.Outbox.Add(ctx, events.TopicOrderFilled, events.NewEvent(
+			events.EventTypeOrderFilled,
+			"trading-service",
+			events.OrderFilledPayload{
+				OrderID:        order.ID,
+				UserID:         order.UserID,
+				Symbol:         order.Symbol,
+				Side:           order.Side,
+				FilledQty:      current.FilledQty,
+				FilledAvgPrice: current.FilledAvgPrice,
+				TotalValue:     current.FilledQty * current.FilledAvgPrice,
+				FilledAt:       filledAt,
+			},
+		))
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().
+		Str("order_id", order.ID).
+		Float64("filled_qty", filledQty).
+		Float64("filled_avg_price", filledAvgPrice).
+		Msg("Order filled")
+
+	s.CancelLinked(ctx, order, source)
+	return nil
+}
+
+// PartialFill settles the shares filled since the last processed fill of a
+// working order and publishes an order-partial-fill event
+func (s *Settler) PartialFill(ctx context.Context, order *types.Order, update *types.AlpacaOrderUpdate, source string) error {
+	filledQty, _ := strconv.ParseFloat(update.FilledQty, 64)
+	filledAvgPrice, _ := strconv.ParseFloat(update.FilledAvgPrice, 64)
+
+	var settled fillIncrement
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		current, err := lockOpenOrder(ctx, tx, order.AlpacaOrderID)
+		if err != nil {
+			return err
+		}
+
+		settled, err = s.applyFill(ctx, tx, current, filledQty, filledAvgPrice)
+		if err != nil {
+			return err
+		}
+		if settled.Qty == 0 {
+			// Redelivered or out-of-order update; nothing new was filled
+			return nil
+		}
+		if err := transition(ctx, tx, current, StatusPartialFill, source, ""); err != nil {
+			return err
+		}
+
+		if err := tx.Orders.UpdateFill(ctx, order.AlpacaOrderID, current.FilledQty, current.FilledAvgPrice, current.LockedAmount, "partial_fill"); err != nil {
+			return err
+		}
+
+		orderQty, _ := strconv.ParseFloat(update.Qty, 64)
+		if orderQty == 0 {
+			orderQty = current.Qty
+		}
+
+		return tx.Outbox.Add(ctx, events.TopicOrderPartialFill, events.NewEvent(
+			events.EventTypeOrderPartialFill,
+			"trading-service",
+			events.OrderPartialFillPayload{
+				OrderID:        order.ID,
+				UserID:         order.UserID,
+				Symbol:         order.Symbol,
+				FilledQty:      current.FilledQty,
+				RemainingQty:   max(orderQty-current.FilledQty, 0),
+				FilledAvgPrice: current.FilledAvgPrice,
+			},
+		))
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().
+		Str("order_id", order.ID).
+		Float64("filled_qty", filledQty).
+		Float64("settled_qty", settled.Qty).
+		Float64("settled_value", settled.Value).
+		Msg("Order partially filled")
+
+	return nil
+}
+
+// Cancel marks an order canceled, settles any shares filled before the cancel
+// and releases the funds still locked for the unfilled remainder
+func (s *Settler) Cancel(ctx context.Context, order *types.Order, update *types.AlpacaOrderUpdate, source string) error {
+	filledQty, _ := strconv.ParseFloat(update.FilledQty, 64)
+	filledAvgPrice, _ := strconv.ParseFloat(update.FilledAvgPrice, 64)
+
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		current, err := lockOpenOrder(ctx, tx, order.AlpacaOrderID)
+		if err != nil {
+			return err
+		}
+
+		settled, err := s.applyFill(ctx, tx, current, filledQty, filledAvgPrice)
+		if err != nil {
+			return err
+		}
+		if settled.Qty > 0 {
+			if err := tx.Orders.UpdateFill(ctx, order.AlpacaOrderID, current.FilledQty, current.FilledAvgPrice, current.LockedAmount, current.Status); err != nil {
+				return err
+			}
+		}
+
+		if err := transition(ctx, tx, current, StatusCanceled, source, ""); err != nil {
+			return err
+		}
+		if err := tx.Orders.UpdateCanceled(ctx, order.AlpacaOrderID); err != nil {
+			return err
+		}
+		return unlockFunds(ctx, tx, current)
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Str("order_id", order.ID).Msg("Order canceled")
+
+	s.CancelLinked(ctx, order, source)
+	return nil
+}
+
+// Expire marks an order the broker has closed at its time-in-force deadline
+// expired, settles any shares filled before it closed and releases the funds
+// still locked for the unfilled remainder
+func (s *Settler) Expire(ctx context.Context, order *types.Order, update *types.AlpacaOrderUpdate, source, reason string) error {
+	filledQty, _ := strconv.ParseFloat(update.FilledQty, 64)
+	filledAvgPrice, _ := strconv.ParseFloat(update.FilledAvgPrice, 64)
+
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		current, err := lockOpenOrder(ctx, tx, order.AlpacaOrderID)
+		if err != nil {
+			return err
+		}
+
+		settled, err := s.applyFill(ctx, tx, current, filledQty, filledAvgPrice)
+		if err != nil {
+			return err
+		}
+		if settled.Qty > 0 {
+			if err := tx.Orders.UpdateFill(ctx, order.AlpacaOrderID, current.FilledQty, current.FilledAvgPrice, current.LockedAmount, current.Status); err != nil {
+				return err
+			}
+		}
+
+		if err := transition(ctx, tx, current, StatusExpired, source, reason); err != nil {
+			return err
+		}
+		if _, err := tx.Orders.MarkExpired(ctx, current.ID); err != nil {
+			return err
+		}
+		if err := unlockFunds(ctx, tx, current); err != nil {
+			return err
+		}
+
+		return tx.Outbox.Add(ctx, events.TopicOrderCancelled, events.NewEvent(
+			events.EventTypeOrderCancelled,
+			"trading-service",
+			events.OrderCancelledPayload{
+				OrderID:      order.ID,
+				UserID:       order.UserID,
+				Symbol:       order.Symbol,
+				CancelledAt:  time.Now().UTC(),
+				CancelReason: "expired",
+			},
+		))
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Str("order_id", order.ID).Msg("Order expired")
+
+	s.CancelLinked(ctx, order, source)
+	return nil
+}
+
+// Reject marks an order failed and releases the funds locked for it
+func (s *Settler) Reject(ctx context.Context, order *types.Order, reason, source string) error {
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		current, err := lockOpenOrder(ctx, tx, order.AlpacaOrderID)
+		if err != nil {
+			return err
+		}
+		if err := transition(ctx, tx, current, StatusFailed, source, reason); err != nil {
+			return err
+		}
+		if err := tx.Orders.UpdateFailed(ctx, order.AlpacaOrderID, reason); err != nil {
+			return err
+		}
+		return unlockFunds(ctx, tx, current)
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Str("order_id", order.ID).Str("reason", reason).Msg("Order rejected")
+
+	s.CancelLinked(ctx, order, source)
+	return nil
+}
+
+// CancelLinked cancels the open orders that can no longer fill now that order
+// has filled or closed, and settles them from the broker's view. Alpaca
+// cancels linked legs itself; this makes sure local state follows without
+// waiting for their updates. Failures are logged and left to the reconciler.
+func (s *Settler) CancelLinked(ctx context.Context, order *types.Order, source string) {
+	if order.OrderClass == "" || order.OrderClass == "simple" {
+		return
+	}
+
+	var linked []types.Order
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		var err error
+		linked, err = linkedOrders(ctx, tx, order)
+		return err
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to list linked orders")
+		return
+	}
+
+	for i := range linked {
+		s.CancelAtBroker(ctx, &linked[i], source)
+	}
+}
+
+// CancelAtBroker cancels an order at Alpaca and settles the state Alpaca
+// reports for it
+func (s *Settler) CancelAtBroker(ctx context.Context, order *types.Order, source string) {
+	if err := s.alpaca.CancelOrder(ctx, order.AlpacaOrderID); err != nil {
+		// Usually already canceled by Alpaca along with its linked order
+		logger.Debug().Err(err).Str("order_id", order.ID).Msg("Alpaca did not cancel linked order")
+	}
+
+	if _, err := s.Sync(ctx, order, source); err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to settle linked order")
+	}
+}
+
+// Sync settles the state Alpaca reports for an order and returns Alpaca's
+// status. An order still working, or pending cancel, at Alpaca is left to
+// the broker's updates; one already settled is not an error.
+func (s *Settler) Sync(ctx context.Context, order *types.Order, source string) (alpaca.OrderStatus, error) {
+	brokerOrder, err := s.alpaca.GetOrder(ctx, order.AlpacaOrderID)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch order from Alpaca: %w", err)
+	}
+
+	event := EventForStatus(brokerOrder.Status)
+	if event == "" {
+		return brokerOrder.Status, nil
+	}
+
+	err = s.Apply(ctx, order, event, UpdateFromOrder(brokerOrder), source)
+	if err != nil && !errors.Is(err, ErrAlreadySettled) {
+		return brokerOrder.Status, fmt.Errorf("failed to settle %s: %w", event, err)
+	}
+	return brokerOrder.Status, nil
+}
+
+// CancelQueued cancels an order still waiting for the omnibus aggregator and
+// releases its lock. It never reached Alpaca, so nothing can have filled. It
+// returns false if the order was aggregated first.
+func (s *Settler) CancelQueued(ctx context.Context, order *types.Order, source, reason string) (bool, error) {
+	var canceled bool
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		var err error
+		canceled, err = tx.Orders.CancelQueued(ctx, order.ID)
+		if err != nil || !canceled {
+			return err
+		}
+		if err := tx.Orders.AddEvent(ctx, NewOrderEvent(order, StatusCanceled, source, reason)); err != nil {
+			return err
+		}
+		return unlockFunds(ctx, tx, order)
+	})
+	return canceled, err
+}
+
+// FinishReplace saves the amendment pending on an order once Alpaca has
+// replaced it, pointing the order at the replacement, and releases what the
+// old terms locked beyond the new ones. It fails with ErrAlreadySettled if the
+// order is already final or the amendment was already saved or dropped.
+func (s *Settler) FinishReplace(ctx context.Context, order *types.Order, replacementID string) error {
+	return s.uow.Do(ctx, func(tx *repository.Tx) error {
+		locked, err := lockOpenOrder(ctx, tx, order.AlpacaOrderID)
+		if err != nil {
+			return err
+		}
+		pending := locked.PendingReplace
+		if pending == nil {
+			return ErrAlreadySettled
+		}
+
+		held := locked.LockedAmount + pending.ExtraLocked
+		previousAlpacaOrderID := locked.AlpacaOrderID
+		locked.AlpacaOrderID = replacementID
+		locked.ReplacesAlpacaOrderID = &previousAlpacaOrderID
+		pending.Apply(locked)
+		locked.PendingReplace = nil
+
+		if err := tx.Orders.UpdateAmended(ctx, locked); err != nil {
+			return err
+		}
+		if locked.Side == "buy" && held > locked.LockedAmount {
+			wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, locked.UserID, "USD")
+			if err != nil {
+				return err
+			}
+			if err := tx.Wallets.Unlock(ctx, wallet.ID, held-locked.LockedAmount); err != nil {
+				return err
+			}
+		}
+
+		*order = *locked
+		return nil
+	})
+}
+
+// DropReplace discards the amendment pending on an order once it is known
+// not to have replaced the order at Alpaca, releasing what was locked for
+// it. It fails with ErrAlreadySettled if there is nothing left to drop.
+func (s *Settler) DropReplace(ctx context.Context, order *types.Order) error {
+	return s.uow.Do(ctx, func(tx *repository.Tx) error {
+		locked, err := lockOpenOrder(ctx, tx, order.AlpacaOrderID)
+		if err != nil {
+			return err
+		}
+		pending := locked.PendingReplace
+		if pending == nil {
+			return ErrAlreadySettled
+		}
+
+		if err := tx.Orders.SetPendingReplace(ctx, locked.ID, nil); err != nil {
+			return err
+		}
+		if pending.ExtraLocked > 0 {
+			wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, locked.UserID, "USD")
+			if err != nil {
+				return err
+			}
+			if err := tx.Wallets.Unlock(ctx, wallet.ID, pending.ExtraLocked); err != nil {
+				return err
+			}
+		}
+
+		order.PendingReplace = nil
+		return nil
+	})
+}
+
+// linkedOrders returns the open orders that cannot fill once order has filled
+// or closed: the other legs of its parent, the parent itself when the two
+// are an OCO pair, and the order's own legs when they are its OCO pair or
+// are still held behind it
+func linkedOrders(ctx context.Context, tx *repository.Tx, order *types.Order) ([]types.Order, error) {
+	var candidates []types.Order
+
+	if order.ParentOrderID != nil {
+		parent, err := tx.Orders.GetByID(ctx, *order.ParentOrderID)
+		if err != nil {
+			return nil, err
+		}
+		legs, err := tx.Orders.ListLegs(ctx, parent.ID)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, legs...)
+		if parent.OrderClass == "oco" {
+			candidates = append(candidates, *parent)
+		}
+	} else {
+		legs, err := tx.Orders.ListLegs(ctx, order.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, leg := range legs {
+			if order.OrderClass == "oco" || leg.Status == "held" {
+				candidates = append(candidates, leg)
+			}
+		}
+	}
+
+	var linked []types.Order
+	for _, candidate := range candidates {
+		if candidate.ID != order.ID && !IsTerminal(candidate.Status) {
+			linked = append(linked, candidate)
+		}
+	}
+	return linked, nil
+}
+
+// lockOpenOrder locks the order row so concurrent updates for the same order
+// serialize, and fails with ErrAlreadySettled if the order is already final
+func lockOpenOrder(ctx context.Context, tx *repository.Tx, alpacaOrderID string) (*types.Order, error) {
+	order, err := tx.Orders.GetByAlpacaOrderIDForUpdate(ctx, alpacaOrderID)
+	if err != nil {
+		return nil, err
+	}
+	if IsTerminal(order.Status) {
+		return nil, ErrAlreadySettled
+	}
+	return order, nil
+}
+
+// fillIncrement is the part of an order's cumulative fill applied by a single
+// update
+type fillIncrement struct {
+	Qty   float64
+	Value float64
+}
+
+// applyFill settles the shares filled since the last processed fill, which is
+// the cumulative fill recorded on the order row. Buys add to holdings, open a
+// tax lot and are paid from the order's lock, then the available balance;
+// sells reduce holdings, consume tax lots and credit the proceeds.
+// The commission due on the new fill is charged from the lock or the
+// proceeds. A buy never pays more commission than it has locked. The order is
+// updated in place with the new cumulative fill and remaining lock; the
+// caller persists it.
+func (s *Settler) applyFill(ctx context.Context, tx *repository.Tx, order *types.Order, filledQty, filledAvgPrice float64) (fillIncrement, error) {
+	if filledQty <= order.FilledQty {
+		return fillIncrement{}, nil
+	}
+
+	inc := fillIncrement{
+		Qty:   filledQty - order.FilledQty,
+		Value: filledQty*filledAvgPrice - order.FilledQty*order.FilledAvgPrice,
+	}
+
+	wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, order.UserID, "USD")
+	if err != nil {
+		return inc, err
+	}
+
+	filledValue := filledQty * filledAvgPrice
+	fee, err := s.commissionDue(ctx, tx, order, filledValue)
+	if err != nil {
+		return inc, err
+	}
+
+	if order.Side == "buy" {
+		if err := tx.Holdings.Upsert(ctx, order.UserID, order.Symbol, inc.Qty, inc.Value/inc.Qty); err != nil {
+			return inc, err
+		}
+		if err := openLot(ctx, tx, order, inc.Qty, inc.Value/inc.Qty, time.Now().UTC()); err != nil {
+			return inc, err
+		}
+		if err := payFill(ctx, tx, order, wallet.ID, inc.Value); err != nil {
+			return inc, err
+		}
+
+		fee = min(fee, order.LockedAmount)
+		if fee > 0 {
+			if err := tx.Wallets.DebitLocked(ctx, wallet.ID, fee); err != nil {
+				return inc, err
+			}
+			order.LockedAmount -= fee
+		}
+	} else {
+		if err := tx.Holdings.ReduceQty(ctx, order.UserID, order.Symbol, inc.Qty); err != nil {
+			return inc, err
+		}
+		// Paid from the proceeds of this fill, and never more than them
+		fee = min(fee, inc.Value)
+		if err := tx.Wallets.Credit(ctx, wallet.ID, inc.Value-fee); err != nil {
+			return inc, err
+		}
+		if err := realizeSale(ctx, tx, order, inc.Qty, inc.Value, fee, time.Now().UTC()); err != nil {
+			return inc, err
+		}
+	}
+
+	if fee > 0 {
+		if err := recordFee(ctx, tx, order, wallet.ID, fee, filledValue); err != nil {
+			return inc, err
+		}
+	}
+
+	order.FilledQty = filledQty
+	order.FilledAvgPrice = filledAvgPrice
+	return inc, nil
+}
+
+// payFill pays for the shares a buy fill bought from the order's lock. A stop
+// or market fill that costs more than was locked takes the rest from the
+// available balance, failing with ErrUnfundedFill if that cannot cover it.
+func payFill(ctx context.Context, tx *repository.Tx, order *types.Order, walletID string, value float64) error {
+	fromLock := min(value, order.LockedAmount)
+	if fromLock > 0 {
+		if err := tx.Wallets.DebitLocked(ctx, walletID, fromLock); err != nil {
+			return err
+		}
+		order.LockedAmount -= fromLock
+	}
+
+	overrun := value - fromLock
+	if overrun <= 0 {
+		return nil
+	}
+	if err := tx.Wallets.Debit(ctx, walletID, overrun); err != nil {
+		if errors.Is(err, repository.ErrInsufficientBalance) {
+			return fmt.Errorf("%w: order %s filled for %.4f more than it locked", ErrUnfundedFill, order.ID, overrun)
+		}
+		return err
+	}
+	return nil
+}
+
+// unlockFunds releases what a buy order still has locked, including anything
+// locked for an amendment that was never saved
+func unlockFunds(ctx context.Context, tx *repository.Tx, order *types.Order) error {
+	amount := order.LockedAmount
+	if order.PendingReplace != nil {
+		amount += order.PendingReplace.ExtraLocked
+	}
+	if order.Side != "buy" || amount <= 0 {
+		return nil
+	}
+	wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, order.UserID, "USD")
+	if err != nil {
+		return err
+	}
+	return tx.Wallets.Unlock(ctx, wallet.ID, amount)
+}
@@ -0,0 +1,92 @@
+package settlement
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// Order statuses
+const (
+	StatusQueued      = "queued"       // Waiting to be aggregated into an omnibus order
+	StatusPending     = "pending"      // Submitted, not yet acknowledged by the broker
+	StatusHeld        = "held"         // Exit leg waiting for its entry to fill
+	StatusNew         = "new"          // Working at the broker
+	StatusPartialFill = "partial_fill" // Partly filled and still working
+	StatusFilled      = "filled"
+	StatusCanceled    = "canceled"
+	StatusExpired     = "expired"
+	StatusFailed      = "failed"
+)
+
+// Sources of order status transitions, recorded in the order history
+const (
+	SourceAPI        = "api"
+	SourceWebhook    = "webhook"
+	SourceStream     = "stream"
+	SourceReconciler = "reconciler"
+	SourceExpiry     = "expiry"
+	SourceAggregator = "aggregator"
+	SourceClosure    = "closure"
+)
+
+// ErrInvalidTransition is returned when an update would move an order to a
+// status it cannot reach from its current one
+var ErrInvalidTransition = errors.New("invalid order status transition")
+
+// IsTransitionAllowed checks if an order status transition is valid. Partial
+// fills may repeat as more of the order fills.
+func IsTransitionAllowed(from, to string) bool {
+	allowed := map[string][]string{
+		StatusQueued:      {StatusPending, StatusCanceled, StatusFailed},
+		StatusPending:     {StatusNew, StatusPartialFill, StatusFilled, StatusCanceled, StatusExpired, StatusFailed},
+		StatusHeld:        {StatusNew, StatusPartialFill, StatusFilled, StatusCanceled, StatusExpired, StatusFailed},
+		StatusNew:         {StatusPartialFill, StatusFilled, StatusCanceled, StatusExpired, StatusFailed},
+		StatusPartialFill: {StatusPartialFill, StatusFilled, StatusCanceled, StatusExpired, StatusFailed},
+		StatusFilled:      {}, // Terminal
+		StatusCanceled:    {}, // Terminal
+		StatusExpired:     {}, // Terminal
+		StatusFailed:      {}, // Terminal
+	}
+
+	for _, s := range allowed[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition checks that order may move to status and records the move in
+// its history. The caller persists the new status in the same transaction.
+func transition(ctx context.Context, tx *repository.Tx, order *types.Order, to, source, reason string) error {
+	if !IsTransitionAllowed(order.Status, to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, order.Status, to)
+	}
+
+	if err := tx.Orders.AddEvent(ctx, NewOrderEvent(order, to, source, reason)); err != nil {
+		return err
+	}
+
+	order.Status = to
+	return nil
+}
+
+// NewOrderEvent builds the history entry for moving order to a new status
+func NewOrderEvent(order *types.Order, to, source, reason string) *types.OrderEvent {
+	from := order.Status
+	event := &types.OrderEvent{
+		OrderID:    order.ID,
+		FromStatus: &from,
+		ToStatus:   to,
+		Source:     source,
+		FilledQty:  order.FilledQty,
+	}
+	if reason != "" {
+		event.Reason = &reason
+	}
+	return event
+}
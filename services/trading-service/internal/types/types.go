@@ -1,25 +1,121 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/fees"
+)
 
 // PlaceOrderRequest is the request to place a new order
 type PlaceOrderRequest struct {
-	Symbol string  `json:"symbol"`
-	Side   string  `json:"side"`   // buy, sell
-	Amount float64 `json:"amount"` // Dollar amount for fractional shares
-	Qty    float64 `json:"qty"`    // Number of shares (alternative to amount)
-	Source string  `json:"source"` // web, mobile, ussd
+	Symbol      string  `json:"symbol"`
+	Side        string  `json:"side"`          // buy, sell
+	Type        string  `json:"type"`          // market, limit, stop, stop_limit (default: market)
+	Amount      float64 `json:"amount"`        // Dollar amount for fractional shares (market orders only)
+	Qty         float64 `json:"qty"`           // Number of shares (alternative to amount)
+	LimitPrice  float64 `json:"limit_price"`   // Required for limit and stop_limit orders
+	StopPrice   float64 `json:"stop_price"`    // Required for stop and stop_limit orders
+	TimeInForce string  `json:"time_in_force"` // day, gtc, ioc, fok (default: day)
+	Source      string  `json:"source"`        // web, mobile, ussd
+	AmountKES   float64 `json:"amount_kes"`    // KES to convert and invest (market buys only, alternative to amount)
+	FXQuoteID   string  `json:"fx_quote_id"`   // Locked quote for amount_kes (optional, quoted on the fly otherwise)
+
+	// Market orders by qty are sent as limit orders this far through the
+	// quote, in basis points (optional, the configured default otherwise)
+	MaxSlippageBps int `json:"max_slippage_bps"`
+
+	OrderClass string             `json:"order_class"` // simple, bracket, oco, oto (default: simple)
+	TakeProfit *TakeProfitRequest `json:"take_profit"` // Limit exit for bracket, oco and oto orders
+	StopLoss   *StopLossRequest   `json:"stop_loss"`   // Stop exit for bracket, oco and oto orders
+
+	LotMethod string   `json:"lot_method"` // Tax lots a sell consumes: fifo, lifo, specific (default: fifo)
+	LotIDs    []string `json:"lot_ids"`    // Lots to sell first, for the specific method
+}
+
+// TakeProfitRequest is the take-profit leg of an advanced order
+type TakeProfitRequest struct {
+	LimitPrice float64 `json:"limit_price"`
+}
+
+// StopLossRequest is the stop-loss leg of an advanced order. A limit price
+// makes the leg a stop-limit order.
+type StopLossRequest struct {
+	StopPrice  float64 `json:"stop_price"`
+	LimitPrice float64 `json:"limit_price"`
 }
 
 // PlaceOrderResponse is the response after placing an order
 type PlaceOrderResponse struct {
-	OrderID       string  `json:"order_id"`
-	AlpacaOrderID string  `json:"alpaca_order_id"`
-	Symbol        string  `json:"symbol"`
-	Side          string  `json:"side"`
-	Amount        float64 `json:"amount"`
-	Status        string  `json:"status"`
-	Message       string  `json:"message"`
+	OrderID        string     `json:"order_id"`
+	AlpacaOrderID  string     `json:"alpaca_order_id"`
+	Symbol         string     `json:"symbol"`
+	Side           string     `json:"side"`
+	Type           string     `json:"type"`
+	Amount         float64    `json:"amount"`
+	Qty            float64    `json:"qty,omitempty"`
+	LimitPrice     float64    `json:"limit_price,omitempty"`
+	StopPrice      float64    `json:"stop_price,omitempty"`
+	MaxSlippageBps int        `json:"max_slippage_bps,omitempty"` // Band of a market order sent as a limit order
+	TimeInForce    string     `json:"time_in_force"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	OrderClass     string     `json:"order_class"`
+	Legs           []Order    `json:"legs,omitempty"`
+	EstimatedFee   float64    `json:"estimated_fee,omitempty"` // Commission quoted at placement
+	Commission     float64    `json:"commission"`              // Commission charged so far
+	Status         string     `json:"status"`
+	Message        string     `json:"message"`
+}
+
+// OrderQuoteResponse prices an order before it is placed
+type OrderQuoteResponse struct {
+	Symbol    string        `json:"symbol"`
+	Side      string        `json:"side"`
+	Type      string        `json:"type"`
+	Notional  float64       `json:"notional"` // Estimated USD value of the order
+	Fee       float64       `json:"fee"`      // Commission on the estimated value
+	Total     float64       `json:"total"`    // What a buy locks, or what a sell is expected to net
+	FeeTier   string        `json:"fee_tier"` // KYC tier whose schedule applies
+	Schedule  fees.Schedule `json:"schedule"`
+	AmountKES float64       `json:"amount_kes,omitempty"`
+	FXQuoteID string        `json:"fx_quote_id,omitempty"` // Locked conversion for a KES buy
+}
+
+// AmendOrderRequest is the request to change an open order. Zero values
+// leave the corresponding field unchanged.
+type AmendOrderRequest struct {
+	Qty         float64 `json:"qty"`
+	LimitPrice  float64 `json:"limit_price"`
+	StopPrice   float64 `json:"stop_price"`
+	TimeInForce string  `json:"time_in_force"`
+}
+
+// PendingReplace is an amendment sent to Alpaca that has not been saved on
+// the order yet. The reconciler applies it once Alpaca reports the order
+// replaced by the one with ClientOrderID, or drops it once Alpaca reports
+// the order was not replaced. ExtraLocked is what was locked on top of the
+// order's lock for the new terms, held until then.
+type PendingReplace struct {
+	ClientOrderID string     `json:"client_order_id"`
+	Qty           float64    `json:"qty"`
+	LimitPrice    *float64   `json:"limit_price,omitempty"`
+	StopPrice     *float64   `json:"stop_price,omitempty"`
+	TimeInForce   string     `json:"time_in_force"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	LockedAmount  float64    `json:"locked_amount"`
+	ExtraLocked   float64    `json:"extra_locked,omitempty"`
+}
+
+// Apply puts the amended terms on order
+func (p *PendingReplace) Apply(order *Order) {
+	order.Qty = p.Qty
+	order.LimitPrice = p.LimitPrice
+	order.StopPrice = p.StopPrice
+	order.TimeInForce = p.TimeInForce
+	order.ExpiresAt = p.ExpiresAt
+	if order.Side == "buy" {
+		order.LockedAmount = p.LockedAmount
+	}
 }
 
 // CancelOrderResponse is the response after canceling an order
@@ -31,25 +127,244 @@ type CancelOrderResponse struct {
 
 // Order represents a trading order
 type Order struct {
+	ID                    string     `json:"id"`
+	UserID                string     `json:"user_id"`
+	AlpacaOrderID         string     `json:"alpaca_order_id"`
+	ClientOrderID         string     `json:"client_order_id"`
+	IdempotencyKey        *string    `json:"idempotency_key,omitempty"`
+	Symbol                string     `json:"symbol"`
+	Side                  string     `json:"side"`
+	Type                  string     `json:"type"`
+	Amount                float64    `json:"amount"`
+	Qty                   float64    `json:"qty"`
+	LimitPrice            *float64   `json:"limit_price,omitempty"`
+	StopPrice             *float64   `json:"stop_price,omitempty"`
+	MaxSlippageBps        *int       `json:"max_slippage_bps,omitempty"` // Set when a market order was sent as a protective limit order
+	LockedAmount          float64    `json:"locked_amount"`
+	TimeInForce           string     `json:"time_in_force"`
+	ExpiresAt             *time.Time `json:"expires_at,omitempty"`
+	ReplacesAlpacaOrderID *string    `json:"replaces_alpaca_order_id,omitempty"`
+	OrderClass            string     `json:"order_class"`               // simple, bracket, oco, oto
+	ParentOrderID         *string    `json:"parent_order_id,omitempty"` // Set on the exit legs of advanced orders
+	Leg                   *string    `json:"leg,omitempty"`             // take_profit, stop_loss
+	BasketID              *string    `json:"basket_id,omitempty"`
+	OmnibusOrderID        *string    `json:"omnibus_order_id,omitempty"` // Set once a queued order is aggregated
+	FilledQty             float64    `json:"filled_qty"`
+	FilledAvgPrice        float64    `json:"filled_avg_price"`
+	Commission            float64    `json:"commission"` // Fees charged so far on the filled value
+	LotMethod             string     `json:"lot_method"` // fifo, lifo, specific
+	LotIDs                []string   `json:"lot_ids,omitempty"`
+	Status                string     `json:"status"`
+	Source                string     `json:"source"`
+	FailedReason          *string    `json:"failed_reason,omitempty"`
+	FilledAt              *time.Time `json:"filled_at,omitempty"`
+	CanceledAt            *time.Time `json:"canceled_at,omitempty"`
+	CancelRequestedAt     *time.Time `json:"cancel_requested_at,omitempty"` // Set while a cancel waits for the broker
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+	Legs                  []Order    `json:"legs,omitempty"`
+
+	// PendingReplace is set while an amendment waits for the broker
+	PendingReplace *PendingReplace `json:"-"`
+}
+
+// OrderEvent records one status transition of an order
+type OrderEvent struct {
+	ID         string    `json:"id"`
+	OrderID    string    `json:"order_id"`
+	FromStatus *string   `json:"from_status,omitempty"` // Unset for the event that created the order
+	ToStatus   string    `json:"to_status"`
+	Source     string    `json:"source"` // api, webhook, stream, reconciler, expiry, aggregator, closure
+	Reason     *string   `json:"reason,omitempty"`
+	FilledQty  float64   `json:"filled_qty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PlaceBasketRequest is the request to invest one amount across several
+// symbols by weight
+type PlaceBasketRequest struct {
+	Name   string             `json:"name"`   // e.g. "Top Tech"
+	Amount float64            `json:"amount"` // Total USD to invest
+	Legs   []BasketLegRequest `json:"legs"`
+	Source string             `json:"source"` // web, mobile, ussd
+}
+
+// BasketLegRequest is one symbol of a basket and its share of the amount
+type BasketLegRequest struct {
+	Symbol string  `json:"symbol"`
+	Weight float64 `json:"weight"` // Percentage of the amount; weights sum to 100
+}
+
+// Basket is a set of notional buy orders placed together from one amount
+type Basket struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	Name           string    `json:"name"`
+	Amount         float64   `json:"amount"`
+	Status         string    `json:"status"`      // pending, placed, partially_placed, failed
+	FillStatus     string    `json:"fill_status"` // open, filled, partially_filled, unfilled
+	FilledValue    float64   `json:"filled_value"`
+	IdempotencyKey *string   `json:"idempotency_key,omitempty"`
+	Source         string    `json:"source"`
+	Orders         []Order   `json:"orders"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// SetOrders attaches the basket's orders and derives its aggregate fill
+// status from them. A basket is open while any order is working; once all are
+// closed it is filled, partially filled or unfilled.
+func (b *Basket) SetOrders(orders []Order) {
+	b.Orders = orders
+	b.FilledValue = 0
+
+	var open, filled int
+	for _, o := range orders {
+		b.FilledValue += o.FilledQty * o.FilledAvgPrice
+		switch o.Status {
+		case "queued", "pending", "new", "partial_fill":
+			open++
+		case "filled":
+			filled++
+		}
+	}
+
+	switch {
+	case open > 0:
+		b.FillStatus = "open"
+	case filled > 0 && filled == len(orders):
+		b.FillStatus = "filled"
+	case b.FilledValue > 0:
+		b.FillStatus = "partially_filled"
+	default:
+		b.FillStatus = "unfilled"
+	}
+}
+
+// OmnibusOrder is a single broker order placed for the queued small
+// notional buys of many users in one symbol
+type OmnibusOrder struct {
 	ID             string     `json:"id"`
-	UserID         string     `json:"user_id"`
-	AlpacaOrderID  string     `json:"alpaca_order_id"`
 	Symbol         string     `json:"symbol"`
-	Side           string     `json:"side"`
-	Type           string     `json:"type"`
-	Amount         float64    `json:"amount"`
-	Qty            float64    `json:"qty"`
+	Notional       float64    `json:"notional"`
+	OrderCount     int        `json:"order_count"`
+	AlpacaOrderID  *string    `json:"alpaca_order_id,omitempty"`
+	ClientOrderID  string     `json:"client_order_id"`
+	Status         string     `json:"status"` // pending, submitted, filled, canceled, failed
 	FilledQty      float64    `json:"filled_qty"`
 	FilledAvgPrice float64    `json:"filled_avg_price"`
-	Status         string     `json:"status"`
-	Source         string     `json:"source"`
-	FailedReason   *string    `json:"failed_reason,omitempty"`
-	FilledAt       *time.Time `json:"filled_at,omitempty"`
-	CanceledAt     *time.Time `json:"canceled_at,omitempty"`
+	SettledAt      *time.Time `json:"settled_at,omitempty"`
 	CreatedAt      time.Time  `json:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at"`
 }
 
+// OmnibusAllocation records the shares of an omnibus fill allocated to one
+// of its orders
+type OmnibusAllocation struct {
+	ID             string    `json:"id"`
+	OmnibusOrderID string    `json:"omnibus_order_id"`
+	OrderID        string    `json:"order_id"`
+	UserID         string    `json:"user_id"`
+	Amount         float64   `json:"amount"`        // The order's notional amount
+	Weight         float64   `json:"weight"`        // The order's share of the omnibus notional
+	ExactQty       float64   `json:"exact_qty"`     // Pro-rata share of the filled qty before rounding
+	AllocatedQty   float64   `json:"allocated_qty"` // Shares credited after rounding
+	RoundingQty    float64   `json:"rounding_qty"`  // Shares added by distributing the rounding remainder
+	Price          float64   `json:"price"`
+	Value          float64   `json:"value"`    // Amount debited for the allocated shares
+	Refunded       float64   `json:"refunded"` // Unspent amount released back to the wallet
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// CorporateAction is a dividend, split or symbol change ingested from the
+// corporate actions provider
+type CorporateAction struct {
+	ID          string     `json:"id"`
+	ProviderRef string     `json:"provider_ref"`
+	Type        string     `json:"type"` // cash_dividend, stock_split, symbol_change
+	Symbol      string     `json:"symbol"`
+	NewSymbol   *string    `json:"new_symbol,omitempty"`
+	Rate        float64    `json:"rate"`       // Cash per share, for dividends
+	SplitFrom   float64    `json:"split_from"` // Old shares, for splits
+	SplitTo     float64    `json:"split_to"`   // New shares they become
+	ExDate      time.Time  `json:"ex_date"`
+	PayableDate *time.Time `json:"payable_date,omitempty"`
+	Status      string     `json:"status"`  // pending, entitled, applied
+	Holders     int        `json:"holders"` // Holdings the action applied to
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// CorporateActionEntry records what one holder received from a corporate
+// action
+type CorporateActionEntry struct {
+	ID             string     `json:"id"`
+	ActionID       string     `json:"action_id"`
+	UserID         string     `json:"user_id"`
+	Type           string     `json:"type"`
+	Symbol         string     `json:"symbol"`
+	NewSymbol      *string    `json:"new_symbol,omitempty"`
+	ExDate         time.Time  `json:"ex_date"`
+	QtyBefore      float64    `json:"qty_before"`
+	QtyAfter       float64    `json:"qty_after"`
+	AvgCostBefore  float64    `json:"avg_cost_before"`
+	AvgCostAfter   float64    `json:"avg_cost_after"`
+	GrossAmount    float64    `json:"gross_amount"`
+	WithholdingTax float64    `json:"withholding_tax"`
+	NetAmount      float64    `json:"net_amount"`
+	PaidAt         *time.Time `json:"paid_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// TaxLot is a block of shares bought in one fill. Sells consume lots and
+// realize a gain or loss on each.
+type TaxLot struct {
+	ID           string     `json:"id"`
+	UserID       string     `json:"user_id"`
+	Symbol       string     `json:"symbol"`
+	OrderID      *string    `json:"order_id,omitempty"` // Unset for lots carried over from before lots were tracked
+	Qty          float64    `json:"qty"`                // Shares bought
+	RemainingQty float64    `json:"remaining_qty"`      // Shares still held
+	CostPerShare float64    `json:"cost_per_share"`
+	CostBasis    float64    `json:"cost_basis"` // Cost of the remaining shares
+	AcquiredAt   time.Time  `json:"acquired_at"`
+	ClosedAt     *time.Time `json:"closed_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// RealizedGain is the gain or loss realized on the shares of one lot sold
+type RealizedGain struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Symbol     string    `json:"symbol"`
+	OrderID    string    `json:"order_id"`
+	LotID      *string   `json:"lot_id,omitempty"` // Unset for shares no lot covered
+	Qty        float64   `json:"qty"`
+	Proceeds   float64   `json:"proceeds"`
+	CostBasis  float64   `json:"cost_basis"`
+	Fee        float64   `json:"fee"`
+	RealizedPL float64   `json:"realized_pl"`
+	Term       string    `json:"term"` // short, long
+	AcquiredAt time.Time `json:"acquired_at"`
+	SoldAt     time.Time `json:"sold_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RealizedGainSummary totals the gains realized in a symbol in a year
+type RealizedGainSummary struct {
+	Symbol      string  `json:"symbol"`
+	Year        int     `json:"year"`
+	Qty         float64 `json:"qty"`
+	Proceeds    float64 `json:"proceeds"`
+	CostBasis   float64 `json:"cost_basis"`
+	Fees        float64 `json:"fees"`
+	RealizedPL  float64 `json:"realized_pl"`
+	ShortTermPL float64 `json:"short_term_pl"`
+	LongTermPL  float64 `json:"long_term_pl"`
+}
+
 // Holding represents a user's stock holding
 type Holding struct {
 	ID              string    `json:"id"`
@@ -76,10 +391,20 @@ type Portfolio struct {
 
 // User represents user info needed for trading
 type User struct {
-	ID            string  `json:"id"`
-	Phone         string  `json:"phone"`
-	IsActive      bool    `json:"is_active"`
-	IsKYCVerified bool    `json:"is_kyc_verified"`
+	ID            string `json:"id"`
+	Phone         string `json:"phone"`
+	IsActive      bool   `json:"is_active"`
+	IsKYCVerified bool   `json:"is_kyc_verified"`
+	KYCTier       string `json:"kyc_tier"`
+}
+
+// TradingTier is the KYC tier whose limits and fees apply to the user: their
+// tier once verified, and "" (the unverified tier) until then
+func (u *User) TradingTier() string {
+	if !u.IsKYCVerified {
+		return ""
+	}
+	return u.KYCTier
 }
 
 // Wallet represents user wallet info
@@ -98,6 +423,221 @@ func (w *Wallet) AvailableBalance() float64 {
 	return w.Balance - w.LockedBalance
 }
 
+// LedgerEntry is a completed wallet movement recorded in the transactions table
+type LedgerEntry struct {
+	ID          string         `json:"id"`
+	UserID      string         `json:"user_id"`
+	WalletID    string         `json:"wallet_id"`
+	Type        string         `json:"type"` // deposit, withdrawal, buy, sell, fee, dividend, transfer
+	Amount      float64        `json:"amount"`
+	Fee         float64        `json:"fee"` // Commission charged, for fee entries
+	Currency    string         `json:"currency"`
+	Reference   string         `json:"reference"`
+	Description string         `json:"description"`
+	Metadata    map[string]any `json:"metadata"`
+}
+
+// TradingHalt is a trading control set by operations: a global halt, a
+// symbol halt, sell-only mode or an account freeze
+type TradingHalt struct {
+	Scope  string    `json:"scope"`            // global, symbol, sell_only, user
+	Target string    `json:"target,omitempty"` // Symbol or user ID, for symbol and user scopes
+	Reason string    `json:"reason,omitempty"`
+	SetBy  string    `json:"set_by"`
+	SetAt  time.Time `json:"set_at"`
+}
+
+// SetTradingHaltRequest is the request to set a trading halt
+type SetTradingHaltRequest struct {
+	Scope  string `json:"scope"`
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// TradingHaltEvent is the audit record of a halt being set or cleared
+type TradingHaltEvent struct {
+	ID        string    `json:"id"`
+	Action    string    `json:"action"` // set, cleared
+	Scope     string    `json:"scope"`
+	Target    string    `json:"target,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Actor     string    `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HoldingTotal is the number of shares users hold in a symbol
+type HoldingTotal struct {
+	Symbol  string
+	Qty     float64
+	Holders int
+}
+
+// PositionReconciliation is one comparison of user holdings, summed per
+// symbol, with the positions held at the broker
+type PositionReconciliation struct {
+	ID             string          `json:"id"`
+	Trigger        string          `json:"trigger"` // scheduled, manual
+	RunDate        time.Time       `json:"run_date"`
+	Status         string          `json:"status"` // running, completed, failed
+	SymbolsChecked int             `json:"symbols_checked"`
+	DriftCount     int             `json:"drift_count"`
+	Error          *string         `json:"error,omitempty"`
+	StartedBy      string          `json:"started_by"`
+	StartedAt      time.Time       `json:"started_at"`
+	CompletedAt    *time.Time      `json:"completed_at,omitempty"`
+	Drifts         []PositionDrift `json:"drifts,omitempty"`
+}
+
+// PositionDrift is a symbol whose holdings disagreed with the broker in a
+// reconciliation
+type PositionDrift struct {
+	ID               string     `json:"id"`
+	ReconciliationID string     `json:"reconciliation_id"`
+	Symbol           string     `json:"symbol"`
+	LocalQty         float64    `json:"local_qty"`
+	BrokerQty        float64    `json:"broker_qty"`
+	Drift            float64    `json:"drift"` // broker_qty - local_qty
+	Holders          int        `json:"holders"`
+	Status           string     `json:"status"` // open, corrected
+	CorrectedBy      *string    `json:"corrected_by,omitempty"`
+	CorrectedAt      *time.Time `json:"corrected_at,omitempty"`
+	CorrectionNote   *string    `json:"correction_note,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
+
+// CorrectPositionDriftRequest confirms a correction of holdings to the
+// broker's position. The operator repeats the symbol and broker qty of the
+// drift being corrected.
+type CorrectPositionDriftRequest struct {
+	ConfirmSymbol    string   `json:"confirm_symbol"`
+	ConfirmBrokerQty *float64 `json:"confirm_broker_qty"`
+	Note             string   `json:"note"`
+}
+
+// AccountClosure is a user's request to close their account. Its status is
+// the step the closure has reached.
+type AccountClosure struct {
+	ID                   string     `json:"id"`
+	UserID               string     `json:"user_id"`
+	Phone                string     `json:"phone"`
+	Status               string     `json:"status"` // canceling_orders, liquidating, awaiting_fills, converting, paying_out, awaiting_payout, closed, failed
+	SellRound            int        `json:"sell_round"`
+	ConvertedUSD         float64    `json:"converted_usd"`
+	ConvertedKES         float64    `json:"converted_kes"`
+	PaidOutKES           float64    `json:"paid_out_kes"`
+	PayoutAmount         *float64   `json:"payout_amount,omitempty"` // KES payout in flight
+	PayoutAttempts       int        `json:"payout_attempts"`
+	PayoutConversationID *string    `json:"-"`
+	PayoutRequestedAt    *time.Time `json:"payout_requested_at,omitempty"`
+	PayoutResultCode     *int       `json:"-"`
+	PayoutResultDesc     *string    `json:"-"`
+	PayoutReceipt        *string    `json:"payout_receipt,omitempty"`
+	ResidualKES          *float64   `json:"residual_kes,omitempty"` // Left below the smallest M-Pesa payout
+	Reason               *string    `json:"reason,omitempty"`       // Why the closure is waiting or failed
+	NextCheckAt          time.Time  `json:"-"`
+	ClosedAt             *time.Time `json:"closed_at,omitempty"`
+	CreatedAt            time.Time  `json:"created_at"`
+	UpdatedAt            time.Time  `json:"updated_at"`
+}
+
+// CloseAccountRequest is the request to close the user's account. Closing
+// sells every holding, so the user must confirm it.
+type CloseAccountRequest struct {
+	Confirm bool `json:"confirm"`
+}
+
+// FXQuoteRequest is the request to quote a currency conversion
+type FXQuoteRequest struct {
+	From   string  `json:"from"`   // KES, USD
+	To     string  `json:"to"`     // KES, USD
+	Amount float64 `json:"amount"` // Amount of the from currency
+}
+
+// InvestmentPlan is a recurring investment of a fixed amount in a symbol
+type InvestmentPlan struct {
+	ID        string     `json:"id"`
+	UserID    string     `json:"user_id"`
+	Symbol    string     `json:"symbol"`
+	Amount    float64    `json:"amount"`
+	Currency  string     `json:"currency"`  // KES, USD
+	Frequency string     `json:"frequency"` // weekly, monthly
+	Status    string     `json:"status"`    // active, paused, canceled
+	NextRunAt time.Time  `json:"next_run_at"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	Attempts  int        `json:"attempts"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Advance moves NextRunAt forward by the plan's frequency until it is after
+// now. Missed periods are skipped rather than run back to back.
+func (p *InvestmentPlan) Advance(now time.Time) {
+	for !p.NextRunAt.After(now) {
+		if p.Frequency == "monthly" {
+			p.NextRunAt = p.NextRunAt.AddDate(0, 1, 0)
+		} else {
+			p.NextRunAt = p.NextRunAt.AddDate(0, 0, 7)
+		}
+	}
+}
+
+// PlanRun records one attempt to run an investment plan
+type PlanRun struct {
+	ID           string    `json:"id"`
+	PlanID       string    `json:"plan_id"`
+	ScheduledFor time.Time `json:"scheduled_for"`
+	Attempt      int       `json:"attempt"`
+	Status       string    `json:"status"` // placed, retrying, skipped, failed
+	OrderID      *string   `json:"order_id,omitempty"`
+	Reason       *string   `json:"reason,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreatePlanRequest is the request to create an investment plan
+type CreatePlanRequest struct {
+	Symbol    string     `json:"symbol"`
+	Amount    float64    `json:"amount"`
+	Currency  string     `json:"currency"`           // KES, USD (default: USD)
+	Frequency string     `json:"frequency"`          // weekly, monthly
+	StartAt   *time.Time `json:"start_at,omitempty"` // First run (default: now)
+}
+
+// UpdatePlanRequest is the request to change an investment plan. Zero values
+// leave the corresponding field unchanged.
+type UpdatePlanRequest struct {
+	Amount    float64 `json:"amount"`
+	Frequency string  `json:"frequency"` // weekly, monthly
+	Status    string  `json:"status"`    // active, paused
+}
+
+// ConditionalOrder is an order held back until a price condition on its
+// symbol is met
+type ConditionalOrder struct {
+	ID           string            `json:"id"`
+	UserID       string            `json:"user_id"`
+	Symbol       string            `json:"symbol"`
+	Condition    string            `json:"condition"` // price_above, price_below, change_from_open, position_gain
+	Threshold    float64           `json:"threshold"` // Price, or signed percentage for relative conditions
+	Order        PlaceOrderRequest `json:"order"`
+	Status       string            `json:"status"` // active, triggered, placed, failed, canceled, expired
+	ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+	TriggeredAt  *time.Time        `json:"triggered_at,omitempty"`
+	TriggerPrice *float64          `json:"trigger_price,omitempty"`
+	OrderID      *string           `json:"order_id,omitempty"`
+	Reason       *string           `json:"reason,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// CreateConditionalOrderRequest is the request to create a conditional order
+type CreateConditionalOrderRequest struct {
+	Condition string             `json:"condition"`
+	Threshold float64            `json:"threshold"`
+	Order     *PlaceOrderRequest `json:"order"`                // Placed when the condition is met
+	ExpiresAt *time.Time         `json:"expires_at,omitempty"` // Optional; active until canceled otherwise
+}
+
 // AlpacaWebhookEvent represents an Alpaca trade update webhook
 type AlpacaWebhookEvent struct {
 	Event string            `json:"event"`
@@ -143,3 +683,12 @@ type AssetInfo struct {
 	Tradable     bool   `json:"tradable"`
 	Fractionable bool   `json:"fractionable"`
 }
+
+// OutboxEvent is an event waiting in the transactional outbox
+type OutboxEvent struct {
+	ID        string       `json:"id"`
+	Topic     string       `json:"topic"`
+	Event     events.Event `json:"event"`
+	Attempts  int          `json:"attempts"`
+	CreatedAt time.Time    `json:"created_at"`
+}
@@ -0,0 +1,629 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/pkg/mpesa"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/exchange"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/risk"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/settlement"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// Account closure statuses, in the order a closure moves through them
+const (
+	ClosureCancelingOrders = "canceling_orders"
+	ClosureLiquidating     = "liquidating"
+	ClosureAwaitingFills   = "awaiting_fills"
+	ClosureConverting      = "converting"
+	ClosurePayingOut       = "paying_out"
+	ClosureAwaitingPayout  = "awaiting_payout"
+	ClosureClosed          = "closed"
+	ClosureFailed          = "failed"
+)
+
+const (
+	// closureBatchSize caps how many closures are advanced per tick
+	closureBatchSize = 20
+
+	// closureLease is how long a claimed closure is hidden from other ticks
+	// and replicas while it is advanced
+	closureLease = 5 * time.Minute
+
+	// closureMaxSellRounds is how many rounds of sell orders are placed
+	// before a closure whose holdings will not sell is failed
+	closureMaxSellRounds = 5
+
+	// minSellQty is the smallest fractional qty the broker sells; anything
+	// less is left in the holding
+	minSellQty = 0.000001
+
+	// minPayoutKES is the smallest B2C payment M-Pesa makes
+	minPayoutKES = 10
+)
+
+var accountClosures = metrics.RegisterCounter(
+	"trading_account_closures_total",
+	"Finished account closures by status (closed, failed)",
+	[]string{"status"},
+)
+
+// Payouts sends M-Pesa B2C payments
+type Payouts interface {
+	B2C(ctx context.Context, phone string, amount int, reference string, b2cConfig *mpesa.B2CConfig) (*mpesa.B2CResponse, error)
+}
+
+// AccountCloser works account closures through to the end: open orders are
+// canceled, holdings sold and, once every order is final, the USD balance is
+// converted to KES and paid out to the user's M-Pesa number in one or more
+// B2C payments. The account is then deactivated. A closure that is waiting
+// (on the market, on fills, on M-Pesa) is checked again every interval.
+//
+// A payout is debited from the KES wallet before it is requested and only
+// credited back when M-Pesa rejects the request or reports it failed. When
+// the outcome is unknown the closure fails with the payout still debited, so
+// that starting a new closure can never pay the same balance twice.
+type AccountCloser struct {
+	uow               *repository.UnitOfWork
+	closureRepo       *repository.AccountClosureRepository
+	orderRepo         *repository.OrderRepository
+	holdingRepo       *repository.HoldingRepository
+	walletRepo        *repository.WalletRepository
+	settler           *settlement.Settler
+	placer            OrderPlacer
+	exchanger         *exchange.Exchanger
+	alpaca            alpaca.TradingClient
+	payouts           Payouts
+	b2c               *mpesa.B2CConfig
+	interval          time.Duration
+	maxPayoutKES      int
+	maxPayoutAttempts int
+	payoutTimeout     time.Duration
+}
+
+// NewAccountCloser creates a new account closure worker. Balances above
+// maxPayoutKES are paid out in several payments; a closure fails after
+// maxPayoutAttempts failed payouts, or when a payout has no result within
+// payoutTimeout.
+func NewAccountCloser(
+	uow *repository.UnitOfWork,
+	closureRepo *repository.AccountClosureRepository,
+	orderRepo *repository.OrderRepository,
+	holdingRepo *repository.HoldingRepository,
+	walletRepo *repository.WalletRepository,
+	settler *settlement.Settler,
+	placer OrderPlacer,
+	exchanger *exchange.Exchanger,
+	alpacaClient alpaca.TradingClient,
+	payouts Payouts,
+	b2c *mpesa.B2CConfig,
+	interval time.Duration,
+	maxPayoutKES int,
+	maxPayoutAttempts int,
+	payoutTimeout time.Duration,
+) *AccountCloser {
+	return &AccountCloser{
+		uow:               uow,
+		closureRepo:       closureRepo,
+		orderRepo:         orderRepo,
+		holdingRepo:       holdingRepo,
+		walletRepo:        walletRepo,
+		settler:           settler,
+		placer:            placer,
+		exchanger:         exchanger,
+		alpaca:            alpacaClient,
+		payouts:           payouts,
+		b2c:               b2c,
+		interval:          interval,
+		maxPayoutKES:      maxPayoutKES,
+		maxPayoutAttempts: maxPayoutAttempts,
+		payoutTimeout:     payoutTimeout,
+	}
+}
+
+// Run advances due account closures on every tick until the context is
+// canceled
+func (w *AccountCloser) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	logger.Info().
+		Dur("interval", w.interval).
+		Int("max_payout_kes", w.maxPayoutKES).
+		Dur("payout_timeout", w.payoutTimeout).
+		Msg("Account closure worker started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Account closure worker stopped")
+			return
+		case <-ticker.C:
+			w.tick(ctx)
+		}
+	}
+}
+
+func (w *AccountCloser) tick(ctx context.Context) {
+	closures, err := w.closureRepo.ClaimDue(ctx, time.Now().Add(closureLease), closureBatchSize)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to claim due account closures")
+		return
+	}
+
+	for i := range closures {
+		w.advance(ctx, &closures[i])
+	}
+}
+
+// advance runs a closure's steps until one has to wait, then saves when the
+// closure is next checked
+func (w *AccountCloser) advance(ctx context.Context, c *types.AccountClosure) {
+	for {
+		var next bool
+		var err error
+		switch c.Status {
+		case ClosureCancelingOrders:
+			next, err = w.cancelOrders(ctx, c)
+		case ClosureLiquidating:
+			next, err = w.liquidate(ctx, c)
+		case ClosureAwaitingFills:
+			next, err = w.awaitFills(ctx, c)
+		case ClosureConverting:
+			next, err = w.convert(ctx, c)
+		case ClosurePayingOut:
+			next, err = w.payOut(ctx, c)
+		case ClosureAwaitingPayout:
+			next, err = w.awaitPayout(ctx, c)
+		default:
+			return
+		}
+		if err != nil {
+			// The lease expires and the step is tried again
+			logger.Error().Err(err).Str("closure_id", c.ID).Str("status", c.Status).Msg("Failed to advance account closure")
+			return
+		}
+		if !next {
+			break
+		}
+	}
+
+	if c.Status == ClosureClosed || c.Status == ClosureFailed {
+		return
+	}
+	c.NextCheckAt = time.Now().Add(w.interval)
+	if err := w.closureRepo.Save(ctx, c); err != nil {
+		logger.Error().Err(err).Str("closure_id", c.ID).Msg("Failed to save account closure")
+	}
+}
+
+// cancelOrders stops the user's plans and conditional orders and cancels
+// their open orders. Orders already submitted in an omnibus order cannot be
+// canceled; the closure waits for them to fill.
+func (w *AccountCloser) cancelOrders(ctx context.Context, c *types.AccountClosure) (bool, error) {
+	err := w.uow.Do(ctx, func(tx *repository.Tx) error {
+		if _, err := tx.Plans.CancelByUser(ctx, c.UserID); err != nil {
+			return err
+		}
+		_, err := tx.Conditionals.CancelByUser(ctx, c.UserID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+
+	orders, err := w.orderRepo.ListOpenByUser(ctx, c.UserID)
+	if err != nil {
+		return false, err
+	}
+	for i := range orders {
+		if err := w.cancelOrder(ctx, &orders[i]); err != nil {
+			return false, err
+		}
+	}
+
+	open, err := w.orderRepo.ListOpenByUser(ctx, c.UserID)
+	if err != nil {
+		return false, err
+	}
+	if len(open) > 0 {
+		wait(c, fmt.Sprintf("Waiting for %d open orders to be canceled", len(open)))
+		return false, nil
+	}
+
+	return true, w.transition(ctx, c, ClosureLiquidating, "", nil)
+}
+
+// cancelOrder cancels one open order. A queued order never reached the
+// broker, so only its lock is released; others are canceled at the broker
+// and settled from the state it reports.
+func (w *AccountCloser) cancelOrder(ctx context.Context, order *types.Order) error {
+	if order.Status != settlement.StatusQueued {
+		if order.OmnibusOrderID == nil {
+			w.settler.CancelAtBroker(ctx, order, settlement.SourceClosure)
+		}
+		return nil
+	}
+
+	_, err := w.settler.CancelQueued(ctx, order, settlement.SourceClosure, "Canceled to close the account")
+	return err
+}
+
+// liquidate places a market sell for every holding. Each round of sells has
+// its own idempotency keys, so a round interrupted part way is completed
+// without selling any holding twice. Holdings whose sell cannot be placed
+// are left for the next round, so they count towards closureMaxSellRounds.
+func (w *AccountCloser) liquidate(ctx context.Context, c *types.AccountClosure) (bool, error) {
+	clock, err := w.alpaca.GetClock(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !clock.IsOpen {
+		wait(c, "Waiting for the market to open to sell holdings")
+		return false, nil
+	}
+
+	holdings, err := w.holdingRepo.ListByUser(ctx, c.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	var unsold []string
+	for _, h := range holdings {
+		qty := sellableQty(h.Qty)
+		if qty <= 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("closure:%s:%d:%s", c.ID, c.SellRound, h.Symbol)
+		existing, err := w.orderRepo.GetByIdempotencyKey(ctx, c.UserID, key)
+		if err != nil {
+			return false, err
+		}
+		if existing != nil {
+			continue
+		}
+
+		_, err = w.placer.Place(ctx, c.UserID, key, &types.PlaceOrderRequest{
+			Symbol:      h.Symbol,
+			Side:        "sell",
+			Type:        "market",
+			TimeInForce: "day",
+			Qty:         qty,
+			Source:      risk.SourceClosure,
+		})
+		if err != nil {
+			unsold = append(unsold, h.Symbol+" ("+reasonOf(err)+")")
+		}
+	}
+
+	var reason string
+	if len(unsold) > 0 {
+		reason = "Could not sell " + strings.Join(unsold, ", ")
+	}
+	return true, w.transition(ctx, c, ClosureAwaitingFills, reason, nil)
+}
+
+// awaitFills waits for every order to be final. Holdings left unsold, by
+// orders that were canceled or rejected or could not be placed, are sold in
+// a new round.
+func (w *AccountCloser) awaitFills(ctx context.Context, c *types.AccountClosure) (bool, error) {
+	open, err := w.orderRepo.ListOpenByUser(ctx, c.UserID)
+	if err != nil {
+		return false, err
+	}
+	if len(open) > 0 {
+		wait(c, fmt.Sprintf("Waiting for %d orders to fill", len(open)))
+		return false, nil
+	}
+
+	holdings, err := w.holdingRepo.ListByUser(ctx, c.UserID)
+	if err != nil {
+		return false, err
+	}
+	var remaining []string
+	for _, h := range holdings {
+		if sellableQty(h.Qty) > 0 {
+			remaining = append(remaining, h.Symbol)
+		}
+	}
+
+	if len(remaining) > 0 {
+		if c.SellRound >= closureMaxSellRounds {
+			return false, w.fail(ctx, c, "Holdings could not be sold: "+strings.Join(remaining, ", "))
+		}
+		c.SellRound++
+		return true, w.transition(ctx, c, ClosureLiquidating, "", nil)
+	}
+
+	return true, w.transition(ctx, c, ClosureConverting, "", nil)
+}
+
+// convert converts the available USD balance, sale proceeds included, to KES
+func (w *AccountCloser) convert(ctx context.Context, c *types.AccountClosure) (bool, error) {
+	wallet, err := w.walletRepo.GetByUserAndCurrency(ctx, c.UserID, "USD")
+	if err != nil {
+		return false, err
+	}
+
+	amount := math.Floor(wallet.AvailableBalance()*100) / 100
+	if amount > 0 {
+		quote, err := w.exchanger.Quote(ctx, c.UserID, "USD", "KES", amount)
+		if err != nil {
+			wait(c, "Could not convert USD to KES ("+reasonOf(err)+")")
+			return false, nil
+		}
+		if err := w.exchanger.Convert(ctx, c.UserID, quote); err != nil {
+			wait(c, "Could not convert USD to KES ("+reasonOf(err)+")")
+			return false, nil
+		}
+		c.ConvertedUSD += quote.FromAmount
+		c.ConvertedKES += quote.ToAmount
+	}
+
+	return true, w.transition(ctx, c, ClosurePayingOut, "", nil)
+}
+
+// payOut debits the next payment from the KES wallet and requests it from
+// M-Pesa. Once less than the smallest payment is left, the account is
+// closed.
+func (w *AccountCloser) payOut(ctx context.Context, c *types.AccountClosure) (bool, error) {
+	wallet, err := w.walletRepo.GetByUserAndCurrency(ctx, c.UserID, "KES")
+	if err != nil {
+		return false, err
+	}
+
+	available := wallet.AvailableBalance()
+	amount := int(math.Floor(available))
+	if amount > w.maxPayoutKES {
+		amount = w.maxPayoutKES
+	}
+	if amount < minPayoutKES {
+		if available > 0 {
+			c.ResidualKES = &available
+		}
+		return false, w.close(ctx, c)
+	}
+
+	payout := float64(amount)
+	c.PayoutAmount = &payout
+	c.NextCheckAt = time.Now().Add(w.interval)
+	err = w.uow.Do(ctx, func(tx *repository.Tx) error {
+		if err := tx.Wallets.Debit(ctx, wallet.ID, payout); err != nil {
+			return err
+		}
+		if err := tx.Ledger.Add(ctx, &types.LedgerEntry{
+			UserID:      c.UserID,
+			WalletID:    wallet.ID,
+			Type:        "withdrawal",
+			Amount:      -payout,
+			Currency:    "KES",
+			Reference:   c.ID,
+			Description: fmt.Sprintf("M-Pesa payout of KES %d to %s on account closure", amount, c.Phone),
+			Metadata:    map[string]any{"account_closure_id": c.ID, "provider": "mpesa", "phone": c.Phone},
+		}); err != nil {
+			return err
+		}
+		if err := tx.Closures.StartPayout(ctx, c); err != nil {
+			return err
+		}
+		return publishClosure(ctx, tx, c)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := w.payouts.B2C(ctx, c.Phone, amount, "EQS-CLOSE-"+c.ID[:8], w.b2c)
+	if err != nil {
+		if errors.Is(err, mpesa.ErrB2CRejected) {
+			return false, w.refund(ctx, c, err.Error())
+		}
+		// M-Pesa may have accepted the request; without a conversation ID its
+		// result cannot be matched, so the payout times out
+		logger.Warn().Err(err).Str("closure_id", c.ID).Int("amount", amount).Msg("M-Pesa payout request unconfirmed")
+		wait(c, "Waiting for M-Pesa to confirm the payout")
+		return false, nil
+	}
+
+	if err := w.closureRepo.SetPayoutConversation(ctx, c.ID, resp.ConversationID); err != nil {
+		return false, err
+	}
+	conversationID := resp.ConversationID
+	c.PayoutConversationID = &conversationID
+
+	logger.Info().
+		Str("closure_id", c.ID).
+		Str("user_id", c.UserID).
+		Int("amount", amount).
+		Str("conversation_id", resp.ConversationID).
+		Msg("M-Pesa payout requested")
+
+	return false, nil
+}
+
+// awaitPayout acts on the M-Pesa result of the payout in flight, recorded
+// by the B2C result webhook
+func (w *AccountCloser) awaitPayout(ctx context.Context, c *types.AccountClosure) (bool, error) {
+	if c.PayoutResultCode == nil {
+		if c.PayoutRequestedAt != nil && time.Since(*c.PayoutRequestedAt) > w.payoutTimeout {
+			return false, w.fail(ctx, c, fmt.Sprintf(
+				"No M-Pesa result for the KES %.0f payout; it must be checked with M-Pesa before the account is closed again",
+				*c.PayoutAmount))
+		}
+		wait(c, "Waiting for M-Pesa to confirm the payout")
+		return false, nil
+	}
+
+	if *c.PayoutResultCode != 0 {
+		desc := ""
+		if c.PayoutResultDesc != nil {
+			desc = *c.PayoutResultDesc
+		}
+		return false, w.refund(ctx, c, desc)
+	}
+
+	c.PaidOutKES += *c.PayoutAmount
+	c.PayoutAmount = nil
+
+	logger.Info().
+		Str("closure_id", c.ID).
+		Str("user_id", c.UserID).
+		Float64("paid_out_kes", c.PaidOutKES).
+		Msg("M-Pesa payout completed")
+
+	return true, w.transition(ctx, c, ClosurePayingOut, "", nil)
+}
+
+// refund credits a failed payout back to the KES wallet. The payout is
+// retried on the next check, until the closure runs out of attempts.
+func (w *AccountCloser) refund(ctx context.Context, c *types.AccountClosure, desc string) error {
+	amount := *c.PayoutAmount
+	c.PayoutAmount = nil
+	c.PayoutAttempts++
+
+	status := ClosurePayingOut
+	reason := "M-Pesa payout failed: " + desc
+	if c.PayoutAttempts >= w.maxPayoutAttempts {
+		status = ClosureFailed
+		reason = fmt.Sprintf("M-Pesa payout failed %d times, the balance is back in the KES wallet: %s", c.PayoutAttempts, desc)
+	}
+
+	return w.transition(ctx, c, status, reason, func(tx *repository.Tx) error {
+		wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, c.UserID, "KES")
+		if err != nil {
+			return err
+		}
+		if err := tx.Wallets.Credit(ctx, wallet.ID, amount); err != nil {
+			return err
+		}
+		return tx.Ledger.Add(ctx, &types.LedgerEntry{
+			UserID:      c.UserID,
+			WalletID:    wallet.ID,
+			Type:        "withdrawal",
+			Amount:      amount,
+			Currency:    "KES",
+			Reference:   c.ID,
+			Description: fmt.Sprintf("Reversal of failed M-Pesa payout of KES %.0f", amount),
+			Metadata:    map[string]any{"account_closure_id": c.ID, "provider": "mpesa", "reason": desc},
+		})
+	})
+}
+
+// close deactivates the account
+func (w *AccountCloser) close(ctx context.Context, c *types.AccountClosure) error {
+	now := time.Now()
+	c.ClosedAt = &now
+	return w.transition(ctx, c, ClosureClosed, "", func(tx *repository.Tx) error {
+		return tx.Users.Deactivate(ctx, c.UserID)
+	})
+}
+
+// fail stops a closure. The account stays open and the user may start a new
+// closure, which picks up from whatever is left.
+func (w *AccountCloser) fail(ctx context.Context, c *types.AccountClosure, reason string) error {
+	return w.transition(ctx, c, ClosureFailed, reason, nil)
+}
+
+// transition moves a closure to status, with fn's changes in the same
+// transaction, and notifies the user
+func (w *AccountCloser) transition(ctx context.Context, c *types.AccountClosure, status, reason string, fn func(tx *repository.Tx) error) error {
+	from := c.Status
+	c.Status = status
+	c.Reason = nil
+	if reason != "" {
+		c.Reason = &reason
+	}
+	c.NextCheckAt = time.Now()
+
+	err := w.uow.Do(ctx, func(tx *repository.Tx) error {
+		if fn != nil {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		if err := tx.Closures.Save(ctx, c); err != nil {
+			return err
+		}
+		return publishClosure(ctx, tx, c)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move account closure from %s to %s: %w", from, status, err)
+	}
+
+	event := logger.Info()
+	if status == ClosureFailed {
+		event = logger.Warn()
+	}
+	if c.Reason != nil {
+		event = event.Str("reason", *c.Reason)
+	}
+	event.
+		Str("closure_id", c.ID).
+		Str("user_id", c.UserID).
+		Str("from", from).
+		Str("to", status).
+		Msg("Account closure advanced")
+
+	if status == ClosureClosed || status == ClosureFailed {
+		accountClosures.WithLabelValues(status).Inc()
+	}
+	return nil
+}
+
+// publishClosure adds a closure's progress to the outbox
+func publishClosure(ctx context.Context, tx *repository.Tx, c *types.AccountClosure) error {
+	payload := events.AccountClosureUpdatedPayload{
+		ClosureID:    c.ID,
+		UserID:       c.UserID,
+		Status:       c.Status,
+		ConvertedUSD: c.ConvertedUSD,
+		ConvertedKES: c.ConvertedKES,
+		PaidOutKES:   c.PaidOutKES,
+		UpdatedAt:    time.Now().UTC(),
+	}
+	if c.PayoutAmount != nil && c.Status == ClosureAwaitingPayout {
+		payload.PayoutKES = *c.PayoutAmount
+	}
+	if c.Reason != nil {
+		payload.Reason = *c.Reason
+	}
+	return tx.Outbox.Add(ctx, events.TopicAccountClosureUpdated, events.NewEvent(
+		events.EventTypeAccountClosureUpdated,
+		"trading-service",
+		payload,
+	))
+}
+
+// wait records why a closure cannot move on yet
+func wait(c *types.AccountClosure, reason string) {
+	c.Reason = &reason
+}
+
+// sellableQty rounds a holding down to the precision orders are placed at
+func sellableQty(qty float64) float64 {
+	units := math.Floor(qty/minSellQty + 1e-6)
+	return units * minSellQty
+}
+
+// reasonOf describes why an order or conversion was refused
+func reasonOf(err error) string {
+	var appErr *apperrors.AppError
+	if errors.As(err, &appErr) {
+		if details, ok := appErr.Details.(string); ok && details != "" {
+			return details
+		}
+		return appErr.Message
+	}
+	return err.Error()
+}
@@ -0,0 +1,403 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/pkg/triggers"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// conditionalStuckAfter is how long a triggered conditional order may wait
+// for its placement to be recorded before it is resolved from the orders
+// table. It is far longer than placing an order takes.
+const conditionalStuckAfter = 10 * time.Minute
+
+var conditionalOrders = metrics.RegisterCounter(
+	"trading_conditional_orders_total",
+	"Triggered conditional orders by condition and outcome (placed, failed)",
+	[]string{"condition", "status"},
+)
+
+// dailyOpen is a symbol's opening price on a trading day
+type dailyOpen struct {
+	day   string
+	price float64
+}
+
+// ConditionalOrderEngine watches prices for symbols with active conditional
+// orders and places each order once its condition is met. Prices come from
+// price update events when a subscriber is given, and from broker quotes
+// polled on every tick otherwise.
+//
+// An order fires at most once, however many replicas run: it is claimed in
+// the database before it is placed, and only one claim can succeed. A replica
+// that dies between the claim and the placement leaves the order triggered;
+// it is later resolved from the orders table, never placed again.
+type ConditionalOrderEngine struct {
+	uow             *repository.UnitOfWork
+	conditionalRepo *repository.ConditionalOrderRepository
+	orderRepo       *repository.OrderRepository
+	holdingRepo     *repository.HoldingRepository
+	placer          OrderPlacer
+	alpaca          alpaca.TradingClient
+	subscriber      events.Subscriber
+	interval        time.Duration
+
+	mu         sync.Mutex
+	symbols    map[string]bool
+	marketOpen bool
+	opens      map[string]dailyOpen
+}
+
+// NewConditionalOrderEngine creates a new conditional order engine.
+// subscriber may be nil to poll quotes instead.
+func NewConditionalOrderEngine(
+	uow *repository.UnitOfWork,
+	conditionalRepo *repository.ConditionalOrderRepository,
+	orderRepo *repository.OrderRepository,
+	holdingRepo *repository.HoldingRepository,
+	placer OrderPlacer,
+	alpacaClient alpaca.TradingClient,
+	subscriber events.Subscriber,
+	interval time.Duration,
+) *ConditionalOrderEngine {
+	return &ConditionalOrderEngine{
+		uow:             uow,
+		conditionalRepo: conditionalRepo,
+		orderRepo:       orderRepo,
+		holdingRepo:     holdingRepo,
+		placer:          placer,
+		alpaca:          alpacaClient,
+		subscriber:      subscriber,
+		interval:        interval,
+		symbols:         make(map[string]bool),
+		opens:           make(map[string]dailyOpen),
+	}
+}
+
+// Run evaluates conditional orders until the context is canceled. Every tick
+// refreshes the watched symbols and market clock, expires lapsed orders and,
+// without a subscriber, polls quotes.
+func (e *ConditionalOrderEngine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	e.refresh(ctx)
+
+	polling := e.subscriber == nil
+	if !polling {
+		err := e.subscriber.Subscribe(ctx, events.TopicPriceUpdate, func(event *events.Event) error {
+			return e.onPriceUpdate(ctx, event)
+		})
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to subscribe to price updates, polling quotes instead")
+			polling = true
+		}
+	}
+
+	logger.Info().
+		Dur("interval", e.interval).
+		Bool("polling", polling).
+		Msg("Conditional order engine started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Conditional order engine stopped")
+			return
+		case <-ticker.C:
+			e.expire(ctx)
+			e.resolveStuck(ctx)
+			e.refresh(ctx)
+			if polling {
+				e.poll(ctx)
+			}
+		}
+	}
+}
+
+// refresh reloads the symbols with active orders and whether the market is
+// open. New orders are watched from the next refresh.
+func (e *ConditionalOrderEngine) refresh(ctx context.Context) {
+	symbols, err := e.conditionalRepo.ActiveSymbols(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list conditional order symbols")
+		return
+	}
+
+	clock, err := e.alpaca.GetClock(ctx)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to get market clock, holding conditional orders")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.symbols = make(map[string]bool, len(symbols))
+	for _, s := range symbols {
+		e.symbols[s] = true
+	}
+	e.marketOpen = err == nil && clock.IsOpen
+}
+
+// watching reports whether prices in symbol are evaluated now
+func (e *ConditionalOrderEngine) watching(symbol string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.marketOpen && e.symbols[symbol]
+}
+
+func (e *ConditionalOrderEngine) onPriceUpdate(ctx context.Context, event *events.Event) error {
+	// The payload arrives decoded as a generic map
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return err
+	}
+	var update events.PriceUpdatePayload
+	if err := json.Unmarshal(data, &update); err != nil {
+		logger.Warn().Err(err).Str("event_id", event.EventID).Msg("Skipping unreadable price update")
+		return nil
+	}
+
+	price := update.LastPrice
+	if price <= 0 {
+		price = midPrice(update.BidPrice, update.AskPrice)
+	}
+	if e.watching(update.Symbol) {
+		e.evaluate(ctx, update.Symbol, price)
+	}
+	return nil
+}
+
+func (e *ConditionalOrderEngine) poll(ctx context.Context) {
+	e.mu.Lock()
+	symbols := make([]string, 0, len(e.symbols))
+	for s := range e.symbols {
+		symbols = append(symbols, s)
+	}
+	open := e.marketOpen
+	e.mu.Unlock()
+
+	if !open || len(symbols) == 0 {
+		return
+	}
+
+	quotes, err := e.alpaca.GetMultiQuotes(ctx, symbols)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to get quotes for conditional orders")
+		return
+	}
+	for symbol, quote := range quotes {
+		e.evaluate(ctx, symbol, midPrice(quote.BidPrice, quote.AskPrice))
+	}
+}
+
+// evaluate fires the active orders in symbol whose condition price meets
+func (e *ConditionalOrderEngine) evaluate(ctx context.Context, symbol string, price float64) {
+	if price <= 0 {
+		return
+	}
+
+	orders, err := e.conditionalRepo.ListActive(ctx, symbol)
+	if err != nil {
+		logger.Error().Err(err).Str("symbol", symbol).Msg("Failed to list active conditional orders")
+		return
+	}
+
+	for i := range orders {
+		o := &orders[i]
+		cond := triggers.Condition{Kind: o.Condition, Threshold: o.Threshold}
+
+		var reference float64
+		switch o.Condition {
+		case triggers.KindChangeFromOpen:
+			reference = e.open(ctx, symbol)
+		case triggers.KindPositionGain:
+			holding, err := e.holdingRepo.GetByUserAndSymbol(ctx, o.UserID, symbol)
+			if err != nil || holding.Qty <= 0 {
+				continue
+			}
+			reference = holding.AvgEntryPrice
+		}
+
+		if cond.Met(price, reference) {
+			e.fire(ctx, o.ID, price)
+		}
+	}
+}
+
+// open returns the symbol's opening price today, or 0 before the first trade
+func (e *ConditionalOrderEngine) open(ctx context.Context, symbol string) float64 {
+	today := time.Now().UTC().Format(time.DateOnly)
+
+	e.mu.Lock()
+	cached, ok := e.opens[symbol]
+	e.mu.Unlock()
+	if ok && cached.day == today {
+		return cached.price
+	}
+
+	snapshot, err := e.alpaca.GetSnapshot(ctx, symbol)
+	if err != nil {
+		logger.Warn().Err(err).Str("symbol", symbol).Msg("Failed to get snapshot for opening price")
+		return 0
+	}
+	bar := snapshot.DailyBar
+	if bar == nil || bar.Timestamp.UTC().Format(time.DateOnly) != today {
+		return 0
+	}
+
+	e.mu.Lock()
+	e.opens[symbol] = dailyOpen{day: today, price: bar.Open}
+	e.mu.Unlock()
+	return bar.Open
+}
+
+// fire claims a conditional order and places it. Losing the claim means
+// another replica or an earlier price already fired it.
+func (e *ConditionalOrderEngine) fire(ctx context.Context, id string, price float64) {
+	o, err := e.conditionalRepo.Claim(ctx, id, price)
+	if err != nil {
+		logger.Error().Err(err).Str("conditional_order_id", id).Msg("Failed to claim conditional order")
+		return
+	}
+	if o == nil {
+		return
+	}
+
+	req := o.Order
+	req.Source = "conditional"
+
+	// The key ties the order to this conditional order, so it cannot be
+	// placed twice even by a retried request
+	placed, err := e.placer.Place(ctx, o.UserID, "conditional:"+o.ID, &req)
+
+	status := "placed"
+	var orderID, reason *string
+	if err != nil {
+		status = "failed"
+		msg := err.Error()
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			msg = appErr.Message
+		}
+		reason = &msg
+	} else if placed.Order.ID != "" {
+		orderID = &placed.Order.ID
+	}
+
+	e.complete(ctx, o, status, orderID, reason)
+}
+
+// complete records the outcome of a triggered order and notifies the user
+func (e *ConditionalOrderEngine) complete(ctx context.Context, o *types.ConditionalOrder, status string, orderID, reason *string) {
+	err := e.uow.Do(ctx, func(tx *repository.Tx) error {
+		if err := tx.Conditionals.Complete(ctx, o.ID, status, orderID, reason); err != nil {
+			return err
+		}
+
+		payload := events.ConditionalOrderTriggeredPayload{
+			ConditionalOrderID: o.ID,
+			UserID:             o.UserID,
+			Symbol:             o.Symbol,
+			Side:               o.Order.Side,
+			Condition:          o.Condition,
+			Threshold:          o.Threshold,
+			Status:             status,
+		}
+		if o.TriggerPrice != nil {
+			payload.TriggerPrice = *o.TriggerPrice
+		}
+		if o.TriggeredAt != nil {
+			payload.TriggeredAt = *o.TriggeredAt
+		}
+		if orderID != nil {
+			payload.OrderID = *orderID
+		}
+		if reason != nil {
+			payload.Reason = *reason
+		}
+		return tx.Outbox.Add(ctx, events.TopicConditionalOrderTriggered, events.NewEvent(
+			events.EventTypeConditionalOrderTriggered,
+			"trading-service",
+			payload,
+		))
+	})
+	if err != nil {
+		// Left triggered, the order is resolved by resolveStuck
+		logger.Error().Err(err).Str("conditional_order_id", o.ID).Msg("Failed to record conditional order outcome")
+		return
+	}
+
+	conditionalOrders.WithLabelValues(o.Condition, status).Inc()
+	event := logger.Info().
+		Str("conditional_order_id", o.ID).
+		Str("user_id", o.UserID).
+		Str("symbol", o.Symbol).
+		Str("condition", o.Condition).
+		Str("status", status)
+	if orderID != nil {
+		event = event.Str("order_id", *orderID)
+	}
+	if reason != nil {
+		event = event.Str("reason", *reason)
+	}
+	event.Msg("Conditional order triggered")
+}
+
+// resolveStuck settles triggered orders whose outcome was never recorded: as
+// placed if the order exists, as failed otherwise. They are not retried.
+func (e *ConditionalOrderEngine) resolveStuck(ctx context.Context) {
+	stuck, err := e.conditionalRepo.ListStuck(ctx, time.Now().Add(-conditionalStuckAfter))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list stuck conditional orders")
+		return
+	}
+
+	for i := range stuck {
+		o := &stuck[i]
+		order, err := e.orderRepo.GetByIdempotencyKey(ctx, o.UserID, "conditional:"+o.ID)
+		if err != nil {
+			logger.Error().Err(err).Str("conditional_order_id", o.ID).Msg("Failed to look up conditional order placement")
+			continue
+		}
+
+		if order != nil {
+			e.complete(ctx, o, "placed", &order.ID, nil)
+			continue
+		}
+		reason := "Placement was interrupted before the order was submitted"
+		e.complete(ctx, o, "failed", nil, &reason)
+	}
+}
+
+func (e *ConditionalOrderEngine) expire(ctx context.Context) {
+	n, err := e.conditionalRepo.ExpireDue(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to expire conditional orders")
+		return
+	}
+	if n > 0 {
+		logger.Info().Int64("count", n).Msg("Expired conditional orders")
+	}
+}
+
+// midPrice is the midpoint of a quote, or whichever side is quoted
+func midPrice(bid, ask float64) float64 {
+	switch {
+	case bid > 0 && ask > 0:
+		return (bid + ask) / 2
+	case ask > 0:
+		return ask
+	}
+	return bid
+}
@@ -0,0 +1,416 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/corpactions"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+const (
+	// corporateActionBatchSize caps how many due actions are processed per tick
+	corporateActionBatchSize = 100
+
+	// corporateActionWindow is how far either side of today actions are
+	// fetched from the provider, so late announcements are still picked up
+	corporateActionWindow = 30 * 24 * time.Hour
+)
+
+var corporateActions = metrics.RegisterCounter(
+	"trading_corporate_actions_total",
+	"Corporate actions by type and step (ingested, entitled, paid, applied)",
+	[]string{"type", "step"},
+)
+
+// CorporateActionProcessor ingests corporate actions from a provider and
+// applies them to holdings once due. Splits and symbol changes apply on the
+// ex date. Dividends are entitled to the holders on the ex date and credited
+// to their USD wallets, net of withholding tax, on the payable date. Each
+// holder is notified through the outbox.
+type CorporateActionProcessor struct {
+	uow             *repository.UnitOfWork
+	actionRepo      *repository.CorporateActionRepository
+	provider        corpactions.Provider
+	withholdingRate float64
+	interval        time.Duration
+}
+
+// NewCorporateActionProcessor creates a new corporate action processor.
+// withholdingRate is the share of each dividend withheld as tax, e.g. 0.3.
+func NewCorporateActionProcessor(
+	uow *repository.UnitOfWork,
+	actionRepo *repository.CorporateActionRepository,
+	provider corpactions.Provider,
+	withholdingRate float64,
+	interval time.Duration,
+) *CorporateActionProcessor {
+	return &CorporateActionProcessor{
+		uow:             uow,
+		actionRepo:      actionRepo,
+		provider:        provider,
+		withholdingRate: withholdingRate,
+		interval:        interval,
+	}
+}
+
+// Run ingests and processes corporate actions on every tick until the
+// context is canceled
+func (p *CorporateActionProcessor) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	logger.Info().
+		Dur("interval", p.interval).
+		Float64("withholding_rate", p.withholdingRate).
+		Msg("Corporate action processor started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Corporate action processor stopped")
+			return
+		case <-ticker.C:
+			today := time.Now().UTC().Truncate(24 * time.Hour)
+			p.ingest(ctx, today)
+			p.process(ctx, today)
+		}
+	}
+}
+
+// ingest saves the actions the provider announces around today. Actions
+// already ingested are skipped.
+func (p *CorporateActionProcessor) ingest(ctx context.Context, today time.Time) {
+	announced, err := p.provider.Actions(ctx, today.Add(-corporateActionWindow), today.Add(corporateActionWindow))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to fetch corporate actions")
+		return
+	}
+
+	for _, a := range announced {
+		if err := a.Validate(); err != nil {
+			logger.Warn().Err(err).Str("provider_ref", a.ID).Msg("Skipping invalid corporate action")
+			continue
+		}
+
+		action := &types.CorporateAction{
+			ProviderRef: a.ID,
+			Type:        a.Type,
+			Symbol:      a.Symbol,
+			Rate:        a.Rate,
+			SplitFrom:   a.SplitFrom,
+			SplitTo:     a.SplitTo,
+			ExDate:      a.ExDate,
+		}
+		if a.NewSymbol != "" {
+			action.NewSymbol = &a.NewSymbol
+		}
+		if !a.PayableDate.IsZero() {
+			action.PayableDate = &a.PayableDate
+		}
+
+		created, err := p.actionRepo.Create(ctx, action)
+		if err != nil {
+			logger.Error().Err(err).Str("provider_ref", a.ID).Msg("Failed to save corporate action")
+			continue
+		}
+		if created {
+			corporateActions.WithLabelValues(a.Type, "ingested").Inc()
+			logger.Info().
+				Str("action_id", action.ID).
+				Str("type", a.Type).
+				Str("symbol", a.Symbol).
+				Time("ex_date", a.ExDate).
+				Msg("Corporate action ingested")
+		}
+	}
+}
+
+// process applies the step that is due for each action
+func (p *CorporateActionProcessor) process(ctx context.Context, today time.Time) {
+	due, err := p.actionRepo.ListDue(ctx, today, corporateActionBatchSize)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list due corporate actions")
+		return
+	}
+
+	for _, a := range due {
+		if err := p.apply(ctx, a.ID, today); err != nil {
+			logger.Error().Err(err).
+				Str("action_id", a.ID).
+				Str("type", a.Type).
+				Str("symbol", a.Symbol).
+				Msg("Failed to process corporate action")
+		}
+	}
+}
+
+// apply runs the due step of one action in a single transaction, so every
+// holding it touches is adjusted or none is
+func (p *CorporateActionProcessor) apply(ctx context.Context, actionID string, today time.Time) error {
+	return p.uow.Do(ctx, func(tx *repository.Tx) error {
+		action, err := tx.Actions.GetByIDForUpdate(ctx, actionID)
+		if err != nil {
+			return err
+		}
+
+		if action.Status == "pending" && !action.ExDate.After(today) {
+			switch action.Type {
+			case corpactions.TypeCashDividend:
+				if err := p.entitle(ctx, tx, action); err != nil {
+					return err
+				}
+			case corpactions.TypeStockSplit:
+				return p.split(ctx, tx, action)
+			case corpactions.TypeSymbolChange:
+				return p.changeSymbol(ctx, tx, action)
+			default:
+				return fmt.Errorf("unknown corporate action type %q", action.Type)
+			}
+		}
+
+		if action.Status == "entitled" && action.PayableDate != nil && !action.PayableDate.After(today) {
+			return p.pay(ctx, tx, action)
+		}
+		return nil
+	})
+}
+
+// entitle records the dividend due to each holder of the symbol on the
+// record date, the close of the day before the ex date; it is paid on the
+// payable date. Holdings are reconstructed as of the ex date, so shares
+// bought or sold since do not change the entitlement when the action is
+// processed late.
+func (p *CorporateActionProcessor) entitle(ctx context.Context, tx *repository.Tx, action *types.CorporateAction) error {
+	holdings, err := tx.Lots.ListHoldersAt(ctx, action.Symbol, action.ExDate)
+	if err != nil {
+		return err
+	}
+
+	var holders int
+	for _, h := range holdings {
+		d := corpactions.ComputeDividend(h.Qty, action.Rate, p.withholdingRate)
+		if d.Gross <= 0 {
+			continue
+		}
+		if err := tx.Actions.AddEntry(ctx, &types.CorporateActionEntry{
+			ActionID:       action.ID,
+			UserID:         h.UserID,
+			Symbol:         action.Symbol,
+			QtyBefore:      h.Qty,
+			QtyAfter:       h.Qty,
+			AvgCostBefore:  h.AvgEntryPrice,
+			AvgCostAfter:   h.AvgEntryPrice,
+			GrossAmount:    d.Gross,
+			WithholdingTax: d.Withholding,
+			NetAmount:      d.Net,
+		}); err != nil {
+			return err
+		}
+		holders++
+	}
+
+	action.Status = "entitled"
+	action.Holders = holders
+	if err := tx.Actions.UpdateStatus(ctx, action.ID, action.Status, holders); err != nil {
+		return err
+	}
+
+	corporateActions.WithLabelValues(action.Type, "entitled").Inc()
+	logger.Info().Str("action_id", action.ID).Str("symbol", action.Symbol).Int("holders", holders).Msg("Dividend entitlements recorded")
+	return nil
+}
+
+// pay credits each holder's net dividend to their USD wallet
+func (p *CorporateActionProcessor) pay(ctx context.Context, tx *repository.Tx, action *types.CorporateAction) error {
+	entries, err := tx.Actions.ListUnpaidForUpdate(ctx, action.ID)
+	if err != nil {
+		return err
+	}
+
+	var paid float64
+	for i := range entries {
+		e := &entries[i]
+		wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, e.UserID, "USD")
+		if err != nil {
+			return err
+		}
+
+		if e.NetAmount > 0 {
+			if err := tx.Wallets.Credit(ctx, wallet.ID, e.NetAmount); err != nil {
+				return err
+			}
+		}
+		if err := tx.Ledger.Add(ctx, &types.LedgerEntry{
+			UserID:      e.UserID,
+			WalletID:    wallet.ID,
+			Type:        "dividend",
+			Amount:      e.NetAmount,
+			Currency:    "USD",
+			Reference:   action.ID,
+			Description: fmt.Sprintf("%s dividend of %.4f per share on %g shares", action.Symbol, action.Rate, e.QtyBefore),
+			Metadata: map[string]any{
+				"corporate_action_id": action.ID,
+				"symbol":              action.Symbol,
+				"rate":                action.Rate,
+				"qty":                 e.QtyBefore,
+				"gross_amount":        e.GrossAmount,
+				"withholding_tax":     e.WithholdingTax,
+				"withholding_rate":    p.withholdingRate,
+			},
+		}); err != nil {
+			return err
+		}
+		if err := tx.Actions.MarkPaid(ctx, e.ID); err != nil {
+			return err
+		}
+		if err := notifyHolder(ctx, tx, action, e); err != nil {
+			return err
+		}
+		paid += e.NetAmount
+	}
+
+	if err := tx.Actions.UpdateStatus(ctx, action.ID, "applied", action.Holders); err != nil {
+		return err
+	}
+
+	corporateActions.WithLabelValues(action.Type, "paid").Inc()
+	logger.Info().
+		Str("action_id", action.ID).
+		Str("symbol", action.Symbol).
+		Int("holders", len(entries)).
+		Float64("net_paid", paid).
+		Msg("Dividend paid")
+	return nil
+}
+
+// split scales the quantity and average cost of every holding and tax lot
+// in the symbol
+func (p *CorporateActionProcessor) split(ctx context.Context, tx *repository.Tx, action *types.CorporateAction) error {
+	holdings, err := tx.Holdings.ListBySymbolForUpdate(ctx, action.Symbol)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range holdings {
+		qty, avgCost := corpactions.ApplySplit(h.Qty, h.AvgEntryPrice, action.SplitFrom, action.SplitTo)
+		if err := tx.Holdings.SetPosition(ctx, h.ID, qty, avgCost); err != nil {
+			return err
+		}
+
+		entry := &types.CorporateActionEntry{
+			ActionID:      action.ID,
+			UserID:        h.UserID,
+			Symbol:        action.Symbol,
+			QtyBefore:     h.Qty,
+			QtyAfter:      qty,
+			AvgCostBefore: h.AvgEntryPrice,
+			AvgCostAfter:  avgCost,
+		}
+		if err := tx.Actions.AddEntry(ctx, entry); err != nil {
+			return err
+		}
+		if err := notifyHolder(ctx, tx, action, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Lots.ApplySplit(ctx, action.Symbol, action.SplitFrom, action.SplitTo); err != nil {
+		return err
+	}
+	if err := tx.Actions.UpdateStatus(ctx, action.ID, "applied", len(holdings)); err != nil {
+		return err
+	}
+
+	corporateActions.WithLabelValues(action.Type, "applied").Inc()
+	logger.Info().
+		Str("action_id", action.ID).
+		Str("symbol", action.Symbol).
+		Float64("split_from", action.SplitFrom).
+		Float64("split_to", action.SplitTo).
+		Int("holders", len(holdings)).
+		Msg("Stock split applied")
+	return nil
+}
+
+// changeSymbol moves every holding and tax lot in the old symbol to the new
+// one, merging holdings into any the user already has in the new symbol
+func (p *CorporateActionProcessor) changeSymbol(ctx context.Context, tx *repository.Tx, action *types.CorporateAction) error {
+	holdings, err := tx.Holdings.ListBySymbolForUpdate(ctx, action.Symbol)
+	if err != nil {
+		return err
+	}
+
+	newSymbol := *action.NewSymbol
+	for _, h := range holdings {
+		if err := tx.Holdings.Upsert(ctx, h.UserID, newSymbol, h.Qty, h.AvgEntryPrice); err != nil {
+			return err
+		}
+		if err := tx.Holdings.Delete(ctx, h.UserID, action.Symbol); err != nil {
+			return err
+		}
+
+		entry := &types.CorporateActionEntry{
+			ActionID:      action.ID,
+			UserID:        h.UserID,
+			Symbol:        action.Symbol,
+			QtyBefore:     h.Qty,
+			QtyAfter:      h.Qty,
+			AvgCostBefore: h.AvgEntryPrice,
+			AvgCostAfter:  h.AvgEntryPrice,
+		}
+		if err := tx.Actions.AddEntry(ctx, entry); err != nil {
+			return err
+		}
+		if err := notifyHolder(ctx, tx, action, entry); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Lots.ChangeSymbol(ctx, action.Symbol, newSymbol); err != nil {
+		return err
+	}
+	if err := tx.Actions.UpdateStatus(ctx, action.ID, "applied", len(holdings)); err != nil {
+		return err
+	}
+
+	corporateActions.WithLabelValues(action.Type, "applied").Inc()
+	logger.Info().
+		Str("action_id", action.ID).
+		Str("symbol", action.Symbol).
+		Str("new_symbol", newSymbol).
+		Int("holders", len(holdings)).
+		Msg("Symbol change applied")
+	return nil
+}
+
+// notifyHolder queues the event telling a holder what an action did to
+// their holding
+func notifyHolder(ctx context.Context, tx *repository.Tx, action *types.CorporateAction, e *types.CorporateActionEntry) error {
+	payload := events.CorporateActionAppliedPayload{
+		ActionID:    action.ID,
+		UserID:      e.UserID,
+		Type:        action.Type,
+		Symbol:      action.Symbol,
+		QtyBefore:   e.QtyBefore,
+		QtyAfter:    e.QtyAfter,
+		GrossAmount: e.GrossAmount,
+		Withholding: e.WithholdingTax,
+		NetAmount:   e.NetAmount,
+		ExDate:      action.ExDate.Format(corpactions.DateLayout),
+	}
+	if action.NewSymbol != nil {
+		payload.NewSymbol = *action.NewSymbol
+	}
+
+	return tx.Outbox.Add(ctx, events.TopicCorporateActionApplied, events.NewEvent(
+		events.EventTypeCorporateActionApplied,
+		"trading-service",
+		payload,
+	))
+}
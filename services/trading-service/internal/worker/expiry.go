@@ -0,0 +1,180 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/settlement"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// expiryBatchSize caps how many orders are expired per sweep
+const expiryBatchSize = 100
+
+// ExpirySweeper periodically expires open orders past their expires_at and
+// releases the USD locked against them
+type ExpirySweeper struct {
+	uow       *repository.UnitOfWork
+	orderRepo *repository.OrderRepository
+	settler   *settlement.Settler
+	alpaca    alpaca.TradingClient
+	interval  time.Duration
+}
+
+// NewExpirySweeper creates a new order expiry sweeper
+func NewExpirySweeper(
+	uow *repository.UnitOfWork,
+	orderRepo *repository.OrderRepository,
+	settler *settlement.Settler,
+	alpacaClient alpaca.TradingClient,
+	interval time.Duration,
+) *ExpirySweeper {
+	return &ExpirySweeper{
+		uow:       uow,
+		orderRepo: orderRepo,
+		settler:   settler,
+		alpaca:    alpacaClient,
+		interval:  interval,
+	}
+}
+
+// Run sweeps expired orders on every tick until the context is canceled
+func (s *ExpirySweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	logger.Info().Dur("interval", s.interval).Msg("Order expiry sweeper started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Order expiry sweeper stopped")
+			return
+		case <-ticker.C:
+			s.sweep(ctx)
+		}
+	}
+}
+
+func (s *ExpirySweeper) sweep(ctx context.Context) {
+	orders, err := s.orderRepo.ListExpired(ctx, expiryBatchSize)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list expired orders")
+		return
+	}
+
+	for i := range orders {
+		s.expire(ctx, &orders[i])
+	}
+
+	if len(orders) > 0 {
+		logger.Info().Int("count", len(orders)).Msg("Processed expired orders")
+	}
+}
+
+func (s *ExpirySweeper) expire(ctx context.Context, order *types.Order) {
+	reason := "Expired at " + order.TimeInForce + " deadline"
+
+	if order.AlpacaOrderID == "" {
+		s.expireLocal(ctx, order, reason)
+		return
+	}
+
+	// Make sure the broker can no longer fill the order before releasing
+	// funds, then settle whatever it filled before it closed
+	if err := s.alpaca.CancelOrder(ctx, order.AlpacaOrderID); err != nil {
+		logger.Debug().Err(err).Str("order_id", order.ID).Msg("Alpaca did not cancel expired order")
+	}
+	alpacaOrder, err := s.alpaca.GetOrder(ctx, order.AlpacaOrderID)
+	if err != nil {
+		logger.Warn().Err(err).Str("order_id", order.ID).Msg("Failed to fetch expired order from Alpaca, will retry")
+		return
+	}
+
+	update := settlement.UpdateFromOrder(alpacaOrder)
+	switch event := settlement.EventForStatus(alpacaOrder.Status); event {
+	case "", settlement.EventPartialFill:
+		// Still working at the broker; its cancel update settles it
+		logger.Warn().
+			Str("order_id", order.ID).
+			Str("alpaca_status", string(alpacaOrder.Status)).
+			Msg("Expired order is not closed at broker, skipping")
+		return
+	case settlement.EventCanceled, settlement.EventExpired:
+		err = s.settler.Expire(ctx, order, update, settlement.SourceExpiry, reason)
+	default:
+		// Filled or rejected before the deadline passed
+		err = s.settler.Apply(ctx, order, event, update, settlement.SourceExpiry)
+	}
+	if errors.Is(err, settlement.ErrAlreadySettled) {
+		return
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to settle expired order")
+		return
+	}
+
+	logger.Info().
+		Str("order_id", order.ID).
+		Str("time_in_force", order.TimeInForce).
+		Str("alpaca_status", string(alpacaOrder.Status)).
+		Msg("Expired order settled")
+}
+
+// expireLocal expires an order that never reached the broker, so nothing can
+// have filled, and releases its lock in the same transaction
+func (s *ExpirySweeper) expireLocal(ctx context.Context, order *types.Order, reason string) {
+	var expired bool
+	err := s.uow.Do(ctx, func(tx *repository.Tx) error {
+		var err error
+		expired, err = tx.Orders.MarkExpired(ctx, order.ID)
+		if err != nil || !expired {
+			return err
+		}
+
+		event := settlement.NewOrderEvent(order, settlement.StatusExpired, settlement.SourceExpiry, reason)
+		if err := tx.Orders.AddEvent(ctx, event); err != nil {
+			return err
+		}
+
+		if order.Side == "buy" && order.LockedAmount > 0 {
+			wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, order.UserID, "USD")
+			if err != nil {
+				return err
+			}
+			if err := tx.Wallets.Unlock(ctx, wallet.ID, order.LockedAmount); err != nil {
+				return err
+			}
+		}
+
+		return tx.Outbox.Add(ctx, events.TopicOrderCancelled, events.NewEvent(
+			events.EventTypeOrderCancelled,
+			"trading-service",
+			events.OrderCancelledPayload{
+				OrderID:      order.ID,
+				UserID:       order.UserID,
+				Symbol:       order.Symbol,
+				CancelledAt:  time.Now().UTC(),
+				CancelReason: "expired",
+			},
+		))
+	})
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to expire order")
+		return
+	}
+	if !expired {
+		return
+	}
+
+	logger.Info().
+		Str("order_id", order.ID).
+		Str("time_in_force", order.TimeInForce).
+		Float64("unlocked", order.LockedAmount).
+		Msg("Order expired")
+}
@@ -0,0 +1,285 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/settlement"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+const (
+	// omnibusBatchSize caps how many queued orders are aggregated per tick
+	omnibusBatchSize = 1000
+
+	// omnibusMinNotional is the smallest notional order Alpaca accepts
+	omnibusMinNotional = 1.0
+)
+
+var omnibusOrders = metrics.RegisterCounter(
+	"trading_omnibus_orders_total",
+	"Omnibus orders by result (submitted, failed, settled)",
+	[]string{"result"},
+)
+
+// OmnibusAggregator batches queued small notional buys per symbol into one
+// Alpaca order on every tick, and checks submitted omnibus orders against
+// Alpaca in case their final update was missed. Fills are allocated back to
+// the queued orders by the settler.
+type OmnibusAggregator struct {
+	uow         *repository.UnitOfWork
+	omnibusRepo *repository.OmnibusRepository
+	settler     *settlement.Settler
+	alpaca      alpaca.TradingClient
+	window      time.Duration
+	maxWait     time.Duration
+}
+
+// NewOmnibusAggregator creates a new omnibus aggregator. Queued orders are
+// submitted every window; a symbol whose queue stays below Alpaca's minimum
+// notional for maxWait has its orders failed and their funds released.
+func NewOmnibusAggregator(
+	uow *repository.UnitOfWork,
+	omnibusRepo *repository.OmnibusRepository,
+	settler *settlement.Settler,
+	alpacaClient alpaca.TradingClient,
+	window, maxWait time.Duration,
+) *OmnibusAggregator {
+	return &OmnibusAggregator{
+		uow:         uow,
+		omnibusRepo: omnibusRepo,
+		settler:     settler,
+		alpaca:      alpacaClient,
+		window:      window,
+		maxWait:     maxWait,
+	}
+}
+
+// Run aggregates queued orders on every tick until the context is canceled
+func (a *OmnibusAggregator) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.window)
+	defer ticker.Stop()
+
+	logger.Info().
+		Dur("window", a.window).
+		Dur("max_wait", a.maxWait).
+		Msg("Omnibus aggregator started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Omnibus aggregator stopped")
+			return
+		case <-ticker.C:
+			a.aggregate(ctx)
+			a.reconcile(ctx)
+		}
+	}
+}
+
+// aggregate moves the queued orders of each symbol into a new omnibus order
+// and submits it
+func (a *OmnibusAggregator) aggregate(ctx context.Context) {
+	var created []types.OmnibusOrder
+	err := a.uow.Do(ctx, func(tx *repository.Tx) error {
+		created = nil
+
+		queued, err := tx.Orders.ClaimQueued(ctx, omnibusBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, group := range groupBySymbol(queued) {
+			var notional float64
+			ids := make([]string, len(group))
+			for i, o := range group {
+				notional += o.Amount
+				ids[i] = o.ID
+			}
+			// Never ask the broker for more than was locked
+			notional = math.Floor(notional*100) / 100
+
+			if notional < omnibusMinNotional {
+				if time.Since(group[0].CreatedAt) < a.maxWait {
+					continue
+				}
+				if err := failQueued(ctx, tx, group, "Order below broker minimum"); err != nil {
+					return err
+				}
+				continue
+			}
+
+			omnibus := types.OmnibusOrder{
+				Symbol:        group[0].Symbol,
+				Notional:      notional,
+				OrderCount:    len(group),
+				ClientOrderID: uuid.New().String(),
+				Status:        "pending",
+			}
+			if err := tx.Omnibus.Create(ctx, &omnibus); err != nil {
+				return err
+			}
+			if err := tx.Orders.AssignOmnibus(ctx, omnibus.ID, ids); err != nil {
+				return err
+			}
+			for i := range group {
+				reason := "Aggregated into omnibus order " + omnibus.ID
+				if err := tx.Orders.AddEvent(ctx, settlement.NewOrderEvent(&group[i], settlement.StatusPending, settlement.SourceAggregator, reason)); err != nil {
+					return err
+				}
+			}
+			created = append(created, omnibus)
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to aggregate queued orders")
+		return
+	}
+
+	for i := range created {
+		a.submit(ctx, &created[i])
+	}
+}
+
+// submit places the Alpaca order for an omnibus order. A retry after a
+// crash finds the earlier submission by its client order ID.
+func (a *OmnibusAggregator) submit(ctx context.Context, omnibus *types.OmnibusOrder) {
+	brokerOrder, err := a.alpaca.CreateOrder(ctx, &alpaca.CreateOrderRequest{
+		Symbol:        omnibus.Symbol,
+		Side:          alpaca.Buy,
+		Type:          alpaca.Market,
+		TimeInForce:   alpaca.Day,
+		Notional:      fmt.Sprintf("%.2f", omnibus.Notional),
+		ClientOrderID: omnibus.ClientOrderID,
+	})
+	if err != nil {
+		existing, lookupErr := a.alpaca.GetOrderByClientID(ctx, omnibus.ClientOrderID)
+		if lookupErr != nil {
+			logger.Error().Err(err).
+				Str("omnibus_order_id", omnibus.ID).
+				Str("symbol", omnibus.Symbol).
+				Msg("Failed to create Alpaca omnibus order")
+			if err := a.settler.FailOmnibus(ctx, omnibus.ID, "Failed to place order", settlement.SourceAggregator); err != nil && !errors.Is(err, settlement.ErrAlreadySettled) {
+				logger.Error().Err(err).Str("omnibus_order_id", omnibus.ID).Msg("Failed to fail omnibus order")
+			}
+			omnibusOrders.WithLabelValues("failed").Inc()
+			return
+		}
+		brokerOrder = existing
+	}
+
+	if err := a.omnibusRepo.MarkSubmitted(ctx, omnibus.ID, brokerOrder.ID); err != nil {
+		// Found again by client order ID on the next reconcile
+		logger.Error().Err(err).Str("omnibus_order_id", omnibus.ID).Msg("Failed to save omnibus order submission")
+		return
+	}
+	omnibus.AlpacaOrderID = &brokerOrder.ID
+	omnibusOrders.WithLabelValues("submitted").Inc()
+
+	logger.Info().
+		Str("omnibus_order_id", omnibus.ID).
+		Str("alpaca_order_id", brokerOrder.ID).
+		Str("symbol", omnibus.Symbol).
+		Float64("notional", omnibus.Notional).
+		Int("orders", omnibus.OrderCount).
+		Msg("Omnibus order submitted")
+
+	// Market orders can fill before the stream reports them
+	a.settle(ctx, omnibus, brokerOrder, settlement.SourceAggregator)
+}
+
+// reconcile checks omnibus orders that have gone a window without an update
+// against Alpaca, and resubmits any whose submission was never recorded
+func (a *OmnibusAggregator) reconcile(ctx context.Context) {
+	open, err := a.omnibusRepo.ListOpen(ctx, time.Now().Add(-a.window), omnibusBatchSize)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list open omnibus orders")
+		return
+	}
+
+	for i := range open {
+		omnibus := &open[i]
+		if omnibus.AlpacaOrderID == nil {
+			a.submit(ctx, omnibus)
+			continue
+		}
+
+		brokerOrder, err := a.alpaca.GetOrder(ctx, *omnibus.AlpacaOrderID)
+		if err != nil {
+			logger.Warn().Err(err).Str("omnibus_order_id", omnibus.ID).Msg("Failed to fetch omnibus order from Alpaca, will retry")
+			continue
+		}
+		if !a.settle(ctx, omnibus, brokerOrder, settlement.SourceReconciler) {
+			if err := a.omnibusRepo.MarkChecked(ctx, omnibus.ID); err != nil {
+				logger.Error().Err(err).Str("omnibus_order_id", omnibus.ID).Msg("Failed to mark omnibus order checked")
+			}
+		}
+	}
+}
+
+// settle applies the broker's view of an omnibus order once it is final. It
+// reports whether the order no longer needs checking.
+func (a *OmnibusAggregator) settle(ctx context.Context, omnibus *types.OmnibusOrder, brokerOrder *alpaca.Order, source string) bool {
+	event := settlement.EventForStatus(brokerOrder.Status)
+	if event == "" || event == settlement.EventPartialFill {
+		return false
+	}
+
+	err := a.settler.ApplyOmnibus(ctx, omnibus, event, settlement.UpdateFromOrder(brokerOrder), source)
+	if errors.Is(err, settlement.ErrAlreadySettled) {
+		return true
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("omnibus_order_id", omnibus.ID).Str("event", event).Msg("Failed to settle omnibus order")
+		return false
+	}
+
+	omnibusOrders.WithLabelValues("settled").Inc()
+	return true
+}
+
+// groupBySymbol splits orders sorted by symbol into one group per symbol
+func groupBySymbol(orders []types.Order) [][]types.Order {
+	var groups [][]types.Order
+	for i := 0; i < len(orders); {
+		j := i + 1
+		for j < len(orders) && orders[j].Symbol == orders[i].Symbol {
+			j++
+		}
+		groups = append(groups, orders[i:j])
+		i = j
+	}
+	return groups
+}
+
+// failQueued fails queued orders that cannot be submitted and releases the
+// funds locked for them
+func failQueued(ctx context.Context, tx *repository.Tx, orders []types.Order, reason string) error {
+	for _, o := range orders {
+		wallet, err := tx.Wallets.GetByUserAndCurrency(ctx, o.UserID, "USD")
+		if err != nil {
+			return err
+		}
+		if err := tx.Wallets.Unlock(ctx, wallet.ID, o.LockedAmount); err != nil {
+			return err
+		}
+		if err := tx.Orders.UpdateFailedByID(ctx, o.ID, reason); err != nil {
+			return err
+		}
+		if err := tx.Orders.AddEvent(ctx, settlement.NewOrderEvent(&o, settlement.StatusFailed, settlement.SourceAggregator, reason)); err != nil {
+			return err
+		}
+		logger.Info().Str("order_id", o.ID).Str("reason", reason).Msg("Queued order failed")
+	}
+	return nil
+}
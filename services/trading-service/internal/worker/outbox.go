@@ -0,0 +1,85 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+)
+
+// outboxBatchSize caps how many events are relayed per poll
+const outboxBatchSize = 100
+
+// OutboxRelay publishes committed outbox events to Kafka
+type OutboxRelay struct {
+	uow       *repository.UnitOfWork
+	publisher events.Publisher
+	interval  time.Duration
+}
+
+// NewOutboxRelay creates a new outbox relay
+func NewOutboxRelay(uow *repository.UnitOfWork, publisher events.Publisher, interval time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		uow:       uow,
+		publisher: publisher,
+		interval:  interval,
+	}
+}
+
+// Run relays pending events on every tick until the context is canceled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	logger.Info().Dur("interval", r.interval).Msg("Outbox relay started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Outbox relay stopped")
+			return
+		case <-ticker.C:
+			r.relay(ctx)
+		}
+	}
+}
+
+func (r *OutboxRelay) relay(ctx context.Context) {
+	var published int
+	err := r.uow.Do(ctx, func(tx *repository.Tx) error {
+		pending, err := tx.Outbox.ListPending(ctx, outboxBatchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range pending {
+			e := &pending[i]
+			if err := r.publisher.Publish(ctx, e.Topic, &e.Event); err != nil {
+				logger.Warn().Err(err).
+					Str("event_id", e.ID).
+					Str("topic", e.Topic).
+					Int("attempts", e.Attempts+1).
+					Msg("Failed to publish outbox event")
+				if err := tx.Outbox.MarkFailed(ctx, e.ID, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Outbox.MarkPublished(ctx, e.ID); err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to relay outbox events")
+		return
+	}
+
+	if published > 0 {
+		logger.Debug().Int("count", published).Msg("Relayed outbox events")
+	}
+}
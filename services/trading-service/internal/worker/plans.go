@@ -0,0 +1,235 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	apperrors "github.com/Rohianon/equishare-global-trading/pkg/errors"
+	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/handler"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+const (
+	// planBatchSize caps how many plans are run per tick
+	planBatchSize = 50
+
+	// planLease is how long a claimed plan is hidden from other ticks and
+	// replicas while it runs
+	planLease = 5 * time.Minute
+)
+
+var planRuns = metrics.RegisterCounter(
+	"trading_plan_runs_total",
+	"Investment plan runs by status (placed, retrying, skipped, failed)",
+	[]string{"status"},
+)
+
+// OrderPlacer places orders on behalf of users
+type OrderPlacer interface {
+	Place(ctx context.Context, userID, idempotencyKey string, req *types.PlaceOrderRequest) (*handler.Placement, error)
+}
+
+// PlanScheduler runs due investment plans as notional market buys. Runs that
+// cannot be funded are retried a few times before the period is skipped.
+type PlanScheduler struct {
+	uow         *repository.UnitOfWork
+	planRepo    *repository.PlanRepository
+	placer      OrderPlacer
+	alpaca      alpaca.TradingClient
+	interval    time.Duration
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+// NewPlanScheduler creates a new investment plan scheduler
+func NewPlanScheduler(
+	uow *repository.UnitOfWork,
+	planRepo *repository.PlanRepository,
+	placer OrderPlacer,
+	alpacaClient alpaca.TradingClient,
+	interval time.Duration,
+	maxAttempts int,
+	retryDelay time.Duration,
+) *PlanScheduler {
+	return &PlanScheduler{
+		uow:         uow,
+		planRepo:    planRepo,
+		placer:      placer,
+		alpaca:      alpacaClient,
+		interval:    interval,
+		maxAttempts: maxAttempts,
+		retryDelay:  retryDelay,
+	}
+}
+
+// Run runs due plans on every tick until the context is canceled
+func (s *PlanScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	logger.Info().
+		Dur("interval", s.interval).
+		Int("max_attempts", s.maxAttempts).
+		Dur("retry_delay", s.retryDelay).
+		Msg("Investment plan scheduler started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Investment plan scheduler stopped")
+			return
+		case <-ticker.C:
+			s.tick(ctx)
+		}
+	}
+}
+
+func (s *PlanScheduler) tick(ctx context.Context) {
+	// Market orders need an open market; due plans wait for the next open
+	clock, err := s.alpaca.GetClock(ctx)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to get market clock, skipping plan run")
+		return
+	}
+	if !clock.IsOpen {
+		return
+	}
+
+	plans, err := s.planRepo.ClaimDue(ctx, time.Now().Add(planLease), planBatchSize)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to claim due investment plans")
+		return
+	}
+
+	for i := range plans {
+		s.run(ctx, &plans[i])
+	}
+
+	if len(plans) > 0 {
+		logger.Info().Int("count", len(plans)).Msg("Ran investment plans")
+	}
+}
+
+func (s *PlanScheduler) run(ctx context.Context, plan *types.InvestmentPlan) {
+	scheduledFor := plan.NextRunAt
+	now := time.Now().UTC()
+
+	req := &types.PlaceOrderRequest{
+		Symbol:      plan.Symbol,
+		Side:        "buy",
+		Type:        "market",
+		TimeInForce: "day",
+		Source:      "plan",
+	}
+	if plan.Currency == "KES" {
+		req.AmountKES = plan.Amount
+	} else {
+		req.Amount = plan.Amount
+	}
+
+	// Every attempt for a period shares a key, so a retry after a crash
+	// returns the order the earlier attempt placed
+	key := fmt.Sprintf("plan:%s:%d", plan.ID, scheduledFor.Unix())
+	placed, err := s.placer.Place(ctx, plan.UserID, key, req)
+
+	run := &types.PlanRun{
+		PlanID:       plan.ID,
+		ScheduledFor: scheduledFor,
+		Attempt:      plan.Attempts + 1,
+	}
+	plan.LastRunAt = &now
+
+	switch {
+	case err == nil:
+		run.Status = "placed"
+		if placed.Order.ID != "" {
+			run.OrderID = &placed.Order.ID
+		}
+	case retryable(err) && run.Attempt < s.maxAttempts:
+		run.Status = "retrying"
+	case retryable(err):
+		run.Status = "skipped"
+	default:
+		run.Status = "failed"
+	}
+	if err != nil {
+		reason := err.Error()
+		var appErr *apperrors.AppError
+		if errors.As(err, &appErr) {
+			reason = appErr.Message
+		}
+		run.Reason = &reason
+	}
+
+	if run.Status == "retrying" {
+		retryAt := now.Add(s.retryDelay)
+		plan.RetryAt = &retryAt
+		plan.Attempts = run.Attempt
+	} else {
+		plan.RetryAt = nil
+		plan.Attempts = 0
+		plan.Advance(now)
+	}
+
+	err = s.uow.Do(ctx, func(tx *repository.Tx) error {
+		if err := tx.Plans.UpdateSchedule(ctx, plan); err != nil {
+			return err
+		}
+		if err := tx.Plans.AddRun(ctx, run); err != nil {
+			return err
+		}
+
+		payload := events.PlanRunPayload{
+			PlanID:       plan.ID,
+			UserID:       plan.UserID,
+			Symbol:       plan.Symbol,
+			Amount:       plan.Amount,
+			Currency:     plan.Currency,
+			Status:       run.Status,
+			ScheduledFor: scheduledFor,
+			NextRunAt:    plan.NextRunAt,
+		}
+		if plan.RetryAt != nil {
+			payload.NextRunAt = *plan.RetryAt
+		}
+		if run.OrderID != nil {
+			payload.OrderID = *run.OrderID
+		}
+		if run.Reason != nil {
+			payload.Reason = *run.Reason
+		}
+		return tx.Outbox.Add(ctx, events.TopicPlanRun, events.NewEvent(events.EventTypePlanRun, "trading-service", payload))
+	})
+	if err != nil {
+		// The lease expires and the plan is picked up again; the idempotency
+		// key stops a second order for the same period
+		logger.Error().Err(err).Str("plan_id", plan.ID).Msg("Failed to record investment plan run")
+		return
+	}
+
+	planRuns.WithLabelValues(run.Status).Inc()
+	logger.Info().
+		Str("plan_id", plan.ID).
+		Str("user_id", plan.UserID).
+		Str("status", run.Status).
+		Int("attempt", run.Attempt).
+		Time("next_run_at", plan.NextRunAt).
+		Msg("Investment plan run")
+}
+
+// retryable reports whether a failed run may succeed if tried again later:
+// the user was short of funds, or a dependency was unavailable
+func retryable(err error) bool {
+	var appErr *apperrors.AppError
+	if !errors.As(err, &appErr) {
+		return true
+	}
+	return appErr.Code == apperrors.ErrInsufficientFunds.Code || appErr.HTTPStatus >= 500
+}
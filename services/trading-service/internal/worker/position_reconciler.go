@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/reconcile"
+)
+
+// positionCheckInterval is how often the scheduler checks whether the
+// nightly reconciliation is due
+const positionCheckInterval = 10 * time.Minute
+
+// PositionReconcileScheduler runs the position reconciliation once a day,
+// after the given hour (UTC). Replicas race for the run; only one wins it.
+type PositionReconcileScheduler struct {
+	reconciler *reconcile.Reconciler
+	hour       int
+}
+
+// NewPositionReconcileScheduler creates a new position reconciliation
+// scheduler. hour is the UTC hour the run becomes due, e.g. 6 for after the
+// US evening session has closed.
+func NewPositionReconcileScheduler(reconciler *reconcile.Reconciler, hour int) *PositionReconcileScheduler {
+	return &PositionReconcileScheduler{reconciler: reconciler, hour: hour}
+}
+
+// Run starts the day's reconciliation once it is due, until the context is
+// canceled
+func (s *PositionReconcileScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(positionCheckInterval)
+	defer ticker.Stop()
+
+	logger.Info().Int("hour_utc", s.hour).Msg("Position reconciliation scheduler started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Position reconciliation scheduler stopped")
+			return
+		case <-ticker.C:
+			if time.Now().UTC().Hour() < s.hour {
+				continue
+			}
+			if _, err := s.reconciler.Run(ctx, reconcile.TriggerScheduled, "scheduler"); err != nil {
+				logger.Error().Err(err).Msg("Position reconciliation failed")
+			}
+		}
+	}
+}
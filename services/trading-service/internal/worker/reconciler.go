@@ -0,0 +1,250 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/settlement"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/types"
+)
+
+// reconcileBatchSize caps how many orders are checked against Alpaca per run
+const reconcileBatchSize = 200
+
+var (
+	reconciledOrders = metrics.RegisterCounter(
+		"trading_reconciler_orders_total",
+		"Open orders checked against Alpaca by result (in_sync, repaired, failed)",
+		[]string{"result"},
+	)
+
+	reconcilerDrift = metrics.RegisterCounter(
+		"trading_reconciler_drift_total",
+		"Open orders whose local status disagreed with Alpaca",
+		[]string{"local_status", "broker_status"},
+	)
+)
+
+// Reconciler periodically checks open orders against Alpaca and applies the
+// fill, cancel and reject transitions for updates the webhook missed
+type Reconciler struct {
+	orderRepo *repository.OrderRepository
+	settler   *settlement.Settler
+	alpaca    alpaca.TradingClient
+	interval  time.Duration
+	minAge    time.Duration
+}
+
+// NewReconciler creates a new order reconciler. Orders are only checked once
+// they have gone minAge without an update, giving webhooks time to arrive.
+func NewReconciler(
+	orderRepo *repository.OrderRepository,
+	settler *settlement.Settler,
+	alpacaClient alpaca.TradingClient,
+	interval, minAge time.Duration,
+) *Reconciler {
+	return &Reconciler{
+		orderRepo: orderRepo,
+		settler:   settler,
+		alpaca:    alpacaClient,
+		interval:  interval,
+		minAge:    minAge,
+	}
+}
+
+// Run reconciles open orders on every tick until the context is canceled
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	logger.Info().
+		Dur("interval", r.interval).
+		Dur("min_age", r.minAge).
+		Msg("Order reconciler started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Order reconciler stopped")
+			return
+		case <-ticker.C:
+			r.reconcile(ctx)
+		}
+	}
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) {
+	r.reconcileBefore(ctx, time.Now().Add(-r.minAge))
+}
+
+// CatchUp reconciles every open order regardless of age, e.g. after the
+// trade updates stream reconnects
+func (r *Reconciler) CatchUp(ctx context.Context) {
+	before := time.Now()
+	for ctx.Err() == nil {
+		checked, failed := r.reconcileBefore(ctx, before)
+		// Checked orders drop out of the next batch unless they failed
+		if checked < reconcileBatchSize || failed > 0 {
+			return
+		}
+	}
+}
+
+// reconcileBefore checks one batch of open orders not updated since before
+func (r *Reconciler) reconcileBefore(ctx context.Context, before time.Time) (checked, failed int) {
+	orders, err := r.orderRepo.ListStale(ctx, before, reconcileBatchSize)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list open orders for reconciliation")
+		return 0, 0
+	}
+
+	var repaired int
+	for i := range orders {
+		result := r.check(ctx, &orders[i])
+		reconciledOrders.WithLabelValues(result).Inc()
+		switch result {
+		case "repaired":
+			repaired++
+		case "failed":
+			failed++
+		}
+	}
+
+	if repaired > 0 {
+		logger.Info().
+			Int("checked", len(orders)).
+			Int("repaired", repaired).
+			Msg("Reconciled orders with Alpaca")
+	}
+
+	return len(orders), failed
+}
+
+// check compares one order with Alpaca and settles any drift
+func (r *Reconciler) check(ctx context.Context, order *types.Order) string {
+	alpacaOrder, err := r.alpaca.GetOrder(ctx, order.AlpacaOrderID)
+	if err != nil {
+		logger.Warn().Err(err).
+			Str("order_id", order.ID).
+			Str("alpaca_order_id", order.AlpacaOrderID).
+			Msg("Failed to fetch order from Alpaca, will retry")
+		return "failed"
+	}
+
+	if alpacaOrder.Status == alpaca.OrderStatusReplaced {
+		return r.finishReplace(ctx, order, alpacaOrder)
+	}
+	if order.PendingReplace != nil && alpacaOrder.Status != alpaca.OrderStatusPendingReplace {
+		// The amendment never replaced the order; release what it locked
+		// and check the order on its old terms
+		err := r.settler.DropReplace(ctx, order)
+		if err != nil && !errors.Is(err, settlement.ErrAlreadySettled) {
+			logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to drop pending amendment")
+			return "failed"
+		}
+		logger.Info().Str("order_id", order.ID).Msg("Dropped amendment Alpaca did not carry out")
+	}
+
+	event := settlement.EventForStatus(alpacaOrder.Status)
+	if event == "" || !drifted(order, event, alpacaOrder) {
+		return r.markReconciled(ctx, order)
+	}
+
+	reconcilerDrift.WithLabelValues(order.Status, string(alpacaOrder.Status)).Inc()
+	logger.Warn().
+		Str("order_id", order.ID).
+		Str("alpaca_order_id", order.AlpacaOrderID).
+		Str("local_status", order.Status).
+		Str("broker_status", string(alpacaOrder.Status)).
+		Str("broker_filled_qty", alpacaOrder.FilledQty).
+		Float64("local_filled_qty", order.FilledQty).
+		Msg("Order drifted from Alpaca")
+
+	err = r.settler.Apply(ctx, order, event, settlement.UpdateFromOrder(alpacaOrder), settlement.SourceReconciler)
+	if errors.Is(err, settlement.ErrAlreadySettled) {
+		// A webhook got there first
+		return "in_sync"
+	}
+	if errors.Is(err, settlement.ErrUnfundedFill) {
+		// Retrying cannot pay for it; move on so the batch is not stuck
+		logger.Error().Err(err).Str("order_id", order.ID).Str("event", event).Msg("Fill cannot be paid for and needs a human")
+		return r.markReconciled(ctx, order)
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Str("event", event).Msg("Failed to settle drifted order")
+		return "failed"
+	}
+
+	return "repaired"
+}
+
+// finishReplace saves an amendment that went through at Alpaca but was not
+// saved on the order, repointing it at the replacement Alpaca order. The
+// replacement's own status is checked on a later run.
+func (r *Reconciler) finishReplace(ctx context.Context, order *types.Order, alpacaOrder *alpaca.Order) string {
+	pending := order.PendingReplace
+	if pending == nil || alpacaOrder.ReplacedBy == nil {
+		// Replaced outside the amend flow; the new terms are unknown and
+		// need a human
+		logger.Warn().
+			Str("order_id", order.ID).
+			Str("alpaca_order_id", order.AlpacaOrderID).
+			Msg("Open order points at a replaced Alpaca order")
+		return r.markReconciled(ctx, order)
+	}
+
+	replacement, err := r.alpaca.GetOrder(ctx, *alpacaOrder.ReplacedBy)
+	if err != nil {
+		logger.Warn().Err(err).Str("order_id", order.ID).Msg("Failed to fetch replacement order from Alpaca, will retry")
+		return "failed"
+	}
+	if replacement.ClientOrderID != pending.ClientOrderID {
+		logger.Warn().
+			Str("order_id", order.ID).
+			Str("replaced_by", replacement.ID).
+			Msg("Alpaca order was replaced by one the pending amendment did not send")
+		return r.markReconciled(ctx, order)
+	}
+
+	previousAlpacaOrderID := order.AlpacaOrderID
+	err = r.settler.FinishReplace(ctx, order, replacement.ID)
+	if errors.Is(err, settlement.ErrAlreadySettled) {
+		// The handler or a settled update got there first
+		return "in_sync"
+	}
+	if err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to save amended order")
+		return "failed"
+	}
+
+	logger.Info().
+		Str("order_id", order.ID).
+		Str("alpaca_order_id", replacement.ID).
+		Str("replaces", previousAlpacaOrderID).
+		Msg("Saved amendment left pending")
+	return "repaired"
+}
+
+// markReconciled records that an order agrees with Alpaca, or needs more than
+// the reconciler can do, so it moves to the back of the batch
+func (r *Reconciler) markReconciled(ctx context.Context, order *types.Order) string {
+	if err := r.orderRepo.MarkReconciled(ctx, order.ID); err != nil {
+		logger.Error().Err(err).Str("order_id", order.ID).Msg("Failed to mark order reconciled")
+	}
+	return "in_sync"
+}
+
+// drifted reports whether the local order lags the broker's view of it
+func drifted(order *types.Order, event string, alpacaOrder *alpaca.Order) bool {
+	if event != settlement.EventPartialFill {
+		return true
+	}
+	filledQty, _ := strconv.ParseFloat(alpacaOrder.FilledQty, 64)
+	return order.Status != "partial_fill" || filledQty != order.FilledQty
+}
@@ -0,0 +1,181 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/settlement"
+)
+
+const (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+)
+
+var tradeUpdates = metrics.RegisterCounter(
+	"trading_trade_updates_total",
+	"Trade updates received from the Alpaca stream by event and result",
+	[]string{"event", "result"},
+)
+
+// TradeStream consumes Alpaca's trade_updates stream and settles order
+// updates through the same transitions as the webhook
+type TradeStream struct {
+	orderRepo   *repository.OrderRepository
+	omnibusRepo *repository.OmnibusRepository
+	settler     *settlement.Settler
+	reconciler  *Reconciler
+	alpaca      alpaca.TradingClient
+
+	lastEventAt atomic.Int64 // unix nanos of the last update received
+	catchingUp  atomic.Bool
+}
+
+// NewTradeStream creates a new trade updates stream consumer. The
+// reconciler is used to catch up on updates missed while disconnected.
+func NewTradeStream(
+	orderRepo *repository.OrderRepository,
+	omnibusRepo *repository.OmnibusRepository,
+	settler *settlement.Settler,
+	reconciler *Reconciler,
+	alpacaClient alpaca.TradingClient,
+) *TradeStream {
+	return &TradeStream{
+		orderRepo:   orderRepo,
+		omnibusRepo: omnibusRepo,
+		settler:     settler,
+		reconciler:  reconciler,
+		alpaca:      alpacaClient,
+	}
+}
+
+// Run keeps the stream connected, reconnecting with exponential backoff,
+// until the context is canceled
+func (s *TradeStream) Run(ctx context.Context) {
+	logger.Info().Msg("Trade updates stream started")
+
+	backoff := streamMinBackoff
+	for {
+		connectedAt := time.Now()
+		err := s.alpaca.StreamTradeUpdates(ctx, &streamHandler{ctx: ctx, stream: s})
+		if ctx.Err() != nil {
+			logger.Info().Msg("Trade updates stream stopped")
+			return
+		}
+
+		// A connection that stayed up for a while starts the backoff over
+		if time.Since(connectedAt) > streamMaxBackoff {
+			backoff = streamMinBackoff
+		}
+
+		logger.Warn().Err(err).
+			Dur("retry_in", backoff).
+			Time("last_event_at", s.lastEvent()).
+			Msg("Trade updates stream disconnected")
+
+		select {
+		case <-ctx.Done():
+			logger.Info().Msg("Trade updates stream stopped")
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > streamMaxBackoff {
+			backoff = streamMaxBackoff
+		}
+	}
+}
+
+func (s *TradeStream) lastEvent() time.Time {
+	if nanos := s.lastEventAt.Load(); nanos > 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
+// resume checks open orders against Alpaca so that anything that happened
+// since the last event is settled before relying on the live stream
+func (s *TradeStream) resume(ctx context.Context) {
+	if !s.catchingUp.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.catchingUp.Store(false)
+
+	logger.Info().Time("last_event_at", s.lastEvent()).Msg("Catching up on missed trade updates")
+	s.reconciler.CatchUp(ctx)
+}
+
+func (s *TradeStream) settle(ctx context.Context, update *alpaca.TradeUpdate) {
+	if !update.Timestamp.IsZero() {
+		s.lastEventAt.Store(update.Timestamp.UnixNano())
+	}
+
+	switch update.Event {
+	case alpaca.TradeEventFill, alpaca.TradeEventPartialFill, alpaca.TradeEventCanceled,
+		alpaca.TradeEventExpired, alpaca.TradeEventRejected:
+	default:
+		// new, replaced, done_for_day etc. carry nothing to settle
+		tradeUpdates.WithLabelValues(update.Event, "ignored").Inc()
+		return
+	}
+
+	order, err := s.orderRepo.GetByAlpacaOrderID(ctx, update.Order.ID)
+	if err != nil {
+		s.settleOmnibus(ctx, update)
+		return
+	}
+
+	err = s.settler.Apply(ctx, order, update.Event, settlement.UpdateFromOrder(&update.Order), settlement.SourceStream)
+	s.record(update.Event, order.ID, err)
+}
+
+// settleOmnibus settles a trade update for an omnibus order, which has no
+// order row of its own
+func (s *TradeStream) settleOmnibus(ctx context.Context, update *alpaca.TradeUpdate) {
+	omnibus, err := s.omnibusRepo.GetByAlpacaOrderID(ctx, update.Order.ID)
+	if err != nil {
+		logger.Debug().Str("alpaca_order_id", update.Order.ID).Msg("Trade update for unknown order")
+		tradeUpdates.WithLabelValues(update.Event, "unknown_order").Inc()
+		return
+	}
+
+	err = s.settler.ApplyOmnibus(ctx, omnibus, update.Event, settlement.UpdateFromOrder(&update.Order), settlement.SourceStream)
+	s.record(update.Event, omnibus.ID, err)
+}
+
+func (s *TradeStream) record(event, orderID string, err error) {
+	switch {
+	case errors.Is(err, settlement.ErrAlreadySettled):
+		tradeUpdates.WithLabelValues(event, "duplicate").Inc()
+	case err != nil:
+		// The reconciler picks the order up again later
+		logger.Error().Err(err).
+			Str("order_id", orderID).
+			Str("event", event).
+			Msg("Failed to settle trade update")
+		tradeUpdates.WithLabelValues(event, "failed").Inc()
+	default:
+		tradeUpdates.WithLabelValues(event, "settled").Inc()
+	}
+}
+
+// streamHandler binds stream callbacks to the context of a Run
+type streamHandler struct {
+	ctx    context.Context
+	stream *TradeStream
+}
+
+func (h *streamHandler) OnListening() {
+	go h.stream.resume(h.ctx)
+}
+
+func (h *streamHandler) OnTradeUpdate(update *alpaca.TradeUpdate) {
+	h.stream.settle(h.ctx, update)
+}
@@ -4,21 +4,39 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 
 	"github.com/Rohianon/equishare-global-trading/pkg/alpaca"
+	"github.com/Rohianon/equishare-global-trading/pkg/cache"
 	"github.com/Rohianon/equishare-global-trading/pkg/config"
+	"github.com/Rohianon/equishare-global-trading/pkg/corpactions"
 	"github.com/Rohianon/equishare-global-trading/pkg/database"
 	"github.com/Rohianon/equishare-global-trading/pkg/events"
+	"github.com/Rohianon/equishare-global-trading/pkg/fees"
+	"github.com/Rohianon/equishare-global-trading/pkg/fx"
 	"github.com/Rohianon/equishare-global-trading/pkg/logger"
+	"github.com/Rohianon/equishare-global-trading/pkg/metrics"
 	"github.com/Rohianon/equishare-global-trading/pkg/middleware"
+	"github.com/Rohianon/equishare-global-trading/pkg/mpesa"
+	"github.com/Rohianon/equishare-global-trading/pkg/slippage"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/exchange"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/halts"
 	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/handler"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/reconcile"
 	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/repository"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/risk"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/settlement"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/webhook"
+	"github.com/Rohianon/equishare-global-trading/services/trading-service/internal/worker"
 )
 
 func main() {
@@ -55,6 +73,24 @@ func main() {
 	defer db.Close()
 	logger.Info().Msg("Connected to database")
 
+	// Redis connection
+	redisCfg := &cache.Config{
+		Host:     getEnvOrDefault("REDIS_HOST", cfg.Redis.Host),
+		Port:     cfg.Redis.Port,
+		Password: getEnvOrDefault("REDIS_PASSWORD", cfg.Redis.Password),
+		DB:       cfg.Redis.DB,
+	}
+	if redisCfg.Port == 0 {
+		redisCfg.Port = 6379
+	}
+
+	redisCache, err := cache.NewRedisCache(redisCfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to connect to Redis")
+	}
+	defer redisCache.Close()
+	logger.Info().Msg("Connected to Redis")
+
 	// Alpaca client
 	var alpacaClient alpaca.TradingClient
 	alpacaAPIKey := os.Getenv("ALPACA_API_KEY")
@@ -69,6 +105,7 @@ func main() {
 			APIKey:    alpacaAPIKey,
 			SecretKey: alpacaSecretKey,
 			Paper:     alpacaPaper,
+			BaseURL:   os.Getenv("ALPACA_BASE_URL"),
 		})
 		logger.Info().Bool("paper", alpacaPaper).Msg("Connected to Alpaca")
 	}
@@ -88,13 +125,168 @@ func main() {
 	walletRepo := repository.NewWalletRepository(db)
 	orderRepo := repository.NewOrderRepository(db)
 	holdingRepo := repository.NewHoldingRepository(db)
+	planRepo := repository.NewPlanRepository(db)
+	basketRepo := repository.NewBasketRepository(db)
+	omnibusRepo := repository.NewOmnibusRepository(db)
+	actionRepo := repository.NewCorporateActionRepository(db)
+	lotRepo := repository.NewTaxLotRepository(db)
+	haltRepo := repository.NewHaltRepository(db)
+	conditionalRepo := repository.NewConditionalOrderRepository(db)
+	reconRepo := repository.NewReconciliationRepository(db)
+	closureRepo := repository.NewAccountClosureRepository(db)
+	uow := repository.NewUnitOfWork(db)
+
+	// Trade commissions: the default schedule applies to every KYC tier
+	// without its own rate in FEE_TIER_RATE_BPS, e.g. "tier2:25,tier3:15"
+	defaultFees := fees.Schedule{
+		Flat:    getFloatOrDefault("FEE_FLAT_USD", 0),
+		RateBps: int(getFloatOrDefault("FEE_RATE_BPS", 0)),
+		Minimum: getFloatOrDefault("FEE_MIN_USD", 0),
+		Maximum: getFloatOrDefault("FEE_MAX_USD", 0),
+	}
+	feeTiers, err := fees.ParseTiers(os.Getenv("FEE_TIER_RATE_BPS"), defaultFees)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Invalid FEE_TIER_RATE_BPS")
+	}
+	feeEngine := fees.NewEngine(&fees.Config{Default: defaultFees, Tiers: feeTiers})
+
+	settler := settlement.New(uow, alpacaClient, feeEngine)
+
+	// Currency conversion between KES and USD wallets
+	kesPerUSD := getFloatOrDefault("KES_PER_USD", 129)
+	quoter := fx.NewQuoter(fx.NewMockProvider(kesPerUSD), &fx.Config{
+		SpreadBps: int(getFloatOrDefault("FX_SPREAD_BPS", 150)),
+		TTL:       getDurationOrDefault("FX_QUOTE_TTL", 30*time.Second),
+	})
+	exchanger := exchange.New(quoter, redisCache, uow)
+
+	// Trading halts set by operations, checked before every other rule
+	haltController := halts.New(redisCache, haltRepo, uow)
+
+	// Nightly reconciliation of holdings against broker positions
+	positionReconciler := reconcile.New(uow, reconRepo, holdingRepo, alpacaClient, getFloatOrDefault("POSITION_DRIFT_TOLERANCE", 0.000001))
+
+	// Pre-trade risk checks
+	riskEngine := risk.NewEngine(
+		&risk.Halts{Source: haltController},
+		&risk.MaxNotional{Limit: getFloatOrDefault("RISK_MAX_ORDER_NOTIONAL_USD", 50000)},
+		risk.NewDenyList(strings.Split(os.Getenv("RISK_RESTRICTED_SYMBOLS"), ",")),
+		&risk.Tradable{Alpaca: alpacaClient},
+		&risk.MarketHours{Alpaca: alpacaClient},
+		&risk.DailyLimit{
+			Orders:    orderRepo,
+			LimitsKES: risk.DailyTradeLimitsKES,
+			KESPerUSD: kesPerUSD,
+		},
+	)
+
+	// Market orders are refused on stale quotes or wide spreads, and orders
+	// by qty are sent as limit orders MARKET_ORDER_SLIPPAGE_BPS through the
+	// quote unless the order asks for its own band
+	slippageGuard := slippage.NewGuard(&slippage.Config{
+		MaxQuoteAge:        getDurationOrDefault("MARKET_ORDER_MAX_QUOTE_AGE", time.Minute),
+		MaxSpreadBps:       int(getFloatOrDefault("MARKET_ORDER_MAX_SPREAD_BPS", 200)),
+		DefaultSlippageBps: int(getFloatOrDefault("MARKET_ORDER_SLIPPAGE_BPS", 100)),
+		MaxSlippageBps:     int(getFloatOrDefault("MARKET_ORDER_MAX_SLIPPAGE_BPS", 500)),
+	})
+
+	// Notional market buys up to this amount are aggregated into omnibus
+	// orders; 0 disables aggregation
+	omnibusMaxAmount := getFloatOrDefault("OMNIBUS_MAX_ORDER_USD", 0)
+
+	// M-Pesa B2C pays out the balance of closed accounts. Results are posted
+	// to MPESA_B2C_RESULT_URL, which carries the callback token.
+	var payoutClient worker.Payouts
+	if os.Getenv("MPESA_SANDBOX") == "true" || os.Getenv("MPESA_CONSUMER_KEY") == "" {
+		logger.Warn().Msg("Using mock M-Pesa client")
+		payoutClient = mpesa.NewMockClient()
+	} else {
+		payoutClient = mpesa.NewClient(&mpesa.Config{
+			ConsumerKey:    os.Getenv("MPESA_CONSUMER_KEY"),
+			ConsumerSecret: os.Getenv("MPESA_CONSUMER_SECRET"),
+			ShortCode:      os.Getenv("MPESA_SHORTCODE"),
+			Sandbox:        os.Getenv("MPESA_SANDBOX") == "true",
+		})
+	}
+	b2cCallbackToken := getEnvOrDefault("MPESA_B2C_CALLBACK_TOKEN", "dev-b2c-callback-token-change-in-production")
+	b2cConfig := &mpesa.B2CConfig{
+		InitiatorName:      os.Getenv("MPESA_B2C_INITIATOR_NAME"),
+		SecurityCredential: os.Getenv("MPESA_B2C_SECURITY_CREDENTIAL"),
+		ResultURL:          withToken(os.Getenv("MPESA_B2C_RESULT_URL"), b2cCallbackToken),
+		QueueTimeoutURL:    os.Getenv("MPESA_B2C_TIMEOUT_URL"),
+	}
 
 	// Handler
-	h := handler.New(userRepo, walletRepo, orderRepo, holdingRepo, alpacaClient, publisher)
+	h := handler.New(userRepo, walletRepo, orderRepo, holdingRepo, planRepo, basketRepo, omnibusRepo, actionRepo, lotRepo, conditionalRepo, closureRepo, settler, riskEngine, exchanger, haltController, positionReconciler, feeEngine, slippageGuard, alpacaClient, publisher, omnibusMaxAmount, b2cCallbackToken)
+
+	// Background workers
+	workerCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
+	expiryInterval := getDurationOrDefault("ORDER_EXPIRY_SWEEP_INTERVAL", time.Minute)
+	go worker.NewExpirySweeper(uow, orderRepo, settler, alpacaClient, expiryInterval).Run(workerCtx)
+
+	reconcileInterval := getDurationOrDefault("ORDER_RECONCILE_INTERVAL", time.Minute)
+	reconcileMinAge := getDurationOrDefault("ORDER_RECONCILE_MIN_AGE", 2*time.Minute)
+	reconciler := worker.NewReconciler(orderRepo, settler, alpacaClient, reconcileInterval, reconcileMinAge)
+	go reconciler.Run(workerCtx)
+	go worker.NewTradeStream(orderRepo, omnibusRepo, settler, reconciler, alpacaClient).Run(workerCtx)
+
+	omnibusWindow := getDurationOrDefault("OMNIBUS_WINDOW", 30*time.Second)
+	omnibusMaxWait := getDurationOrDefault("OMNIBUS_MAX_WAIT", 10*time.Minute)
+	go worker.NewOmnibusAggregator(uow, omnibusRepo, settler, alpacaClient, omnibusWindow, omnibusMaxWait).Run(workerCtx)
+
+	planInterval := getDurationOrDefault("PLAN_SCHEDULER_INTERVAL", time.Minute)
+	planRetryDelay := getDurationOrDefault("PLAN_RETRY_DELAY", 6*time.Hour)
+	planMaxAttempts := int(getFloatOrDefault("PLAN_MAX_ATTEMPTS", 3))
+	go worker.NewPlanScheduler(uow, planRepo, h, alpacaClient, planInterval, planMaxAttempts, planRetryDelay).Run(workerCtx)
+
+	// Conditional orders are evaluated on price update events when Kafka is
+	// configured, and on quotes polled every interval otherwise
+	var priceUpdates events.Subscriber
+	if publisher != nil {
+		groupID := getEnvOrDefault("KAFKA_GROUP_ID", cfg.Kafka.GroupID)
+		if groupID == "" {
+			groupID = "trading-service"
+		}
+		subscriber := events.NewKafkaSubscriber(cfg.Kafka.Brokers, groupID+"-conditional-orders")
+		defer subscriber.Close()
+		priceUpdates = subscriber
+	}
+	conditionalInterval := getDurationOrDefault("CONDITIONAL_ORDERS_INTERVAL", 15*time.Second)
+	go worker.NewConditionalOrderEngine(uow, conditionalRepo, orderRepo, holdingRepo, h, alpacaClient, priceUpdates, conditionalInterval).Run(workerCtx)
+
+	reconcileHour := int(getFloatOrDefault("POSITION_RECONCILE_HOUR_UTC", 6))
+	go worker.NewPositionReconcileScheduler(positionReconciler, reconcileHour).Run(workerCtx)
+
+	// Corporate actions are imported from CORPORATE_ACTIONS_FILE when set
+	var actionProvider corpactions.Provider = corpactions.NewMockProvider()
+	if path := os.Getenv("CORPORATE_ACTIONS_FILE"); path != "" {
+		actionProvider = corpactions.NewFileProvider(path)
+	}
+	withholdingRate := getFloatOrDefault("DIVIDEND_WITHHOLDING_RATE", 0.30)
+	actionInterval := getDurationOrDefault("CORPORATE_ACTIONS_INTERVAL", time.Hour)
+	go worker.NewCorporateActionProcessor(uow, actionRepo, actionProvider, withholdingRate, actionInterval).Run(workerCtx)
+
+	closureInterval := getDurationOrDefault("ACCOUNT_CLOSURE_INTERVAL", 30*time.Second)
+	closureMaxPayout := int(getFloatOrDefault("ACCOUNT_CLOSURE_MAX_PAYOUT_KES", 150000))
+	closureMaxPayoutAttempts := int(getFloatOrDefault("ACCOUNT_CLOSURE_MAX_PAYOUT_ATTEMPTS", 3))
+	closurePayoutTimeout := getDurationOrDefault("ACCOUNT_CLOSURE_PAYOUT_TIMEOUT", 24*time.Hour)
+	go worker.NewAccountCloser(uow, closureRepo, orderRepo, holdingRepo, walletRepo, settler, h, exchanger, alpacaClient, payoutClient, b2cConfig, closureInterval, closureMaxPayout, closureMaxPayoutAttempts, closurePayoutTimeout).Run(workerCtx)
+
+	if publisher != nil {
+		outboxInterval := getDurationOrDefault("OUTBOX_RELAY_INTERVAL", time.Second)
+		go worker.NewOutboxRelay(uow, publisher, outboxInterval).Run(workerCtx)
+	}
 
 	// JWT secret
 	jwtSecret := getEnvOrDefault("JWT_SECRET", "dev-secret-change-in-production")
 
+	// Webhook verification
+	webhookSecret := getEnvOrDefault("ALPACA_WEBHOOK_SECRET", "dev-webhook-secret-change-in-production")
+	webhookTolerance := getDurationOrDefault("ALPACA_WEBHOOK_TOLERANCE", 5*time.Minute)
+	webhookVerifier := webhook.NewVerifier(webhookSecret, webhookTolerance, redisCache)
+
 	// Fiber app
 	app := fiber.New(fiber.Config{
 		AppName:      "EquiShare Trading Service",
@@ -104,14 +296,39 @@ func main() {
 	app.Use(middleware.RequestID())
 	app.Use(middleware.Logger())
 	app.Use(middleware.SecurityHeaders())
+	app.Use(metrics.Middleware(metrics.Config{
+		ServiceName: "trading-service",
+		SkipPaths:   []string{"/health", "/metrics"},
+	}))
 
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "healthy", "service": "trading-service"})
 	})
 
-	// Webhook endpoint (no auth required)
-	app.Post("/webhooks/alpaca/orders", h.AlpacaWebhook)
+	// Prometheus metrics
+	app.Get("/metrics", metrics.Handler())
+
+	// Webhook endpoint (signed by Alpaca, no user auth)
+	app.Post("/webhooks/alpaca/orders", webhookVerifier.Middleware(), h.AlpacaWebhook)
+
+	// M-Pesa B2C payout results (callback token in the query, no user auth)
+	app.Post("/webhooks/mpesa/b2c/result", h.MpesaB2CResult)
+
+	// Operator endpoints (shared admin token, disabled when unset)
+	if adminToken := os.Getenv("ADMIN_API_TOKEN"); adminToken != "" {
+		admin := app.Group("/admin/v1", middleware.AdminAuth(adminToken))
+		admin.Get("/halts", h.ListHalts)
+		admin.Post("/halts", h.SetHalt)
+		admin.Get("/halts/events", h.ListHaltEvents)
+		admin.Delete("/halts/:scope/:target?", h.ClearHalt)
+		admin.Get("/reconciliations", h.ListReconciliations)
+		admin.Post("/reconciliations", h.RunReconciliation)
+		admin.Get("/reconciliations/:id", h.GetReconciliation)
+		admin.Post("/reconciliations/drifts/:id/correct", h.CorrectDrift)
+	} else {
+		logger.Warn().Msg("ADMIN_API_TOKEN not set, admin endpoints disabled")
+	}
 
 	// API routes (auth required)
 	api := app.Group("/api/v1", middleware.Auth(jwtSecret))
@@ -120,11 +337,41 @@ func main() {
 	orders := api.Group("/orders")
 	orders.Post("/", h.PlaceOrder)
 	orders.Get("/", h.ListOrders)
+	orders.Get("/export", h.ExportOrders)
+	orders.Post("/quote", h.QuoteOrder)
+	orders.Post("/conditional", h.CreateConditionalOrder)
+	orders.Get("/conditional", h.ListConditionalOrders)
+	orders.Get("/conditional/:id", h.GetConditionalOrder)
+	orders.Delete("/conditional/:id", h.CancelConditionalOrder)
+	orders.Post("/basket", h.PlaceBasket)
+	orders.Get("/basket", h.ListBaskets)
+	orders.Get("/basket/:id", h.GetBasket)
 	orders.Get("/:id", h.GetOrder)
+	orders.Get("/:id/history", h.GetOrderHistory)
+	orders.Get("/:id/allocation", h.GetOrderAllocation)
+	orders.Patch("/:id", h.AmendOrder)
 	orders.Delete("/:id", h.CancelOrder)
 
+	// Currency conversion
+	api.Post("/fx/quotes", h.CreateFXQuote)
+
+	// Recurring investment plans
+	plans := api.Group("/plans")
+	plans.Post("/", h.CreatePlan)
+	plans.Get("/", h.ListPlans)
+	plans.Get("/:id", h.GetPlan)
+	plans.Patch("/:id", h.UpdatePlan)
+	plans.Delete("/:id", h.CancelPlan)
+
 	// Portfolio
 	api.Get("/portfolio", h.GetPortfolio)
+	api.Get("/portfolio/lots", h.ListTaxLots)
+	api.Get("/portfolio/realized-gains", h.GetRealizedGains)
+	api.Get("/corporate-actions", h.ListCorporateActions)
+
+	// Account closure
+	api.Post("/account/closure", h.CloseAccount)
+	api.Get("/account/closure", h.GetAccountClosure)
 
 	// Market data
 	api.Get("/quotes/:symbol", h.GetQuote)
@@ -145,6 +392,7 @@ func main() {
 	<-quit
 
 	logger.Info().Msg("Shutting down Trading Service")
+	stopWorkers()
 	if err := app.Shutdown(); err != nil {
 		logger.Error().Err(err).Msg("Error during shutdown")
 	}
@@ -157,6 +405,42 @@ func getEnvOrDefault(key, defaultVal string) string {
 	return defaultVal
 }
 
+func getDurationOrDefault(key string, defaultVal time.Duration) time.Duration {
+	if val := os.Getenv(key); val != "" {
+		if d, err := time.ParseDuration(val); err == nil {
+			return d
+		}
+		logger.Warn().Str("key", key).Str("value", val).Msg("Invalid duration, using default")
+	}
+	return defaultVal
+}
+
+func getFloatOrDefault(key string, defaultVal float64) float64 {
+	if val := os.Getenv(key); val != "" {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+		logger.Warn().Str("key", key).Str("value", val).Msg("Invalid number, using default")
+	}
+	return defaultVal
+}
+
+// withToken adds the callback token to a webhook URL registered with a
+// provider that cannot sign its callbacks
+func withToken(rawURL, token string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		logger.Fatal().Err(err).Str("url", rawURL).Msg("Invalid callback URL")
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
 func errorHandler(c *fiber.Ctx, err error) error {
 	code := fiber.StatusInternalServerError
 	message := "Internal Server Error"
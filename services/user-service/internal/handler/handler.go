@@ -240,6 +240,24 @@ func (h *Handler) DeleteAccount(c *fiber.Ctx) error {
 
 	ctx := c.Context()
 
+	// Shares and balances are sold and paid out by the account closure flow
+	hasAssets, err := h.repo.HasAssets(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to check user assets")
+		return c.Status(fiber.StatusInternalServerError).JSON(types.ErrorResponse{
+			Error:   "internal_error",
+			Message: "Failed to delete account",
+			Code:    500,
+		})
+	}
+	if hasAssets {
+		return c.Status(fiber.StatusConflict).JSON(types.ErrorResponse{
+			Error:   "conflict",
+			Message: "Account still has holdings, balances or open orders; close it with POST /api/v1/account/closure",
+			Code:    409,
+		})
+	}
+
 	if err := h.repo.DeactivateUser(ctx, userID); err != nil {
 		logger.Error().Err(err).Str("user_id", userID).Msg("Failed to deactivate user")
 		return c.Status(fiber.StatusInternalServerError).JSON(types.ErrorResponse{
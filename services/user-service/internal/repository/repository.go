@@ -87,6 +87,26 @@ func (r *Repository) DeactivateUser(ctx context.Context, userID string) error {
 	return nil
 }
 
+// HasAssets reports whether a user still holds shares, has a wallet balance
+// or has open orders. Such an account is closed through trading-service,
+// which sells and pays everything out first.
+func (r *Repository) HasAssets(ctx context.Context, userID string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM holdings WHERE user_id = $1 AND quantity > 0)
+			OR EXISTS (SELECT 1 FROM wallets WHERE user_id = $1 AND balance > 0)
+			OR EXISTS (
+				SELECT 1 FROM orders
+				WHERE user_id = $1 AND status IN ('queued', 'pending', 'held', 'new', 'partial_fill')
+			)
+	`, userID).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check user assets: %w", err)
+	}
+
+	return exists, nil
+}
+
 // GetUserSettings retrieves user settings (using defaults if not stored)
 func (r *Repository) GetUserSettings(ctx context.Context, userID string) (*types.UserSettings, error) {
 	// For now, return default settings since we don't have a settings table
@@ -1,6 +1,7 @@
 package integration
 
 import (
+	"strconv"
 	"testing"
 	"time"
 )
@@ -378,3 +379,56 @@ func TestAlpacaGetQuote(t *testing.T) {
 		t.Error("Quote missing ask/bid prices")
 	}
 }
+
+func TestAlpacaSignWebhookPayload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	h := NewHarness(t)
+
+	if err := h.WaitForAlpaca(30 * time.Second); err != nil {
+		t.Skipf("Alpaca mock not available: %v", err)
+	}
+
+	secret := "integration-webhook-secret"
+	body := []byte(`{"event":"fill","order":{"id":"order-1","status":"filled"}}`)
+
+	resp, err := h.Do(Request{
+		Method:  "POST",
+		URL:     h.Config().AlpacaURL + "/admin/webhooks/sign?secret=" + secret,
+		RawBody: body,
+		Headers: map[string]string{
+			"APCA-API-KEY-ID":     "test-key",
+			"APCA-API-SECRET-KEY": "test-secret",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	h.AssertStatus(resp, 200)
+
+	var data struct {
+		Headers map[string]string `json:"headers"`
+	}
+	if err := resp.JSON(&data); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	timestamp, err := strconv.ParseInt(data.Headers[AlpacaWebhookTimestampHeader], 10, 64)
+	if err != nil {
+		t.Fatalf("Invalid timestamp header: %v", err)
+	}
+	if skew := time.Since(time.Unix(timestamp, 0)); skew > time.Minute || skew < -time.Minute {
+		t.Errorf("Timestamp skew too large: %v", skew)
+	}
+
+	// The mock must sign exactly like the harness and trading-service
+	want := SignAlpacaWebhook(secret, timestamp, body)
+	if got := data.Headers[AlpacaWebhookSignatureHeader]; got != want {
+		t.Errorf("Signature = %s, want %s", got, want)
+	}
+	if data.Headers[AlpacaWebhookDeliveryHeader] == "" {
+		t.Error("Missing delivery ID header")
+	}
+}
@@ -2,11 +2,15 @@ package integration
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -73,6 +77,7 @@ type Request struct {
 	Method  string
 	URL     string
 	Body    any
+	RawBody []byte // Sent as-is instead of Body, e.g. for signed payloads
 	Headers map[string]string
 }
 
@@ -86,7 +91,9 @@ type Response struct {
 // Do executes an HTTP request and returns the response
 func (h *Harness) Do(req Request) (*Response, error) {
 	var body io.Reader
-	if req.Body != nil {
+	if req.RawBody != nil {
+		body = bytes.NewReader(req.RawBody)
+	} else if req.Body != nil {
 		jsonBody, err := json.Marshal(req.Body)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal body: %w", err)
@@ -193,6 +200,37 @@ func (h *Harness) ResetAll() error {
 	return nil
 }
 
+// =============================================================================
+// Webhook Signing
+// =============================================================================
+
+// Alpaca order webhook headers, as verified by trading-service
+const (
+	AlpacaWebhookSignatureHeader = "X-Alpaca-Signature"
+	AlpacaWebhookTimestampHeader = "X-Alpaca-Timestamp"
+	AlpacaWebhookDeliveryHeader  = "X-Alpaca-Delivery-ID"
+)
+
+// SignAlpacaWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func SignAlpacaWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// AlpacaWebhookHeaders returns the headers of a signed webhook delivery of
+// body with the given delivery ID
+func AlpacaWebhookHeaders(secret, deliveryID string, body []byte) map[string]string {
+	timestamp := time.Now().Unix()
+	return map[string]string{
+		AlpacaWebhookTimestampHeader: strconv.FormatInt(timestamp, 10),
+		AlpacaWebhookSignatureHeader: SignAlpacaWebhook(secret, timestamp, body),
+		AlpacaWebhookDeliveryHeader:  deliveryID,
+	}
+}
+
 // =============================================================================
 // Health Checks
 // =============================================================================
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"strconv"
 	"sync"
@@ -10,6 +16,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/websocket/v2"
 	"github.com/google/uuid"
 )
 
@@ -23,6 +30,7 @@ import (
 // - Position management
 // - Asset information
 // - Market data (quotes)
+// - Trade updates websocket stream
 // =============================================================================
 
 type Server struct {
@@ -31,6 +39,14 @@ type Server struct {
 	orders    map[string]*Order
 	positions map[string]*Position
 	assets    map[string]*Asset
+
+	subMu       sync.Mutex
+	subscribers map[chan []byte]struct{}
+
+	// Signed order webhook delivery, configured by env or /admin/webhooks
+	webhookMu     sync.RWMutex
+	webhookURL    string
+	webhookSecret string
 }
 
 type Account struct {
@@ -76,6 +92,11 @@ type Order struct {
 	StopPrice      string     `json:"stop_price,omitempty"`
 	Status         string     `json:"status"`
 	ExtendedHours  bool       `json:"extended_hours"`
+	OrderClass     string     `json:"order_class"`
+	Legs           []*Order   `json:"legs"`
+
+	// parent is the order this is an exit leg of
+	parent *Order
 }
 
 type Position struct {
@@ -122,9 +143,12 @@ func NewServer() *Server {
 			Equity:         "100000.00",
 			LastEquity:     "99500.00",
 		},
-		orders:    make(map[string]*Order),
-		positions: make(map[string]*Position),
-		assets:    make(map[string]*Asset),
+		orders:        make(map[string]*Order),
+		positions:     make(map[string]*Position),
+		assets:        make(map[string]*Asset),
+		subscribers:   make(map[chan []byte]struct{}),
+		webhookURL:    os.Getenv("WEBHOOK_URL"),
+		webhookSecret: os.Getenv("WEBHOOK_SECRET"),
 	}
 
 	// Initialize default assets
@@ -159,7 +183,8 @@ func main() {
 
 	// Authentication middleware
 	app.Use(func(c *fiber.Ctx) error {
-		if c.Path() == "/health" || c.Path() == "/admin/reset" {
+		// The stream authenticates in-band with an auth message
+		if c.Path() == "/health" || c.Path() == "/admin/reset" || c.Path() == "/stream" {
 			return c.Next()
 		}
 		apiKey := c.Get("APCA-API-KEY-ID")
@@ -194,8 +219,23 @@ func main() {
 	// Market Data
 	app.Get("/v2/stocks/:symbol/quotes/latest", server.getQuote)
 
+	// Clock
+	app.Get("/v2/clock", server.getClock)
+
+	// Trade updates stream
+	app.Use("/stream", func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+		return c.Next()
+	})
+	app.Get("/stream", websocket.New(server.stream))
+
 	// Admin endpoints
 	app.Post("/admin/reset", server.reset)
+	app.Post("/admin/orders/:id/fill", server.fillOrder)
+	app.Post("/admin/webhooks", server.configureWebhook)
+	app.Post("/admin/webhooks/sign", server.signWebhookPayload)
 	app.Post("/admin/set-cash", server.setCash)
 	app.Get("/admin/state", server.getState)
 
@@ -238,6 +278,14 @@ type CreateOrderRequest struct {
 	StopPrice     string `json:"stop_price,omitempty"`
 	ClientOrderID string `json:"client_order_id,omitempty"`
 	ExtendedHours bool   `json:"extended_hours,omitempty"`
+	OrderClass    string `json:"order_class,omitempty"`
+	TakeProfit    *struct {
+		LimitPrice string `json:"limit_price"`
+	} `json:"take_profit,omitempty"`
+	StopLoss *struct {
+		StopPrice  string `json:"stop_price"`
+		LimitPrice string `json:"limit_price,omitempty"`
+	} `json:"stop_loss,omitempty"`
 }
 
 func (s *Server) createOrder(c *fiber.Ctx) error {
@@ -250,6 +298,25 @@ func (s *Server) createOrder(c *fiber.Ctx) error {
 		return c.Status(422).JSON(fiber.Map{"message": "Missing required fields"})
 	}
 
+	switch req.OrderClass {
+	case "", "simple":
+		req.OrderClass = "simple"
+	case "bracket":
+		if req.TakeProfit == nil || req.StopLoss == nil {
+			return c.Status(422).JSON(fiber.Map{"message": "bracket orders require take_profit and stop_loss"})
+		}
+	case "oco":
+		if req.Type != "limit" || req.TakeProfit == nil || req.StopLoss == nil {
+			return c.Status(422).JSON(fiber.Map{"message": "oco orders must be limit orders with take_profit and stop_loss"})
+		}
+	case "oto":
+		if (req.TakeProfit == nil) == (req.StopLoss == nil) {
+			return c.Status(422).JSON(fiber.Map{"message": "oto orders require one of take_profit or stop_loss"})
+		}
+	default:
+		return c.Status(422).JSON(fiber.Map{"message": "Invalid order_class"})
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -285,27 +352,143 @@ func (s *Server) createOrder(c *fiber.Ctx) error {
 		StopPrice:     req.StopPrice,
 		Status:        "new",
 		ExtendedHours: req.ExtendedHours,
+		OrderClass:    req.OrderClass,
+	}
+	s.orders[orderID] = order
+	s.emit("new", order)
+
+	// Exit legs of bracket and OTO orders are held until the entry fills; an
+	// OCO order is the take-profit itself, with the stop-loss live alongside
+	switch req.OrderClass {
+	case "bracket", "oto":
+		exit := "sell"
+		if req.Side == "sell" {
+			exit = "buy"
+		}
+		if req.TakeProfit != nil {
+			s.addLeg(order, exit, "limit", req.TakeProfit.LimitPrice, "", "held")
+		}
+		if req.StopLoss != nil {
+			s.addLeg(order, exit, stopLossType(req.StopLoss.LimitPrice), req.StopLoss.LimitPrice, req.StopLoss.StopPrice, "held")
+		}
+	case "oco":
+		order.LimitPrice = req.TakeProfit.LimitPrice
+		s.addLeg(order, req.Side, stopLossType(req.StopLoss.LimitPrice), req.StopLoss.LimitPrice, req.StopLoss.StopPrice, "new")
 	}
 
 	// Simulate immediate fill for market orders
 	if req.Type == "market" {
-		order.Status = "filled"
-		order.FilledQty = order.Qty
+		filledQty := order.Qty
 		if order.Qty == "" && order.Notional != "" {
-			order.FilledQty = "1"
+			filledQty = "1"
 		}
-		order.FilledAvgPrice = s.getMockPrice(req.Symbol)
-		filledAt := now
-		order.FilledAt = &filledAt
-
-		// Update position
-		s.updatePosition(order)
+		s.fill(order, filledQty, s.getMockPrice(req.Symbol))
 	}
 
-	s.orders[orderID] = order
 	return c.Status(201).JSON(order)
 }
 
+func stopLossType(limitPrice string) string {
+	if limitPrice != "" {
+		return "stop_limit"
+	}
+	return "stop"
+}
+
+// addLeg creates an exit leg of parent. Callers must hold s.mu.
+func (s *Server) addLeg(parent *Order, side, orderType, limitPrice, stopPrice, status string) {
+	now := time.Now()
+	leg := &Order{
+		ID:            uuid.New().String(),
+		ClientOrderID: uuid.New().String(),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		SubmittedAt:   now,
+		Symbol:        parent.Symbol,
+		AssetID:       parent.AssetID,
+		AssetClass:    parent.AssetClass,
+		Qty:           parent.Qty,
+		FilledQty:     "0",
+		OrderType:     orderType,
+		Side:          side,
+		TimeInForce:   parent.TimeInForce,
+		LimitPrice:    limitPrice,
+		StopPrice:     stopPrice,
+		Status:        status,
+		OrderClass:    parent.OrderClass,
+		parent:        parent,
+	}
+
+	s.orders[leg.ID] = leg
+	parent.Legs = append(parent.Legs, leg)
+	if status == "new" {
+		s.emit("new", leg)
+	}
+}
+
+// fill completes an order and applies the order class rules: a filled entry
+// releases its held legs, and a filled exit cancels the exits linked to it.
+// Callers must hold s.mu.
+func (s *Server) fill(order *Order, filledQty, price string) {
+	now := time.Now()
+	order.Status = "filled"
+	order.FilledQty = filledQty
+	order.FilledAvgPrice = price
+	order.FilledAt = &now
+	order.UpdatedAt = now
+
+	s.updatePosition(order)
+	s.emit("fill", order)
+
+	for _, leg := range order.Legs {
+		if leg.Status == "held" {
+			leg.Status = "new"
+			leg.Qty = filledQty
+			leg.UpdatedAt = now
+			s.emit("new", leg)
+		}
+	}
+	s.cancelLinked(order)
+}
+
+// cancel cancels an order along with the orders that cannot fill without
+// it. Callers must hold s.mu.
+func (s *Server) cancel(order *Order) {
+	now := time.Now()
+	order.Status = "canceled"
+	order.CanceledAt = &now
+	order.UpdatedAt = now
+	s.emit("canceled", order)
+
+	for _, leg := range order.Legs {
+		if leg.Status == "held" {
+			s.cancel(leg)
+		}
+	}
+	s.cancelLinked(order)
+}
+
+// cancelLinked cancels the open exits that are one-cancels-other with order:
+// its sibling legs, plus the parent or own legs of an OCO pair. Callers must
+// hold s.mu.
+func (s *Server) cancelLinked(order *Order) {
+	var linked []*Order
+	if order.parent != nil {
+		linked = append(linked, order.parent.Legs...)
+		if order.parent.OrderClass == "oco" {
+			linked = append(linked, order.parent)
+		}
+	} else if order.OrderClass == "oco" {
+		linked = order.Legs
+	}
+
+	for _, other := range linked {
+		if other != order && (other.Status == "new" || other.Status == "partially_filled") {
+			s.cancel(other)
+		}
+	}
+}
+
 func (s *Server) getMockPrice(symbol string) string {
 	prices := map[string]string{
 		"AAPL":  "175.50",
@@ -370,7 +553,7 @@ func (s *Server) listOrders(c *fiber.Ctx) error {
 	orders := make([]Order, 0)
 	for _, order := range s.orders {
 		if status == "all" ||
-			(status == "open" && (order.Status == "new" || order.Status == "accepted" || order.Status == "pending_new")) ||
+			(status == "open" && (order.Status == "new" || order.Status == "partially_filled" || order.Status == "accepted" || order.Status == "pending_new")) ||
 			(status == "closed" && (order.Status == "filled" || order.Status == "canceled" || order.Status == "expired")) {
 			orders = append(orders, *order)
 		}
@@ -414,9 +597,7 @@ func (s *Server) cancelOrder(c *fiber.Ctx) error {
 		return c.Status(422).JSON(fiber.Map{"message": "Cannot cancel filled order"})
 	}
 
-	now := time.Now()
-	order.Status = "canceled"
-	order.CanceledAt = &now
+	s.cancel(order)
 
 	return c.SendStatus(204)
 }
@@ -432,6 +613,7 @@ func (s *Server) cancelAllOrders(c *fiber.Ctx) error {
 		if order.Status != "filled" && order.Status != "canceled" {
 			order.Status = "canceled"
 			order.CanceledAt = &now
+			s.emit("canceled", order)
 			canceled = append(canceled, fiber.Map{
 				"id":     order.ID,
 				"status": 200,
@@ -498,6 +680,8 @@ func (s *Server) replaceOrder(c *fiber.Ctx) error {
 	order.ReplacedAt = &now
 
 	s.orders[newOrderID] = newOrder
+	s.emit("replaced", order)
+	s.emit("new", newOrder)
 
 	return c.JSON(newOrder)
 }
@@ -564,6 +748,7 @@ func (s *Server) closePosition(c *fiber.Ctx) error {
 	}
 
 	s.orders[order.ID] = order
+	s.emit("fill", order)
 	delete(s.positions, symbol)
 
 	return c.JSON(order)
@@ -596,6 +781,7 @@ func (s *Server) closeAllPositions(c *fiber.Ctx) error {
 		}
 		orders = append(orders, order)
 		s.orders[order.ID] = &order
+		s.emit("fill", &order)
 	}
 
 	s.positions = make(map[string]*Position)
@@ -658,6 +844,233 @@ func (s *Server) getQuote(c *fiber.Ctx) error {
 	})
 }
 
+// =============================================================================
+// Clock
+// =============================================================================
+
+// getClock reports the market as always open so sandbox orders can be placed
+// at any time of day
+func (s *Server) getClock(c *fiber.Ctx) error {
+	now := time.Now().UTC()
+	return c.JSON(fiber.Map{
+		"timestamp":  now.Format(time.RFC3339),
+		"is_open":    true,
+		"next_open":  now.Add(24 * time.Hour).Format(time.RFC3339),
+		"next_close": now.Add(6 * time.Hour).Format(time.RFC3339),
+	})
+}
+
+// =============================================================================
+// Trade Updates Stream
+// =============================================================================
+
+// stream implements Alpaca's account websocket: the client sends an auth
+// message, then subscribes to trade_updates with a listen message
+func (s *Server) stream(conn *websocket.Conn) {
+	defer conn.Close()
+
+	var auth struct {
+		Action string `json:"action"`
+		Key    string `json:"key"`
+		Secret string `json:"secret"`
+	}
+	if err := conn.ReadJSON(&auth); err != nil {
+		return
+	}
+	if auth.Action != "auth" || auth.Key == "" || auth.Secret == "" {
+		conn.WriteJSON(fiber.Map{
+			"stream": "authorization",
+			"data":   fiber.Map{"status": "unauthorized", "action": "authenticate"},
+		})
+		return
+	}
+	if err := conn.WriteJSON(fiber.Map{
+		"stream": "authorization",
+		"data":   fiber.Map{"status": "authorized", "action": "authenticate"},
+	}); err != nil {
+		return
+	}
+
+	var listen struct {
+		Action string `json:"action"`
+		Data   struct {
+			Streams []string `json:"streams"`
+		} `json:"data"`
+	}
+	if err := conn.ReadJSON(&listen); err != nil || listen.Action != "listen" {
+		return
+	}
+
+	updates := make(chan []byte, 256)
+	s.subMu.Lock()
+	s.subscribers[updates] = struct{}{}
+	s.subMu.Unlock()
+	defer func() {
+		s.subMu.Lock()
+		delete(s.subscribers, updates)
+		s.subMu.Unlock()
+	}()
+
+	if err := conn.WriteJSON(fiber.Map{
+		"stream": "listening",
+		"data":   fiber.Map{"streams": listen.Data.Streams},
+	}); err != nil {
+		return
+	}
+
+	// Detect the client going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case msg := <-updates:
+			// Alpaca sends trade updates as binary frames
+			if err := conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// emit broadcasts an order event to stream subscribers
+func (s *Server) emit(event string, order *Order) {
+	data := fiber.Map{
+		"event":     event,
+		"order":     order,
+		"timestamp": time.Now().Format(time.RFC3339Nano),
+	}
+	if event == "fill" || event == "partial_fill" {
+		data["execution_id"] = uuid.New().String()
+		data["price"] = order.FilledAvgPrice
+		data["qty"] = order.FilledQty
+	}
+
+	msg, err := json.Marshal(fiber.Map{"stream": "trade_updates", "data": data})
+	if err != nil {
+		return
+	}
+
+	s.deliverWebhook(event, order)
+
+	s.subMu.Lock()
+	defer s.subMu.Unlock()
+	for ch := range s.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			// Drop updates for slow subscribers
+		}
+	}
+}
+
+// =============================================================================
+// Order Webhooks
+// =============================================================================
+
+const (
+	webhookSignatureHeader = "X-Alpaca-Signature"
+	webhookTimestampHeader = "X-Alpaca-Timestamp"
+	webhookDeliveryHeader  = "X-Alpaca-Delivery-ID"
+)
+
+// signWebhook returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookHeaders returns the headers of a signed delivery of body
+func webhookHeaders(secret string, body []byte) map[string]string {
+	timestamp := time.Now().Unix()
+	return map[string]string{
+		webhookTimestampHeader: strconv.FormatInt(timestamp, 10),
+		webhookSignatureHeader: signWebhook(secret, timestamp, body),
+		webhookDeliveryHeader:  uuid.New().String(),
+	}
+}
+
+// deliverWebhook posts a signed order event to the configured webhook URL
+func (s *Server) deliverWebhook(event string, order *Order) {
+	s.webhookMu.RLock()
+	url, secret := s.webhookURL, s.webhookSecret
+	s.webhookMu.RUnlock()
+	if url == "" {
+		return
+	}
+
+	body, err := json.Marshal(fiber.Map{"event": event, "order": order})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			log.Printf("webhook: invalid URL %s: %v", url, err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range webhookHeaders(secret, body) {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("webhook: delivery of %s failed: %v", event, err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+// configureWebhook sets where order events are delivered and the secret
+// they are signed with. An empty URL disables delivery.
+func (s *Server) configureWebhook(c *fiber.Ctx) error {
+	var req struct {
+		URL    string `json:"url"`
+		Secret string `json:"secret"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": "Invalid request"})
+	}
+
+	s.webhookMu.Lock()
+	s.webhookURL = req.URL
+	s.webhookSecret = req.Secret
+	s.webhookMu.Unlock()
+
+	return c.JSON(fiber.Map{"status": "webhook configured", "url": req.URL})
+}
+
+// signWebhookPayload signs an arbitrary payload so tests can post crafted
+// deliveries straight to a webhook endpoint
+func (s *Server) signWebhookPayload(c *fiber.Ctx) error {
+	secret := c.Query("secret")
+	if secret == "" {
+		s.webhookMu.RLock()
+		secret = s.webhookSecret
+		s.webhookMu.RUnlock()
+	}
+	if secret == "" {
+		return c.Status(422).JSON(fiber.Map{"message": "No webhook secret configured"})
+	}
+
+	return c.JSON(fiber.Map{"headers": webhookHeaders(secret, c.Body())})
+}
+
 // =============================================================================
 // Admin
 // =============================================================================
@@ -674,6 +1087,61 @@ func (s *Server) reset(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"status": "reset complete"})
 }
 
+// fillOrder fills an open order, fully or partially, so tests can drive
+// limit and stop orders through their lifecycle
+func (s *Server) fillOrder(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var req struct {
+		Qty   string `json:"qty"`
+		Price string `json:"price"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"message": "Invalid request"})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, exists := s.orders[id]
+	if !exists {
+		return c.Status(404).JSON(fiber.Map{"message": "Order not found"})
+	}
+	if order.Status != "new" && order.Status != "partially_filled" {
+		return c.Status(422).JSON(fiber.Map{"message": "Order is not open"})
+	}
+
+	total, _ := strconv.ParseFloat(order.Qty, 64)
+	filled, _ := strconv.ParseFloat(order.FilledQty, 64)
+	qty := total - filled
+	if req.Qty != "" {
+		qty, _ = strconv.ParseFloat(req.Qty, 64)
+	}
+	if qty <= 0 || filled+qty > total {
+		return c.Status(422).JSON(fiber.Map{"message": "Invalid fill quantity"})
+	}
+
+	price := req.Price
+	if price == "" {
+		price = s.getMockPrice(order.Symbol)
+	}
+
+	now := time.Now()
+	order.FilledQty = strconv.FormatFloat(filled+qty, 'f', -1, 64)
+	order.FilledAvgPrice = price
+	order.UpdatedAt = now
+
+	if filled+qty >= total {
+		s.fill(order, order.FilledQty, price)
+		return c.JSON(order)
+	}
+
+	order.Status = "partially_filled"
+	s.emit("partial_fill", order)
+
+	return c.JSON(order)
+}
+
 func (s *Server) setCash(c *fiber.Ctx) error {
 	var req struct {
 		Cash string `json:"cash"`